@@ -0,0 +1,490 @@
+/*
+Package asm assembles bytecode from text, so VM edge cases can be written
+by hand instead of going through the parser and compiler. It reads the
+listing printed by package disasm:
+
+	constants:
+	  0  1
+	  1  "one"
+	  2  fn#2
+
+	main:
+	  OpConstant 0
+	  OpJumpIf done
+	  OpClosure 2 0
+	  OpPop
+	done:
+	  OpNull
+	  OpPop
+
+	fn#2 params=1 locals=1 stack=1:
+	  OpGetLocal 0
+	  OpReturnValue
+
+Everything after a `;` is a comment. Instructions name an opcode as in
+package code, may be preceded by their offset, which is ignored, and by
+OpWide to force the wide encoding; operands that do not fit are widened
+anyway. Any operand can be a label, which stands for the offset of the
+instruction following it. Function blocks `fn#N` define constant N; the
+constants section lists the other constants, integers or Go-quoted
+strings, and may refer to functions as fn#N. The stack depth of main and
+of functions without a `stack=N` attribute is computed with
+[code.MaxStackDepth]; giving it explicitly allows writing bytecode that
+the verifier rejects.
+
+Debug information such as names and line tables is not read back.
+*/
+package asm
+
+import (
+	"fmt"
+	"math/big"
+	"monc/code"
+	"monc/compiler"
+	"monc/object"
+	"strconv"
+	"strings"
+)
+
+// Assemble parses src into bytecode. The bytecode is not verified.
+func Assemble(src string) (*compiler.Bytecode, error) {
+	a := &assembler{constants: map[int]constant{}}
+
+	for i, line := range strings.Split(src, "\n") {
+		fields, err := fields(line)
+		if err != nil {
+			return nil, fmt.Errorf("asm: line %d: %s", i+1, err)
+		}
+		if len(fields) == 0 {
+			continue
+		}
+
+		if err := a.line(i+1, fields); err != nil {
+			return nil, fmt.Errorf("asm: line %d: %s", i+1, err)
+		}
+	}
+
+	return a.bytecode()
+}
+
+// MustAssemble is like Assemble but panics on errors. It is meant for
+// tests.
+func MustAssemble(src string) *compiler.Bytecode {
+	bytecode, err := Assemble(src)
+	if err != nil {
+		panic(err)
+	}
+	return bytecode
+}
+
+type operand struct {
+	value int
+	label string // if not empty, the operand is the offset of this label
+}
+
+type instruction struct {
+	line     int
+	label    string // set for label definitions, which have no opcode
+	op       code.Opcode
+	wide     bool
+	operands []operand
+}
+
+// block is the main program or a function
+type block struct {
+	line          int
+	index         int // constant index, -1 for main
+	numParameters int
+	numLocals     int
+	maxStack      int // -1 if not given
+	instructions  []instruction
+}
+
+// constant is an entry of the constants section
+type constant struct {
+	line  int
+	value object.Object // nil for references to function blocks
+}
+
+type assembler struct {
+	constants map[int]constant
+	main      *block
+	functions []*block
+
+	inConstants bool
+	current     *block
+}
+
+func (a *assembler) line(line int, fields []string) error {
+	first := fields[0]
+
+	switch {
+	case first == "constants:" && len(fields) == 1:
+		a.inConstants = true
+		a.current = nil
+		return nil
+
+	case first == "main:" && len(fields) == 1:
+		if a.main != nil {
+			return fmt.Errorf("main defined twice")
+		}
+		a.main = &block{line: line, index: -1, maxStack: -1}
+		a.inConstants = false
+		a.current = a.main
+		return nil
+
+	case strings.HasPrefix(first, "fn#"):
+		b, err := functionHeader(line, fields)
+		if err != nil {
+			return err
+		}
+		a.functions = append(a.functions, b)
+		a.inConstants = false
+		a.current = b
+		return nil
+	}
+
+	if a.inConstants {
+		return a.constant(line, fields)
+	}
+
+	if a.current == nil {
+		return fmt.Errorf("instruction outside of main or a function")
+	}
+
+	if len(fields) == 1 && strings.HasSuffix(first, ":") {
+		a.current.instructions = append(a.current.instructions,
+			instruction{line: line, label: strings.TrimSuffix(first, ":")})
+		return nil
+	}
+
+	ins, err := parseInstruction(line, fields)
+	if err != nil {
+		return err
+	}
+	a.current.instructions = append(a.current.instructions, ins)
+
+	return nil
+}
+
+// functionHeader parses `fn#N params=P locals=L free=F stack=S:`; free is
+// accepted for the disassembler's output but not needed
+func functionHeader(line int, fields []string) (*block, error) {
+	last := fields[len(fields)-1]
+	if !strings.HasSuffix(last, ":") {
+		return nil, fmt.Errorf("function header must end with ':'")
+	}
+	fields[len(fields)-1] = strings.TrimSuffix(last, ":")
+
+	index, err := strconv.Atoi(strings.TrimPrefix(fields[0], "fn#"))
+	if err != nil || index < 0 {
+		return nil, fmt.Errorf("invalid function %q", fields[0])
+	}
+
+	b := &block{line: line, index: index, maxStack: -1}
+
+	for _, f := range fields[1:] {
+		key, value, ok := strings.Cut(f, "=")
+		n, err := strconv.Atoi(value)
+		if !ok || err != nil || n < 0 {
+			return nil, fmt.Errorf("invalid attribute %q", f)
+		}
+
+		switch key {
+		case "params":
+			b.numParameters = n
+		case "locals":
+			b.numLocals = n
+		case "stack":
+			b.maxStack = n
+		case "free":
+		default:
+			return nil, fmt.Errorf("unknown attribute %q", key)
+		}
+	}
+
+	return b, nil
+}
+
+func (a *assembler) constant(line int, fields []string) error {
+	if len(fields) != 2 {
+		return fmt.Errorf("expected constant index and value")
+	}
+
+	index, err := strconv.Atoi(fields[0])
+	if err != nil || index < 0 {
+		return fmt.Errorf("invalid constant index %q", fields[0])
+	}
+	if _, ok := a.constants[index]; ok {
+		return fmt.Errorf("constant %d defined twice", index)
+	}
+
+	value := fields[1]
+	c := constant{line: line}
+
+	switch {
+	case strings.HasPrefix(value, `"`):
+		s, err := strconv.Unquote(value)
+		if err != nil {
+			return fmt.Errorf("invalid string %s", value)
+		}
+		c.value = &object.String{Value: s}
+
+	case strings.HasPrefix(value, "fn#"):
+		if value != fmt.Sprintf("fn#%d", index) {
+			return fmt.Errorf("constant %d refers to %s", index, value)
+		}
+
+	default:
+		n, ok := new(big.Int).SetString(value, 10)
+		if !ok {
+			return fmt.Errorf("invalid constant %q", value)
+		}
+		c.value = object.NewBigInt(n)
+	}
+
+	a.constants[index] = c
+	return nil
+}
+
+func parseInstruction(line int, fields []string) (instruction, error) {
+	ins := instruction{line: line}
+
+	// offsets printed by the disassembler
+	if len(fields) > 1 && isNumber(fields[0]) {
+		fields = fields[1:]
+	}
+
+	if fields[0] == "OpWide" {
+		ins.wide = true
+		fields = fields[1:]
+		if len(fields) == 0 {
+			return ins, fmt.Errorf("OpWide without an instruction")
+		}
+	}
+
+	op, ok := code.LookupName(fields[0])
+	if !ok {
+		return ins, fmt.Errorf("unknown opcode %q", fields[0])
+	}
+	ins.op = op
+
+	def, _ := code.Lookup(byte(op))
+	if len(fields)-1 != len(def.OperandWidths) {
+		return ins, fmt.Errorf("%s takes %d operands, got %d", def.Name, len(def.OperandWidths), len(fields)-1)
+	}
+
+	for _, f := range fields[1:] {
+		if isNumber(f) {
+			n, err := strconv.Atoi(f)
+			if err != nil {
+				return ins, fmt.Errorf("invalid operand %q", f)
+			}
+			ins.operands = append(ins.operands, operand{value: n})
+		} else {
+			ins.operands = append(ins.operands, operand{label: f})
+		}
+	}
+
+	return ins, nil
+}
+
+func (a *assembler) bytecode() (*compiler.Bytecode, error) {
+	bytecode := &compiler.Bytecode{Instructions: code.Instructions{}}
+
+	if a.main != nil {
+		ins, err := a.main.assemble()
+		if err != nil {
+			return nil, err
+		}
+		bytecode.Instructions = ins
+		bytecode.MaxStack = a.main.stackDepth(ins)
+	}
+
+	functions := map[int]*object.CompiledFn{}
+	for _, b := range a.functions {
+		if _, ok := functions[b.index]; ok {
+			return nil, fmt.Errorf("asm: line %d: fn#%d defined twice", b.line, b.index)
+		}
+		if c, ok := a.constants[b.index]; ok && c.value != nil {
+			return nil, fmt.Errorf("asm: line %d: fn#%d is also constant %s", b.line, b.index, c.value.Inspect())
+		}
+
+		ins, err := b.assemble()
+		if err != nil {
+			return nil, err
+		}
+
+		functions[b.index] = &object.CompiledFn{
+			Instructions:  ins,
+			NumLocals:     b.numLocals,
+			NumParameters: b.numParameters,
+			MaxStack:      b.stackDepth(ins),
+		}
+	}
+
+	size := len(a.constants)
+	for index := range functions {
+		if _, ok := a.constants[index]; !ok {
+			size++
+		}
+	}
+
+	bytecode.Constants = make([]object.Object, size)
+	for i := range bytecode.Constants {
+		if fn, ok := functions[i]; ok {
+			bytecode.Constants[i] = fn
+			continue
+		}
+
+		c, ok := a.constants[i]
+		if !ok {
+			return nil, fmt.Errorf("asm: constant %d is missing", i)
+		}
+		if c.value == nil {
+			return nil, fmt.Errorf("asm: line %d: fn#%d is not defined", c.line, i)
+		}
+		bytecode.Constants[i] = c.value
+	}
+
+	return bytecode, nil
+}
+
+// assemble encodes the block. Instructions before a label grow when their
+// operands need OpWide, which moves the label, so the layout is repeated
+// until the offsets settle.
+func (b *block) assemble() (code.Instructions, error) {
+	offsets := map[string]int{}
+
+	for _, ins := range b.instructions {
+		if ins.label == "" {
+			continue
+		}
+		if _, ok := offsets[ins.label]; ok {
+			return nil, fmt.Errorf("asm: line %d: label %s defined twice", ins.line, ins.label)
+		}
+		offsets[ins.label] = 0
+	}
+
+	for {
+		changed := false
+		offset := 0
+
+		for _, ins := range b.instructions {
+			if ins.label != "" {
+				if offsets[ins.label] != offset {
+					offsets[ins.label] = offset
+					changed = true
+				}
+				continue
+			}
+
+			encoded, err := ins.encode(offsets)
+			if err != nil {
+				return nil, err
+			}
+			offset += len(encoded)
+		}
+
+		if !changed {
+			break
+		}
+	}
+
+	out := code.Instructions{}
+	for _, ins := range b.instructions {
+		if ins.label != "" {
+			continue
+		}
+		encoded, err := ins.encode(offsets)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, encoded...)
+	}
+
+	return out, nil
+}
+
+// stackDepth returns the declared stack depth or else computes it. Blocks
+// that underflow the stack get 0 and are left for the verifier to reject.
+func (b *block) stackDepth(ins code.Instructions) int {
+	if b.maxStack >= 0 {
+		return b.maxStack
+	}
+	depth, err := code.MaxStackDepth(ins)
+	if err != nil {
+		return 0
+	}
+	return depth
+}
+
+func (ins instruction) encode(labels map[string]int) ([]byte, error) {
+	operands := make([]int, len(ins.operands))
+	for i, o := range ins.operands {
+		if o.label == "" {
+			operands[i] = o.value
+			continue
+		}
+		offset, ok := labels[o.label]
+		if !ok {
+			return nil, fmt.Errorf("asm: line %d: undefined label %s", ins.line, o.label)
+		}
+		operands[i] = offset
+	}
+
+	var encoded []byte
+	var err error
+	if ins.wide {
+		encoded, err = code.MakeWide(ins.op, operands...)
+	} else {
+		encoded, err = code.MakeChecked(ins.op, operands...)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("asm: line %d: %s", ins.line, err)
+	}
+
+	return encoded, nil
+}
+
+// fields splits a line into whitespace separated fields, keeping quoted
+// strings together and dropping comments
+func fields(line string) ([]string, error) {
+	out := []string{}
+
+	for {
+		line = strings.TrimLeft(line, " \t\r")
+		if line == "" || line[0] == ';' {
+			return out, nil
+		}
+
+		if line[0] == '"' {
+			quoted, err := strconv.QuotedPrefix(line)
+			if err != nil {
+				return nil, fmt.Errorf("unterminated string")
+			}
+			out = append(out, quoted)
+			line = line[len(quoted):]
+			continue
+		}
+
+		end := strings.IndexAny(line, " \t\r;")
+		if end < 0 {
+			end = len(line)
+		}
+		out = append(out, line[:end])
+		line = line[end:]
+	}
+}
+
+func isNumber(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, r := range s {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,275 @@
+package asm
+
+import (
+	"bytes"
+	"fmt"
+	"monc/code"
+	"monc/compiler"
+	"monc/disasm"
+	"monc/lexer"
+	"monc/object"
+	"monc/parser"
+	"monc/vm"
+	"strings"
+	"testing"
+)
+
+func TestAssemble(t *testing.T) {
+	input := `
+constants:
+  0  1
+  1  "one; two"   ; comments may follow strings
+  2  fn#2
+  3  -7
+
+main:
+  OpConstant 0
+  OpJumpIf done        ; 1 is truthy, so no jump
+  OpClosure 2 0
+  OpConstant 3
+  OpCall 1
+  OpPop
+done:
+  OpNull
+  OpPop
+
+fn#2 params=1 locals=1:
+  OpGetLocal 0
+  OpReturnValue
+`
+
+	bytecode, err := Assemble(input)
+	if err != nil {
+		t.Fatalf("assemble failed: %s", err)
+	}
+
+	expected := concat(
+		code.Make(code.OpConstant, 0),
+		code.Make(code.OpJumpIf, 16),
+		code.Make(code.OpClosure, 2, 0),
+		code.Make(code.OpConstant, 3),
+		code.Make(code.OpCall, 1),
+		code.Make(code.OpPop),
+		code.Make(code.OpNull),
+		code.Make(code.OpPop),
+	)
+	if !bytes.Equal(bytecode.Instructions, expected) {
+		t.Errorf("wrong instructions.\nwant=%s\ngot=%s", expected, bytecode.Instructions)
+	}
+
+	if len(bytecode.Constants) != 4 {
+		t.Fatalf("wrong number of constants. got=%d", len(bytecode.Constants))
+	}
+	if s, ok := bytecode.Constants[1].(*object.String); !ok || s.Value != "one; two" {
+		t.Errorf("wrong string constant. got=%+v", bytecode.Constants[1])
+	}
+	fn, ok := bytecode.Constants[2].(*object.CompiledFn)
+	if !ok || fn.NumParameters != 1 || fn.NumLocals != 1 || fn.MaxStack != 1 {
+		t.Fatalf("wrong function constant. got=%+v", bytecode.Constants[2])
+	}
+	if bytecode.MaxStack != 2 {
+		t.Errorf("wrong max stack for main. want=2, got=%d", bytecode.MaxStack)
+	}
+
+	machine, err := vm.NewVerified(bytecode)
+	if err != nil {
+		t.Fatalf("verify failed: %s", err)
+	}
+	if err := machine.Run(); err != nil {
+		t.Fatalf("vm error: %s", err)
+	}
+}
+
+func TestAssembleWide(t *testing.T) {
+	bytecode := MustAssemble(`
+main:
+  OpWide OpConstant 0
+  OpConstant 70000
+  OpPop
+`)
+
+	expected := concat(
+		[]byte{byte(code.OpWide), byte(code.OpConstant), 0, 0, 0, 0},
+		code.Make(code.OpConstant, 70000),
+		code.Make(code.OpPop),
+	)
+	if !bytes.Equal(bytecode.Instructions, expected) {
+		t.Errorf("wrong instructions.\nwant=%v\ngot=%v", expected, bytecode.Instructions)
+	}
+}
+
+func TestAssembleWidensJumpsPastLabels(t *testing.T) {
+	// with narrow jumps `end` is at 65536, so both jumps are widened, which
+	// moves `end` again
+	src := "main:\n  OpJump end\n  OpJump end\n" +
+		strings.Repeat("  OpNull\n  OpPop\n", 32765) + "end:\n  OpNull\n"
+
+	bytecode := MustAssemble(src)
+	end := len(bytecode.Instructions) - 1
+
+	if end != 65542 {
+		t.Errorf("wrong layout. want end=%d, got=%d", 65542, end)
+	}
+
+	for _, pos := range []int{0, 6} {
+		op, operands, _, err := code.Decode(bytecode.Instructions, pos)
+		if err != nil {
+			t.Fatalf("decode failed: %s", err)
+		}
+		if op != code.OpJump || operands[0] != end {
+			t.Errorf("wrong jump at %d. got=%d %v, want target %d", pos, op, operands, end)
+		}
+		if code.Opcode(bytecode.Instructions[pos]) != code.OpWide {
+			t.Errorf("expected a wide jump at %d", pos)
+		}
+	}
+}
+
+func TestAssembleDeclaredStack(t *testing.T) {
+	bytecode := MustAssemble(`
+constants:
+  0  fn#0
+
+main:
+  OpClosure 0 0
+  OpCall 0
+  OpPop
+
+fn#0 stack=1:
+  OpNull
+  OpNull
+  OpAdd
+  OpReturnValue
+`)
+
+	fn := bytecode.Constants[0].(*object.CompiledFn)
+	if fn.MaxStack != 1 {
+		t.Fatalf("declared stack not used. got=%d", fn.MaxStack)
+	}
+
+	_, err := vm.NewVerified(bytecode)
+	if err == nil || err.Error() != "verify: fn#0 at 0001: stack depth 2 exceeds the declared maximum 1" {
+		t.Errorf("wrong verify error. got=%v", err)
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	params := []string{}
+	for i := 0; i < 300; i++ {
+		params = append(params, fmt.Sprintf("p%c%c", 'a'+i/26, 'a'+i%26))
+	}
+
+	tests := []string{
+		`1 + 2; "a" + "b; c";`,
+		`let x = 5; if (x > 3) { x } else { -x }; if (false) { 1 };`,
+		`let f = fn(a, b) { let c = a * b; fn(d) { c + d + a } }; f(1, 2)(3);`,
+		`let fib = fn(x) { if (x < 2) { return x; } fib(x - 1) + fib(x - 2) }; fib(10);`,
+		`[1, 2, 3][0]; {"a": 1, 2: true}[2]; len("four"); puts(first([1]));`,
+		`18446744073709551616 * 2;`,
+		fmt.Sprintf("fn(%s) { %s };", strings.Join(params, ", "), params[299]),
+		"if (true) { " + strings.Repeat("true; ", 40000) + "};",
+	}
+
+	for _, input := range tests {
+		original := compile(t, input)
+
+		listing := disasm.Disassemble(original)
+		assembled, err := Assemble(listing)
+		if err != nil {
+			t.Fatalf("assemble failed for %q: %s", input, err)
+		}
+
+		if assembled.MaxStack != original.MaxStack {
+			t.Errorf("wrong max stack for %q. want=%d, got=%d", input, original.MaxStack, assembled.MaxStack)
+		}
+
+		if !bytes.Equal(assembled.Instructions, original.Instructions) {
+			t.Errorf("wrong instructions for %q.\nwant=%s\ngot=%s", input, original.Instructions, assembled.Instructions)
+		}
+
+		if len(assembled.Constants) != len(original.Constants) {
+			t.Fatalf("wrong number of constants for %q. want=%d, got=%d",
+				input, len(original.Constants), len(assembled.Constants))
+		}
+
+		for i, want := range original.Constants {
+			got := assembled.Constants[i]
+
+			if want, ok := want.(*object.CompiledFn); ok {
+				got, ok := got.(*object.CompiledFn)
+				if !ok || !bytes.Equal(got.Instructions, want.Instructions) ||
+					got.NumLocals != want.NumLocals || got.NumParameters != want.NumParameters ||
+					got.MaxStack != want.MaxStack {
+					t.Errorf("wrong function %d for %q. want=%+v, got=%+v", i, input, want, got)
+				}
+				continue
+			}
+
+			if got.Type() != want.Type() || got.Inspect() != want.Inspect() {
+				t.Errorf("wrong constant %d for %q. want=%s, got=%s", i, input, want.Inspect(), got.Inspect())
+			}
+		}
+	}
+}
+
+func TestAssembleErrors(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"OpPop", "asm: line 1: instruction outside of main or a function"},
+		{"main:\n  OpNope", `asm: line 2: unknown opcode "OpNope"`},
+		{"main:\n  OpConstant", "asm: line 2: OpConstant takes 1 operands, got 0"},
+		{"main:\n  OpJump nowhere", "asm: line 2: undefined label nowhere"},
+		{"main:\nx:\nx:\n  OpNull", "asm: line 3: label x defined twice"},
+		{"main:\n  OpGetLocal 70000", "asm: line 2: operand 0 of OpGetLocal out of range: 70000"},
+		{"main:\n  OpWide OpPop", "asm: line 2: OpPop has no operands to widen"},
+		{"main:\nmain:", "asm: line 2: main defined twice"},
+		{"constants:\n  0 \"open", "asm: line 2: unterminated string"},
+		{"constants:\n  0 1\n  0 2", "asm: line 3: constant 0 defined twice"},
+		{"constants:\n  0 fn#1", "asm: line 2: constant 0 refers to fn#1"},
+		{"constants:\n  0 fn#0", "asm: line 2: fn#0 is not defined"},
+		{"constants:\n  1 1", "asm: constant 0 is missing"},
+		{"fn#0 params=1\n  OpReturn", "asm: line 1: function header must end with ':'"},
+		{"fn#0 arity=1:\n  OpReturn", `asm: line 1: unknown attribute "arity"`},
+		{"constants:\n  0 1\nfn#0:\n  OpReturn", "asm: line 3: fn#0 is also constant 1"},
+	}
+
+	for _, tt := range tests {
+		_, err := Assemble(tt.input)
+		if err == nil {
+			t.Errorf("expected error for %q", tt.input)
+			continue
+		}
+		if err.Error() != tt.expected {
+			t.Errorf("wrong error for %q.\nwant=%q\ngot =%q", tt.input, tt.expected, err)
+		}
+	}
+}
+
+func compile(t *testing.T, input string) *compiler.Bytecode {
+	t.Helper()
+
+	p := parser.New(lexer.New(input))
+	program := p.ParseProgram()
+	if len(p.Errors()) != 0 {
+		t.Fatalf("parser errors: %v", p.Errors())
+	}
+
+	comp := compiler.New()
+	if err := comp.Compile(program); err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+
+	return comp.Bytecode()
+}
+
+func concat(s ...[]byte) code.Instructions {
+	out := code.Instructions{}
+
+	for _, ins := range s {
+		out = append(out, ins...)
+	}
+
+	return out
+}
@@ -3,6 +3,7 @@ package ast
 import (
 	"bytes"
 	"fmt"
+	"math/big"
 	"monc/token"
 	"strings"
 )
@@ -48,6 +49,7 @@ func (p *Program) String() string {
 type LetStatement struct {
 	Token token.Token // token.LET
 	Name  *Identifier
+	Type  TypeExpression // optional annotation, nil if absent
 	Value Expression
 }
 
@@ -61,6 +63,14 @@ type ExpressionStatement struct {
 	Expression Expression
 }
 
+// AssignStatement stores a value in an element of an array or hash,
+// `left[index] = value;`
+type AssignStatement struct {
+	Token  token.Token // the '=' token
+	Target *IndexExpression
+	Value  Expression
+}
+
 func (ls *LetStatement) statementNode()       {}
 func (ls *LetStatement) TokenLiteral() string { return ls.Token.Literal }
 func (ls *LetStatement) String() string {
@@ -68,6 +78,9 @@ func (ls *LetStatement) String() string {
 
 	out.WriteString(ls.TokenLiteral() + " ")
 	out.WriteString(ls.Name.String())
+	if ls.Type != nil {
+		out.WriteString(": " + ls.Type.String())
+	}
 	out.WriteString(" = ")
 
 	if ls.Value != nil {
@@ -96,6 +109,25 @@ func (rs *ReturnStatement) String() string {
 	return out.String()
 }
 
+func (as *AssignStatement) statementNode()       {}
+func (as *AssignStatement) TokenLiteral() string { return as.Token.Literal }
+func (as *AssignStatement) String() string {
+	var out bytes.Buffer
+
+	out.WriteString(as.Target.Left.String())
+	out.WriteString("[")
+	out.WriteString(as.Target.Index.String())
+	out.WriteString("] = ")
+
+	if as.Value != nil {
+		out.WriteString(as.Value.String())
+	}
+
+	out.WriteString(";")
+
+	return out.String()
+}
+
 func (es *ExpressionStatement) statementNode()       {}
 func (es *ExpressionStatement) TokenLiteral() string { return es.Token.Literal }
 func (es *ExpressionStatement) String() string {
@@ -121,6 +153,7 @@ func (i *Identifier) String() string       { return i.Value }
 type IntegerLiteral struct {
 	Token token.Token
 	Value int64
+	Big   *big.Int // the value instead of Value if it does not fit int64
 }
 
 func (il *IntegerLiteral) expressionNode()      {}
@@ -230,8 +263,12 @@ func (bs *BlockStatement) String() string {
 type FunctionLiteral struct {
 	Token      token.Token
 	Parameters []*Identifier
-	Body       *BlockStatement
-	Name       string
+	// ParameterTypes holds the annotation of each parameter, nil entries
+	// for unannotated ones. It is either empty or as long as Parameters.
+	ParameterTypes []TypeExpression
+	ReturnType     TypeExpression // optional annotation, nil if absent
+	Body           *BlockStatement
+	Name           string
 }
 
 func (fl *FunctionLiteral) expressionNode()      {}
@@ -241,8 +278,12 @@ func (fl *FunctionLiteral) String() string {
 
 	params := []string{}
 
-	for _, p := range fl.Parameters {
-		params = append(params, p.String())
+	for i, p := range fl.Parameters {
+		if i < len(fl.ParameterTypes) && fl.ParameterTypes[i] != nil {
+			params = append(params, p.String()+": "+fl.ParameterTypes[i].String())
+		} else {
+			params = append(params, p.String())
+		}
 	}
 
 	out.WriteString(fl.TokenLiteral())
@@ -252,6 +293,9 @@ func (fl *FunctionLiteral) String() string {
 	out.WriteString("(")
 	out.WriteString(strings.Join(params, ", "))
 	out.WriteString(") ")
+	if fl.ReturnType != nil {
+		out.WriteString("-> " + fl.ReturnType.String() + " ")
+	}
 	out.WriteString(fl.Body.String())
 
 	return out.String()
@@ -330,7 +374,13 @@ func (ie *IndexExpression) String() string {
 
 type HashLiteral struct {
 	Token token.Token // the '{' token
-	Pairs map[Expression]Expression
+	Pairs []HashPair  // in source order
+}
+
+// HashPair is a key and its value in a HashLiteral
+type HashPair struct {
+	Key   Expression
+	Value Expression
 }
 
 func (hl *HashLiteral) expressionNode()      {}
@@ -339,8 +389,8 @@ func (hl *HashLiteral) String() string {
 	var out bytes.Buffer
 
 	pairs := []string{}
-	for key, value := range hl.Pairs {
-		pairs = append(pairs, key.String()+":"+value.String())
+	for _, pair := range hl.Pairs {
+		pairs = append(pairs, pair.Key.String()+":"+pair.Value.String())
 	}
 
 	out.WriteString("{")
@@ -376,3 +426,72 @@ func (ml *MacroLiteral) String() string {
 
 	return out.String()
 }
+
+// -------------------------------- TYPES -------------------------------
+
+// TypeExpression is an optional type annotation. Annotations are only read
+// by the static checker; the evaluator and the compiler ignore them.
+type TypeExpression interface {
+	Node
+	typeNode()
+}
+
+// NamedType is a basic type such as `int`, `bool`, `string` or `any`
+type NamedType struct {
+	Token token.Token // token.IDENT
+	Name  string
+}
+
+func (nt *NamedType) typeNode()            {}
+func (nt *NamedType) TokenLiteral() string { return nt.Token.Literal }
+func (nt *NamedType) String() string       { return nt.Name }
+
+// ArrayType is written `[T]`
+type ArrayType struct {
+	Token   token.Token // the '[' token
+	Element TypeExpression
+}
+
+func (at *ArrayType) typeNode()            {}
+func (at *ArrayType) TokenLiteral() string { return at.Token.Literal }
+func (at *ArrayType) String() string       { return "[" + at.Element.String() + "]" }
+
+// HashType is written `{K: V}`
+type HashType struct {
+	Token token.Token // the '{' token
+	Key   TypeExpression
+	Value TypeExpression
+}
+
+func (ht *HashType) typeNode()            {}
+func (ht *HashType) TokenLiteral() string { return ht.Token.Literal }
+func (ht *HashType) String() string {
+	return "{" + ht.Key.String() + ": " + ht.Value.String() + "}"
+}
+
+// FunctionType is written `fn(T1, T2) -> R`
+type FunctionType struct {
+	Token      token.Token // the 'fn' token
+	Parameters []TypeExpression
+	Return     TypeExpression // nil if no return type was given
+}
+
+func (ft *FunctionType) typeNode()            {}
+func (ft *FunctionType) TokenLiteral() string { return ft.Token.Literal }
+func (ft *FunctionType) String() string {
+	var out bytes.Buffer
+
+	params := []string{}
+	for _, p := range ft.Parameters {
+		params = append(params, p.String())
+	}
+
+	out.WriteString("fn(")
+	out.WriteString(strings.Join(params, ", "))
+	out.WriteString(")")
+	if ft.Return != nil {
+		out.WriteString(" -> " + ft.Return.String())
+	}
+
+	return out.String()
+}
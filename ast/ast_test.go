@@ -26,3 +26,18 @@ func TestString(t *testing.T) {
 		t.Errorf("program.String() wrong. got=%q", ps)
 	}
 }
+
+func TestAssignStatementString(t *testing.T) {
+	stmt := &AssignStatement{
+		Token: token.Token{Type: token.ASSIGN, Literal: "="},
+		Target: &IndexExpression{
+			Left:  &Identifier{Token: token.Token{Type: token.IDENT, Literal: "a"}, Value: "a"},
+			Index: &IntegerLiteral{Token: token.Token{Type: token.INT, Literal: "0"}, Value: 0},
+		},
+		Value: &Identifier{Token: token.Token{Type: token.IDENT, Literal: "b"}, Value: "b"},
+	}
+
+	if s := stmt.String(); s != "a[0] = b;" {
+		t.Errorf("stmt.String() wrong. got=%q", s)
+	}
+}
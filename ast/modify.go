@@ -41,6 +41,12 @@ func Modify(node Node, modifier ModifierFunc) Node {
 	case *LetStatement:
 		node.Value, _ = Modify(node.Value, modifier).(Expression)
 
+	case *AssignStatement:
+		if target, ok := Modify(node.Target, modifier).(*IndexExpression); ok {
+			node.Target = target
+		}
+		node.Value, _ = Modify(node.Value, modifier).(Expression)
+
 	case *FunctionLiteral:
 		for i, p := range node.Parameters {
 			node.Parameters[i], _ = Modify(p, modifier).(*Identifier)
@@ -54,13 +60,10 @@ func Modify(node Node, modifier ModifierFunc) Node {
 		}
 
 	case *HashLiteral:
-		newPairs := make(map[Expression]Expression)
-		for key, val := range node.Pairs {
-			newKey, _ := Modify(key, modifier).(Expression)
-			newVal, _ := Modify(val, modifier).(Expression)
-			newPairs[newKey] = newVal
+		for i, pair := range node.Pairs {
+			node.Pairs[i].Key, _ = Modify(pair.Key, modifier).(Expression)
+			node.Pairs[i].Value, _ = Modify(pair.Value, modifier).(Expression)
 		}
-		node.Pairs = newPairs
 	}
 
 	return modifier(node)
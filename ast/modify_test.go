@@ -56,6 +56,10 @@ func TestModify(t *testing.T) {
 			&IndexExpression{Left: one(), Index: one()},
 			&IndexExpression{Left: two(), Index: two()},
 		},
+		{
+			&AssignStatement{Target: &IndexExpression{Left: one(), Index: one()}, Value: one()},
+			&AssignStatement{Target: &IndexExpression{Left: two(), Index: two()}, Value: two()},
+		},
 		{
 			&IfExpression{
 				Condition: one(),
@@ -114,6 +118,10 @@ func TestModify(t *testing.T) {
 			&ArrayLiteral{Elements: []Expression{one(), two()}},
 			&ArrayLiteral{Elements: []Expression{two(), two()}},
 		},
+		{
+			&HashLiteral{Pairs: []HashPair{{Key: one(), Value: one()}, {Key: two(), Value: one()}}},
+			&HashLiteral{Pairs: []HashPair{{Key: two(), Value: two()}, {Key: two(), Value: two()}}},
+		},
 	}
 
 	for _, tt := range tests {
@@ -124,29 +132,4 @@ func TestModify(t *testing.T) {
 			t.Errorf("not equal. got=%#v, expected=%#v", modified, tt.expected)
 		}
 	}
-
-	/*
-	 * Due to the way `reflect.DeepEqual` works with maps having pointers for keys and values,
-	 * we need a separate section `*ast.HashLiterals`.
-	 */
-	hashLiteral := &HashLiteral{
-		Pairs: map[Expression]Expression{
-			one(): one(),
-			one(): one(),
-		},
-	}
-
-	Modify(hashLiteral, turnOneIntoTwo)
-
-	for key, val := range hashLiteral.Pairs {
-		key, _ := key.(*IntegerLiteral)
-		if key.Value != 2 {
-			t.Errorf("value is not %d, got=%d", 2, key.Value)
-		}
-
-		val, _ := val.(*IntegerLiteral)
-		if val.Value != 2 {
-			t.Errorf("value is not %d, got=%d", 2, val.Value)
-		}
-	}
 }
@@ -12,7 +12,7 @@ import (
 	"time"
 )
 
-var engine = flag.String("engine", "vm", "use 'vm' or 'eval'")
+var engine = flag.String("engine", "vm", "use 'vm', 'register', 'eval' or 'closure'")
 
 var input = `
    let fibonacci = fn(x) {
@@ -38,8 +38,13 @@ func main() {
 	p := parser.New(l)
 	program := p.ParseProgram()
 
-	if *engine == "vm" {
-		comp := compiler.New()
+	if *engine == "vm" || *engine == "register" {
+		var options []compiler.Option
+		if *engine == "register" {
+			options = append(options, compiler.WithRegisters())
+		}
+
+		comp := compiler.New(options...)
 		err := comp.Compile(program)
 		if err != nil {
 			fmt.Printf("compiler error: %s", err)
@@ -58,6 +63,12 @@ func main() {
 		duration = time.Since(start)
 		result = machine.LastPoppedStackElem()
 
+	} else if *engine == "closure" {
+		compiled := evaluator.Compile(program)
+		start := time.Now()
+		result = compiled.Run()
+		duration = time.Since(start)
+
 	} else {
 		env := object.NewEnvironment()
 		start := time.Now()
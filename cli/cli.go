@@ -0,0 +1,635 @@
+// Package cli implements the monc command line: running and building
+// Monkey programs and starting the REPL
+package cli
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"monc/asm"
+	"monc/ast"
+	"monc/compiler"
+	"monc/debugger"
+	"monc/disasm"
+	"monc/evaluator"
+	"monc/gogen"
+	"monc/lexer"
+	"monc/mkc"
+	"monc/object"
+	"monc/parser"
+	"monc/profiler"
+	"monc/repl"
+	"monc/types"
+	"monc/vm"
+	"os"
+	"os/user"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// exit codes
+const (
+	ExitOK      = 0
+	ExitRuntime = 1 // the program failed while running
+	ExitUsage   = 2 // bad command line
+	ExitCompile = 3 // the program could not be parsed, type checked or compiled
+)
+
+// App is one invocation of the command line. Output of the Monkey
+// program itself (e.g. `puts`) always goes to os.Stdout.
+type App struct {
+	Stdin  io.Reader
+	Stdout io.Writer
+	Stderr io.Writer
+}
+
+type command struct {
+	usage string
+	run   func(a *App, args []string) int
+}
+
+var commands map[string]command
+
+func init() {
+	commands = map[string]command{
+		"run":    {"run [--engine=vm|register|eval|closure] [--profile FILE] [--trace] [--trace-function NAME] FILE.mk|FILE.mkc", (*App).run},
+		"build":  {"build FILE.mk [-o FILE.mkc]", (*App).build},
+		"eval":   {"eval [--engine=vm|register|eval|closure] -e SOURCE", (*App).eval},
+		"disasm": {"disasm FILE.mk|FILE.mkc", (*App).disasm},
+		"debug":  {"debug [--engine=vm|register] FILE.mk", (*App).debug},
+		"asm":    {"asm FILE.asm [-o FILE.mkc]", (*App).asm},
+		"gogen":  {"gogen FILE.mk [-o FILE.go]", (*App).gogen},
+		"repl":   {"repl", (*App).repl},
+		"help":   {"help", (*App).help},
+	}
+}
+
+// Main runs the command line with the process' standard streams and
+// returns the exit code
+func Main(args []string) int {
+	app := &App{Stdin: os.Stdin, Stdout: os.Stdout, Stderr: os.Stderr}
+	return app.Run(args)
+}
+
+func (a *App) Run(args []string) int {
+	if len(args) == 0 {
+		return a.repl(nil)
+	}
+
+	cmd, ok := commands[args[0]]
+	if !ok {
+		fmt.Fprintf(a.Stderr, "monc: unknown command %q\n", args[0])
+		a.printUsage()
+		return ExitUsage
+	}
+
+	return cmd.run(a, args[1:])
+}
+
+func (a *App) help(args []string) int {
+	a.printUsage()
+	return ExitOK
+}
+
+func (a *App) printUsage() {
+	names := []string{}
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	fmt.Fprintf(a.Stderr, "usage:\n")
+	fmt.Fprintf(a.Stderr, "  monc                  start the REPL\n")
+	for _, name := range names {
+		fmt.Fprintf(a.Stderr, "  monc %s\n", commands[name].usage)
+	}
+}
+
+func (a *App) repl(args []string) int {
+	name := "there"
+	if u, err := user.Current(); err == nil {
+		name = u.Username
+	}
+
+	fmt.Fprintf(a.Stdout, "Hello %s! This is the Monkey programming language!\n", name)
+	fmt.Fprintf(a.Stdout, "Feel free to type in commands\n")
+	repl.Start(a.Stdin, a.Stdout)
+
+	return ExitOK
+}
+
+func (a *App) run(args []string) int {
+	fs := a.flagSet("run")
+	engine := fs.String("engine", "vm", "use 'vm', 'register', 'eval' or 'closure'")
+	profile := fs.String("profile", "", "write a pprof profile of the program to `FILE`")
+	trace := fs.Bool("trace", false, "write every instruction executed to stderr")
+	traceFunction := fs.String("trace-function", "", "trace only the function `NAME`, implies --trace")
+
+	files, ok := a.parseFlags(fs, args)
+	if !ok {
+		return ExitUsage
+	}
+	if len(files) != 1 {
+		return a.usageError("run", "expected exactly one file")
+	}
+	if !validEngine(*engine) {
+		return a.usageError("run", fmt.Sprintf("unknown engine %q", *engine))
+	}
+	*trace = *trace || *traceFunction != ""
+	if (*profile != "" || *trace) && (*engine == "eval" || *engine == "closure") {
+		return a.usageError("run", "only the VM can be profiled or traced")
+	}
+	if *profile != "" && *trace {
+		return a.usageError("run", "--profile and --trace cannot be combined")
+	}
+
+	path := files[0]
+
+	var options []vm.Option
+	if *profile != "" {
+		prof := profiler.New()
+		options = append(options, vm.WithHook(prof))
+		defer func() { a.writeProfile(prof, *profile, path) }()
+	}
+	if *trace {
+		tracer := vm.NewTracer(a.Stderr)
+		if *traceFunction != "" {
+			tracer.Functions = []string{*traceFunction}
+		}
+		options = append(options, vm.WithHook(tracer))
+	}
+
+	if filepath.Ext(path) == ".mkc" {
+		if *engine != "vm" {
+			return a.usageError("run", "compiled files can only be run with --engine=vm")
+		}
+
+		bytecode, code := a.loadCompiled(path)
+		if code != ExitOK {
+			return code
+		}
+
+		// unlike the compiler's output, files can contain anything
+		machine, err := vm.NewVerified(bytecode, options...)
+		if err != nil {
+			fmt.Fprintf(a.Stderr, "monc: %s: %s\n", path, err)
+			return ExitCompile
+		}
+
+		_, code = a.runVM(machine)
+		return code
+	}
+
+	src, err := os.ReadFile(path)
+	if err != nil {
+		fmt.Fprintf(a.Stderr, "monc: %s\n", err)
+		return ExitUsage
+	}
+
+	_, code := a.execute(path, string(src), *engine, options...)
+	return code
+}
+
+// writeProfile writes the profile of the program that ran, if it did, to
+// path and a report to stderr
+func (a *App) writeProfile(prof *profiler.Profiler, path, source string) {
+	prof.Stop()
+	if len(prof.Functions()) == 0 {
+		return
+	}
+
+	f, err := os.Create(path)
+	if err == nil {
+		err = prof.WritePprof(f, source)
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+	}
+	if err != nil {
+		fmt.Fprintf(a.Stderr, "monc: writing profile: %s\n", err)
+	}
+
+	prof.WriteReport(a.Stderr)
+}
+
+func (a *App) eval(args []string) int {
+	fs := a.flagSet("eval")
+	engine := fs.String("engine", "vm", "use 'vm', 'register', 'eval' or 'closure'")
+	source := fs.String("e", "", "the program to evaluate")
+
+	rest, ok := a.parseFlags(fs, args)
+	if !ok {
+		return ExitUsage
+	}
+	if len(rest) != 0 || *source == "" {
+		return a.usageError("eval", "expected a program to evaluate with -e")
+	}
+	if !validEngine(*engine) {
+		return a.usageError("eval", fmt.Sprintf("unknown engine %q", *engine))
+	}
+
+	result, code := a.execute("-e", *source, *engine)
+	if code == ExitOK && result != nil {
+		fmt.Fprintln(a.Stdout, result.Inspect())
+	}
+
+	return code
+}
+
+// debug runs a program in the debugger, which reads its commands from
+// stdin. Output of the program itself goes to os.Stdout as usual.
+func (a *App) debug(args []string) int {
+	fs := a.flagSet("debug")
+	engine := fs.String("engine", "vm", "use 'vm' or 'register'")
+
+	files, ok := a.parseFlags(fs, args)
+	if !ok {
+		return ExitUsage
+	}
+	if len(files) != 1 {
+		return a.usageError("debug", "expected exactly one file")
+	}
+	if *engine != "vm" && *engine != "register" {
+		return a.usageError("debug", fmt.Sprintf("unknown engine %q", *engine))
+	}
+
+	path := files[0]
+	src, err := os.ReadFile(path)
+	if err != nil {
+		fmt.Fprintf(a.Stderr, "monc: %s\n", err)
+		return ExitUsage
+	}
+
+	var options []compiler.Option
+	if *engine == "register" {
+		options = append(options, compiler.WithRegisters())
+	}
+
+	bytecode, code := a.compile(path, string(src), options...)
+	if code != ExitOK {
+		return code
+	}
+
+	d := debugger.New(string(src), a.Stdin, a.Stdout)
+	result, code := a.runVM(vm.New(bytecode, vm.WithHook(d)))
+	if code == ExitOK && result != nil {
+		fmt.Fprintf(a.Stdout, "program finished: %s\n", result.Inspect())
+	}
+	return code
+}
+
+func (a *App) build(args []string) int {
+	fs := a.flagSet("build")
+	output := fs.String("o", "", "output file, defaults to FILE.mkc")
+
+	files, ok := a.parseFlags(fs, args)
+	if !ok {
+		return ExitUsage
+	}
+	if len(files) != 1 {
+		return a.usageError("build", "expected exactly one file")
+	}
+
+	path := files[0]
+	if *output == "" {
+		*output = strings.TrimSuffix(path, filepath.Ext(path)) + ".mkc"
+	}
+
+	src, err := os.ReadFile(path)
+	if err != nil {
+		fmt.Fprintf(a.Stderr, "monc: %s\n", err)
+		return ExitUsage
+	}
+
+	bytecode, code := a.compile(path, string(src))
+	if code != ExitOK {
+		return code
+	}
+
+	file := &mkc.File{
+		Instructions: bytecode.Instructions,
+		Constants:    bytecode.Constants,
+		MaxStack:     bytecode.MaxStack,
+		Source:       path,
+		Lines:        bytecode.Lines,
+		GlobalNames:  bytecode.GlobalNames,
+	}
+
+	return a.writeCompiled(*output, file)
+}
+
+func (a *App) asm(args []string) int {
+	fs := a.flagSet("asm")
+	output := fs.String("o", "", "output file, defaults to FILE.mkc")
+
+	files, ok := a.parseFlags(fs, args)
+	if !ok {
+		return ExitUsage
+	}
+	if len(files) != 1 {
+		return a.usageError("asm", "expected exactly one file")
+	}
+
+	path := files[0]
+	if *output == "" {
+		*output = strings.TrimSuffix(path, filepath.Ext(path)) + ".mkc"
+	}
+
+	src, err := os.ReadFile(path)
+	if err != nil {
+		fmt.Fprintf(a.Stderr, "monc: %s\n", err)
+		return ExitUsage
+	}
+
+	// not verified here, so files for testing the verifier can be written;
+	// `monc run` verifies them
+	bytecode, err := asm.Assemble(string(src))
+	if err != nil {
+		fmt.Fprintf(a.Stderr, "%s: %s\n", path, err)
+		return ExitCompile
+	}
+
+	file := &mkc.File{
+		Instructions: bytecode.Instructions,
+		Constants:    bytecode.Constants,
+		MaxStack:     bytecode.MaxStack,
+	}
+
+	return a.writeCompiled(*output, file)
+}
+
+// gogen translates a program to Go, written to stdout unless -o is given
+func (a *App) gogen(args []string) int {
+	fs := a.flagSet("gogen")
+	output := fs.String("o", "", "output file")
+
+	files, ok := a.parseFlags(fs, args)
+	if !ok {
+		return ExitUsage
+	}
+	if len(files) != 1 {
+		return a.usageError("gogen", "expected exactly one file")
+	}
+
+	path := files[0]
+	src, err := os.ReadFile(path)
+	if err != nil {
+		fmt.Fprintf(a.Stderr, "monc: %s\n", err)
+		return ExitUsage
+	}
+
+	program, code := a.parse(path, string(src))
+	if code != ExitOK {
+		return code
+	}
+
+	generated, err := gogen.Generate(program)
+	if err != nil {
+		fmt.Fprintf(a.Stderr, "%s: %s\n", path, err)
+		return ExitCompile
+	}
+
+	if *output == "" {
+		a.Stdout.Write(generated)
+		return ExitOK
+	}
+
+	if err := os.WriteFile(*output, generated, 0o644); err != nil {
+		fmt.Fprintf(a.Stderr, "monc: %s\n", err)
+		return ExitUsage
+	}
+	return ExitOK
+}
+
+func (a *App) writeCompiled(path string, file *mkc.File) int {
+	out, err := os.Create(path)
+	if err != nil {
+		fmt.Fprintf(a.Stderr, "monc: %s\n", err)
+		return ExitUsage
+	}
+
+	err = file.Encode(out)
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(path)
+		fmt.Fprintf(a.Stderr, "monc: writing %s: %s\n", path, err)
+		return ExitCompile
+	}
+
+	return ExitOK
+}
+
+func (a *App) disasm(args []string) int {
+	fs := a.flagSet("disasm")
+
+	files, ok := a.parseFlags(fs, args)
+	if !ok {
+		return ExitUsage
+	}
+	if len(files) != 1 {
+		return a.usageError("disasm", "expected exactly one file")
+	}
+
+	bytecode, code := a.load(files[0])
+	if code != ExitOK {
+		return code
+	}
+
+	if err := disasm.Fprint(a.Stdout, bytecode); err != nil {
+		fmt.Fprintf(a.Stderr, "monc: %s\n", err)
+		return ExitRuntime
+	}
+
+	return ExitOK
+}
+
+// load compiles a source file or reads a compiled one, depending on its
+// extension
+func (a *App) load(path string) (*compiler.Bytecode, int) {
+	if filepath.Ext(path) == ".mkc" {
+		return a.loadCompiled(path)
+	}
+
+	src, err := os.ReadFile(path)
+	if err != nil {
+		fmt.Fprintf(a.Stderr, "monc: %s\n", err)
+		return nil, ExitUsage
+	}
+
+	return a.compile(path, string(src))
+}
+
+// execute runs source with the given engine and returns the value of the
+// last expression statement. The options are those of the VM.
+func (a *App) execute(name, source, engine string, options ...vm.Option) (object.Object, int) {
+	if engine == "eval" || engine == "closure" {
+		program, code := a.parse(name, source)
+		if code != ExitOK {
+			return nil, code
+		}
+
+		env := object.NewEnvironment()
+		macroEnv := object.NewEnvironment()
+		evaluator.DefineMacros(program, macroEnv)
+		expanded := evaluator.ExpandMacros(program, macroEnv)
+
+		var result object.Object
+		if engine == "closure" {
+			result = evaluator.Compile(expanded.(*ast.Program)).Run()
+		} else {
+			result = evaluator.Eval(expanded, env)
+		}
+		if err, ok := result.(*object.Error); ok {
+			fmt.Fprintf(a.Stderr, "%s: runtime error: %s\n", name, err.Message)
+			return nil, ExitRuntime
+		}
+		return result, ExitOK
+	}
+
+	var compilerOptions []compiler.Option
+	if engine == "register" {
+		compilerOptions = append(compilerOptions, compiler.WithRegisters())
+	}
+
+	bytecode, code := a.compile(name, source, compilerOptions...)
+	if code != ExitOK {
+		return nil, code
+	}
+
+	return a.runVM(vm.New(bytecode, options...))
+}
+
+func validEngine(engine string) bool {
+	return engine == "vm" || engine == "register" || engine == "eval" || engine == "closure"
+}
+
+func (a *App) runVM(machine *vm.VM) (object.Object, int) {
+	if err := machine.Run(); err != nil {
+		if errors.Is(err, debugger.ErrQuit) {
+			return nil, ExitOK
+		}
+
+		fmt.Fprintf(a.Stderr, "runtime error: %s\n", err)
+
+		var runtimeErr *vm.RuntimeError
+		if errors.As(err, &runtimeErr) {
+			for _, line := range runtimeErr.Trace.Lines() {
+				fmt.Fprintf(a.Stderr, "\t%s\n", line)
+			}
+		}
+		return nil, ExitRuntime
+	}
+
+	result := machine.LastPoppedStackElem()
+	if err, ok := result.(*object.Error); ok {
+		fmt.Fprintf(a.Stderr, "runtime error: %s\n", err.Message)
+		return nil, ExitRuntime
+	}
+
+	return result, ExitOK
+}
+
+// parse parses and type checks source, reporting errors prefixed with name
+func (a *App) parse(name, source string) (*ast.Program, int) {
+	p := parser.New(lexer.New(source))
+	program := p.ParseProgram()
+
+	if len(p.Errors()) != 0 {
+		for _, msg := range p.Errors() {
+			fmt.Fprintf(a.Stderr, "%s: %s\n", name, msg)
+		}
+		return nil, ExitCompile
+	}
+
+	if errs := types.Check(program); len(errs) != 0 {
+		for _, err := range errs {
+			fmt.Fprintf(a.Stderr, "%s:%s\n", name, err)
+		}
+		return nil, ExitCompile
+	}
+
+	return program, ExitOK
+}
+
+func (a *App) compile(name, source string, options ...compiler.Option) (*compiler.Bytecode, int) {
+	program, code := a.parse(name, source)
+	if code != ExitOK {
+		return nil, code
+	}
+
+	comp := compiler.New(options...)
+	if err := comp.Compile(program); err != nil {
+		fmt.Fprintf(a.Stderr, "%s: compile error: %s\n", name, err)
+		return nil, ExitCompile
+	}
+
+	for _, d := range comp.Diagnostics() {
+		fmt.Fprintf(a.Stderr, "%s:%s\n", name, d)
+	}
+
+	bytecode := comp.Bytecode()
+	bytecode.Source = name
+
+	return bytecode, ExitOK
+}
+
+func (a *App) loadCompiled(path string) (*compiler.Bytecode, int) {
+	f, err := os.Open(path)
+	if err != nil {
+		fmt.Fprintf(a.Stderr, "monc: %s\n", err)
+		return nil, ExitUsage
+	}
+	defer f.Close()
+
+	file, err := mkc.Decode(f)
+	if err != nil {
+		fmt.Fprintf(a.Stderr, "monc: %s: %s\n", path, err)
+		return nil, ExitCompile
+	}
+
+	bytecode := &compiler.Bytecode{
+		Instructions: file.Instructions,
+		Constants:    file.Constants,
+		MaxStack:     file.MaxStack,
+		Source:       file.Source,
+		Lines:        file.Lines,
+		GlobalNames:  file.GlobalNames,
+	}
+
+	return bytecode, ExitOK
+}
+
+func (a *App) flagSet(name string) *flag.FlagSet {
+	fs := flag.NewFlagSet("monc "+name, flag.ContinueOnError)
+	fs.SetOutput(a.Stderr)
+	return fs
+}
+
+// parseFlags parses args, allowing flags after positional arguments as in
+// `monc build file.mk -o file.mkc`
+func (a *App) parseFlags(fs *flag.FlagSet, args []string) ([]string, bool) {
+	positional := []string{}
+
+	for {
+		if err := fs.Parse(args); err != nil {
+			return nil, false
+		}
+
+		args = fs.Args()
+		if len(args) == 0 {
+			return positional, true
+		}
+
+		positional = append(positional, args[0])
+		args = args[1:]
+	}
+}
+
+func (a *App) usageError(cmd, msg string) int {
+	fmt.Fprintf(a.Stderr, "monc %s: %s\nusage: monc %s\n", cmd, msg, commands[cmd].usage)
+	return ExitUsage
+}
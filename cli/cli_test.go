@@ -0,0 +1,399 @@
+package cli
+
+import (
+	"bytes"
+	"monc/code"
+	"monc/mkc"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func runApp(args ...string) (string, string, int) {
+	var stdout, stderr bytes.Buffer
+	app := &App{Stdin: strings.NewReader(""), Stdout: &stdout, Stderr: &stderr}
+	code := app.Run(args)
+	return stdout.String(), stderr.String(), code
+}
+
+func writeFile(t *testing.T, name, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatalf("write failed: %s", err)
+	}
+	return path
+}
+
+func TestEval(t *testing.T) {
+	tests := []struct {
+		args   []string
+		stdout string
+		stderr string
+		code   int
+	}{
+		{[]string{"eval", "-e", "5 * 5"}, "25\n", "", ExitOK},
+		{[]string{"eval", "--engine=eval", "-e", "5 * 5"}, "25\n", "", ExitOK},
+		{[]string{"eval", "--engine=register", "-e", "5 * 5"}, "25\n", "", ExitOK},
+		{[]string{"eval", "--engine=closure", "-e", "5 * 5"}, "25\n", "", ExitOK},
+		{[]string{"eval", "-e", `"a" + "b"`}, "ab\n", "", ExitOK},
+		{[]string{"eval", "-e", `{"b": 1, "a": 2, 0: 3}`}, "{b: 1, a: 2, 0: 3}\n", "", ExitOK},
+		{[]string{"eval", "--engine=register", "-e", `{"b": 1, "a": 2, 0: 3}`}, "{b: 1, a: 2, 0: 3}\n", "", ExitOK},
+		{[]string{"eval", "--engine=eval", "-e", `{"b": 1, "a": 2, 0: 3}`}, "{b: 1, a: 2, 0: 3}\n", "", ExitOK},
+		{[]string{"eval", "--engine=closure", "-e", `{"b": 1, "a": 2, 0: 3}`}, "{b: 1, a: 2, 0: 3}\n", "", ExitOK},
+		{[]string{"eval", "-e", "let x = ;"}, "", "-e: no prefix parse function for ;", ExitCompile},
+		{[]string{"eval", "-e", `1 + "a"`}, "", "-e:1:3: invalid operation: int + string", ExitCompile},
+		{[]string{"eval", "-e", "y"}, "", "-e: compile error: undefined variable y", ExitCompile},
+		{[]string{"eval", "-e", "let f = fn(x) { x }; f(1, 2)"}, "", "wrong number of arguments", ExitCompile},
+		{[]string{"eval", "-e", "len(1)"}, "", "runtime error: argument to `len` not supported", ExitRuntime},
+		{[]string{"eval", "--engine=eval", "-e", "len(1)"}, "", "runtime error: argument to `len` not supported", ExitRuntime},
+		{[]string{"eval", "--engine=closure", "-e", "len(1)"}, "", "runtime error: argument to `len` not supported", ExitRuntime},
+		{[]string{"eval", "--engine=jit", "-e", "1"}, "", `unknown engine "jit"`, ExitUsage},
+		{[]string{"eval"}, "", "expected a program to evaluate", ExitUsage},
+	}
+
+	for _, tt := range tests {
+		stdout, stderr, code := runApp(tt.args...)
+
+		if code != tt.code {
+			t.Errorf("%v: wrong exit code. want=%d, got=%d (stderr=%q)", tt.args, tt.code, code, stderr)
+		}
+		if stdout != tt.stdout {
+			t.Errorf("%v: wrong output. want=%q, got=%q", tt.args, tt.stdout, stdout)
+		}
+		if !strings.Contains(stderr, tt.stderr) {
+			t.Errorf("%v: stderr does not contain %q. got=%q", tt.args, tt.stderr, stderr)
+		}
+	}
+}
+
+func TestDebug(t *testing.T) {
+	path := writeFile(t, "main.mk", "let add = fn(a, b) { a + b };\nadd(1, 2);\n")
+
+	for _, engine := range []string{"vm", "register"} {
+		var stdout, stderr bytes.Buffer
+		app := &App{Stdin: strings.NewReader("b add\nc\nlocals\nc\n"), Stdout: &stdout, Stderr: &stderr}
+		code := app.Run([]string{"debug", "--engine=" + engine, path})
+
+		if code != ExitOK {
+			t.Errorf("engine %s: wrong exit code %d (stderr=%q)", engine, code, stderr.String())
+		}
+		for _, want := range []string{"breakpoint 1 at function add", "a = 1\nb = 2\n", "program finished: 3\n"} {
+			if !strings.Contains(stdout.String(), want) {
+				t.Errorf("engine %s: output does not contain %q. got=%q", engine, want, stdout.String())
+			}
+		}
+	}
+
+	// the input ends, which quits
+	if _, stderr, code := runApp("debug", path); code != ExitOK {
+		t.Errorf("wrong exit code for quitting. want=%d, got=%d (stderr=%q)", ExitOK, code, stderr)
+	}
+
+	if _, _, code := runApp("debug", "--engine=eval", path); code != ExitUsage {
+		t.Errorf("wrong exit code for the evaluator. want=%d, got=%d", ExitUsage, code)
+	}
+}
+
+func TestRun(t *testing.T) {
+	path := writeFile(t, "main.mk", `let add = fn(a, b) { a + b }; add(1, 2);`)
+
+	for _, engine := range []string{"vm", "register", "eval", "closure"} {
+		_, stderr, code := runApp("run", "--engine="+engine, path)
+		if code != ExitOK {
+			t.Errorf("engine %s: wrong exit code %d (stderr=%q)", engine, code, stderr)
+		}
+	}
+
+	failing := writeFile(t, "fail.mk", `let id = fn(x) { x }; id([1]) + 1;`)
+	if _, _, code := runApp("run", failing); code != ExitRuntime {
+		t.Errorf("wrong exit code for runtime error. want=%d, got=%d", ExitRuntime, code)
+	}
+
+	if _, _, code := runApp("run", filepath.Join(t.TempDir(), "missing.mk")); code != ExitUsage {
+		t.Errorf("wrong exit code for missing file. want=%d, got=%d", ExitUsage, code)
+	}
+}
+
+func TestRunProfile(t *testing.T) {
+	path := writeFile(t, "main.mk", "let add = fn(a, b) { a + b };\nadd(1, 2);\n")
+
+	for _, engine := range []string{"vm", "register"} {
+		out := filepath.Join(t.TempDir(), "prof.pb.gz")
+		_, stderr, code := runApp("run", "--engine="+engine, "--profile", out, path)
+		if code != ExitOK {
+			t.Errorf("engine %s: wrong exit code %d (stderr=%q)", engine, code, stderr)
+		}
+		for _, want := range []string{"add (line 1)", "<main> (line 1)", "instructions  opcode"} {
+			if !strings.Contains(stderr, want) {
+				t.Errorf("engine %s: report does not contain %q. got=%q", engine, want, stderr)
+			}
+		}
+		if info, err := os.Stat(out); err != nil || info.Size() == 0 {
+			t.Errorf("engine %s: no profile written: %v", engine, err)
+		}
+	}
+
+	_, stderr, code := runApp("run", "--engine=eval", "--profile", "prof.pb.gz", path)
+	if code != ExitUsage || !strings.Contains(stderr, "only the VM can be profiled or traced") {
+		t.Errorf("wrong result for the evaluator: code=%d, stderr=%q", code, stderr)
+	}
+}
+
+func TestRunTrace(t *testing.T) {
+	path := writeFile(t, "main.mk", "let add = fn(a, b) { a + b };\nadd(1, 2);\n")
+
+	_, stderr, code := runApp("run", "--trace-function", "add", path)
+	if code != ExitOK {
+		t.Fatalf("wrong exit code %d (stderr=%q)", code, stderr)
+	}
+	want := "  2 add          0004 OpAdd                        [1 2]\n"
+	if !strings.Contains(stderr, want) || strings.Contains(stderr, "<main>") {
+		t.Errorf("wrong trace. want only add, with %q. got=%q", want, stderr)
+	}
+
+	if _, _, code := runApp("run", "--trace", "--profile", "prof.pb.gz", path); code != ExitUsage {
+		t.Errorf("wrong exit code for --trace with --profile. want=%d, got=%d", ExitUsage, code)
+	}
+}
+
+func TestBuildAndRunCompiled(t *testing.T) {
+	path := writeFile(t, "prog.mk", `let double = fn(x) { x * 2 }; double(21);`)
+	output := filepath.Join(filepath.Dir(path), "prog.mkc")
+
+	// flags may follow the file name
+	if _, stderr, code := runApp("build", path, "-o", output); code != ExitOK {
+		t.Fatalf("build failed with %d: %s", code, stderr)
+	}
+
+	if _, stderr, code := runApp("run", output); code != ExitOK {
+		t.Fatalf("run failed with %d: %s", code, stderr)
+	}
+
+	if _, _, code := runApp("run", "--engine=eval", output); code != ExitUsage {
+		t.Errorf("wrong exit code for eval engine on compiled file. want=%d, got=%d", ExitUsage, code)
+	}
+
+	corrupt := writeFile(t, "bad.mkc", "not bytecode")
+	if _, _, code := runApp("run", corrupt); code != ExitCompile {
+		t.Errorf("wrong exit code for corrupt file. want=%d, got=%d", ExitCompile, code)
+	}
+}
+
+func TestBuildAndRunBigInteger(t *testing.T) {
+	path := writeFile(t, "big.mk", `99999999999999999999 + 1;`)
+	output := strings.TrimSuffix(path, ".mk") + ".mkc"
+
+	if _, stderr, code := runApp("build", path); code != ExitOK {
+		t.Fatalf("build failed with %d: %s", code, stderr)
+	}
+
+	// the file has to pass the verifier run and asm go through
+	if _, stderr, code := runApp("run", output); code != ExitOK {
+		t.Fatalf("run failed with %d: %s", code, stderr)
+	}
+
+	// and through the assembler, from the listing of the compiled file
+	listing, stderr, code := runApp("disasm", output)
+	if code != ExitOK {
+		t.Fatalf("disasm failed with %d: %s", code, stderr)
+	}
+	source := writeFile(t, "big.asm", listing)
+	if _, stderr, code := runApp("asm", source); code != ExitOK {
+		t.Fatalf("asm failed with %d: %s", code, stderr)
+	}
+
+	if _, stderr, code := runApp("run", strings.TrimSuffix(source, ".asm")+".mkc"); code != ExitOK {
+		t.Fatalf("run of assembled file failed with %d: %s", code, stderr)
+	}
+}
+
+func TestBuildDefaultOutput(t *testing.T) {
+	path := writeFile(t, "prog.mk", `1 + 2`)
+
+	if _, stderr, code := runApp("build", path); code != ExitOK {
+		t.Fatalf("build failed with %d: %s", code, stderr)
+	}
+
+	if _, err := os.Stat(strings.TrimSuffix(path, ".mk") + ".mkc"); err != nil {
+		t.Errorf("default output not written: %s", err)
+	}
+}
+
+func TestBuildWrongArgumentCount(t *testing.T) {
+	// the type checker runs first and stops the build, so the compiler's
+	// diagnostic of the same call is never printed
+	checked := writeFile(t, "checked.mk", "let f = fn(a, b) { a };\nf(1);\n")
+	_, stderr, code := runApp("build", checked)
+	if code != ExitCompile {
+		t.Errorf("wrong exit code for checked call. want=%d, got=%d (stderr=%q)", ExitCompile, code, stderr)
+	}
+	if !strings.Contains(stderr, "checked.mk:2:2: wrong number of arguments: want=2, got=1") ||
+		strings.Contains(stderr, "[wrong-argument-count]") {
+		t.Errorf("wrong diagnostics for checked call. got=%q", stderr)
+	}
+
+	// a function declared as any is not checked, so only the compiler
+	// reports the call, which does not stop the build
+	unchecked := writeFile(t, "unchecked.mk", "let f: any = fn(a, b) { a + b };\nf(1);\n")
+	_, stderr, code = runApp("build", unchecked)
+	if code != ExitOK {
+		t.Errorf("wrong exit code for unchecked call. want=%d, got=%d (stderr=%q)", ExitOK, code, stderr)
+	}
+	if !strings.Contains(stderr, "unchecked.mk:2:1: error: f called with 1 arguments, want 2 [wrong-argument-count]") {
+		t.Errorf("wrong diagnostics for unchecked call. got=%q", stderr)
+	}
+}
+
+func TestUsageErrors(t *testing.T) {
+	tests := [][]string{
+		{"bogus"},
+		{"run"},
+		{"run", "a.mk", "b.mk"},
+		{"build"},
+		{"run", "--nope", "a.mk"},
+	}
+
+	for _, args := range tests {
+		_, stderr, code := runApp(args...)
+		if code != ExitUsage {
+			t.Errorf("%v: wrong exit code. want=%d, got=%d", args, ExitUsage, code)
+		}
+		if !strings.Contains(stderr, "usage:") && !strings.Contains(stderr, "Usage") {
+			t.Errorf("%v: no usage printed. got=%q", args, stderr)
+		}
+	}
+}
+
+func TestGogen(t *testing.T) {
+	path := writeFile(t, "prog.mk", `let double = fn(x) { x * 2 }; puts(double(21));`)
+
+	stdout, stderr, code := runApp("gogen", path)
+	if code != ExitOK {
+		t.Fatalf("gogen failed with %d: %s", code, stderr)
+	}
+	if !strings.HasPrefix(stdout, "// Code generated by monc gogen. DO NOT EDIT.") ||
+		!strings.Contains(stdout, "rt.Mul(m1_x, rt.Int(2))") {
+		t.Errorf("unexpected output:\n%s", stdout)
+	}
+
+	output := filepath.Join(filepath.Dir(path), "prog.go")
+	if _, stderr, code := runApp("gogen", path, "-o", output); code != ExitOK {
+		t.Fatalf("gogen -o failed with %d: %s", code, stderr)
+	}
+	if written, err := os.ReadFile(output); err != nil || string(written) != stdout {
+		t.Errorf("wrong file written: %v", err)
+	}
+
+	bad := writeFile(t, "bad.mk", `quote(1)`)
+	if _, stderr, code := runApp("gogen", bad); code != ExitCompile || !strings.Contains(stderr, "quote") {
+		t.Errorf("wrong result for quote. code=%d, stderr=%q", code, stderr)
+	}
+}
+
+func TestDisasm(t *testing.T) {
+	path := writeFile(t, "prog.mk", `let answer = 42; puts(answer);`)
+
+	stdout, stderr, code := runApp("disasm", path)
+	if code != ExitOK {
+		t.Fatalf("disasm failed with %d: %s", code, stderr)
+	}
+
+	for _, want := range []string{"OpSetGlobal 0          ; answer", "OpGetBuiltin 1         ; puts"} {
+		if !strings.Contains(stdout, want) {
+			t.Errorf("listing does not contain %q. got=\n%s", want, stdout)
+		}
+	}
+}
+
+func TestRunRefusesUnverifiedBytecode(t *testing.T) {
+	var buf bytes.Buffer
+	file := &mkc.File{Instructions: code.Make(code.OpPop)}
+	if err := file.Encode(&buf); err != nil {
+		t.Fatalf("encode failed: %s", err)
+	}
+
+	path := writeFile(t, "bad.mkc", buf.String())
+
+	_, stderr, exit := runApp("run", path)
+	if exit != ExitCompile {
+		t.Errorf("wrong exit code. want=%d, got=%d", ExitCompile, exit)
+	}
+	if !strings.Contains(stderr, "verify: main at 0000") {
+		t.Errorf("verifier error not reported. got=%q", stderr)
+	}
+}
+
+func TestRuntimeErrorStackTrace(t *testing.T) {
+	path := writeFile(t, "trace.mk", "let f = fn(x) {\n  x()\n};\nf(1);")
+	compiled := strings.TrimSuffix(path, ".mk") + ".mkc"
+
+	if _, stderr, exit := runApp("build", path); exit != ExitOK {
+		t.Fatalf("build failed with %d: %s", exit, stderr)
+	}
+
+	for _, file := range []string{path, compiled} {
+		_, stderr, exit := runApp("run", file)
+		if exit != ExitRuntime {
+			t.Errorf("%s: wrong exit code. want=%d, got=%d", file, ExitRuntime, exit)
+		}
+
+		expected := "\tat f (" + path + ":2:4)\n\tat <main> (" + path + ":4:2)\n"
+		if !strings.HasSuffix(stderr, expected) {
+			t.Errorf("%s: wrong stack trace.\nwant=%q\ngot =%q", file, expected, stderr)
+		}
+	}
+}
+
+func TestStackOverflowTraceIsCollapsed(t *testing.T) {
+	path := writeFile(t, "inf.mk", "let f = fn(x) { f(x) };\nf(1);")
+
+	_, stderr, exit := runApp("run", path)
+	if exit != ExitRuntime {
+		t.Fatalf("wrong exit code. want=%d, got=%d (%s)", ExitRuntime, exit, stderr)
+	}
+
+	lines := strings.Split(strings.TrimSuffix(stderr, "\n"), "\n")
+	if len(lines) != 4 {
+		t.Fatalf("wrong number of lines. want=4, got=%d:\n%s", len(lines), stderr)
+	}
+	if !strings.HasPrefix(lines[2], "\t... repeated ") || !strings.HasSuffix(lines[2], " more times") {
+		t.Errorf("repeated frames not collapsed. got=%q", lines[2])
+	}
+	expected := "\tat <main> (" + path + ":2:2)"
+	if lines[3] != expected {
+		t.Errorf("wrong last frame. want=%q, got=%q", expected, lines[3])
+	}
+}
+
+func TestAsm(t *testing.T) {
+	path := writeFile(t, "prog.mk", `let sq = fn(x) { x * x }; sq(3);`)
+
+	listing, stderr, exit := runApp("disasm", path)
+	if exit != ExitOK {
+		t.Fatalf("disasm failed with %d: %s", exit, stderr)
+	}
+
+	source := filepath.Join(filepath.Dir(path), "prog.asm")
+	if err := os.WriteFile(source, []byte(listing), 0o644); err != nil {
+		t.Fatalf("write failed: %s", err)
+	}
+
+	if _, stderr, exit := runApp("asm", source); exit != ExitOK {
+		t.Fatalf("asm failed with %d: %s", exit, stderr)
+	}
+
+	if _, stderr, exit := runApp("run", strings.TrimSuffix(source, ".asm")+".mkc"); exit != ExitOK {
+		t.Fatalf("run failed with %d: %s", exit, stderr)
+	}
+
+	bad := writeFile(t, "bad.asm", "main:\n  OpNope\n")
+	_, stderr, exit = runApp("asm", bad)
+	if exit != ExitCompile {
+		t.Errorf("wrong exit code. want=%d, got=%d", ExitCompile, exit)
+	}
+	if !strings.Contains(stderr, `asm: line 2: unknown opcode "OpNope"`) {
+		t.Errorf("wrong error. got=%q", stderr)
+	}
+}
@@ -11,6 +11,14 @@ type Opcode byte
 type Definition struct {
 	Name          string
 	OperandWidths []int
+
+	// Pops and Pushes are how many values the instruction takes from and
+	// puts on the stack; see [Definition.StackEffect]
+	Pops   int
+	Pushes int
+	// PopsOperand is set if the instruction also pops as many values as
+	// its last operand says
+	PopsOperand bool
 }
 
 const (
@@ -25,6 +33,7 @@ const (
 	OpEqual
 	OpNotEqual
 	OpGreaterThan
+	OpLessThan
 	OpMinus
 	OpBang
 	OpJumpIf
@@ -35,6 +44,7 @@ const (
 	OpArray
 	OpHash
 	OpIndex
+	OpSetIndex
 	OpCall
 	OpReturnValue
 	OpReturn
@@ -44,36 +54,76 @@ const (
 	OpClosure
 	OpGetFree
 	OpCurrentClosure
+	OpWide
+
+	// register instruction set, see the definitions below
+	OpRMove
+	OpRConstant
+	OpRTrue
+	OpRFalse
+	OpRNull
+	OpRGetGlobal
+	OpRSetGlobal
+	OpRGetBuiltin
+	OpRGetFree
+	OpRCurrentClosure
+	OpRAdd
+	OpRSub
+	OpRMul
+	OpRDiv
+	OpREqual
+	OpRNotEqual
+	OpRGreaterThan
+	OpRLessThan
+	OpRMinus
+	OpRBang
+	OpRJump
+	OpRJumpIf
+	OpRArray
+	OpRHash
+	OpRIndex
+	OpRSetIndex
+	OpRCall
+	OpRClosure
+	OpRReturnValue
+	OpRReturn
+	OpRPop
 )
 
 var definitions = map[Opcode]*Definition{
-	OpConstant:    {"OpConstant", []int{2}},
-	OpAdd:         {"OpAdd", []int{}},   // addition
-	OpPop:         {"OpPop", []int{}},   // pop the topmost element
-	OpSub:         {"OpSub", []int{}},   // subtraction
-	OpMul:         {"OpMul", []int{}},   // multiplication
-	OpDiv:         {"OpDiv", []int{}},   // division
-	OpTrue:        {"OpTrue", []int{}},  // push true to stack
-	OpFalse:       {"OpFalse", []int{}}, // push false to stack
-	OpEqual:       {"OpEqual", []int{}},
-	OpNotEqual:    {"OpNotEqual", []int{}},
-	OpGreaterThan: {"OpGreaterThan", []int{}},
-	OpMinus:       {"OpMinus", []int{}},
-	OpBang:        {"OpBang", []int{}},
-	OpJump:        {"OpJump", []int{2}},
-	OpJumpIf:      {"OpJumpIf", []int{2}}, // jump over if stack top is not truthy
-	OpNull:        {"OpNull", []int{}},    // put vm.Null on the stack
-	OpSetGlobal:   {"OpSetGlobal", []int{2}},
-	OpGetGlobal:   {"OpGetGlobal", []int{2}},
-	OpArray:       {"OpArray", []int{2}}, // operand is the array length
-	OpHash:        {"OpHash", []int{2}},  // operand specifies the number of keys and values
-	OpIndex:       {"OpIndex", []int{}},
-	OpCall:        {"OpCall", []int{1}},
-	OpReturnValue: {"OpReturnValue", []int{}},
-	OpReturn:      {"OpReturn", []int{}},
-	OpSetLocal:    {"OpSetLocal", []int{1}},
-	OpGetLocal:    {"OpGetLocal", []int{1}},
-	OpGetBuiltin:  {"OpGetBuiltin", []int{1}},
+	OpConstant:    {"OpConstant", []int{2}, 0, 1, false},
+	OpAdd:         {"OpAdd", []int{}, 2, 1, false},   // addition
+	OpPop:         {"OpPop", []int{}, 1, 0, false},   // pop the topmost element
+	OpSub:         {"OpSub", []int{}, 2, 1, false},   // subtraction
+	OpMul:         {"OpMul", []int{}, 2, 1, false},   // multiplication
+	OpDiv:         {"OpDiv", []int{}, 2, 1, false},   // division
+	OpTrue:        {"OpTrue", []int{}, 0, 1, false},  // push true to stack
+	OpFalse:       {"OpFalse", []int{}, 0, 1, false}, // push false to stack
+	OpEqual:       {"OpEqual", []int{}, 2, 1, false},
+	OpNotEqual:    {"OpNotEqual", []int{}, 2, 1, false},
+	OpGreaterThan: {"OpGreaterThan", []int{}, 2, 1, false},
+	OpLessThan:    {"OpLessThan", []int{}, 2, 1, false},
+	OpMinus:       {"OpMinus", []int{}, 1, 1, false},
+	OpBang:        {"OpBang", []int{}, 1, 1, false},
+	OpJump:        {"OpJump", []int{2}, 0, 0, false},
+	OpJumpIf:      {"OpJumpIf", []int{2}, 1, 0, false}, // jump over if stack top is not truthy
+	OpNull:        {"OpNull", []int{}, 0, 1, false},    // put vm.Null on the stack
+	OpSetGlobal:   {"OpSetGlobal", []int{2}, 1, 0, false},
+	OpGetGlobal:   {"OpGetGlobal", []int{2}, 0, 1, false},
+	OpArray:       {"OpArray", []int{2}, 0, 1, true}, // operand is the array length
+	OpHash:        {"OpHash", []int{2}, 0, 1, true},  // operand specifies the number of keys and values
+	OpIndex:       {"OpIndex", []int{}, 2, 1, false},
+	// pops the value, the index and the array or hash below them, and
+	// stores the value in the array or hash
+	OpSetIndex: {"OpSetIndex", []int{}, 3, 0, false},
+	// pops the arguments and the function below them, pushes the result
+	OpCall: {"OpCall", []int{1}, 1, 1, true},
+	// the caller's frame ends, so the value is pushed onto the caller's stack
+	OpReturnValue: {"OpReturnValue", []int{}, 1, 0, false},
+	OpReturn:      {"OpReturn", []int{}, 0, 0, false},
+	OpSetLocal:    {"OpSetLocal", []int{1}, 1, 0, false},
+	OpGetLocal:    {"OpGetLocal", []int{1}, 0, 1, false},
+	OpGetBuiltin:  {"OpGetBuiltin", []int{1}, 0, 1, false},
 	/*
 	   OpClosure has two operands
 	   - 2 bytes wide constant index: specifies where in the constant pool
@@ -81,11 +131,69 @@ var definitions = map[Opcode]*Definition{
 	   - 1 byte wide count: specifies how many free variables sit on the
 	     stack
 	*/
-	OpClosure: {"OpClosure", []int{2, 1}},
+	OpClosure: {"OpClosure", []int{2, 1}, 0, 1, true},
 	// retrieve the values in object.Closure Free field and push it on the stack
-	OpGetFree: {"OpGetFree", []int{1}},
+	OpGetFree: {"OpGetFree", []int{1}, 0, 1, false},
 	// load the current closure currently being executed on the stack
-	OpCurrentClosure: {"OpGetCurrentClosure", []int{}},
+	OpCurrentClosure: {"OpGetCurrentClosure", []int{}, 0, 1, false},
+	/*
+	   OpWide is a prefix for the instruction that follows it: every operand
+	   of that instruction is encoded with twice its usual width (1 -> 2 and
+	   2 -> 4 bytes). It is emitted by [code.Make] whenever an operand does
+	   not fit its narrow width.
+	*/
+	OpWide: {"OpWide", []int{}, 0, 0, false},
+
+	/*
+	   The register instruction set is emitted instead of the above when
+	   compiling with compiler.WithRegisters. Its instructions do not use
+	   the stack: they read and write registers, which are the slots of the
+	   current frame. A function's parameters are its first registers.
+	   Register operands are 2 bytes wide and come first when they are
+	   written to, so `OpRAdd 3 0 1` stores r0 + r1 in r3.
+	*/
+	OpRMove:           {"OpRMove", []int{2, 2}, 0, 0, false},     // dst, src
+	OpRConstant:       {"OpRConstant", []int{2, 2}, 0, 0, false}, // dst, constant index
+	OpRTrue:           {"OpRTrue", []int{2}, 0, 0, false},
+	OpRFalse:          {"OpRFalse", []int{2}, 0, 0, false},
+	OpRNull:           {"OpRNull", []int{2}, 0, 0, false},
+	OpRGetGlobal:      {"OpRGetGlobal", []int{2, 2}, 0, 0, false}, // dst, global index
+	OpRSetGlobal:      {"OpRSetGlobal", []int{2, 2}, 0, 0, false}, // global index, src
+	OpRGetBuiltin:     {"OpRGetBuiltin", []int{2, 1}, 0, 0, false},
+	OpRGetFree:        {"OpRGetFree", []int{2, 1}, 0, 0, false},
+	OpRCurrentClosure: {"OpRCurrentClosure", []int{2}, 0, 0, false},
+	OpRAdd:            {"OpRAdd", []int{2, 2, 2}, 0, 0, false}, // dst, left, right
+	OpRSub:            {"OpRSub", []int{2, 2, 2}, 0, 0, false},
+	OpRMul:            {"OpRMul", []int{2, 2, 2}, 0, 0, false},
+	OpRDiv:            {"OpRDiv", []int{2, 2, 2}, 0, 0, false},
+	OpREqual:          {"OpREqual", []int{2, 2, 2}, 0, 0, false},
+	OpRNotEqual:       {"OpRNotEqual", []int{2, 2, 2}, 0, 0, false},
+	OpRGreaterThan:    {"OpRGreaterThan", []int{2, 2, 2}, 0, 0, false},
+	OpRLessThan:       {"OpRLessThan", []int{2, 2, 2}, 0, 0, false},
+	OpRMinus:          {"OpRMinus", []int{2, 2}, 0, 0, false}, // dst, src
+	OpRBang:           {"OpRBang", []int{2, 2}, 0, 0, false},
+	// like OpJump and OpJumpIf the target comes first; OpRJumpIf jumps if
+	// its register is not truthy
+	OpRJump:   {"OpRJump", []int{2}, 0, 0, false},
+	OpRJumpIf: {"OpRJumpIf", []int{2, 2}, 0, 0, false},
+	// dst, first, count: the elements are in count registers from first on
+	OpRArray:    {"OpRArray", []int{2, 2, 2}, 0, 0, false},
+	OpRHash:     {"OpRHash", []int{2, 2, 2}, 0, 0, false},
+	OpRIndex:    {"OpRIndex", []int{2, 2, 2}, 0, 0, false},    // dst, left, index
+	OpRSetIndex: {"OpRSetIndex", []int{2, 2, 2}, 0, 0, false}, // left, index, src
+	/*
+	   OpRCall dst, fn, count calls the function in register fn with the
+	   count arguments in the registers after it, which become the first
+	   registers of the callee's frame. The result is stored in dst.
+	*/
+	OpRCall: {"OpRCall", []int{2, 2, 1}, 0, 0, false},
+	// dst, constant index, first, count: the free variables are in count
+	// registers from first on
+	OpRClosure:     {"OpRClosure", []int{2, 2, 2, 1}, 0, 0, false},
+	OpRReturnValue: {"OpRReturnValue", []int{2}, 0, 0, false},
+	OpRReturn:      {"OpRReturn", []int{}, 0, 0, false},
+	// the value of an expression statement, see vm.VM.LastPoppedStackElem
+	OpRPop: {"OpRPop", []int{2}, 0, 0, false},
 }
 
 func (ins Instructions) String() string {
@@ -93,22 +201,62 @@ func (ins Instructions) String() string {
 
 	i := 0
 	for i < len(ins) {
-		def, err := Lookup(ins[i])
+		op, operands, n, err := Decode(ins, i)
 		if err != nil {
 			fmt.Fprintf(&out, "ERROR: %s\n", err)
-			continue
+			break
 		}
 
-		operands, read := ReadOperands(def, ins[i+1:])
-
-		fmt.Fprintf(&out, "%04d %s\n", i, ins.fmtInstruction(def, operands))
+		def := definitions[op]
+		if Opcode(ins[i]) == OpWide {
+			fmt.Fprintf(&out, "%04d OpWide %s\n", i, ins.fmtInstruction(def, operands))
+		} else {
+			fmt.Fprintf(&out, "%04d %s\n", i, ins.fmtInstruction(def, operands))
+		}
 
-		i += 1 + read
+		i += n
 	}
 
 	return out.String()
 }
 
+// Decode reads the instruction starting at ins[pos] and returns its opcode,
+// its operands and its length in bytes. An [OpWide] prefix is folded into
+// the instruction it applies to.
+func Decode(ins Instructions, pos int) (Opcode, []int, int, error) {
+	start := pos
+	scale := 1
+
+	if Opcode(ins[pos]) == OpWide {
+		if pos+1 >= len(ins) {
+			return 0, nil, 0, fmt.Errorf("OpWide at %d is not followed by an instruction", start)
+		}
+		pos++
+		scale = 2
+	}
+
+	def, err := Lookup(ins[pos])
+	if err != nil {
+		return 0, nil, 0, err
+	}
+
+	if scale == 2 && (len(def.OperandWidths) == 0 || Opcode(ins[pos]) == OpWide) {
+		return 0, nil, 0, fmt.Errorf("OpWide at %d applied to %s", start, def.Name)
+	}
+
+	width := 0
+	for _, w := range def.OperandWidths {
+		width += w * scale
+	}
+	if pos+1+width > len(ins) {
+		return 0, nil, 0, fmt.Errorf("%s at %d is truncated", def.Name, start)
+	}
+
+	operands, read := readOperands(def, ins[pos+1:], scale)
+
+	return Opcode(ins[pos]), operands, pos + 1 + read - start, nil
+}
+
 func (ins Instructions) fmtInstruction(def *Definition, operands []int) string {
 	operandCount := len(def.OperandWidths)
 
@@ -116,16 +264,12 @@ func (ins Instructions) fmtInstruction(def *Definition, operands []int) string {
 		return fmt.Sprintf("ERROR: operand len %d does not match defined %d\n ", len(operands), operandCount)
 	}
 
-	switch operandCount {
-	case 0:
-		return def.Name
-	case 1:
-		return fmt.Sprintf("%s %d", def.Name, operands[0])
-	case 2:
-		return fmt.Sprintf("%s %d %d", def.Name, operands[0], operands[1])
+	out := def.Name
+	for _, o := range operands {
+		out += fmt.Sprintf(" %d", o)
 	}
 
-	return fmt.Sprintf("ERROR: unhandled operandCount for %s\n", def.Name)
+	return out
 }
 
 func Lookup(op byte) (*Definition, error) {
@@ -137,11 +281,65 @@ func Lookup(op byte) (*Definition, error) {
 	return def, nil
 }
 
-// Make encodes into a bytecode instruction
+// LookupName returns the opcode whose definition has the given name, e.g.
+// "OpAdd"
+func LookupName(name string) (Opcode, bool) {
+	for op, def := range definitions {
+		if def.Name == name {
+			return op, true
+		}
+	}
+	return 0, false
+}
+
+// Make encodes into a bytecode instruction. Operands that do not fit
+// their defined width are encoded with an [OpWide] prefix. Make panics if
+// an operand does not fit even the wide width; use [MakeChecked] where
+// the operands are not known to be in range.
 func Make(op Opcode, operands ...int) []byte {
+	instruction, err := MakeChecked(op, operands...)
+	if err != nil {
+		panic(err)
+	}
+
+	return instruction
+}
+
+// MakeChecked is like [Make] but reports operands that cannot be encoded
+// instead of panicking
+func MakeChecked(op Opcode, operands ...int) ([]byte, error) {
+	return makeInstruction(op, operands, false)
+}
+
+// MakeWide is like [MakeChecked] but always encodes the instruction with
+// an [OpWide] prefix, even if its operands fit the narrow width
+func MakeWide(op Opcode, operands ...int) ([]byte, error) {
+	return makeInstruction(op, operands, true)
+}
+
+func makeInstruction(op Opcode, operands []int, wide bool) ([]byte, error) {
 	def, ok := definitions[op]
 	if !ok {
-		return []byte{}
+		return nil, fmt.Errorf("opcode %d undefined", op)
+	}
+
+	if len(operands) > len(def.OperandWidths) {
+		return nil, fmt.Errorf("too many operands for %s: got=%d, want=%d",
+			def.Name, len(operands), len(def.OperandWidths))
+	}
+
+	if wide && len(def.OperandWidths) == 0 {
+		return nil, fmt.Errorf("%s has no operands to widen", def.Name)
+	}
+
+	for i, o := range operands {
+		width := def.OperandWidths[i]
+		if o < 0 || o > maxOperand(2*width) {
+			return nil, fmt.Errorf("operand %d of %s out of range: %d", i, def.Name, o)
+		}
+		if o > maxOperand(width) {
+			wide = true
+		}
 	}
 
 	instructionLen := 1
@@ -149,13 +347,28 @@ func Make(op Opcode, operands ...int) []byte {
 		instructionLen += w
 	}
 
-	instruction := make([]byte, instructionLen)
-	instruction[0] = byte(op)
+	if wide {
+		instructionLen = 2 + 2*(instructionLen-1)
+	}
 
+	instruction := make([]byte, instructionLen)
 	offset := 1
+
+	if wide {
+		instruction[0] = byte(OpWide)
+		offset = 2
+	}
+	instruction[offset-1] = byte(op)
+
 	for i, o := range operands {
 		width := def.OperandWidths[i]
+		if wide {
+			width *= 2
+		}
+
 		switch width {
+		case 4:
+			binary.BigEndian.PutUint32(instruction[offset:], uint32(o))
 		case 2:
 			binary.BigEndian.PutUint16(instruction[offset:], uint16(o))
 		case 1:
@@ -164,17 +377,45 @@ func Make(op Opcode, operands ...int) []byte {
 		offset += width
 	}
 
-	return instruction
+	return instruction, nil
+}
+
+// maxOperand returns the largest value an operand of the given byte width
+// can hold
+func maxOperand(width int) int {
+	switch width {
+	case 1:
+		return 0xFF
+	case 2:
+		return 0xFFFF
+	case 4:
+		return 0xFFFFFFFF
+	}
+	return 0
 }
 
 // ReadOperands decodes the operands of the encoded bytecode instruction by [code.Make]
 // and returns a slice of operands and the number of bytes taken by the operands
 func ReadOperands(def *Definition, ins Instructions) ([]int, int) {
+	return readOperands(def, ins, 1)
+}
+
+// ReadWideOperands decodes the operands of an instruction that was
+// prefixed with [OpWide]. ins starts after the opcode following OpWide.
+func ReadWideOperands(def *Definition, ins Instructions) ([]int, int) {
+	return readOperands(def, ins, 2)
+}
+
+func readOperands(def *Definition, ins Instructions, scale int) ([]int, int) {
 	operands := make([]int, len(def.OperandWidths))
 	offset := 0
 
 	for i, width := range def.OperandWidths {
+		width *= scale
+
 		switch width {
+		case 4:
+			operands[i] = int(ReadUint32(ins[offset:]))
 		case 2:
 			operands[i] = int(ReadUint16(ins[offset:]))
 		case 1:
@@ -185,6 +426,10 @@ func ReadOperands(def *Definition, ins Instructions) ([]int, int) {
 	return operands, offset
 }
 
+func ReadUint32(instructions Instructions) uint32 {
+	return binary.BigEndian.Uint32(instructions)
+}
+
 func ReadUint16(instructions Instructions) uint16 {
 	return binary.BigEndian.Uint16(instructions)
 }
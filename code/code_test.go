@@ -1,6 +1,9 @@
 package code
 
-import "testing"
+import (
+	"bytes"
+	"testing"
+)
 
 func TestMake(t *testing.T) {
 	tests := []struct {
@@ -12,6 +15,9 @@ func TestMake(t *testing.T) {
 		{OpAdd, []int{}, []byte{byte(OpAdd)}},
 		{OpGetLocal, []int{255}, []byte{byte(OpGetLocal), 255}},
 		{OpClosure, []int{65534, 255}, []byte{byte(OpClosure), 255, 254, 255}},
+		{OpGetLocal, []int{256}, []byte{byte(OpWide), byte(OpGetLocal), 1, 0}},
+		{OpJump, []int{65536}, []byte{byte(OpWide), byte(OpJump), 0, 1, 0, 0}},
+		{OpClosure, []int{1, 256}, []byte{byte(OpWide), byte(OpClosure), 0, 0, 0, 1, 1, 0}},
 	}
 
 	for _, tt := range tests {
@@ -37,6 +43,7 @@ func TestInstructionsString(t *testing.T) {
 		Make(OpConstant, 2),
 		Make(OpConstant, 65535),
 		Make(OpClosure, 65535, 255),
+		Make(OpGetLocal, 300),
 	}
 
 	expected := `0000 OpAdd
@@ -44,6 +51,7 @@ func TestInstructionsString(t *testing.T) {
 0003 OpConstant 2
 0006 OpConstant 65535
 0009 OpClosure 65535 255
+0013 OpWide OpGetLocal 300
 `
 	concatted := Instructions{}
 	for _, ins := range instructions {
@@ -88,3 +96,90 @@ func TestReadOperands(t *testing.T) {
 	}
 
 }
+
+func TestReadWideOperands(t *testing.T) {
+	tests := []struct {
+		op        Opcode
+		operands  []int
+		bytesRead int
+	}{
+		{OpGetLocal, []int{65535}, 2},
+		{OpCall, []int{300}, 2},
+		{OpConstant, []int{70000}, 4},
+		{OpClosure, []int{70000, 300}, 6},
+	}
+
+	for _, tt := range tests {
+		instruction := Make(tt.op, tt.operands...)
+
+		if Opcode(instruction[0]) != OpWide {
+			t.Fatalf("instruction not prefixed with OpWide. got=%d", instruction[0])
+		}
+
+		op, operandsRead, n, err := Decode(instruction, 0)
+		if err != nil {
+			t.Fatalf("decode failed: %s", err)
+		}
+
+		if op != tt.op {
+			t.Fatalf("op wrong. got=%d, expected=%d", op, tt.op)
+		}
+
+		if n != len(instruction) || n != 2+tt.bytesRead {
+			t.Fatalf("n wrong. got=%d, expected=%d", n, 2+tt.bytesRead)
+		}
+
+		for i, want := range tt.operands {
+			if operandsRead[i] != want {
+				t.Errorf("operand wrong. got=%d, expected=%d", operandsRead[i], want)
+			}
+		}
+	}
+}
+
+func TestMakeCheckedOverflow(t *testing.T) {
+	tests := []struct {
+		op       Opcode
+		operands []int
+	}{
+		{OpGetLocal, []int{65536}},
+		{OpCall, []int{-1}},
+		{OpConstant, []int{1 << 32}},
+	}
+
+	for _, tt := range tests {
+		_, err := MakeChecked(tt.op, tt.operands...)
+		if err == nil {
+			t.Errorf("expected overflow error for %d %v", tt.op, tt.operands)
+		}
+	}
+}
+
+func TestMakeWide(t *testing.T) {
+	instruction, err := MakeWide(OpGetLocal, 1)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	expected := []byte{byte(OpWide), byte(OpGetLocal), 0, 1}
+	if !bytes.Equal(instruction, expected) {
+		t.Errorf("wrong instruction. want=%v, got=%v", expected, instruction)
+	}
+
+	if _, err := MakeWide(OpAdd); err == nil {
+		t.Errorf("expected error widening OpAdd")
+	}
+}
+
+func TestLookupName(t *testing.T) {
+	for op, def := range definitions {
+		actual, ok := LookupName(def.Name)
+		if !ok || actual != op {
+			t.Errorf("LookupName(%q) wrong. want=%d, got=%d (%t)", def.Name, op, actual, ok)
+		}
+	}
+
+	if _, ok := LookupName("OpNope"); ok {
+		t.Errorf("LookupName found undefined opcode")
+	}
+}
@@ -0,0 +1,48 @@
+package code
+
+import "sort"
+
+// LineEntry says that the instructions from Offset up to the next entry
+// were compiled from the source at Line and Column
+type LineEntry struct {
+	Offset int
+	Line   int
+	Column int
+}
+
+// LineTable maps instruction offsets back to source positions. It only
+// holds an entry where the position changes and is ordered by offset.
+type LineTable []LineEntry
+
+// Add records the position of the instruction at offset, which must not be
+// before any offset already in the table
+func (lt LineTable) Add(offset, line, column int) LineTable {
+	if n := len(lt); n > 0 {
+		last := lt[n-1]
+		if last.Line == line && last.Column == column {
+			return lt
+		}
+		if last.Offset == offset {
+			lt = lt[:n-1]
+		}
+	}
+
+	return append(lt, LineEntry{Offset: offset, Line: line, Column: column})
+}
+
+// Truncate drops the entries of the instructions from offset on, for when
+// instructions are removed from the end
+func (lt LineTable) Truncate(offset int) LineTable {
+	i := sort.Search(len(lt), func(i int) bool { return lt[i].Offset >= offset })
+	return lt[:i]
+}
+
+// Lookup returns the source position of the instruction containing offset,
+// or 0, 0 if it is not known
+func (lt LineTable) Lookup(offset int) (int, int) {
+	i := sort.Search(len(lt), func(i int) bool { return lt[i].Offset > offset })
+	if i == 0 {
+		return 0, 0
+	}
+	return lt[i-1].Line, lt[i-1].Column
+}
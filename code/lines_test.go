@@ -0,0 +1,46 @@
+package code
+
+import "testing"
+
+func TestLineTable(t *testing.T) {
+	var lt LineTable
+	lt = lt.Add(0, 1, 1)
+	lt = lt.Add(3, 1, 1) // same position, no new entry
+	lt = lt.Add(5, 2, 4)
+	lt = lt.Add(8, 3, 1)
+	lt = lt.Add(8, 3, 7) // replaces the entry at the same offset
+
+	if len(lt) != 3 {
+		t.Fatalf("wrong number of entries. want=3, got=%d (%v)", len(lt), lt)
+	}
+
+	tests := []struct {
+		offset int
+		line   int
+		column int
+	}{
+		{0, 1, 1},
+		{4, 1, 1},
+		{5, 2, 4},
+		{7, 2, 4},
+		{8, 3, 7},
+		{100, 3, 7},
+	}
+
+	for _, tt := range tests {
+		line, column := lt.Lookup(tt.offset)
+		if line != tt.line || column != tt.column {
+			t.Errorf("wrong position for offset %d. want=%d:%d, got=%d:%d",
+				tt.offset, tt.line, tt.column, line, column)
+		}
+	}
+
+	lt = lt.Truncate(5)
+	if line, column := lt.Lookup(9); line != 1 || column != 1 {
+		t.Errorf("wrong position after truncate. want=1:1, got=%d:%d", line, column)
+	}
+
+	if line, column := LineTable(nil).Lookup(0); line != 0 || column != 0 {
+		t.Errorf("empty table returned %d:%d", line, column)
+	}
+}
@@ -0,0 +1,64 @@
+package code
+
+import "fmt"
+
+// StackEffect returns how many values an instruction with these operands
+// pops from and pushes onto the stack
+func (def *Definition) StackEffect(operands []int) (int, int) {
+	pops := def.Pops
+	if def.PopsOperand {
+		pops += operands[len(operands)-1]
+	}
+	return pops, def.Pushes
+}
+
+// MaxStackDepth returns the largest number of values ins keeps on the stack
+// on any path, not counting locals. Paths end at a return or past the last
+// instruction.
+func MaxStackDepth(ins Instructions) (int, error) {
+	depths := map[int]int{} // position -> stack depth before it
+	type state struct{ pos, depth int }
+	work := []state{{0, 0}}
+	maxDepth := 0
+
+	for len(work) > 0 {
+		s := work[len(work)-1]
+		work = work[:len(work)-1]
+
+		if s.pos >= len(ins) {
+			continue
+		}
+		if _, ok := depths[s.pos]; ok {
+			continue
+		}
+		depths[s.pos] = s.depth
+
+		op, operands, n, err := Decode(ins, s.pos)
+		if err != nil {
+			return 0, err
+		}
+
+		def, _ := Lookup(byte(op))
+		pops, pushes := def.StackEffect(operands)
+		if s.depth < pops {
+			return 0, fmt.Errorf("%s at %d pops %d values from a stack of %d", def.Name, s.pos, pops, s.depth)
+		}
+
+		depth := s.depth - pops + pushes
+		if depth > maxDepth {
+			maxDepth = depth
+		}
+
+		switch op {
+		case OpReturn, OpReturnValue:
+		case OpJump:
+			work = append(work, state{operands[0], depth})
+		case OpJumpIf:
+			work = append(work, state{operands[0], depth}, state{s.pos + n, depth})
+		default:
+			work = append(work, state{s.pos + n, depth})
+		}
+	}
+
+	return maxDepth, nil
+}
@@ -0,0 +1,99 @@
+package code
+
+import "testing"
+
+func TestStackEffect(t *testing.T) {
+	tests := []struct {
+		op       Opcode
+		operands []int
+		pops     int
+		pushes   int
+	}{
+		{OpConstant, []int{1}, 0, 1},
+		{OpAdd, []int{}, 2, 1},
+		{OpPop, []int{}, 1, 0},
+		{OpJumpIf, []int{10}, 1, 0},
+		{OpArray, []int{3}, 3, 1},
+		{OpHash, []int{4}, 4, 1},
+		{OpCall, []int{2}, 3, 1},
+		{OpClosure, []int{7, 2}, 2, 1},
+		{OpReturn, []int{}, 0, 0},
+	}
+
+	for _, tt := range tests {
+		def, err := Lookup(byte(tt.op))
+		if err != nil {
+			t.Fatalf("definition not found: %q", err)
+		}
+
+		pops, pushes := def.StackEffect(tt.operands)
+		if pops != tt.pops || pushes != tt.pushes {
+			t.Errorf("wrong stack effect for %s %v. want=%d/%d, got=%d/%d",
+				def.Name, tt.operands, tt.pops, tt.pushes, pops, pushes)
+		}
+	}
+}
+
+func TestMaxStackDepth(t *testing.T) {
+	tests := []struct {
+		instructions []Instructions
+		expected     int
+	}{
+		{[]Instructions{}, 0},
+		{
+			[]Instructions{
+				Make(OpConstant, 0),
+				Make(OpConstant, 1),
+				Make(OpAdd),
+				Make(OpPop),
+			},
+			2,
+		},
+		{
+			// if (true) { [1, 2, 3] } else { 4 }
+			[]Instructions{
+				Make(OpTrue),        // 0000
+				Make(OpJumpIf, 19),  // 0001
+				Make(OpConstant, 0), // 0004
+				Make(OpConstant, 1), // 0007
+				Make(OpConstant, 2), // 0010
+				Make(OpArray, 3),    // 0013
+				Make(OpJump, 22),    // 0016
+				Make(OpConstant, 3), // 0019
+				Make(OpPop),         // 0022
+			},
+			3,
+		},
+		{
+			// f(1, 2) where f is a global
+			[]Instructions{
+				Make(OpGetGlobal, 0),
+				Make(OpConstant, 0),
+				Make(OpConstant, 1),
+				Make(OpCall, 2),
+				Make(OpReturnValue),
+			},
+			3,
+		},
+	}
+
+	for _, tt := range tests {
+		ins := Instructions{}
+		for _, i := range tt.instructions {
+			ins = append(ins, i...)
+		}
+
+		depth, err := MaxStackDepth(ins)
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if depth != tt.expected {
+			t.Errorf("wrong depth for\n%s\nwant=%d, got=%d", ins, tt.expected, depth)
+		}
+	}
+
+	_, err := MaxStackDepth(Instructions(Make(OpPop)))
+	if err == nil || err.Error() != "OpPop at 0 pops 1 values from a stack of 0" {
+		t.Errorf("wrong error for underflow. got=%v", err)
+	}
+}
@@ -5,12 +5,19 @@ import (
 	"monc/ast"
 	"monc/code"
 	"monc/object"
-	"sort"
+	"monc/token"
 )
 
 type Bytecode struct {
 	Instructions code.Instructions
 	Constants    []object.Object
+	MaxStack     int  // most values the main program keeps on the stack
+	Registers    bool // the instructions use the register instruction set
+
+	// debug information, empty if unknown
+	Source      string         // name of the source file, set by the caller
+	Lines       code.LineTable // source positions of the main program
+	GlobalNames []string       // indexed by the operand of OpGetGlobal
 }
 
 type EmittedInstruction struct {
@@ -23,15 +30,40 @@ type Compiler struct {
 	symbolTable *SymbolTable
 	scopes      []CompilationScope
 	scopeIndex  int
+
+	// err holds the first instruction that could not be encoded; it is
+	// returned from Compile once the current node has been compiled
+	err error
+
+	diagnostics []Diagnostic
+
+	// position is the token of the innermost node being compiled that has
+	// a source position; emitted instructions are attributed to it
+	position token.Token
+
+	// registers is set by WithRegisters
+	registers bool
 }
 
 type CompilationScope struct {
 	instructions        code.Instructions
 	lastInstruction     EmittedInstruction
 	previousInstruction EmittedInstruction
+	bindings            []*binding
+	lines               code.LineTable
+	allocator           registerAllocator
 }
 
-func New() *Compiler {
+// Option configures a Compiler
+type Option func(*Compiler)
+
+// WithRegisters makes the compiler emit the register instruction set of
+// package code instead of the stack one. The VM runs either.
+func WithRegisters() Option {
+	return func(c *Compiler) { c.registers = true }
+}
+
+func New(options ...Option) *Compiler {
 	mainScope := CompilationScope{
 		instructions:        code.Instructions{},
 		lastInstruction:     EmittedInstruction{},
@@ -44,12 +76,18 @@ func New() *Compiler {
 		mainStab.DefineBuiltin(i, v.Name)
 	}
 
-	return &Compiler{
+	c := &Compiler{
 		constants:   []object.Object{},
 		symbolTable: mainStab,
 		scopes:      []CompilationScope{mainScope},
 		scopeIndex:  0,
 	}
+
+	for _, option := range options {
+		option(c)
+	}
+
+	return c
 }
 
 func (c *Compiler) currentInstructions() code.Instructions {
@@ -57,8 +95,20 @@ func (c *Compiler) currentInstructions() code.Instructions {
 }
 
 func (c *Compiler) Compile(node ast.Node) error {
+	if c.registers {
+		return c.compileRegisters(node)
+	}
+
+	if tok := nodeToken(node); tok.Line > 0 {
+		outer := c.position
+		c.position = tok
+		defer func() { c.position = outer }()
+	}
+
 	switch node := node.(type) {
 	case *ast.CallExpression:
+		c.checkArgumentCount(node)
+
 		err := c.Compile(node.Function)
 		if err != nil {
 			return err
@@ -89,6 +139,7 @@ func (c *Compiler) Compile(node ast.Node) error {
 
 		for _, p := range node.Parameters {
 			c.symbolTable.Define(p.Value)
+			c.addBinding(p, true, nil)
 		}
 
 		err := c.Compile(node.Body)
@@ -96,6 +147,8 @@ func (c *Compiler) Compile(node ast.Node) error {
 			return err
 		}
 
+		c.reportUnused()
+
 		if c.lastInstructionIs(code.OpPop) {
 			c.replaceLastPopWithReturn()
 		}
@@ -106,8 +159,15 @@ func (c *Compiler) Compile(node ast.Node) error {
 
 		freeSymbols := c.symbolTable.FreeSymbols
 		numLocals := c.symbolTable.numDefinitions
+		localNames := c.symbolTable.definedNames()
+		lines := c.scopes[c.scopeIndex].lines
 		instructions := c.leaveScope()
 
+		maxStack, err := code.MaxStackDepth(instructions)
+		if err != nil {
+			return err
+		}
+
 		for _, s := range freeSymbols {
 			c.loadSymbol(s)
 		}
@@ -116,6 +176,11 @@ func (c *Compiler) Compile(node ast.Node) error {
 			Instructions:  instructions,
 			NumLocals:     numLocals,
 			NumParameters: len(node.Parameters),
+			MaxStack:      maxStack,
+			Name:          node.Name,
+			Lines:         lines,
+			LocalNames:    localNames,
+			FreeNames:     symbolNames(freeSymbols),
 		}
 
 		fnIndex := c.addConstant(compiledFn)
@@ -134,27 +199,13 @@ func (c *Compiler) Compile(node ast.Node) error {
 		c.emit(code.OpIndex)
 
 	case *ast.HashLiteral:
-		keys := []ast.Expression{}
-		for k := range node.Pairs {
-			keys = append(keys, k)
-		}
-
-		/* Go does not guarantee a consistent order when iterating through
-		 * keys and values of a map (node.Paira). We're sorting by keys
-		 * because not doing so will cause our tests to break randomly
-		 * since the instruction order won't match.
-		 */
-
-		sort.Slice(keys, func(i, j int) bool {
-			return keys[i].String() < keys[j].String()
-		})
-
-		for _, k := range keys {
-			err := c.Compile(k)
+		// in source order, which is the order of the pairs in the hash
+		for _, pair := range node.Pairs {
+			err := c.Compile(pair.Key)
 			if err != nil {
 				return err
 			}
-			err = c.Compile(node.Pairs[k])
+			err = c.Compile(pair.Value)
 			if err != nil {
 				return err
 			}
@@ -177,6 +228,8 @@ func (c *Compiler) Compile(node ast.Node) error {
 		c.emit(code.OpConstant, c.addConstant(str))
 
 	case *ast.Program:
+		c.reportUnreachable(node.Statements)
+
 		for _, s := range node.Statements {
 			if err := c.Compile(s); err != nil {
 				return err
@@ -191,20 +244,6 @@ func (c *Compiler) Compile(node ast.Node) error {
 		c.emit(code.OpPop)
 
 	case *ast.InfixExpression:
-		if node.Operator == "<" {
-
-			if err := c.Compile(node.Right); err != nil {
-				return err
-			}
-
-			if err := c.Compile(node.Left); err != nil {
-				return err
-			}
-
-			c.emit(code.OpGreaterThan)
-			return nil
-		}
-
 		if err := c.Compile(node.Left); err != nil {
 			return err
 		}
@@ -224,6 +263,8 @@ func (c *Compiler) Compile(node ast.Node) error {
 			c.emit(code.OpDiv)
 		case ">":
 			c.emit(code.OpGreaterThan)
+		case "<":
+			c.emit(code.OpLessThan)
 		case "==":
 			c.emit(code.OpEqual)
 		case "!=":
@@ -234,8 +275,7 @@ func (c *Compiler) Compile(node ast.Node) error {
 		}
 
 	case *ast.IntegerLiteral:
-		integer := &object.Integer{Value: node.Value}
-		c.emit(code.OpConstant, c.addConstant(integer))
+		c.emit(code.OpConstant, c.addConstant(integerConstant(node)))
 
 	case *ast.Boolean:
 		if node.Value {
@@ -271,9 +311,7 @@ func (c *Compiler) Compile(node ast.Node) error {
 			return err
 		}
 
-		if c.lastInstructionIs(code.OpPop) {
-			c.removeLastPop()
-		}
+		c.blockValue()
 
 		jumpPos := c.emit(code.OpJump, 9999)
 
@@ -281,6 +319,9 @@ func (c *Compiler) Compile(node ast.Node) error {
 		afterConsequencePos := len(c.currentInstructions())
 		c.changeOperands(jumptNotTruthyPos, afterConsequencePos)
 
+		// widening the OpJumpIf moves the OpJump emitted after it
+		jumpPos = c.scopes[c.scopeIndex].lastInstruction.Position
+
 		if node.Alternative == nil {
 			c.emit(code.OpNull)
 		} else {
@@ -288,15 +329,15 @@ func (c *Compiler) Compile(node ast.Node) error {
 				return err
 			}
 
-			if c.lastInstructionIs(code.OpPop) {
-				c.removeLastPop()
-			}
+			c.blockValue()
 		}
 
 		afterAlternative := len(c.currentInstructions())
 		c.changeOperands(jumpPos, afterAlternative)
 
 	case *ast.BlockStatement:
+		c.reportUnreachable(node.Statements)
+
 		for _, s := range node.Statements {
 			err := c.Compile(s)
 			if err != nil {
@@ -307,16 +348,32 @@ func (c *Compiler) Compile(node ast.Node) error {
 	case *ast.LetStatement:
 		// defining before function body allows recursive calls in the body
 		symbol := c.symbolTable.Define(node.Name.Value)
+		c.addBinding(node.Name, false, node.Value)
+
+		// another value that reads the variable reads null rather than an
+		// unset slot
+		if _, ok := node.Value.(*ast.FunctionLiteral); !ok && mentions(node.Value, node.Name.Value) {
+			c.emit(code.OpNull)
+			c.setSymbol(symbol)
+		}
 
 		err := c.Compile(node.Value)
 		if err != nil {
 			return err
 		}
-		if symbol.Scope == GlobalScope {
-			c.emit(code.OpSetGlobal, symbol.Index)
-		} else {
-			c.emit(code.OpSetLocal, symbol.Index)
+		c.setSymbol(symbol)
+
+	case *ast.AssignStatement:
+		if err := c.Compile(node.Target.Left); err != nil {
+			return err
 		}
+		if err := c.Compile(node.Target.Index); err != nil {
+			return err
+		}
+		if err := c.Compile(node.Value); err != nil {
+			return err
+		}
+		c.emit(code.OpSetIndex)
 
 	case *ast.Identifier:
 		symbol, ok := c.symbolTable.Resolve(node.Value)
@@ -324,11 +381,12 @@ func (c *Compiler) Compile(node ast.Node) error {
 			return fmt.Errorf("undefined variable %s", node.Value)
 
 		}
+		c.markUsed(node.Value)
 
 		c.loadSymbol(symbol)
 	}
 
-	return nil
+	return c.err
 }
 
 func (c *Compiler) replaceLastPopWithReturn() {
@@ -338,14 +396,71 @@ func (c *Compiler) replaceLastPopWithReturn() {
 }
 
 func (c *Compiler) Bytecode() *Bytecode {
+	global := c.symbolTable
+	for global.Outer != nil {
+		global = global.Outer
+	}
+
+	// the compiler never emits instructions that underflow the stack
+	maxStack, _ := code.MaxStackDepth(c.currentInstructions())
+	if c.registers {
+		maxStack = c.scopes[c.scopeIndex].allocator.size()
+	}
+
 	return &Bytecode{
 		Instructions: c.currentInstructions(),
 		Constants:    c.constants,
+		MaxStack:     maxStack,
+		Registers:    c.registers,
+		Lines:        c.scopes[c.scopeIndex].lines,
+		GlobalNames:  global.definedNames(),
 	}
 }
 
 // ------------------------------ HELPERS -------------------------------
 
+// nodeToken returns the token a node starts with, or for operators and
+// calls the token of the operator, which is where errors are reported
+func nodeToken(node ast.Node) token.Token {
+	switch node := node.(type) {
+	case *ast.LetStatement:
+		return node.Token
+	case *ast.ReturnStatement:
+		return node.Token
+	case *ast.ExpressionStatement:
+		return node.Token
+	case *ast.AssignStatement:
+		return node.Token
+	case *ast.BlockStatement:
+		return node.Token
+	case *ast.Identifier:
+		return node.Token
+	case *ast.IntegerLiteral:
+		return node.Token
+	case *ast.StringLiteral:
+		return node.Token
+	case *ast.Boolean:
+		return node.Token
+	case *ast.PrefixExpression:
+		return node.Token
+	case *ast.InfixExpression:
+		return node.Token
+	case *ast.IfExpression:
+		return node.Token
+	case *ast.FunctionLiteral:
+		return node.Token
+	case *ast.CallExpression:
+		return node.Token
+	case *ast.ArrayLiteral:
+		return node.Token
+	case *ast.IndexExpression:
+		return node.Token
+	case *ast.HashLiteral:
+		return node.Token
+	}
+	return token.Token{}
+}
+
 func (c *Compiler) enterScope() {
 	scope := CompilationScope{
 		instructions:        code.Instructions{},
@@ -370,6 +485,15 @@ func (c *Compiler) leaveScope() code.Instructions {
 // addConstant appends the passed `obj` to the `constants` slice
 // and returns its index which can be used to refer to that
 // object in the `constants` pool
+// integerConstant is the constant of an integer literal, which is a
+// BigInt if it does not fit int64
+func integerConstant(node *ast.IntegerLiteral) object.Object {
+	if node.Big != nil {
+		return object.NewBigInt(node.Big)
+	}
+	return &object.Integer{Value: node.Value}
+}
+
 func (c *Compiler) addConstant(obj object.Object) int {
 	c.constants = append(c.constants, obj)
 	return len(c.constants) - 1
@@ -385,9 +509,17 @@ func (c *Compiler) setLastInstruction(op code.Opcode, pos int) {
 }
 
 func (c *Compiler) emit(op code.Opcode, operands ...int) int {
-	ins := code.Make(op, operands...)
+	ins, err := code.MakeChecked(op, operands...)
+	if err != nil && c.err == nil {
+		c.err = err
+	}
 	pos := c.addInstruction(ins)
 
+	if c.position.Line > 0 {
+		scope := &c.scopes[c.scopeIndex]
+		scope.lines = scope.lines.Add(pos, c.position.Line, c.position.Column)
+	}
+
 	c.setLastInstruction(op, pos)
 
 	return pos
@@ -409,10 +541,22 @@ func (c *Compiler) lastInstructionIs(op code.Opcode) bool {
 	return c.scopes[c.scopeIndex].lastInstruction.Opcode == op
 }
 
+// blockValue leaves the value of the block just compiled as a branch of an
+// if on the stack: that of its last expression statement, or null for
+// blocks that are empty or end in another statement, like in the evaluator
+func (c *Compiler) blockValue() {
+	if c.lastInstructionIs(code.OpPop) {
+		c.removeLastPop()
+	} else {
+		c.emit(code.OpNull)
+	}
+}
+
 // removeLastPop removes the last OpPop instruction
 func (c *Compiler) removeLastPop() {
 	scope := &c.scopes[c.scopeIndex]
 	scope.instructions = c.currentInstructions()[:scope.lastInstruction.Position]
+	scope.lines = scope.lines.Truncate(scope.lastInstruction.Position)
 	scope.lastInstruction = scope.previousInstruction
 }
 
@@ -429,19 +573,172 @@ func (c *Compiler) changeOperands(opPos int, operand int) {
 	// changing the operand can get messy with multi-byte operands
 	// therefore, just creating a new instruction and replcaing at
 	// the position
-	op := code.Opcode(c.currentInstructions()[opPos])
-	newInstruction := code.Make(op, operand)
+	op, operands, oldLen, err := code.Decode(c.currentInstructions(), opPos)
+	if err != nil {
+		panic(err)
+	}
+	operands[0] = operand
+	newInstruction := code.Make(op, operands...)
+
+	if len(newInstruction) != oldLen {
+		// the new operand needs an OpWide prefix, which shifts every
+		// instruction after opPos
+		c.relayout(opPos, operand)
+		return
+	}
 
 	c.replaceInstructions(opPos, newInstruction)
 }
 
-func NewWithState(s *SymbolTable, constants []object.Object) *Compiler {
-	compiler := New()
+// relayout re-encodes the current scope after the jump at jumpPos has been
+// given a target that no longer fits its narrow encoding. Growing one
+// instruction moves everything after it, so every jump target and recorded
+// position is remapped; jumps pushed over the narrow limit by the move are
+// widened in turn until the layout settles.
+func (c *Compiler) relayout(jumpPos int, target int) {
+	type decoded struct {
+		op       code.Opcode
+		operands []int
+		pos      int
+	}
+
+	ins := c.currentInstructions()
+	decodedIns := []decoded{}
+
+	for i := 0; i < len(ins); {
+		op, operands, n, err := code.Decode(ins, i)
+		if err != nil {
+			panic(err)
+		}
+		if i == jumpPos {
+			operands[0] = target
+		}
+		decodedIns = append(decodedIns, decoded{op, operands, i})
+		i += n
+	}
+
+	newPos := map[int]int{}
+	mapPos := func(pos int) int {
+		if p, ok := newPos[pos]; ok {
+			return p
+		}
+		// not an instruction boundary, e.g. a jump placeholder
+		return pos
+	}
+
+	encode := func(d decoded) []byte {
+		operands := d.operands
+		if isJump(d.op) {
+			operands = append([]int{mapPos(d.operands[0])}, d.operands[1:]...)
+		}
+		return code.Make(d.op, operands...)
+	}
+
+	for {
+		changed := false
+		offset := 0
+		for _, d := range decodedIns {
+			if p, ok := newPos[d.pos]; !ok || p != offset {
+				changed = true
+			}
+			newPos[d.pos] = offset
+			offset += len(encode(d))
+		}
+		if p, ok := newPos[len(ins)]; !ok || p != offset {
+			changed = true
+		}
+		newPos[len(ins)] = offset
+
+		if !changed {
+			break
+		}
+	}
+
+	relaid := code.Instructions{}
+	for _, d := range decodedIns {
+		relaid = append(relaid, encode(d)...)
+	}
+
+	scope := &c.scopes[c.scopeIndex]
+	scope.instructions = relaid
+	scope.lastInstruction.Position = mapPos(scope.lastInstruction.Position)
+	scope.previousInstruction.Position = mapPos(scope.previousInstruction.Position)
+
+	for i := range scope.lines {
+		scope.lines[i].Offset = mapPos(scope.lines[i].Offset)
+	}
+}
+
+func NewWithState(s *SymbolTable, constants []object.Object, options ...Option) *Compiler {
+	compiler := New(options...)
 	compiler.symbolTable = s
 	compiler.constants = constants
 	return compiler
 }
 
+func (c *Compiler) setSymbol(symbol Symbol) {
+	if symbol.Scope == GlobalScope {
+		c.emit(code.OpSetGlobal, symbol.Index)
+	} else {
+		c.emit(code.OpSetLocal, symbol.Index)
+	}
+}
+
+// mentions reports whether name occurs as an identifier anywhere in node
+func mentions(node ast.Node, name string) bool {
+	switch node := node.(type) {
+	case *ast.Identifier:
+		return node.Value == name
+	case *ast.ExpressionStatement:
+		return mentions(node.Expression, name)
+	case *ast.LetStatement:
+		return mentions(node.Value, name)
+	case *ast.ReturnStatement:
+		return mentions(node.ReturnValue, name)
+	case *ast.AssignStatement:
+		return mentions(node.Target, name) || mentions(node.Value, name)
+	case *ast.BlockStatement:
+		for _, s := range node.Statements {
+			if mentions(s, name) {
+				return true
+			}
+		}
+	case *ast.PrefixExpression:
+		return mentions(node.Right, name)
+	case *ast.InfixExpression:
+		return mentions(node.Left, name) || mentions(node.Right, name)
+	case *ast.IndexExpression:
+		return mentions(node.Left, name) || mentions(node.Index, name)
+	case *ast.IfExpression:
+		return mentions(node.Condition, name) || mentions(node.Consequence, name) ||
+			node.Alternative != nil && mentions(node.Alternative, name)
+	case *ast.FunctionLiteral:
+		return mentions(node.Body, name)
+	case *ast.CallExpression:
+		if mentions(node.Function, name) {
+			return true
+		}
+		for _, a := range node.Arguments {
+			if mentions(a, name) {
+				return true
+			}
+		}
+	case *ast.ArrayLiteral:
+		for _, e := range node.Elements {
+			if mentions(e, name) {
+				return true
+			}
+		}
+	case *ast.HashLiteral:
+		for _, pair := range node.Pairs {
+			if mentions(pair.Key, name) || mentions(pair.Value, name) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
 func (c *Compiler) loadSymbol(symbol Symbol) {
 	switch symbol.Scope {
 	case GlobalScope:
@@ -456,3 +753,12 @@ func (c *Compiler) loadSymbol(symbol Symbol) {
 		c.emit(code.OpCurrentClosure)
 	}
 }
+
+// isJump reports whether op has a jump target as its first operand
+func isJump(op code.Opcode) bool {
+	switch op {
+	case code.OpJump, code.OpJumpIf, code.OpRJump, code.OpRJumpIf:
+		return true
+	}
+	return false
+}
@@ -1,12 +1,16 @@
 package compiler
 
 import (
+	"bytes"
 	"fmt"
 	"monc/ast"
 	"monc/code"
 	"monc/lexer"
+	"monc/mkc"
 	"monc/object"
 	"monc/parser"
+	"reflect"
+	"strings"
 	"testing"
 )
 
@@ -46,11 +50,11 @@ func TestBooleanExpressions(t *testing.T) {
 		},
 		{
 			input:             "1 < 2",
-			expectedConstants: []interface{}{2, 1},
+			expectedConstants: []interface{}{1, 2},
 			expectedInstructions: []code.Instructions{
 				code.Make(code.OpConstant, 0),
 				code.Make(code.OpConstant, 1),
-				code.Make(code.OpGreaterThan),
+				code.Make(code.OpLessThan),
 				code.Make(code.OpPop),
 			},
 		},
@@ -402,6 +406,49 @@ func TestIndexExpressions(t *testing.T) {
 	runCompilerTests(t, tests)
 }
 
+func TestIndexAssignments(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input:             "let a = [1]; a[0] = 2;",
+			expectedConstants: []interface{}{1, 0, 2},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpArray, 1),
+				code.Make(code.OpSetGlobal, 0),
+				code.Make(code.OpGetGlobal, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpConstant, 2),
+				code.Make(code.OpSetIndex),
+			},
+		},
+		{
+			input: `fn(h) { h["k"] = h["k"] + 1 }`,
+			expectedConstants: []interface{}{
+				"k",
+				"k",
+				1,
+				[]code.Instructions{
+					code.Make(code.OpGetLocal, 0),
+					code.Make(code.OpConstant, 0),
+					code.Make(code.OpGetLocal, 0),
+					code.Make(code.OpConstant, 1),
+					code.Make(code.OpIndex),
+					code.Make(code.OpConstant, 2),
+					code.Make(code.OpAdd),
+					code.Make(code.OpSetIndex),
+					code.Make(code.OpReturn),
+				},
+			},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpClosure, 3, 0),
+				code.Make(code.OpPop),
+			},
+		},
+	}
+
+	runCompilerTests(t, tests)
+}
+
 func TestFunctions(t *testing.T) {
 	tests := []compilerTestCase{
 		{
@@ -929,6 +976,179 @@ func TestRecursiveFunctions(t *testing.T) {
 	runCompilerTests(t, ts)
 }
 
+func TestWideOperands(t *testing.T) {
+	params := []string{}
+	for i := 0; i < 300; i++ {
+		params = append(params, letterName(i))
+	}
+
+	tests := []compilerTestCase{
+		{
+			input: fmt.Sprintf("fn(%s) { %s }", strings.Join(params, ", "), params[299]),
+			expectedConstants: []interface{}{
+				[]code.Instructions{
+					code.Make(code.OpGetLocal, 299),
+					code.Make(code.OpReturnValue),
+				},
+			},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpClosure, 0, 0),
+				code.Make(code.OpPop),
+			},
+		},
+	}
+
+	runCompilerTests(t, tests)
+
+	if ins := code.Make(code.OpGetLocal, 299); code.Opcode(ins[0]) != code.OpWide {
+		t.Fatalf("expected OpWide prefix. got=%q", code.Instructions(ins))
+	}
+}
+
+func TestWideJumps(t *testing.T) {
+	// OpTrue, OpPop is two bytes, so the consequence alone does not
+	// fit a narrow jump operand
+	statements := strings.Repeat("true; ", 40000)
+
+	consequence := []code.Instructions{}
+	for i := 0; i < 39999; i++ {
+		consequence = append(consequence, code.Make(code.OpTrue), code.Make(code.OpPop))
+	}
+	consequence = append(consequence, code.Make(code.OpTrue))
+
+	// 1 byte OpTrue, 6 byte OpWide OpJumpIf, the consequence and a
+	// 6 byte OpWide OpJump
+	afterConsequence := 7 + len(concatInstructions(consequence)) + 6
+
+	expected := []code.Instructions{
+		code.Make(code.OpTrue),
+		code.Make(code.OpJumpIf, afterConsequence),
+	}
+	expected = append(expected, consequence...)
+	expected = append(expected,
+		code.Make(code.OpJump, afterConsequence+1),
+		code.Make(code.OpNull),
+		code.Make(code.OpPop),
+	)
+
+	tests := []compilerTestCase{
+		{
+			input:                fmt.Sprintf("if (true) { %s }", statements),
+			expectedConstants:    []interface{}{},
+			expectedInstructions: expected,
+		},
+	}
+
+	runCompilerTests(t, tests)
+}
+
+func TestLineTables(t *testing.T) {
+	input := `let add = fn(a, b) {
+  a + b
+};
+add(1,
+  2);`
+
+	compiler := New()
+	if err := compiler.Compile(parse(input)); err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+	bytecode := compiler.Bytecode()
+
+	expectedMain := code.LineTable{
+		{Offset: 0, Line: 1, Column: 11}, // OpClosure
+		{Offset: 4, Line: 1, Column: 1},  // OpSetGlobal
+		{Offset: 7, Line: 4, Column: 1},  // OpGetGlobal
+		{Offset: 10, Line: 4, Column: 5}, // OpConstant
+		{Offset: 13, Line: 5, Column: 3}, // OpConstant
+		{Offset: 16, Line: 4, Column: 4}, // OpCall
+		{Offset: 18, Line: 4, Column: 1}, // OpPop
+	}
+	if !reflect.DeepEqual(bytecode.Lines, expectedMain) {
+		t.Errorf("wrong main line table.\nwant=%v\ngot =%v", expectedMain, bytecode.Lines)
+	}
+
+	fn := bytecode.Constants[0].(*object.CompiledFn)
+	if fn.Name != "add" {
+		t.Errorf("wrong function name. want=%q, got=%q", "add", fn.Name)
+	}
+
+	expectedFn := code.LineTable{
+		{Offset: 0, Line: 2, Column: 3}, // OpGetLocal a
+		{Offset: 2, Line: 2, Column: 7}, // OpGetLocal b
+		{Offset: 4, Line: 2, Column: 5}, // OpAdd
+		{Offset: 5, Line: 2, Column: 3}, // OpReturnValue
+	}
+	if !reflect.DeepEqual(fn.Lines, expectedFn) {
+		t.Errorf("wrong function line table.\nwant=%v\ngot =%v", expectedFn, fn.Lines)
+	}
+}
+
+func TestLineTablesAfterWideJumps(t *testing.T) {
+	input := "if (true) {\n" + strings.Repeat("true; ", 40000) + "\n};\n5;"
+
+	compiler := New()
+	if err := compiler.Compile(parse(input)); err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+	bytecode := compiler.Bytecode()
+
+	tests := []struct {
+		offset int
+		line   int
+	}{
+		{0, 1},                              // OpTrue
+		{7, 2},                              // first OpTrue of the consequence, after OpWide OpJumpIf
+		{len(bytecode.Instructions) - 4, 4}, // OpConstant 5
+	}
+
+	for _, tt := range tests {
+		if line, _ := bytecode.Lines.Lookup(tt.offset); line != tt.line {
+			t.Errorf("wrong line for offset %d. want=%d, got=%d", tt.offset, tt.line, line)
+		}
+	}
+}
+
+func TestMaxStack(t *testing.T) {
+	tests := []struct {
+		input    string
+		main     int
+		function int // of the first function constant, if any
+	}{
+		{`1; 2; 3`, 1, 0},
+		{`1 + 2 * 3`, 3, 0},
+		{`[1, 2, [3, 4]]`, 4, 0},
+		{`{1: 2, 3: 4}`, 4, 0},
+		{`if (true) { 1 + 2 } else { 3 }`, 2, 0},
+		{`let f = fn(a, b) { let c = a + b; c * (a - b) }; f(1, 2)`, 3, 3},
+		{`fn() { return 1; }()`, 1, 1},
+		{`let a = 1; fn(x) { fn() { x + a } }`, 1, 2}, // the inner function comes first
+	}
+
+	for _, tt := range tests {
+		compiler := New()
+		if err := compiler.Compile(parse(tt.input)); err != nil {
+			t.Fatalf("compiler error: %s", err)
+		}
+		bytecode := compiler.Bytecode()
+
+		if bytecode.MaxStack != tt.main {
+			t.Errorf("wrong max stack for main of %q. want=%d, got=%d", tt.input, tt.main, bytecode.MaxStack)
+		}
+
+		for _, c := range bytecode.Constants {
+			fn, ok := c.(*object.CompiledFn)
+			if !ok {
+				continue
+			}
+			if fn.MaxStack != tt.function {
+				t.Errorf("wrong max stack for function of %q. want=%d, got=%d", tt.input, tt.function, fn.MaxStack)
+			}
+			break
+		}
+	}
+}
+
 // ------------------------------ HELPERS -------------------------------
 
 func runCompilerTests(t *testing.T, tests []compilerTestCase) {
@@ -955,7 +1175,73 @@ func runCompilerTests(t *testing.T, tests []compilerTestCase) {
 		if err != nil {
 			t.Fatalf("testConstants failed: %s", err)
 		}
+
+		err = testFileRoundTrip(bytecode)
+		if err != nil {
+			t.Fatalf("testFileRoundTrip failed: %s", err)
+		}
+	}
+}
+
+// testFileRoundTrip checks that bytecode survives being written to and
+// read from an .mkc file
+func testFileRoundTrip(bytecode *Bytecode) error {
+	var buf bytes.Buffer
+
+	original := &mkc.File{
+		Instructions: bytecode.Instructions,
+		Constants:    bytecode.Constants,
+		MaxStack:     bytecode.MaxStack,
+		Lines:        bytecode.Lines,
+		GlobalNames:  bytecode.GlobalNames,
+	}
+	if err := original.Encode(&buf); err != nil {
+		return err
+	}
+
+	decoded, err := mkc.Decode(&buf)
+	if err != nil {
+		return err
+	}
+
+	if !bytes.Equal(decoded.Instructions, bytecode.Instructions) {
+		return fmt.Errorf("wrong instructions.\ngot=%q\nwant=%q", decoded.Instructions, bytecode.Instructions)
+	}
+
+	if decoded.MaxStack != bytecode.MaxStack {
+		return fmt.Errorf("wrong max stack. got=%d, want=%d", decoded.MaxStack, bytecode.MaxStack)
+	}
+
+	if len(decoded.Constants) != len(bytecode.Constants) {
+		return fmt.Errorf("wrong number of constants. got=%d, want=%d",
+			len(decoded.Constants), len(bytecode.Constants))
+	}
+
+	for i, want := range bytecode.Constants {
+		got := decoded.Constants[i]
+		if got.Type() != want.Type() {
+			return fmt.Errorf("constant %d - wrong type. got=%s, want=%s", i, got.Type(), want.Type())
+		}
+
+		switch want := want.(type) {
+		case *object.CompiledFn:
+			got := got.(*object.CompiledFn)
+			if !bytes.Equal(got.Instructions, want.Instructions) ||
+				got.NumLocals != want.NumLocals || got.NumParameters != want.NumParameters ||
+				got.MaxStack != want.MaxStack {
+				return fmt.Errorf("constant %d - wrong function. got=%+v, want=%+v", i, got, want)
+			}
+			if got.Name != want.Name || len(got.Lines) != len(want.Lines) {
+				return fmt.Errorf("constant %d - wrong debug info. got=%+v, want=%+v", i, got, want)
+			}
+		default:
+			if got.Inspect() != want.Inspect() {
+				return fmt.Errorf("constant %d - wrong value. got=%s, want=%s", i, got.Inspect(), want.Inspect())
+			}
+		}
 	}
+
+	return nil
 }
 
 func testConstants(t *testing.T, expected []interface{}, actual []object.Object) error {
@@ -1045,6 +1331,19 @@ func testInstructions(
 	return nil
 }
 
+// letterName returns a distinct identifier for i, since identifiers
+// cannot contain digits
+func letterName(i int) string {
+	name := ""
+	for {
+		name = string(rune('a'+i%26)) + name
+		i = i/26 - 1
+		if i < 0 {
+			return "v" + name
+		}
+	}
+}
+
 func concatInstructions(s []code.Instructions) code.Instructions {
 	out := code.Instructions{}
 
@@ -0,0 +1,186 @@
+package compiler
+
+import (
+	"fmt"
+	"monc/ast"
+	"monc/object"
+	"monc/token"
+	"sort"
+)
+
+type Severity int
+
+const (
+	SeverityWarning Severity = iota
+	// the program compiles, but the flagged code fails whenever it runs
+	SeverityError
+)
+
+func (s Severity) String() string {
+	switch s {
+	case SeverityWarning:
+		return "warning"
+	case SeverityError:
+		return "error"
+	}
+	return fmt.Sprintf("severity(%d)", int(s))
+}
+
+// diagnostic codes
+const (
+	UnusedVariable     = "unused-variable"
+	UnusedParameter    = "unused-parameter"
+	ShadowedBuiltin    = "shadowed-builtin"
+	UnreachableCode    = "unreachable-code"
+	WrongArgumentCount = "wrong-argument-count"
+)
+
+// Diagnostic is a problem found in a program that compiled successfully
+type Diagnostic struct {
+	Severity Severity
+	Line     int
+	Column   int
+	Code     string
+	Message  string
+}
+
+func (d Diagnostic) String() string {
+	return fmt.Sprintf("%d:%d: %s: %s [%s]", d.Line, d.Column, d.Severity, d.Message, d.Code)
+}
+
+/*
+binding tracks a name introduced by a `let` statement or a parameter in a
+compilation scope, so that unused names and calls with the wrong number of
+arguments can be reported. It lives next to the symbol table rather than in
+it because symbols are plain values compared by the tests.
+*/
+type binding struct {
+	name  string
+	token token.Token
+	param bool
+	used  bool
+	arity int // parameter count if bound to a function literal, -1 otherwise
+}
+
+// Diagnostics returns the warnings collected while compiling, ordered by
+// position
+func (c *Compiler) Diagnostics() []Diagnostic {
+	diagnostics := make([]Diagnostic, len(c.diagnostics))
+	copy(diagnostics, c.diagnostics)
+
+	sort.SliceStable(diagnostics, func(i, j int) bool {
+		if diagnostics[i].Line != diagnostics[j].Line {
+			return diagnostics[i].Line < diagnostics[j].Line
+		}
+		return diagnostics[i].Column < diagnostics[j].Column
+	})
+
+	return diagnostics
+}
+
+func (c *Compiler) report(severity Severity, tok token.Token, code, format string, a ...interface{}) {
+	c.diagnostics = append(c.diagnostics, Diagnostic{
+		Severity: severity,
+		Line:     tok.Line,
+		Column:   tok.Column,
+		Code:     code,
+		Message:  fmt.Sprintf(format, a...),
+	})
+}
+
+// addBinding records a name defined in the current scope and warns if it
+// hides a builtin function
+func (c *Compiler) addBinding(ident *ast.Identifier, param bool, value ast.Expression) {
+	if object.GetBuiltinByName(ident.Value) != nil {
+		c.report(SeverityWarning, ident.Token, ShadowedBuiltin,
+			"%s shadows the builtin function of the same name", ident.Value)
+	}
+
+	arity := -1
+	if fl, ok := value.(*ast.FunctionLiteral); ok {
+		arity = len(fl.Parameters)
+	}
+
+	scope := &c.scopes[c.scopeIndex]
+	scope.bindings = append(scope.bindings, &binding{
+		name:  ident.Value,
+		token: ident.Token,
+		param: param,
+		arity: arity,
+	})
+}
+
+// lookupBinding finds the innermost binding of name, nil if the name was
+// not defined by this compiler (e.g. a global from an earlier REPL line)
+func (c *Compiler) lookupBinding(name string) *binding {
+	for i := c.scopeIndex; i >= 0; i-- {
+		bindings := c.scopes[i].bindings
+		for j := len(bindings) - 1; j >= 0; j-- {
+			if bindings[j].name == name {
+				return bindings[j]
+			}
+		}
+	}
+	return nil
+}
+
+func (c *Compiler) markUsed(name string) {
+	if b := c.lookupBinding(name); b != nil {
+		b.used = true
+	}
+}
+
+/*
+reportUnused warns about the bindings of the current function scope that
+were never read. Globals are left alone: top-level bindings are how REPL
+sessions and scripts keep results around.
+*/
+func (c *Compiler) reportUnused() {
+	for _, b := range c.scopes[c.scopeIndex].bindings {
+		if b.used || b.name == "_" {
+			continue
+		}
+
+		if b.param {
+			c.report(SeverityWarning, b.token, UnusedParameter, "parameter %s is never used", b.name)
+		} else {
+			c.report(SeverityWarning, b.token, UnusedVariable, "%s is declared but never used", b.name)
+		}
+	}
+}
+
+// reportUnreachable warns about the first statement following a return
+// statement in the same block
+func (c *Compiler) reportUnreachable(statements []ast.Statement) {
+	for i := 1; i < len(statements); i++ {
+		if _, ok := statements[i-1].(*ast.ReturnStatement); ok {
+			c.report(SeverityWarning, nodeToken(statements[i]), UnreachableCode,
+				"unreachable code after return statement")
+			return
+		}
+	}
+}
+
+// checkArgumentCount flags calls to function literals, directly or through
+// a `let` binding, with a different number of arguments than parameters
+func (c *Compiler) checkArgumentCount(call *ast.CallExpression) {
+	arity := -1
+	name := "function"
+	tok := call.Token
+
+	switch fn := call.Function.(type) {
+	case *ast.FunctionLiteral:
+		arity = len(fn.Parameters)
+	case *ast.Identifier:
+		if b := c.lookupBinding(fn.Value); b != nil {
+			arity = b.arity
+			name = fn.Value
+			tok = fn.Token
+		}
+	}
+
+	if arity >= 0 && arity != len(call.Arguments) {
+		c.report(SeverityError, tok, WrongArgumentCount,
+			"%s called with %d arguments, want %d", name, len(call.Arguments), arity)
+	}
+}
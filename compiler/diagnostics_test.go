@@ -0,0 +1,87 @@
+package compiler
+
+import "testing"
+
+func TestDiagnostics(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected []string
+	}{
+		{
+			input:    `let add = fn(a, b) { a + b }; add(1, 2);`,
+			expected: []string{},
+		},
+		{
+			input: `let f = fn(a, b) { let c = 1; a };`,
+			expected: []string{
+				"1:15: warning: parameter b is never used [unused-parameter]",
+				"1:24: warning: c is declared but never used [unused-variable]",
+			},
+		},
+		{
+			input:    `let unused = 1;`,
+			expected: []string{},
+		},
+		{
+			input: `let len = fn(x) { x }; len(1);`,
+			expected: []string{
+				"1:5: warning: len shadows the builtin function of the same name [shadowed-builtin]",
+			},
+		},
+		{
+			input: `fn(first) { first }(1);`,
+			expected: []string{
+				"1:4: warning: first shadows the builtin function of the same name [shadowed-builtin]",
+			},
+		},
+		{
+			input: `fn() {
+   return 1;
+   2;
+}();`,
+			expected: []string{
+				"3:4: warning: unreachable code after return statement [unreachable-code]",
+			},
+		},
+		{
+			input: `let add = fn(a, b) { a + b };
+add(1);`,
+			expected: []string{
+				"2:1: error: add called with 1 arguments, want 2 [wrong-argument-count]",
+			},
+		},
+		{
+			input: `fn(a) { a }(1, 2);`,
+			expected: []string{
+				"1:12: error: function called with 2 arguments, want 1 [wrong-argument-count]",
+			},
+		},
+		{
+			input: `let countDown = fn(x) { if (x == 0) { 0 } else { countDown(x - 1) } }; countDown(1, 2);`,
+			expected: []string{
+				"1:72: error: countDown called with 2 arguments, want 1 [wrong-argument-count]",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		comp := New()
+		err := comp.Compile(parse(tt.input))
+		if err != nil {
+			t.Fatalf("compiler error: %s", err)
+		}
+
+		diagnostics := comp.Diagnostics()
+		if len(diagnostics) != len(tt.expected) {
+			t.Errorf("wrong number of diagnostics for %q. want=%d, got=%d (%v)",
+				tt.input, len(tt.expected), len(diagnostics), diagnostics)
+			continue
+		}
+
+		for i, d := range diagnostics {
+			if d.String() != tt.expected[i] {
+				t.Errorf("wrong diagnostic. want=%q, got=%q", tt.expected[i], d.String())
+			}
+		}
+	}
+}
@@ -0,0 +1,628 @@
+package compiler
+
+import (
+	"fmt"
+	"monc/ast"
+	"monc/code"
+	"monc/object"
+)
+
+/*
+compileRegisters compiles node to the register instruction set. Every
+expression is compiled into a destination register: locals live in a
+register for the whole function, everything else in temporaries that are
+released once the instruction reading them has been emitted.
+*/
+func (c *Compiler) compileRegisters(node ast.Node) error {
+	switch node := node.(type) {
+	case *ast.Program:
+		c.reportUnreachable(node.Statements)
+
+		for _, s := range node.Statements {
+			if err := c.statement(s); err != nil {
+				return err
+			}
+		}
+
+	case ast.Statement:
+		if err := c.statement(node); err != nil {
+			return err
+		}
+
+	case ast.Expression:
+		r, err := c.operand(node)
+		if err != nil {
+			return err
+		}
+		c.release(r, 1)
+	}
+
+	return c.err
+}
+
+// at attributes the instructions emitted for node to its position until
+// the returned function is called
+func (c *Compiler) at(node ast.Node) func() {
+	outer := c.position
+	if tok := nodeToken(node); tok.Line > 0 {
+		c.position = tok
+	}
+	return func() { c.position = outer }
+}
+
+func (c *Compiler) statement(s ast.Statement) error {
+	defer c.at(s)()
+
+	switch s := s.(type) {
+	case *ast.ExpressionStatement:
+		r, err := c.operand(s.Expression)
+		if err != nil {
+			return err
+		}
+		c.emit(code.OpRPop, r)
+		c.release(r, 1)
+
+	case *ast.LetStatement:
+		// defining before the value allows recursive calls in the body
+		symbol := c.symbolTable.Define(s.Name.Value)
+		c.addBinding(s.Name, false, s.Value)
+
+		// a value that reads the variable reads null, as in Compile
+		_, isFunction := s.Value.(*ast.FunctionLiteral)
+		nullFirst := !isFunction && mentions(s.Value, s.Name.Value)
+
+		if symbol.Scope != GlobalScope {
+			local := c.allocator().local(symbol.Index)
+			if nullFirst {
+				c.emit(code.OpRNull, local)
+			}
+			return c.expr(s.Value, local)
+		}
+
+		if nullFirst {
+			r := c.allocator().temp(1)
+			c.emit(code.OpRNull, r)
+			c.emit(code.OpRSetGlobal, symbol.Index, r)
+			c.release(r, 1)
+		}
+
+		r, err := c.operand(s.Value)
+		if err != nil {
+			return err
+		}
+		c.emit(code.OpRSetGlobal, symbol.Index, r)
+		c.release(r, 1)
+
+	case *ast.AssignStatement:
+		left, err := c.operand(s.Target.Left)
+		if err != nil {
+			return err
+		}
+		index, err := c.operand(s.Target.Index)
+		if err != nil {
+			return err
+		}
+		value, err := c.operand(s.Value)
+		if err != nil {
+			return err
+		}
+
+		c.emit(code.OpRSetIndex, left, index, value)
+		c.release(left, 1)
+		c.release(index, 1)
+		c.release(value, 1)
+
+	case *ast.ReturnStatement:
+		r, err := c.operand(s.ReturnValue)
+		if err != nil {
+			return err
+		}
+		c.emit(code.OpRReturnValue, r)
+		c.release(r, 1)
+
+	case *ast.BlockStatement:
+		c.reportUnreachable(s.Statements)
+
+		for _, s := range s.Statements {
+			if err := c.statement(s); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// block compiles the statements of b, storing the value of the last one
+// in dst, or null if it is not an expression
+func (c *Compiler) block(b *ast.BlockStatement, dst int) error {
+	defer c.at(b)()
+	c.reportUnreachable(b.Statements)
+
+	for i, s := range b.Statements {
+		last, ok := s.(*ast.ExpressionStatement)
+		if ok && i == len(b.Statements)-1 {
+			restore := c.at(last)
+			err := c.expr(last.Expression, dst)
+			restore()
+			return err
+		}
+
+		if err := c.statement(s); err != nil {
+			return err
+		}
+	}
+
+	c.emit(code.OpRNull, dst)
+	return nil
+}
+
+// operand returns the register holding the value of node: the register of
+// a local variable or a temporary the caller has to release
+func (c *Compiler) operand(node ast.Expression) (int, error) {
+	if ident, ok := node.(*ast.Identifier); ok {
+		symbol, ok := c.symbolTable.Resolve(ident.Value)
+		if ok && symbol.Scope == LocalScope {
+			c.markUsed(ident.Value)
+			return c.allocator().localRegister(symbol.Index), nil
+		}
+	}
+
+	// a call can return its result in the register of the function, so
+	// calls nested in expressions need no register besides their own
+	if call, ok := node.(*ast.CallExpression); ok {
+		fn := c.allocator().top(len(call.Arguments) + 1)
+		return fn, c.call(call, fn, fn)
+	}
+
+	r := c.allocator().temp(1)
+	return r, c.expr(node, r)
+}
+
+// release frees the temporaries among the n registers from first on
+func (c *Compiler) release(first, n int) {
+	c.allocator().release(first, n)
+}
+
+func (c *Compiler) allocator() *registerAllocator {
+	return &c.scopes[c.scopeIndex].allocator
+}
+
+// expr compiles node so that its value ends up in dst
+func (c *Compiler) expr(node ast.Expression, dst int) error {
+	defer c.at(node)()
+
+	switch node := node.(type) {
+	case *ast.IntegerLiteral:
+		c.emit(code.OpRConstant, dst, c.addConstant(integerConstant(node)))
+
+	case *ast.StringLiteral:
+		c.emit(code.OpRConstant, dst, c.addConstant(&object.String{Value: node.Value}))
+
+	case *ast.Boolean:
+		if node.Value {
+			c.emit(code.OpRTrue, dst)
+		} else {
+			c.emit(code.OpRFalse, dst)
+		}
+
+	case *ast.Identifier:
+		symbol, ok := c.symbolTable.Resolve(node.Value)
+		if !ok {
+			return fmt.Errorf("undefined variable %s", node.Value)
+		}
+		c.markUsed(node.Value)
+
+		c.loadSymbolInto(symbol, dst)
+
+	case *ast.PrefixExpression:
+		r, err := c.operand(node.Right)
+		if err != nil {
+			return err
+		}
+
+		switch node.Operator {
+		case "!":
+			c.emit(code.OpRBang, dst, r)
+		case "-":
+			c.emit(code.OpRMinus, dst, r)
+		default:
+			return fmt.Errorf("unknown operator %s", node.Operator)
+		}
+		c.release(r, 1)
+
+	case *ast.InfixExpression:
+		return c.infix(node, dst)
+
+	case *ast.IfExpression:
+		cond, err := c.operand(node.Condition)
+		if err != nil {
+			return err
+		}
+		jumpIfPos := c.emit(code.OpRJumpIf, 9999, cond) // back-patched below
+		c.release(cond, 1)
+
+		if err := c.block(node.Consequence, dst); err != nil {
+			return err
+		}
+
+		c.emit(code.OpRJump, 9999)
+		c.changeOperands(jumpIfPos, len(c.currentInstructions()))
+
+		// widening the OpRJumpIf moves the OpRJump emitted after it
+		jumpPos := c.scopes[c.scopeIndex].lastInstruction.Position
+
+		if node.Alternative == nil {
+			c.emit(code.OpRNull, dst)
+		} else if err := c.block(node.Alternative, dst); err != nil {
+			return err
+		}
+
+		c.changeOperands(jumpPos, len(c.currentInstructions()))
+
+	case *ast.ArrayLiteral:
+		n := len(node.Elements)
+		first := c.allocator().temp(n)
+
+		for i, el := range node.Elements {
+			if err := c.expr(el, first+i); err != nil {
+				return err
+			}
+		}
+
+		c.emit(code.OpRArray, dst, first, n)
+		c.release(first, n)
+
+	case *ast.HashLiteral:
+		n := 2 * len(node.Pairs)
+		first := c.allocator().temp(n)
+
+		for i, pair := range node.Pairs {
+			if err := c.expr(pair.Key, first+2*i); err != nil {
+				return err
+			}
+			if err := c.expr(pair.Value, first+2*i+1); err != nil {
+				return err
+			}
+		}
+
+		c.emit(code.OpRHash, dst, first, n)
+		c.release(first, n)
+
+	case *ast.IndexExpression:
+		left, err := c.operand(node.Left)
+		if err != nil {
+			return err
+		}
+		index, err := c.operand(node.Index)
+		if err != nil {
+			return err
+		}
+
+		c.emit(code.OpRIndex, dst, left, index)
+		c.release(left, 1)
+		c.release(index, 1)
+
+	case *ast.CallExpression:
+		fn := c.allocator().top(len(node.Arguments) + 1)
+		err := c.call(node, dst, fn)
+		c.release(fn, 1)
+		return err
+
+	case *ast.FunctionLiteral:
+		return c.functionLiteral(node, dst)
+
+	default:
+		return fmt.Errorf("cannot compile %T to registers", node)
+	}
+
+	return nil
+}
+
+func (c *Compiler) infix(node *ast.InfixExpression, dst int) error {
+	l, err := c.operand(node.Left)
+	if err != nil {
+		return err
+	}
+	r, err := c.operand(node.Right)
+	if err != nil {
+		return err
+	}
+
+	switch node.Operator {
+	case "+":
+		c.emit(code.OpRAdd, dst, l, r)
+	case "-":
+		c.emit(code.OpRSub, dst, l, r)
+	case "*":
+		c.emit(code.OpRMul, dst, l, r)
+	case "/":
+		c.emit(code.OpRDiv, dst, l, r)
+	case ">":
+		c.emit(code.OpRGreaterThan, dst, l, r)
+	case "<":
+		c.emit(code.OpRLessThan, dst, l, r)
+	case "==":
+		c.emit(code.OpREqual, dst, l, r)
+	case "!=":
+		c.emit(code.OpRNotEqual, dst, l, r)
+	default:
+		return fmt.Errorf("unknown operator %s", node.Operator)
+	}
+
+	c.release(l, 1)
+	c.release(r, 1)
+	return nil
+}
+
+// call compiles a call of node.Function in register fn with the arguments
+// in the registers after it, which it allocated with top: the callee's
+// frame starts after the function, so nothing may be live above it
+func (c *Compiler) call(node *ast.CallExpression, dst, fn int) error {
+	defer c.at(node)()
+	c.checkArgumentCount(node)
+
+	n := len(node.Arguments)
+	if err := c.expr(node.Function, fn); err != nil {
+		return err
+	}
+	for i, a := range node.Arguments {
+		if err := c.expr(a, fn+1+i); err != nil {
+			return err
+		}
+	}
+
+	c.emit(code.OpRCall, dst, fn, n)
+	c.release(fn+1, n)
+	return nil
+}
+
+func (c *Compiler) functionLiteral(node *ast.FunctionLiteral, dst int) error {
+	c.enterScope()
+
+	if name := node.Name; name != "" {
+		c.symbolTable.DefineFunctionName(name)
+	}
+
+	// parameters are the first registers, where OpRCall puts the arguments
+	for _, p := range node.Parameters {
+		symbol := c.symbolTable.Define(p.Value)
+		c.addBinding(p, true, nil)
+		c.allocator().local(symbol.Index)
+	}
+
+	// the lets get their registers before the body is compiled: a let
+	// in the argument of a call would otherwise get the lowest free
+	// register, which may be above the arguments, where the callee's
+	// frame starts
+	c.allocator().reserve(countLets(node.Body))
+
+	statements := node.Body.Statements
+	c.reportUnreachable(statements)
+
+	returned := false
+	for i, s := range statements {
+		if last, ok := s.(*ast.ExpressionStatement); ok && i == len(statements)-1 {
+			restore := c.at(last)
+			r, err := c.operand(last.Expression)
+			if err != nil {
+				return err
+			}
+			c.emit(code.OpRReturnValue, r)
+			restore()
+			returned = true
+			continue
+		}
+
+		if err := c.statement(s); err != nil {
+			return err
+		}
+		_, returned = s.(*ast.ReturnStatement)
+	}
+
+	if !returned {
+		c.emit(code.OpRReturn)
+	}
+
+	c.reportUnused()
+
+	freeSymbols := c.symbolTable.FreeSymbols
+	numLocals := c.symbolTable.numDefinitions
+	localNames := c.symbolTable.definedNames()
+	lines := c.scopes[c.scopeIndex].lines
+	registers := c.allocator().size()
+	localRegisters := append([]int(nil), c.allocator().locals...)
+	instructions := c.leaveScope()
+
+	compiledFn := &object.CompiledFn{
+		Instructions:  instructions,
+		NumLocals:     numLocals,
+		NumParameters: len(node.Parameters),
+		MaxStack:      registers - numLocals,
+		Name:          node.Name,
+		Lines:         lines,
+		LocalNames:    localNames,
+		FreeNames:     symbolNames(freeSymbols),
+
+		LocalRegisters: localRegisters,
+	}
+	fnIndex := c.addConstant(compiledFn)
+
+	n := len(freeSymbols)
+	first := c.allocator().temp(n)
+	for i, s := range freeSymbols {
+		c.loadSymbolInto(s, first+i)
+	}
+
+	c.emit(code.OpRClosure, dst, fnIndex, first, n)
+	c.release(first, n)
+
+	return nil
+}
+
+// countLets returns the number of lets in node, without descending into
+// function literals, which have registers of their own
+func countLets(node ast.Node) int {
+	n := 0
+	switch node := node.(type) {
+	case *ast.BlockStatement:
+		for _, s := range node.Statements {
+			n += countLets(s)
+		}
+	case *ast.LetStatement:
+		n = 1 + countLets(node.Value)
+	case *ast.ExpressionStatement:
+		n = countLets(node.Expression)
+	case *ast.AssignStatement:
+		n = countLets(node.Target) + countLets(node.Value)
+	case *ast.ReturnStatement:
+		n = countLets(node.ReturnValue)
+	case *ast.PrefixExpression:
+		n = countLets(node.Right)
+	case *ast.InfixExpression:
+		n = countLets(node.Left) + countLets(node.Right)
+	case *ast.IfExpression:
+		n = countLets(node.Condition) + countLets(node.Consequence)
+		if node.Alternative != nil {
+			n += countLets(node.Alternative)
+		}
+	case *ast.CallExpression:
+		n = countLets(node.Function)
+		for _, a := range node.Arguments {
+			n += countLets(a)
+		}
+	case *ast.IndexExpression:
+		n = countLets(node.Left) + countLets(node.Index)
+	case *ast.ArrayLiteral:
+		for _, e := range node.Elements {
+			n += countLets(e)
+		}
+	case *ast.HashLiteral:
+		for _, pair := range node.Pairs {
+			n += countLets(pair.Key) + countLets(pair.Value)
+		}
+	}
+	return n
+}
+
+func (c *Compiler) loadSymbolInto(symbol Symbol, dst int) {
+	switch symbol.Scope {
+	case GlobalScope:
+		c.emit(code.OpRGetGlobal, dst, symbol.Index)
+	case LocalScope:
+		if src := c.allocator().localRegister(symbol.Index); src != dst {
+			c.emit(code.OpRMove, dst, src)
+		}
+	case BuiltinScope:
+		c.emit(code.OpRGetBuiltin, dst, symbol.Index)
+	case FreeScope:
+		c.emit(code.OpRGetFree, dst, symbol.Index)
+	case FunctionScope:
+		c.emit(code.OpRCurrentClosure, dst)
+	}
+}
+
+type registerState byte
+
+const (
+	registerFree registerState = iota
+	registerTemp
+	registerLocal
+)
+
+/*
+registerAllocator hands out the registers of one compilation scope. Locals
+keep their register until the scope ends; functions reserve them ahead of
+their body, so they are the first registers. Temporaries are reused once
+released. The number of registers a function needs is the most ever in use.
+*/
+type registerAllocator struct {
+	registers []registerState
+	locals    []int // register of each local, by symbol index
+	reserved  []int // registers of the locals still to be allocated
+}
+
+// reserve allocates the registers of the next n locals above every
+// register in use
+func (ra *registerAllocator) reserve(n int) {
+	first := ra.take(len(ra.registers), n, registerLocal)
+	for r := first; r < first+n; r++ {
+		ra.reserved = append(ra.reserved, r)
+	}
+}
+
+// local allocates the register of the local with the given symbol index,
+// the next reserved one if there is any
+func (ra *registerAllocator) local(index int) int {
+	var r int
+	if len(ra.reserved) > 0 {
+		r, ra.reserved = ra.reserved[0], ra.reserved[1:]
+	} else {
+		r = ra.take(ra.find(1), 1, registerLocal)
+	}
+	for len(ra.locals) <= index {
+		ra.locals = append(ra.locals, 0)
+	}
+	ra.locals[index] = r
+	return r
+}
+
+func (ra *registerAllocator) localRegister(index int) int {
+	return ra.locals[index]
+}
+
+// temp allocates n consecutive temporaries and returns the first
+func (ra *registerAllocator) temp(n int) int {
+	if n == 0 {
+		return 0
+	}
+	return ra.take(ra.find(n), n, registerTemp)
+}
+
+// top allocates n consecutive temporaries above every register in use
+func (ra *registerAllocator) top(n int) int {
+	first := len(ra.registers)
+	for first > 0 && ra.registers[first-1] == registerFree {
+		first--
+	}
+	return ra.take(first, n, registerTemp)
+}
+
+func (ra *registerAllocator) release(first, n int) {
+	for r := first; r < first+n && r < len(ra.registers); r++ {
+		if ra.registers[r] == registerTemp {
+			ra.registers[r] = registerFree
+		}
+	}
+}
+
+func (ra *registerAllocator) size() int {
+	return len(ra.registers)
+}
+
+// find returns the first of the lowest n consecutive free registers,
+// which may extend past the registers allocated so far
+func (ra *registerAllocator) find(n int) int {
+	run := 0
+	for r, state := range ra.registers {
+		if state != registerFree {
+			run = 0
+			continue
+		}
+		run++
+		if run == n {
+			return r - n + 1
+		}
+	}
+	return len(ra.registers) - run
+}
+
+func (ra *registerAllocator) take(first, n int, state registerState) int {
+	for len(ra.registers) < first+n {
+		ra.registers = append(ra.registers, registerFree)
+	}
+	for r := first; r < first+n; r++ {
+		ra.registers[r] = state
+	}
+	return first
+}
@@ -0,0 +1,163 @@
+package compiler
+
+import (
+	"monc/code"
+	"testing"
+)
+
+func TestRegisterInstructions(t *testing.T) {
+	tests := []struct {
+		input                string
+		expectedConstants    []interface{}
+		expectedInstructions []code.Instructions
+		expectedRegisters    int
+	}{
+		{
+			input:             "1 + 2",
+			expectedConstants: []interface{}{1, 2},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpRConstant, 1, 0),
+				code.Make(code.OpRConstant, 2, 1),
+				code.Make(code.OpRAdd, 0, 1, 2),
+				code.Make(code.OpRPop, 0),
+			},
+			expectedRegisters: 3,
+		},
+		{
+			input:             "let one = 1; -one",
+			expectedConstants: []interface{}{1},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpRConstant, 0, 0),
+				code.Make(code.OpRSetGlobal, 0, 0),
+				code.Make(code.OpRGetGlobal, 1, 0),
+				code.Make(code.OpRMinus, 0, 1),
+				code.Make(code.OpRPop, 0),
+			},
+			expectedRegisters: 2,
+		},
+		{
+			input:             "if (true) { 10 }; [1, 2]",
+			expectedConstants: []interface{}{10, 1, 2},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpRTrue, 1),        // 0000
+				code.Make(code.OpRJumpIf, 16, 1),  // 0003
+				code.Make(code.OpRConstant, 0, 0), // 0008
+				code.Make(code.OpRJump, 19),       // 0013
+				code.Make(code.OpRNull, 0),        // 0016
+				code.Make(code.OpRPop, 0),         // 0019
+				code.Make(code.OpRConstant, 1, 1), // 0022
+				code.Make(code.OpRConstant, 2, 2), // 0027
+				code.Make(code.OpRArray, 0, 1, 2), // 0032
+				code.Make(code.OpRPop, 0),         // 0039
+			},
+			expectedRegisters: 3,
+		},
+		{
+			input: "fn(a, b) { let c = a + b; c }(1, 2)",
+			expectedConstants: []interface{}{
+				[]code.Instructions{
+					code.Make(code.OpRAdd, 2, 0, 1),
+					code.Make(code.OpRReturnValue, 2),
+				},
+				1,
+				2,
+			},
+			expectedInstructions: []code.Instructions{
+				// the result goes to the register of the function
+				code.Make(code.OpRClosure, 0, 0, 0, 0),
+				code.Make(code.OpRConstant, 1, 1),
+				code.Make(code.OpRConstant, 2, 2),
+				code.Make(code.OpRCall, 0, 0, 2),
+				code.Make(code.OpRPop, 0),
+			},
+			expectedRegisters: 3,
+		},
+		{
+			input: "fn(a) { a[0] = a; }",
+			expectedConstants: []interface{}{
+				0,
+				[]code.Instructions{
+					code.Make(code.OpRConstant, 1, 0),
+					code.Make(code.OpRSetIndex, 0, 1, 0),
+					code.Make(code.OpRReturn),
+				},
+			},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpRClosure, 0, 1, 0, 0),
+				code.Make(code.OpRPop, 0),
+			},
+			expectedRegisters: 1,
+		},
+	}
+
+	for _, tt := range tests {
+		compiler := New(WithRegisters())
+		if err := compiler.Compile(parse(tt.input)); err != nil {
+			t.Fatalf("compiler error: %s", err)
+		}
+
+		bytecode := compiler.Bytecode()
+		if !bytecode.Registers {
+			t.Errorf("%q: bytecode not marked as using registers", tt.input)
+		}
+
+		if err := testInstructions(tt.expectedInstructions, bytecode.Instructions); err != nil {
+			t.Fatalf("%q: testInstructions failed: %s", tt.input, err)
+		}
+
+		if err := testConstants(t, tt.expectedConstants, bytecode.Constants); err != nil {
+			t.Fatalf("%q: testConstants failed: %s", tt.input, err)
+		}
+
+		if bytecode.MaxStack != tt.expectedRegisters {
+			t.Errorf("%q: wrong number of registers. want=%d, got=%d",
+				tt.input, tt.expectedRegisters, bytecode.MaxStack)
+		}
+	}
+}
+
+func TestRegisterAllocator(t *testing.T) {
+	ra := registerAllocator{}
+
+	if r := ra.temp(2); r != 0 {
+		t.Errorf("first temporaries at %d, want 0", r)
+	}
+	if r := ra.local(0); r != 2 {
+		t.Errorf("local at %d, want 2", r)
+	}
+
+	// released temporaries are reused, locals are not
+	ra.release(0, 2)
+	ra.release(2, 1)
+	if r := ra.temp(1); r != 0 {
+		t.Errorf("reused temporary at %d, want 0", r)
+	}
+	if r := ra.temp(2); r != 3 {
+		t.Errorf("run of 2 temporaries at %d, want 3", r)
+	}
+
+	// top ignores the free register below the ones in use
+	ra.release(3, 2)
+	if r := ra.top(1); r != 3 {
+		t.Errorf("top at %d, want 3", r)
+	}
+
+	if ra.localRegister(0) != 2 {
+		t.Errorf("local 0 moved to %d", ra.localRegister(0))
+	}
+	if ra.size() != 5 {
+		t.Errorf("wrong size. want=5, got=%d", ra.size())
+	}
+
+	// reserved registers go to the next locals, in order
+	ra.reserve(2)
+	if r := ra.local(1); r != 5 {
+		t.Errorf("first reserved local at %d, want 5", r)
+	}
+	if r := ra.local(2); r != 6 {
+		t.Errorf("second reserved local at %d, want 6", r)
+	}
+	if r := ra.temp(2); r != 7 {
+		t.Errorf("run of 2 temporaries at %d, want 7", r)
+	}
+}
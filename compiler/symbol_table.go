@@ -80,6 +80,26 @@ func (s *SymbolTable) DefineFunctionName(name string) (symbol Symbol) {
 
 // ------------------------------ helpers -------------------------------
 
+// definedNames returns the names of the symbols created by Define, indexed
+// by their Index
+func (s *SymbolTable) definedNames() []string {
+	names := make([]string, s.numDefinitions)
+	for name, symbol := range s.store {
+		if symbol.Scope == GlobalScope || symbol.Scope == LocalScope {
+			names[symbol.Index] = name
+		}
+	}
+	return names
+}
+
+func symbolNames(symbols []Symbol) []string {
+	names := make([]string, len(symbols))
+	for i, s := range symbols {
+		names[i] = s.Name
+	}
+	return names
+}
+
 /*
 defineFree adds a `Symbol` to `FreeSymbols` and returns
 a `FreeScope` version of it
@@ -0,0 +1,320 @@
+// Package debugger implements the interactive debugger of `monc debug` as
+// a hook of the VM
+package debugger
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"monc/vm"
+	"strconv"
+	"strings"
+)
+
+const PROMPT = "(monc) "
+
+// ErrQuit stops the program when the user quits, or the input ends
+var ErrQuit = errors.New("quit")
+
+/*
+Debugger is a vm.Hook that stops the program at breakpoints and after
+steps, reading commands from in and writing to out. It works with source
+lines: a line is reached when a frame starts executing instructions of it,
+so a line with calls is reached once, not again after each call returns.
+It stops at the first line of the program.
+*/
+type Debugger struct {
+	lines   []string // the source, for listing where the program stopped
+	in      *bufio.Scanner
+	out     io.Writer
+	started bool
+
+	breakpoints []breakpoint
+	nextID      int
+
+	mode mode
+	from int // the depth a step, next or finish started at
+
+	// last line reached by each active frame, 0 before the first
+	reached []int
+	// set when the frame a step started in returns, to stop in the caller
+	// even though it is still on the line of the call
+	stopNext bool
+
+	lastCommand string
+}
+
+type breakpoint struct {
+	id       int
+	line     int    // 0 for a function breakpoint
+	function string // the name the function was bound to with `let`
+}
+
+func (b breakpoint) String() string {
+	if b.line > 0 {
+		return fmt.Sprintf("breakpoint %d at line %d", b.id, b.line)
+	}
+	return fmt.Sprintf("breakpoint %d at function %s", b.id, b.function)
+}
+
+// mode is what the debugger does until the next breakpoint
+type mode int
+
+const (
+	modeContinue mode = iota
+	modeStep          // stop at the next line
+	modeNext          // stop at the next line of the frame or a caller
+	modeFinish        // stop at the next line of a caller
+)
+
+func New(source string, in io.Reader, out io.Writer) *Debugger {
+	return &Debugger{
+		lines:  strings.Split(source, "\n"),
+		in:     bufio.NewScanner(in),
+		out:    out,
+		nextID: 1,
+	}
+}
+
+func (d *Debugger) Instruction(machine *vm.VM) error {
+	depth := machine.Depth()
+	for len(d.reached) < depth {
+		d.reached = append(d.reached, 0)
+	}
+
+	frame := machine.StackFrame(0)
+	last := d.reached[depth-1]
+	if d.stopNext {
+		d.reached[depth-1] = frame.Line
+		return d.stop(machine)
+	}
+	if frame.Line == 0 || frame.Line == last {
+		return nil
+	}
+	d.reached[depth-1] = frame.Line
+
+	if b, ok := d.breakpointAt(frame, last == 0); ok {
+		fmt.Fprintln(d.out, b)
+		return d.stop(machine)
+	}
+
+	switch {
+	case !d.started,
+		d.mode == modeStep,
+		d.mode == modeNext && depth <= d.from,
+		d.mode == modeFinish && depth < d.from:
+		return d.stop(machine)
+	}
+	return nil
+}
+
+func (d *Debugger) Call(machine *vm.VM) error {
+	return nil
+}
+
+// Return forgets the line the returning frame reached, so that the next
+// frame at its depth starts afresh
+func (d *Debugger) Return(machine *vm.VM) error {
+	depth := machine.Depth()
+	if depth <= len(d.reached) {
+		d.reached = d.reached[:depth-1]
+	}
+	if d.mode != modeContinue && depth == d.from {
+		d.stopNext = true
+	}
+	return nil
+}
+
+// breakpointAt finds a breakpoint at the line of frame, or at its function
+// if it was just entered
+func (d *Debugger) breakpointAt(frame vm.StackFrame, entered bool) (breakpoint, bool) {
+	for _, b := range d.breakpoints {
+		if b.line == frame.Line || entered && b.function == frame.Function {
+			return b, true
+		}
+	}
+	return breakpoint{}, false
+}
+
+// stop shows where the program is and runs commands until one resumes it
+func (d *Debugger) stop(machine *vm.VM) error {
+	d.started = true
+	d.mode = modeContinue
+	d.stopNext = false
+	d.printLocation(machine.StackFrame(0))
+
+	for {
+		fmt.Fprint(d.out, PROMPT)
+		if !d.in.Scan() {
+			fmt.Fprintln(d.out)
+			return ErrQuit
+		}
+
+		line := strings.TrimSpace(d.in.Text())
+		if line == "" {
+			line = d.lastCommand
+		}
+		d.lastCommand = line
+
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+
+		resume, err := d.command(machine, fields[0], fields[1:])
+		if err != nil {
+			return err
+		}
+		if resume {
+			d.from = machine.Depth()
+			return nil
+		}
+	}
+}
+
+// command runs one command and reports whether it resumes the program
+func (d *Debugger) command(machine *vm.VM, name string, args []string) (bool, error) {
+	switch name {
+	case "break", "b":
+		d.addBreakpoint(args)
+	case "delete", "d":
+		d.deleteBreakpoint(args)
+	case "info", "i":
+		for _, b := range d.breakpoints {
+			fmt.Fprintln(d.out, b)
+		}
+	case "continue", "c":
+		d.mode = modeContinue
+		return true, nil
+	case "step", "s":
+		d.mode = modeStep
+		return true, nil
+	case "next", "n":
+		d.mode = modeNext
+		return true, nil
+	case "finish", "f":
+		if machine.Depth() == 1 {
+			fmt.Fprintln(d.out, "finish is not meaningful in the main program")
+			return false, nil
+		}
+		d.mode = modeFinish
+		return true, nil
+	case "locals", "l":
+		d.printVariables(machine)
+	case "print", "p":
+		d.printVariable(machine, args)
+	case "backtrace", "bt":
+		for n, frame := range machine.StackTrace() {
+			fmt.Fprintf(d.out, "#%d %s\n", n, frame)
+		}
+	case "quit", "q":
+		return false, ErrQuit
+	case "help", "h":
+		fmt.Fprint(d.out, help)
+	default:
+		fmt.Fprintf(d.out, "unknown command %q, try help\n", name)
+	}
+	return false, nil
+}
+
+const help = `break LINE|FUNCTION  stop at a line, or when a function is called
+delete N             delete breakpoint N
+info                 list the breakpoints
+continue             run until a breakpoint
+step                 run to the next line, entering calls
+next                 run to the next line, stepping over calls
+finish               run until the current function returns
+locals               print the variables of the current frame
+print NAME           print a variable
+backtrace            print the active calls
+quit                 stop the program
+An empty line repeats the last command.
+`
+
+func (d *Debugger) addBreakpoint(args []string) {
+	if len(args) != 1 {
+		fmt.Fprintln(d.out, "usage: break LINE|FUNCTION")
+		return
+	}
+
+	b := breakpoint{id: d.nextID}
+	if line, err := strconv.Atoi(args[0]); err == nil {
+		if line < 1 || line > len(d.lines) {
+			fmt.Fprintf(d.out, "no line %d, the program has %d\n", line, len(d.lines))
+			return
+		}
+		b.line = line
+	} else {
+		b.function = args[0]
+	}
+
+	d.nextID++
+	d.breakpoints = append(d.breakpoints, b)
+	fmt.Fprintln(d.out, b)
+}
+
+func (d *Debugger) deleteBreakpoint(args []string) {
+	if len(args) != 1 {
+		fmt.Fprintln(d.out, "usage: delete N")
+		return
+	}
+
+	id, _ := strconv.Atoi(args[0])
+	for i, b := range d.breakpoints {
+		if b.id == id {
+			d.breakpoints = append(d.breakpoints[:i], d.breakpoints[i+1:]...)
+			return
+		}
+	}
+	fmt.Fprintf(d.out, "no breakpoint %s\n", args[0])
+}
+
+func (d *Debugger) printLocation(frame vm.StackFrame) {
+	fmt.Fprintln(d.out, frame)
+	if frame.Line > 0 && frame.Line <= len(d.lines) {
+		fmt.Fprintf(d.out, "%4d  %s\n", frame.Line, d.lines[frame.Line-1])
+	}
+}
+
+// variables are the locals of the current frame, or the globals in the
+// main program
+func (d *Debugger) variables(machine *vm.VM) []vm.Variable {
+	if machine.Depth() == 1 {
+		return machine.Globals()
+	}
+	return machine.Locals(0)
+}
+
+func (d *Debugger) printVariables(machine *vm.VM) {
+	for _, v := range d.variables(machine) {
+		d.printValue(v)
+	}
+}
+
+// printVariable looks name up like the compiler: in the current frame,
+// then among the globals
+func (d *Debugger) printVariable(machine *vm.VM, args []string) {
+	if len(args) != 1 {
+		fmt.Fprintln(d.out, "usage: print NAME")
+		return
+	}
+
+	for _, variables := range [][]vm.Variable{machine.Locals(0), machine.Globals()} {
+		for _, v := range variables {
+			if v.Name == args[0] {
+				d.printValue(v)
+				return
+			}
+		}
+	}
+	fmt.Fprintf(d.out, "no variable %s\n", args[0])
+}
+
+func (d *Debugger) printValue(v vm.Variable) {
+	if v.Value == nil {
+		fmt.Fprintf(d.out, "%s = <unset>\n", v.Name)
+		return
+	}
+	fmt.Fprintf(d.out, "%s = %s\n", v.Name, v.Value.Inspect())
+}
@@ -0,0 +1,146 @@
+package debugger
+
+import (
+	"bytes"
+	"errors"
+	"monc/compiler"
+	"monc/lexer"
+	"monc/parser"
+	"monc/vm"
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+const program = `let add = fn(a, b) {
+  let sum = a + b;
+  sum
+};
+let x = add(1, 2);
+let y = add(x, 3);
+y`
+
+var backends = []struct {
+	name    string
+	options []compiler.Option
+}{
+	{"stack", nil},
+	{"registers", []compiler.Option{compiler.WithRegisters()}},
+}
+
+// debug runs source in the debugger with the given commands and returns
+// its output and the error of the VM
+func debug(t *testing.T, source, commands string, options []compiler.Option) (string, error) {
+	t.Helper()
+
+	p := parser.New(lexer.New(source))
+	comp := compiler.New(options...)
+	if err := comp.Compile(p.ParseProgram()); err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+
+	var out bytes.Buffer
+	d := New(source, strings.NewReader(commands), &out)
+	err := vm.New(comp.Bytecode(), vm.WithHook(d)).Run()
+	return out.String(), err
+}
+
+var location = regexp.MustCompile(`^at (\S+) \((\d+):\d+\)$`)
+
+// stops lists where the debugger stopped as function:line
+func stops(output string) []string {
+	var stops []string
+	for _, line := range strings.Split(output, "\n") {
+		for strings.HasPrefix(line, PROMPT) {
+			line = strings.TrimPrefix(line, PROMPT)
+		}
+		if m := location.FindStringSubmatch(line); m != nil {
+			stops = append(stops, m[1]+":"+m[2])
+		}
+	}
+	return stops
+}
+
+func TestStepping(t *testing.T) {
+	tests := []struct {
+		commands string
+		stops    []string
+	}{
+		{"c\n", []string{"<main>:1"}},
+		{"s\ns\ns\ns\nn\nn\nc\n", []string{"<main>:1", "<main>:5", "add:2", "add:3", "<main>:5", "<main>:6", "<main>:7"}},
+		{"n\nn\nn\nc\n", []string{"<main>:1", "<main>:5", "<main>:6", "<main>:7"}},
+		{"s\ns\nfinish\nc\n", []string{"<main>:1", "<main>:5", "add:2", "<main>:5"}},
+		// an empty line repeats the last command
+		{"s\n\n\nc\n", []string{"<main>:1", "<main>:5", "add:2", "add:3"}},
+	}
+
+	for _, backend := range backends {
+		for _, tt := range tests {
+			output, err := debug(t, program, tt.commands, backend.options)
+			if err != nil {
+				t.Fatalf("%s/%q: vm error: %s", backend.name, tt.commands, err)
+			}
+			if got := stops(output); !reflect.DeepEqual(got, tt.stops) {
+				t.Errorf("%s/%q: wrong stops. want=%v, got=%v\n%s", backend.name, tt.commands, tt.stops, got, output)
+			}
+		}
+	}
+}
+
+func TestBreakpoints(t *testing.T) {
+	tests := []struct {
+		commands string
+		stops    []string
+	}{
+		{"b 6\nc\nc\n", []string{"<main>:1", "<main>:6"}},
+		{"b 3\nc\nc\nc\n", []string{"<main>:1", "add:3", "add:3"}},
+		{"b add\nc\nc\nc\n", []string{"<main>:1", "add:2", "add:2"}},
+		{"b add\nc\nd 1\nc\n", []string{"<main>:1", "add:2"}},
+	}
+
+	for _, backend := range backends {
+		for _, tt := range tests {
+			output, err := debug(t, program, tt.commands, backend.options)
+			if err != nil {
+				t.Fatalf("%s/%q: vm error: %s", backend.name, tt.commands, err)
+			}
+			if got := stops(output); !reflect.DeepEqual(got, tt.stops) {
+				t.Errorf("%s/%q: wrong stops. want=%v, got=%v\n%s", backend.name, tt.commands, tt.stops, got, output)
+			}
+		}
+	}
+}
+
+func TestInspection(t *testing.T) {
+	commands := "b 3\nc\nlocals\np sum\np x\nbt\nc\nlocals\np x\nc\n"
+	expected := []string{
+		"a = 1\nb = 2\nsum = 3\n",
+		"sum = 3\n",
+		"no variable x\n", // not set until add returns
+		"#0 at add (3:3)\n#1 at <main> (5:",
+		"a = 3\nb = 3\nsum = 6\n",
+		"x = 3\n",
+	}
+
+	for _, backend := range backends {
+		output, err := debug(t, program, commands, backend.options)
+		if err != nil {
+			t.Fatalf("%s: vm error: %s", backend.name, err)
+		}
+		for _, want := range expected {
+			if !strings.Contains(output, want) {
+				t.Errorf("%s: output does not contain %q:\n%s", backend.name, want, output)
+			}
+		}
+	}
+}
+
+func TestQuit(t *testing.T) {
+	for _, commands := range []string{"q\n", "s\n"} {
+		_, err := debug(t, program, commands, nil)
+		if !errors.Is(err, ErrQuit) {
+			t.Errorf("%q: wrong error. want=%s, got=%v", commands, ErrQuit, err)
+		}
+	}
+}
@@ -0,0 +1,278 @@
+/*
+Package disasm prints compiled programs in a readable form. Unlike
+[code.Instructions.String] it lists every function in the constant pool,
+resolves constant, builtin and variable operands and labels jump targets:
+
+	constants:
+	  0  10
+	  1  fn#1
+
+	main:
+	  0000  OpConstant 0            ; 10
+	  0003  OpJumpIf L1
+	  ...
+	L1:
+	  0010  OpNull
+
+	fn#1 params=1 locals=1 free=0 stack=1: ; double
+	  ; line 2
+	  0000  OpGetLocal 0            ; x
+	  0002  OpReturnValue
+
+Functions are printed in the order they are reached from the main program.
+Source lines and names are shown if the compiler recorded them.
+*/
+package disasm
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"monc/code"
+	"monc/compiler"
+	"monc/object"
+	"sort"
+	"strings"
+)
+
+// Disassemble returns the listing of bytecode
+func Disassemble(bytecode *compiler.Bytecode) string {
+	var out bytes.Buffer
+	Fprint(&out, bytecode)
+	return out.String()
+}
+
+// Fprint writes the listing of bytecode to w. Malformed instructions are
+// reported in the listing and end the function they appear in.
+func Fprint(w io.Writer, bytecode *compiler.Bytecode) error {
+	d := &disassembler{bytecode: bytecode, printed: map[int]bool{}}
+
+	d.constants()
+	d.function("main:", bytecode.Instructions, nil)
+
+	for len(d.pending) > 0 {
+		index := d.pending[0]
+		d.pending = d.pending[1:]
+		d.compiledFn(index)
+	}
+
+	// functions that are not reachable from main, e.g. from earlier
+	// lines of a REPL session
+	for i, c := range bytecode.Constants {
+		if _, ok := c.(*object.CompiledFn); ok && !d.printed[i] {
+			d.compiledFn(i)
+		}
+	}
+
+	_, err := w.Write(d.out.Bytes())
+	return err
+}
+
+type disassembler struct {
+	bytecode *compiler.Bytecode
+	out      bytes.Buffer
+
+	printed map[int]bool // functions printed or queued, by constant index
+	pending []int
+}
+
+func (d *disassembler) constants() {
+	if len(d.bytecode.Constants) == 0 {
+		return
+	}
+
+	fmt.Fprintf(&d.out, "constants:\n")
+	for i := range d.bytecode.Constants {
+		fmt.Fprintf(&d.out, "  %d  %s\n", i, d.constant(i))
+	}
+	fmt.Fprintf(&d.out, "\n")
+}
+
+// constant describes the constant at index, as used in comments
+func (d *disassembler) constant(index int) string {
+	if index >= len(d.bytecode.Constants) {
+		return "<invalid constant>"
+	}
+
+	switch c := d.bytecode.Constants[index].(type) {
+	case *object.String:
+		return fmt.Sprintf("%q", c.Value)
+	case *object.CompiledFn:
+		return fmt.Sprintf("fn#%d", index)
+	case nil:
+		return "<nil>"
+	default:
+		return c.Inspect()
+	}
+}
+
+func (d *disassembler) compiledFn(index int) {
+	d.printed[index] = true
+
+	fn := d.bytecode.Constants[index].(*object.CompiledFn)
+	header := fmt.Sprintf("\nfn#%d params=%d locals=%d free=%d stack=%d:",
+		index, fn.NumParameters, fn.NumLocals, len(fn.FreeNames), fn.MaxStack)
+	if fn.Name != "" {
+		header += " ; " + fn.Name
+	}
+	d.function(header, fn.Instructions, fn)
+}
+
+// function prints one instruction sequence; fn is nil for the main program
+func (d *disassembler) function(header string, ins code.Instructions, fn *object.CompiledFn) {
+	fmt.Fprintf(&d.out, "%s\n", header)
+
+	labels := jumpLabels(ins)
+
+	lines := d.bytecode.Lines
+	if fn != nil {
+		lines = fn.Lines
+	}
+	lastLine := 0
+
+	pos := 0
+	for pos < len(ins) {
+		if label, ok := labels[pos]; ok {
+			fmt.Fprintf(&d.out, "%s:\n", label)
+		}
+
+		if line, _ := lines.Lookup(pos); line != lastLine && line > 0 {
+			fmt.Fprintf(&d.out, "  ; line %d\n", line)
+			lastLine = line
+		}
+
+		op, operands, n, err := code.Decode(ins, pos)
+		if err != nil {
+			fmt.Fprintf(&d.out, "  %04d  ERROR: %s\n", pos, err)
+			return
+		}
+
+		def, _ := code.Lookup(byte(op))
+		text := d.instruction(def, op, operands, labels)
+		if code.Opcode(ins[pos]) == code.OpWide {
+			text = "OpWide " + text
+		}
+
+		if comment := d.comment(op, operands, fn); comment != "" {
+			fmt.Fprintf(&d.out, "  %04d  %-22s ; %s\n", pos, text, comment)
+		} else {
+			fmt.Fprintf(&d.out, "  %04d  %s\n", pos, text)
+		}
+
+		pos += n
+	}
+
+	if label, ok := labels[pos]; ok {
+		fmt.Fprintf(&d.out, "%s:\n", label)
+	}
+}
+
+func (d *disassembler) instruction(def *code.Definition, op code.Opcode, operands []int, labels map[int]string) string {
+	parts := []string{def.Name}
+
+	for i, o := range operands {
+		if label, ok := labels[o]; ok && i == 0 && isJump(op) {
+			parts = append(parts, label)
+			continue
+		}
+		parts = append(parts, fmt.Sprint(o))
+	}
+
+	return strings.Join(parts, " ")
+}
+
+// comment resolves the operand of op to what it refers to
+func (d *disassembler) comment(op code.Opcode, operands []int, fn *object.CompiledFn) string {
+	switch op {
+	case code.OpConstant:
+		return d.constant(operands[0])
+
+	case code.OpClosure:
+		index := operands[0]
+		if index < len(d.bytecode.Constants) {
+			if _, ok := d.bytecode.Constants[index].(*object.CompiledFn); ok {
+				if !d.printed[index] {
+					d.printed[index] = true
+					d.pending = append(d.pending, index)
+				}
+				return fmt.Sprintf("fn#%d", index)
+			}
+		}
+		return "<not a function>"
+
+	case code.OpGetGlobal, code.OpSetGlobal:
+		return name(d.bytecode.GlobalNames, operands[0])
+
+	case code.OpGetLocal, code.OpSetLocal:
+		if fn != nil {
+			return name(fn.LocalNames, operands[0])
+		}
+
+	case code.OpGetFree:
+		if fn != nil {
+			return name(fn.FreeNames, operands[0])
+		}
+
+	case code.OpGetBuiltin:
+		if operands[0] < len(object.Builtins) {
+			return object.Builtins[operands[0]].Name
+		}
+		return "<invalid builtin>"
+	}
+
+	return ""
+}
+
+func name(names []string, index int) string {
+	if index < len(names) {
+		return names[index]
+	}
+	return ""
+}
+
+func isJump(op code.Opcode) bool {
+	switch op {
+	case code.OpJump, code.OpJumpIf, code.OpRJump, code.OpRJumpIf:
+		return true
+	}
+	return false
+}
+
+// jumpLabels names the targets of the jumps in ins L1, L2, ... in order of
+// their position. Targets that are not instruction boundaries stay
+// unlabelled and are printed as offsets.
+func jumpLabels(ins code.Instructions) map[int]string {
+	boundaries := map[int]bool{}
+	jumps := []int{}
+
+	pos := 0
+	for pos < len(ins) {
+		boundaries[pos] = true
+		op, operands, n, err := code.Decode(ins, pos)
+		if err != nil {
+			break
+		}
+		if isJump(op) {
+			jumps = append(jumps, operands[0])
+		}
+		pos += n
+	}
+	boundaries[pos] = true
+
+	targets := []int{}
+	seen := map[int]bool{}
+	for _, t := range jumps {
+		if boundaries[t] && !seen[t] {
+			seen[t] = true
+			targets = append(targets, t)
+		}
+	}
+
+	sort.Ints(targets)
+
+	labels := map[int]string{}
+	for i, t := range targets {
+		labels[t] = fmt.Sprintf("L%d", i+1)
+	}
+	return labels
+}
@@ -0,0 +1,122 @@
+package disasm
+
+import (
+	"monc/code"
+	"monc/compiler"
+	"monc/lexer"
+	"monc/object"
+	"monc/parser"
+	"strings"
+	"testing"
+)
+
+func TestDisassemble(t *testing.T) {
+	input := `
+let limit = 2;
+let count = fn(n) { let step = 1; fn() { if (n < limit) { n + step } else { len("") } } };
+`
+
+	expected := `constants:
+  0  2
+  1  1
+  2  ""
+  3  fn#3
+  4  fn#4
+
+main:
+  ; line 2
+  0000  OpConstant 0           ; 2
+  0003  OpSetGlobal 0          ; limit
+  ; line 3
+  0006  OpClosure 4 0          ; fn#4
+  0010  OpSetGlobal 1          ; count
+
+fn#4 params=1 locals=2 free=0 stack=2: ; count
+  ; line 3
+  0000  OpConstant 1           ; 1
+  0003  OpSetLocal 1           ; step
+  0005  OpGetLocal 0           ; n
+  0007  OpGetLocal 1           ; step
+  0009  OpClosure 3 2          ; fn#3
+  0013  OpReturnValue
+
+fn#3 params=0 locals=0 free=2 stack=2:
+  ; line 3
+  0000  OpGetFree 0            ; n
+  0002  OpGetGlobal 0          ; limit
+  0005  OpLessThan
+  0006  OpJumpIf L1
+  0009  OpGetFree 0            ; n
+  0011  OpGetFree 1            ; step
+  0013  OpAdd
+  0014  OpJump L2
+L1:
+  0017  OpGetBuiltin 0         ; len
+  0019  OpConstant 2           ; ""
+  0022  OpCall 1
+L2:
+  0024  OpReturnValue
+`
+
+	actual := Disassemble(compile(t, input))
+	if actual != expected {
+		t.Errorf("wrong listing.\nwant=\n%s\ngot=\n%s", expected, actual)
+	}
+}
+
+func TestDisassembleWithoutDebugInfo(t *testing.T) {
+	bytecode := compile(t, `let x = 1; let f = fn(a) { a }; f(x);`)
+
+	bytecode.GlobalNames = nil
+	bytecode.Lines = nil
+	for _, c := range bytecode.Constants {
+		if fn, ok := c.(*object.CompiledFn); ok {
+			fn.Name, fn.Lines, fn.LocalNames = "", nil, nil
+		}
+	}
+
+	actual := Disassemble(bytecode)
+	if !strings.Contains(actual, "  0000  OpGetLocal 0\n") {
+		t.Errorf("expected local without name, got=\n%s", actual)
+	}
+	if !strings.Contains(actual, "OpSetGlobal 0\n") {
+		t.Errorf("expected global without name, got=\n%s", actual)
+	}
+	if strings.Contains(actual, "; line") || strings.Contains(actual, "; f\n") {
+		t.Errorf("unexpected debug information, got=\n%s", actual)
+	}
+}
+
+func TestDisassembleMalformed(t *testing.T) {
+	bytecode := &compiler.Bytecode{
+		Instructions: append(code.Make(code.OpJump, 2), code.Make(code.OpClosure, 7, 0)[:2]...),
+		Constants:    []object.Object{},
+	}
+
+	expected := `main:
+  0000  OpJump 2
+  0003  ERROR: OpClosure at 3 is truncated
+`
+
+	actual := Disassemble(bytecode)
+	if actual != expected {
+		t.Errorf("wrong listing.\nwant=\n%s\ngot=\n%s", expected, actual)
+	}
+}
+
+func compile(t *testing.T, input string) *compiler.Bytecode {
+	t.Helper()
+
+	p := parser.New(lexer.New(input))
+	program := p.ParseProgram()
+	if len(p.Errors()) != 0 {
+		t.Fatalf("parser errors: %v", p.Errors())
+	}
+
+	comp := compiler.New()
+	if err := comp.Compile(program); err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+
+	return comp.Bytecode()
+}
@@ -11,4 +11,9 @@ var builtins = map[string]*object.Builtin{
 	"rest":  object.GetBuiltinByName("rest"),
 	"push":  object.GetBuiltinByName("push"),
 	"puts":  object.GetBuiltinByName("puts"),
+
+	"append": object.GetBuiltinByName("append"),
+	"delete": object.GetBuiltinByName("delete"),
+	"keys":   object.GetBuiltinByName("keys"),
+	"values": object.GetBuiltinByName("values"),
 }
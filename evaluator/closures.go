@@ -0,0 +1,553 @@
+package evaluator
+
+import (
+	"monc/ast"
+	"monc/object"
+)
+
+/*
+Program is a program compiled by Compile into nested Go closures, one for
+each node, which run without the type switch of Eval and find variables by
+their slot in a frame instead of by name in chained environments. Values,
+errors and return values are the objects of Eval, which Run matches
+exactly.
+
+Every function, and the program itself, has a scope whose slots are its
+parameters and the names of all its lets, including those in nested
+blocks, declared before its body is compiled. Like a lookup in an
+environment, an identifier resolves to the innermost scope whose slot for
+it is set, so it may be used before its let has run in an outer scope, as
+by a recursive function, and finds a builtin or an outer variable until
+then.
+*/
+type Program struct {
+	run func() object.Object
+}
+
+// Compile compiles program; errors, like undefined identifiers, only occur
+// when it runs, as with Eval
+func Compile(program *ast.Program) (compiled *Program) {
+	defer func() {
+		if r := recover(); r != nil {
+			err := newError("internal error: %v", r)
+			compiled = &Program{run: func() object.Object { return err }}
+		}
+	}()
+
+	s := newScope(nil)
+	s.declare(program)
+	statements := compileStatements(program.Statements, s)
+	size := len(s.names)
+
+	return &Program{run: func() object.Object {
+		f := &frame{slots: make([]object.Object, size)}
+
+		var result object.Object
+		for _, statement := range statements {
+			result = statement(f)
+
+			switch result := result.(type) {
+			case *object.ReturnValue:
+				return result.Value
+			case *object.Error:
+				return result
+			}
+		}
+		return result
+	}}
+}
+
+// Run runs the program with fresh globals and returns the value of its
+// last statement, like Eval
+func (p *Program) Run() (result object.Object) {
+	defer func() {
+		if r := recover(); r != nil {
+			result = newError("internal error: %v", r)
+		}
+	}()
+
+	return p.run()
+}
+
+// frame holds the variables of one call of a function, or of the program
+type frame struct {
+	slots []object.Object
+	outer *frame // the frame the function was created in
+	depth int    // the number of calls the frame is nested in
+}
+
+// evalFunc evaluates a compiled node in a frame
+type evalFunc func(f *frame) object.Object
+
+// scope maps the names of a function to the slots of its frames
+type scope struct {
+	names map[string]int
+	outer *scope
+}
+
+func newScope(outer *scope) *scope {
+	return &scope{names: map[string]int{}, outer: outer}
+}
+
+func (s *scope) define(name string) int {
+	slot, ok := s.names[name]
+	if !ok {
+		slot = len(s.names)
+		s.names[name] = slot
+	}
+	return slot
+}
+
+// declare defines the names node binds with let, without descending into
+// function literals, which have scopes of their own
+func (s *scope) declare(node ast.Node) {
+	switch node := node.(type) {
+	case *ast.Program:
+		for _, statement := range node.Statements {
+			s.declare(statement)
+		}
+	case *ast.BlockStatement:
+		for _, statement := range node.Statements {
+			s.declare(statement)
+		}
+	case *ast.LetStatement:
+		s.define(node.Name.Value)
+		s.declare(node.Value)
+	case *ast.ExpressionStatement:
+		s.declare(node.Expression)
+	case *ast.AssignStatement:
+		s.declare(node.Target)
+		s.declare(node.Value)
+	case *ast.ReturnStatement:
+		s.declare(node.ReturnValue)
+	case *ast.PrefixExpression:
+		s.declare(node.Right)
+	case *ast.InfixExpression:
+		s.declare(node.Left)
+		s.declare(node.Right)
+	case *ast.IfExpression:
+		s.declare(node.Condition)
+		s.declare(node.Consequence)
+		if node.Alternative != nil {
+			s.declare(node.Alternative)
+		}
+	case *ast.CallExpression:
+		s.declare(node.Function)
+		for _, argument := range node.Arguments {
+			s.declare(argument)
+		}
+	case *ast.IndexExpression:
+		s.declare(node.Left)
+		s.declare(node.Index)
+	case *ast.ArrayLiteral:
+		for _, element := range node.Elements {
+			s.declare(element)
+		}
+	case *ast.HashLiteral:
+		for _, pair := range node.Pairs {
+			s.declare(pair.Key)
+			s.declare(pair.Value)
+		}
+	}
+}
+
+// variable is a slot of the frame depth functions out from the current one
+type variable struct {
+	depth, slot int
+}
+
+// resolve lists the variables name may refer to, innermost first
+func (s *scope) resolve(name string) []variable {
+	var variables []variable
+	for depth := 0; s != nil; depth, s = depth+1, s.outer {
+		if slot, ok := s.names[name]; ok {
+			variables = append(variables, variable{depth, slot})
+		}
+	}
+	return variables
+}
+
+func compileStatements(statements []ast.Statement, s *scope) []evalFunc {
+	compiled := make([]evalFunc, len(statements))
+	for i, statement := range statements {
+		compiled[i] = compileNode(statement, s)
+	}
+	return compiled
+}
+
+func compileNode(node ast.Node, s *scope) evalFunc {
+	switch node := node.(type) {
+
+	// Statements
+	case *ast.ExpressionStatement:
+		return compileNode(node.Expression, s)
+
+	case *ast.LetStatement:
+		value := compileNode(node.Value, s)
+		slot := s.define(node.Name.Value)
+		return func(f *frame) object.Object {
+			val := value(f)
+			if isError(val) {
+				return val
+			}
+			f.slots[slot] = val
+			return nil
+		}
+
+	case *ast.AssignStatement:
+		left, index := compileNode(node.Target.Left, s), compileNode(node.Target.Index, s)
+		value := compileNode(node.Value, s)
+		return func(f *frame) object.Object {
+			l := left(f)
+			if isError(l) {
+				return l
+			}
+			i := index(f)
+			if isError(i) {
+				return i
+			}
+			val := value(f)
+			if isError(val) {
+				return val
+			}
+			if err := evalAssignment(l, i, val); err != nil {
+				return err
+			}
+			return nil
+		}
+
+	case *ast.BlockStatement:
+		return compileBlock(node, s)
+
+	case *ast.ReturnStatement:
+		value := compileNode(node.ReturnValue, s)
+		return func(f *frame) object.Object {
+			val := value(f)
+			if isError(val) {
+				return val
+			}
+			return &object.ReturnValue{Value: val}
+		}
+
+	// Expressions
+	case *ast.Identifier:
+		return compileIdentifier(node, s)
+
+	case *ast.IntegerLiteral:
+		var integer object.Object = &object.Integer{Value: node.Value}
+		if node.Big != nil {
+			integer = object.NewBigInt(node.Big)
+		}
+		return func(*frame) object.Object { return integer }
+
+	case *ast.Boolean:
+		boolean := nativeBooleanToBooleanObject(node.Value)
+		return func(*frame) object.Object { return boolean }
+
+	case *ast.StringLiteral:
+		str := &object.String{Value: node.Value}
+		return func(*frame) object.Object { return str }
+
+	case *ast.PrefixExpression:
+		right := compileNode(node.Right, s)
+		operator := node.Operator
+		return func(f *frame) object.Object {
+			r := right(f)
+			if isError(r) {
+				return r
+			}
+			return evalPrefixExpression(operator, r)
+		}
+
+	case *ast.InfixExpression:
+		left, right := compileNode(node.Left, s), compileNode(node.Right, s)
+		operator := node.Operator
+		return func(f *frame) object.Object {
+			l := left(f)
+			if isError(l) {
+				return l
+			}
+			r := right(f)
+			if isError(r) {
+				return r
+			}
+			return evalInfixExpression(operator, l, r)
+		}
+
+	case *ast.IfExpression:
+		return compileIf(node, s)
+
+	case *ast.FunctionLiteral:
+		return compileFunction(node, s)
+
+	case *ast.CallExpression:
+		if node.Function.TokenLiteral() == "quote" {
+			return compileQuote(node.Arguments[0], s)
+		}
+		return compileCall(node, s)
+
+	case *ast.ArrayLiteral:
+		elements := compileExpressions(node.Elements, s)
+		return func(f *frame) object.Object {
+			values := evalCompiled(elements, f)
+			if len(values) == 1 && isError(values[0]) {
+				return values[0]
+			}
+			return &object.Array{Elements: values}
+		}
+
+	case *ast.IndexExpression:
+		left, index := compileNode(node.Left, s), compileNode(node.Index, s)
+		return func(f *frame) object.Object {
+			l := left(f)
+			if isError(l) {
+				return l
+			}
+			i := index(f)
+			if isError(i) {
+				return i
+			}
+			return evalIndexExpression(l, i)
+		}
+
+	case *ast.HashLiteral:
+		return compileHash(node, s)
+	}
+
+	return func(*frame) object.Object { return nil }
+}
+
+func compileBlock(block *ast.BlockStatement, s *scope) evalFunc {
+	statements := compileStatements(block.Statements, s)
+	return func(f *frame) object.Object {
+		var result object.Object
+		for _, statement := range statements {
+			result = statement(f)
+			if result != nil {
+				rt := result.Type()
+				if rt == object.RETURN_VALUE_OBJ || rt == object.ERROR_OBJ {
+					return result
+				}
+			}
+		}
+		return result
+	}
+}
+
+// compileIdentifier reads the first variable that is set, or else the
+// builtin of the name
+func compileIdentifier(node *ast.Identifier, s *scope) evalFunc {
+	variables := s.resolve(node.Value)
+	var fallback object.Object = newError("identifier not found: " + node.Value)
+	if builtin, ok := builtins[node.Value]; ok {
+		fallback = builtin
+	}
+
+	// the common cases: a local, or a variable of the enclosing function
+	// or program
+	if len(variables) == 1 && variables[0].depth <= 1 {
+		slot := variables[0].slot
+		if variables[0].depth == 0 {
+			return func(f *frame) object.Object {
+				if val := f.slots[slot]; val != nil {
+					return val
+				}
+				return fallback
+			}
+		}
+		return func(f *frame) object.Object {
+			if val := f.outer.slots[slot]; val != nil {
+				return val
+			}
+			return fallback
+		}
+	}
+
+	return func(f *frame) object.Object {
+		depth := 0
+		for _, v := range variables {
+			for ; depth < v.depth; depth++ {
+				f = f.outer
+			}
+			if val := f.slots[v.slot]; val != nil {
+				return val
+			}
+		}
+		return fallback
+	}
+}
+
+func compileIf(ie *ast.IfExpression, s *scope) evalFunc {
+	condition := compileNode(ie.Condition, s)
+	consequence := compileBlock(ie.Consequence, s)
+	alternative := func(*frame) object.Object { return NULL }
+	if ie.Alternative != nil {
+		block := compileBlock(ie.Alternative, s)
+		alternative = func(f *frame) object.Object { return blockValue(block(f)) }
+	}
+
+	return func(f *frame) object.Object {
+		cond := condition(f)
+		if isError(cond) {
+			return cond
+		}
+		if isTruthy(cond) {
+			return blockValue(consequence(f))
+		}
+		return alternative(f)
+	}
+}
+
+// closure is a function value of a compiled program: a function literal
+// with the frame it was created in
+type closure struct {
+	fn    *function
+	outer *frame
+}
+
+// function is a compiled function literal
+type function struct {
+	literal *ast.FunctionLiteral
+	params  []int // the slot of each parameter
+	size    int   // the number of slots of its frames
+	body    evalFunc
+}
+
+func (c *closure) Type() object.ObjectType { return object.FUNCTION_OBJ }
+func (c *closure) Inspect() string {
+	fn := &object.Function{Parameters: c.fn.literal.Parameters, Body: c.fn.literal.Body}
+	return fn.Inspect()
+}
+
+func compileFunction(literal *ast.FunctionLiteral, outer *scope) evalFunc {
+	s := newScope(outer)
+	fn := &function{literal: literal, params: make([]int, len(literal.Parameters))}
+	for i, param := range literal.Parameters {
+		fn.params[i] = s.define(param.Value)
+	}
+	s.declare(literal.Body)
+	fn.body = compileBlock(literal.Body, s)
+	fn.size = len(s.names)
+
+	return func(f *frame) object.Object {
+		return &closure{fn: fn, outer: f}
+	}
+}
+
+func compileCall(node *ast.CallExpression, s *scope) evalFunc {
+	function := compileNode(node.Function, s)
+	arguments := compileExpressions(node.Arguments, s)
+
+	return func(f *frame) object.Object {
+		fn := function(f)
+		if isError(fn) {
+			return fn
+		}
+
+		args := evalCompiled(arguments, f)
+		if len(args) == 1 && isError(args[0]) {
+			return args[0]
+		}
+
+		return applyCompiled(fn, args, f.depth)
+	}
+}
+
+// applyCompiled calls fn from a caller that is depth calls deep, with the
+// same limit as applyFunction
+func applyCompiled(fn object.Object, args []object.Object, depth int) object.Object {
+	cl, ok := fn.(*closure)
+	if !ok {
+		return applyFunction(fn, args, depth)
+	}
+
+	if len(args) != len(cl.fn.params) {
+		return newError("wrong number of arguments: want=%d, got=%d", len(cl.fn.params), len(args))
+	}
+	if depth+1 >= MaxCallDepth {
+		return newError("maximum recursion depth exceeded")
+	}
+
+	f := &frame{slots: make([]object.Object, cl.fn.size), outer: cl.outer, depth: depth + 1}
+	for i, slot := range cl.fn.params {
+		f.slots[slot] = args[i]
+	}
+	return blockValue(unwrapReturnValue(cl.fn.body(f)))
+}
+
+func compileExpressions(exps []ast.Expression, s *scope) []evalFunc {
+	compiled := make([]evalFunc, len(exps))
+	for i, e := range exps {
+		compiled[i] = compileNode(e, s)
+	}
+	return compiled
+}
+
+// evalCompiled is evalExpressions for compiled expressions
+func evalCompiled(exps []evalFunc, f *frame) []object.Object {
+	var result []object.Object
+
+	for _, e := range exps {
+		evaluated := e(f)
+		if isError(evaluated) {
+			return []object.Object{evaluated}
+		}
+		result = append(result, evaluated)
+	}
+
+	return result
+}
+
+func compileHash(node *ast.HashLiteral, s *scope) evalFunc {
+	type pair struct{ key, value evalFunc }
+	pairs := make([]pair, 0, len(node.Pairs))
+	for _, p := range node.Pairs {
+		pairs = append(pairs, pair{compileNode(p.Key, s), compileNode(p.Value, s)})
+	}
+
+	return func(f *frame) object.Object {
+		hash := object.NewHash(len(pairs))
+		for _, p := range pairs {
+			key := p.key(f)
+			if isError(key) {
+				return key
+			}
+
+			hashKey, ok := key.(object.Hashable)
+			if !ok {
+				return newError("unusable as hash key: %s", key.Type())
+			}
+
+			value := p.value(f)
+			if isError(value) {
+				return value
+			}
+
+			hash.Set(hashKey.HashKey(), object.HashPair{Key: key, Value: value})
+		}
+		return hash
+	}
+}
+
+// compileQuote compiles the arguments of the unquote calls in quoted,
+// which are evaluated and substituted where the quote is
+func compileQuote(quoted ast.Node, s *scope) evalFunc {
+	unquoted := map[*ast.CallExpression]evalFunc{}
+	ast.Modify(quoted, func(node ast.Node) ast.Node {
+		if call, ok := node.(*ast.CallExpression); ok && isUnquoteCall(call) && len(call.Arguments) == 1 {
+			unquoted[call] = compileNode(call.Arguments[0], s)
+		}
+		return node
+	})
+
+	return func(f *frame) object.Object {
+		node := ast.Modify(quoted, func(node ast.Node) ast.Node {
+			call, ok := node.(*ast.CallExpression)
+			if !ok || unquoted[call] == nil {
+				return node
+			}
+			return convertObjectToASTNode(unquoted[call](f))
+		})
+		return &object.Quote{Node: node}
+	}
+}
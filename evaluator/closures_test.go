@@ -0,0 +1,122 @@
+package evaluator
+
+import (
+	"monc/ast"
+	"monc/lexer"
+	"monc/object"
+	"monc/parser"
+	"strings"
+	"testing"
+)
+
+func testCompile(s string) object.Object {
+	return Compile(parser.New(lexer.New(s)).ParseProgram()).Run()
+}
+
+func TestCompiledScopes(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		// a global used by a function before its let has run
+		{"let f = fn() { x }; let x = 5; f()", 5},
+		{"let f = fn() { x }; f(); let x = 1;", "identifier not found: x"},
+		// a local not set yet falls back to the global, or the builtin
+		{"let x = 1; let f = fn() { let y = x; let x = 2; y + x }; f()", 3},
+		{`let f = fn() { let a = len("ab"); let len = fn(s) { 10 }; a + len("abc") }; f()`, 12},
+		// lets in blocks belong to the function
+		{"let f = fn(c) { if (c) { let a = 1; } else { let a = 2; }; a }; f(true) + f(false)", 3},
+		{"let f = fn() { if (false) { let a = 1; }; a }; f()", "identifier not found: a"},
+		{"let f = fn() { let a = [0]; a[0] = if (true) { let b = 2; b }; a[0] + b }; f()", 4},
+		// each call has its own frame
+		{"let fib = fn(n) { if (n < 2) { return n; } fib(n - 1) + fib(n - 2) }; fib(15)", 610},
+		{"let adder = fn(x) { fn(y) { x + y } }; let a = adder(1); let b = adder(10); a(1) + b(1)", 13},
+		{"let f = fn(a, b, c) { fn() { fn() { a + b + c } } }; f(1, 2, 3)()()", 6},
+		// the last of duplicate parameters wins, like in environments
+		{"let f = fn(a, a) { a }; f(1, 2)", 2},
+		{"let a = 1; let a = a + 1; a", 2},
+	}
+
+	for _, tt := range tests {
+		compiled := testCompile(tt.input)
+		switch expected := tt.expected.(type) {
+		case int:
+			testIntegerObject(t, compiled, int64(expected))
+		case string:
+			errObj, ok := compiled.(*object.Error)
+			if !ok || errObj.Message != expected {
+				t.Errorf("%q: wrong result. want error %q, got=%s", tt.input, expected, inspect(compiled))
+			}
+		}
+
+		if errObj, ok := testEval(tt.input).(*object.Error); ok && strings.HasPrefix(errObj.Message, "evaluators disagree") {
+			t.Errorf("%q: %s", tt.input, errObj.Message)
+		}
+	}
+}
+
+func TestCompiledProgramRunsAgain(t *testing.T) {
+	program := Compile(parser.New(lexer.New("let a = [1]; let b = push(a, 2); len(b)")).ParseProgram())
+
+	for i := 0; i < 2; i++ {
+		testIntegerObject(t, program.Run(), 2)
+	}
+}
+
+func TestCompiledRecursionDepth(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{"let f = fn(n) { if (n == 0) { 0 } else { 1 + f(n - 1) } }; f(1022)", 1022},
+		{"let f = fn(n) { if (n == 0) { 0 } else { 1 + f(n - 1) } }; f(1023)", "maximum recursion depth exceeded"},
+		{"let f = fn(x) { f(x) }; f(1);", "maximum recursion depth exceeded"},
+	}
+
+	for _, tt := range tests {
+		compiled := testEval(tt.input)
+		switch expected := tt.expected.(type) {
+		case int:
+			testIntegerObject(t, compiled, int64(expected))
+		case string:
+			errObj, ok := compiled.(*object.Error)
+			if !ok || errObj.Message != expected {
+				t.Errorf("%q: wrong result. want error %q, got=%s", tt.input, expected, inspect(compiled))
+			}
+		}
+	}
+}
+
+func TestCompiledRecoversPanics(t *testing.T) {
+	// a prefix expression without operand, which the parser never produces
+	program := &ast.Program{Statements: []ast.Statement{
+		&ast.ExpressionStatement{Expression: &ast.PrefixExpression{Operator: "-"}},
+	}}
+
+	compiled := Compile(program).Run()
+
+	errObj, ok := compiled.(*object.Error)
+	if !ok {
+		t.Fatalf("no error object returned. got=%T(%+v)", compiled, compiled)
+	}
+	if !strings.HasPrefix(errObj.Message, "internal error: ") {
+		t.Errorf("wrong error message. got=%q", errObj.Message)
+	}
+}
+
+func BenchmarkFibonacci(b *testing.B) {
+	input := "let fib = fn(n) { if (n < 2) { return n; } fib(n - 1) + fib(n - 2) }; fib(20)"
+
+	b.Run("eval", func(b *testing.B) {
+		program := parser.New(lexer.New(input)).ParseProgram()
+		for i := 0; i < b.N; i++ {
+			Eval(program, object.NewEnvironment())
+		}
+	})
+	b.Run("compile", func(b *testing.B) {
+		program := Compile(parser.New(lexer.New(input)).ParseProgram())
+		for i := 0; i < b.N; i++ {
+			program.Run()
+		}
+	})
+}
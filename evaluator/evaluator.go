@@ -6,6 +6,11 @@ import (
 	"monc/object"
 )
 
+// MaxCallDepth limits the depth of calls, the main program included, like
+// the default frame limit of the VM. Deeper recursion would overflow the Go
+// stack, which kills the process.
+const MaxCallDepth = 1024
+
 var (
 	TRUE  = &object.Boolean{Value: true}
 	FALSE = &object.Boolean{Value: false}
@@ -30,10 +35,31 @@ func Eval(node ast.Node, env *object.Environment) object.Object {
 
 		env.Set(node.Name.Value, val)
 
+	case *ast.AssignStatement:
+		left := Eval(node.Target.Left, env)
+		if isError(left) {
+			return left
+		}
+		index := Eval(node.Target.Index, env)
+		if isError(index) {
+			return index
+		}
+		val := Eval(node.Value, env)
+		if isError(val) {
+			return val
+		}
+
+		if err := evalAssignment(left, index, val); err != nil {
+			return err
+		}
+
 		// Expressions
 	case *ast.Identifier:
 		return evalIdentifier(node, env)
 	case *ast.IntegerLiteral:
+		if node.Big != nil {
+			return object.NewBigInt(node.Big)
+		}
 		return &object.Integer{Value: node.Value}
 
 	case *ast.Boolean:
@@ -87,7 +113,7 @@ func Eval(node ast.Node, env *object.Environment) object.Object {
 			return args[0]
 		}
 
-		return applyFunction(function, args)
+		return applyFunction(function, args, env.Depth())
 
 	case *ast.StringLiteral:
 		return &object.String{Value: node.Value}
@@ -119,10 +145,10 @@ func Eval(node ast.Node, env *object.Environment) object.Object {
 }
 
 func evalHashLiteral(node *ast.HashLiteral, env *object.Environment) object.Object {
-	pairs := make(map[object.HashKey]object.HashPair)
+	hash := object.NewHash(len(node.Pairs))
 
-	for keyNode, valueNode := range node.Pairs {
-		key := Eval(keyNode, env)
+	for _, pair := range node.Pairs {
+		key := Eval(pair.Key, env)
 		if isError(key) {
 			return key
 		}
@@ -132,17 +158,15 @@ func evalHashLiteral(node *ast.HashLiteral, env *object.Environment) object.Obje
 			return newError("unusable as hash key: %s", key.Type())
 		}
 
-		value := Eval(valueNode, env)
+		value := Eval(pair.Value, env)
 		if isError(value) {
 			return value
 		}
 
-		hashed := hashKey.HashKey()
-		pairs[hashed] = object.HashPair{Key: key, Value: value}
-
+		hash.Set(hashKey.HashKey(), object.HashPair{Key: key, Value: value})
 	}
 
-	return &object.Hash{Pairs: pairs}
+	return hash
 }
 
 func evalIndexExpression(left, index object.Object) object.Object {
@@ -156,6 +180,29 @@ func evalIndexExpression(left, index object.Object) object.Object {
 	}
 }
 
+// evalAssignment stores val in an element of an array, which must exist,
+// or in a hash
+func evalAssignment(left, index, val object.Object) *object.Error {
+	switch {
+	case left.Type() == object.ARRAY_OBJ && index.Type() == object.INTEGER_OBJ:
+		elements := left.(*object.Array).Elements
+		i, ok := index.(*object.Integer)
+		if !ok || i.Value < 0 || i.Value >= int64(len(elements)) {
+			return newError("index out of range: %s with length %d", index.Inspect(), len(elements))
+		}
+		elements[i.Value] = val
+	case left.Type() == object.HASH_OBJ:
+		key, ok := index.(object.Hashable)
+		if !ok {
+			return newError("unusable as hash key: %s", index.Type())
+		}
+		left.(*object.Hash).Set(key.HashKey(), object.HashPair{Key: index, Value: val})
+	default:
+		return newError("index operator not supported: %s", left.Type())
+	}
+	return nil
+}
+
 func evalHashIndexExpression(hash, index object.Object) object.Object {
 	hashObject := hash.(*object.Hash)
 
@@ -164,7 +211,7 @@ func evalHashIndexExpression(hash, index object.Object) object.Object {
 		return newError("unusable as hash key: %s", index.Type())
 	}
 
-	pair, ok := hashObject.Pairs[key.HashKey()]
+	pair, ok := hashObject.Get(key.HashKey())
 	if !ok {
 		return NULL
 	}
@@ -174,7 +221,11 @@ func evalHashIndexExpression(hash, index object.Object) object.Object {
 
 func evalArrayIndexExpression(array, index object.Object) object.Object {
 	arrayObject := array.(*object.Array)
-	idx := index.(*object.Integer).Value
+	i, ok := index.(*object.Integer)
+	if !ok {
+		return NULL // a BigInt, out of any array's range
+	}
+	idx := i.Value
 	max := int64(len(arrayObject.Elements) - 1)
 
 	if idx < 0 || idx > max {
@@ -184,13 +235,20 @@ func evalArrayIndexExpression(array, index object.Object) object.Object {
 	return arrayObject.Elements[idx]
 }
 
-func applyFunction(fn object.Object, args []object.Object) object.Object {
+// applyFunction calls fn from a caller that is depth calls deep
+func applyFunction(fn object.Object, args []object.Object, depth int) object.Object {
 	switch fn := fn.(type) {
 
 	case *object.Function:
-		extendedEnv := extendFunctionEnv(fn, args)
+		if len(args) != len(fn.Parameters) {
+			return newError("wrong number of arguments: want=%d, got=%d", len(fn.Parameters), len(args))
+		}
+		if depth+1 >= MaxCallDepth {
+			return newError("maximum recursion depth exceeded")
+		}
+		extendedEnv := extendFunctionEnv(fn, args, depth+1)
 		evaluated := Eval(fn.Body, extendedEnv)
-		return unwrapReturnValue(evaluated)
+		return blockValue(unwrapReturnValue(evaluated))
 
 	case *object.Builtin:
 		if result := fn.Fn(args...); result != nil {
@@ -203,8 +261,8 @@ func applyFunction(fn object.Object, args []object.Object) object.Object {
 	}
 }
 
-func extendFunctionEnv(fn *object.Function, args []object.Object) *object.Environment {
-	env := object.NewEnclosedEnvironment(fn.Env)
+func extendFunctionEnv(fn *object.Function, args []object.Object, depth int) *object.Environment {
+	env := object.NewCallEnvironment(fn.Env, depth)
 
 	for i, param := range fn.Parameters {
 		env.Set(param.Value, args[i])
@@ -280,14 +338,24 @@ func evalIfExpression(ie *ast.IfExpression, env *object.Environment) object.Obje
 	}
 
 	if isTruthy(condition) {
-		return Eval(ie.Consequence, env)
+		return blockValue(Eval(ie.Consequence, env))
 	} else if ie.Alternative != nil {
-		return Eval(ie.Alternative, env)
+		return blockValue(Eval(ie.Alternative, env))
 	} else {
 		return NULL
 	}
 }
 
+// blockValue is the value of a block used as one: that of its last
+// statement, or null for blocks that are empty or end in a let, whose value
+// is nil
+func blockValue(obj object.Object) object.Object {
+	if obj == nil {
+		return NULL
+	}
+	return obj
+}
+
 func isTruthy(obj object.Object) bool {
 	switch obj {
 	case NULL:
@@ -310,39 +378,24 @@ func evalInfixExpression(operator string, left, right object.Object) object.Obje
 	case left.Type() == object.STRING_OBJ && right.Type() == object.STRING_OBJ:
 		return evalStringInfixExpression(operator, left, right)
 	case operator == "==":
-		return nativeBooleanToBooleanObject(left == right) // pointer comparison
+		return nativeBooleanToBooleanObject(object.Equal(left, right))
 	case operator == "!=":
-		return nativeBooleanToBooleanObject(left != right)
+		return nativeBooleanToBooleanObject(!object.Equal(left, right))
 
 	default:
 		return newError("unknown operator: %s %s %s", left.Type(), operator, right.Type())
 	}
 }
 
+// evalStringInfixExpression concatenates strings and compares them by
+// value, lexicographically by bytes
 func evalStringInfixExpression(operator string, left, right object.Object) object.Object {
-	if operator != "+" {
-		return newError("unknown operator: %s %s %s", left.Type(), operator, right.Type())
-	}
-
 	leftVal := left.(*object.String).Value
 	rightVal := right.(*object.String).Value
 
-	return &object.String{Value: leftVal + rightVal}
-}
-
-func evalIntegerInfixExpression(operator string, left, right object.Object) object.Object {
-	leftVal := left.(*object.Integer).Value
-	rightVal := right.(*object.Integer).Value
-
 	switch operator {
 	case "+":
-		return &object.Integer{Value: leftVal + rightVal}
-	case "-":
-		return &object.Integer{Value: leftVal - rightVal}
-	case "*":
-		return &object.Integer{Value: leftVal * rightVal}
-	case "/":
-		return &object.Integer{Value: leftVal / rightVal}
+		return &object.String{Value: leftVal + rightVal}
 	case "<":
 		return nativeBooleanToBooleanObject(leftVal < rightVal)
 	case ">":
@@ -354,6 +407,28 @@ func evalIntegerInfixExpression(operator string, left, right object.Object) obje
 	default:
 		return newError("unknown operator: %s %s %s", left.Type(), operator, right.Type())
 	}
+}
+
+// evalIntegerInfixExpression promotes results that overflow int64 to
+// object.BigInt, like the VM
+func evalIntegerInfixExpression(operator string, left, right object.Object) object.Object {
+	switch operator {
+	case "+", "-", "*", "/":
+		if r, ok := right.(*object.Integer); ok && operator == "/" && r.Value == 0 {
+			return newError("division by zero")
+		}
+		return object.IntegerArithmetic(operator, left, right)
+	case "<":
+		return nativeBooleanToBooleanObject(object.CompareIntegers(left, right) < 0)
+	case ">":
+		return nativeBooleanToBooleanObject(object.CompareIntegers(left, right) > 0)
+	case "==":
+		return nativeBooleanToBooleanObject(object.CompareIntegers(left, right) == 0)
+	case "!=":
+		return nativeBooleanToBooleanObject(object.CompareIntegers(left, right) != 0)
+	default:
+		return newError("unknown operator: %s %s %s", left.Type(), operator, right.Type())
+	}
 
 }
 
@@ -373,8 +448,7 @@ func evalMinusPrefixOperatorExpression(right object.Object) object.Object {
 		return newError("unknown operator: -%s", right.Type())
 	}
 
-	value := right.(*object.Integer).Value
-	return &object.Integer{Value: -value}
+	return object.NegateInteger(right)
 }
 
 func evalBangOperatorExpression(right object.Object) *object.Boolean {
@@ -398,8 +472,14 @@ func nativeBooleanToBooleanObject(b bool) *object.Boolean {
 	return FALSE
 }
 
-func evalProgram(prog *ast.Program, env *object.Environment) object.Object {
-	var result object.Object
+// evalProgram recovers from panics, which are bugs of the evaluator, into
+// an error
+func evalProgram(prog *ast.Program, env *object.Environment) (result object.Object) {
+	defer func() {
+		if r := recover(); r != nil {
+			result = newError("internal error: %v", r)
+		}
+	}()
 
 	for _, stmt := range prog.Statements {
 		result = Eval(stmt, env)
@@ -1,20 +1,74 @@
 package evaluator
 
 import (
+	"monc/ast"
 	"monc/lexer"
 	"monc/object"
 	"monc/parser"
+	"strings"
 	"testing"
 )
 
 // ------------------------------ HELPERS -------------------------------
+// testEval evaluates s with Eval and with Compile, and returns the result
+// of Eval if they agree, or else an error describing both results. Each
+// gets its own parse, since quote modifies the program.
 func testEval(s string) object.Object {
-	l := lexer.New(s)
-	p := parser.New(l)
-	prog := p.ParseProgram()
 	env := object.NewEnvironment()
+	evaluated := Eval(parser.New(lexer.New(s)).ParseProgram(), env)
+	compiled := Compile(parser.New(lexer.New(s)).ParseProgram()).Run()
 
-	return Eval(prog, env)
+	if !sameObject(evaluated, compiled) {
+		return newError("evaluators disagree: Eval=%s, Compile=%s", inspect(evaluated), inspect(compiled))
+	}
+	return evaluated
+}
+
+// sameObject reports whether the evaluators produced the same object, by
+// content for arrays and hashes, whose pairs are unordered, and by Inspect
+// otherwise
+func sameObject(a, b object.Object) bool {
+	if a == nil || b == nil {
+		return a == nil && b == nil
+	}
+	if a.Type() != b.Type() {
+		return false
+	}
+
+	switch a := a.(type) {
+	case *object.Array:
+		b := b.(*object.Array)
+		if len(a.Elements) != len(b.Elements) {
+			return false
+		}
+		for i := range a.Elements {
+			if !sameObject(a.Elements[i], b.Elements[i]) {
+				return false
+			}
+		}
+		return true
+	case *object.Hash:
+		b := b.(*object.Hash)
+		// the same pairs in the same order
+		aPairs, bPairs := a.Pairs(), b.Pairs()
+		if len(aPairs) != len(bPairs) {
+			return false
+		}
+		for i, pair := range aPairs {
+			if !sameObject(pair.Key, bPairs[i].Key) || !sameObject(pair.Value, bPairs[i].Value) {
+				return false
+			}
+		}
+		return true
+	}
+	return a.Inspect() == b.Inspect()
+}
+
+func inspect(obj object.Object) string {
+	if obj == nil {
+		return "nil"
+	}
+	return obj.Inspect()
 }
 
 func testNullObject(t *testing.T, obj object.Object) bool {
@@ -86,6 +140,36 @@ func TestEvalIntegerExpression(t *testing.T) {
 
 }
 
+func TestEvalBigIntegers(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+		big      bool
+	}{
+		{"9223372036854775807 + 1", "9223372036854775808", true},
+		{"-9223372036854775807 - 2", "-9223372036854775809", true},
+		{"4294967296 * 4294967296", "18446744073709551616", true},
+		{"-9223372036854775808", "-9223372036854775808", false},
+		{"-9223372036854775808 / -1", "9223372036854775808", true},
+		{"9223372036854775808 - 1", "9223372036854775807", false},
+		{"100000000000000000000 / 3", "33333333333333333333", true},
+		{"99999999999999999999 > 9223372036854775807", "true", false},
+		{"18446744073709551616 == 4294967296 * 4294967296", "true", false},
+		{"{18446744073709551616: 1}[4294967296 * 4294967296]", "1", false},
+		{"[1, 2][18446744073709551616]", "null", false},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		if evaluated.Inspect() != tt.expected {
+			t.Errorf("%q: want=%s, got=%s", tt.input, tt.expected, evaluated.Inspect())
+		}
+		if _, isBig := evaluated.(*object.BigInt); isBig != tt.big {
+			t.Errorf("%q: wrong representation %T", tt.input, evaluated)
+		}
+	}
+}
+
 func TestEvalBooleanExpression(t *testing.T) {
 	tests := []struct {
 		input    string
@@ -118,6 +202,46 @@ func TestEvalBooleanExpression(t *testing.T) {
 	}
 }
 
+func TestEquality(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected bool
+	}{
+		{`"a" == "a"`, true},
+		{`"a" + "b" == "ab"`, true},
+		{`let s = "a"; s + s != "aa"`, false},
+		{`"a" == "b"`, false},
+		{`"a" < "b"`, true},
+		{`"ab" < "b"`, true},
+		{`"b" > "ab"`, true},
+		{`"a" < "a"`, false},
+		{`"" < "a"`, true},
+		{`"Z" < "a"`, true},
+		{`[1, "a", [true]] == [1, "a", [true]]`, true},
+		{`[1, 2] == [2, 1]`, false},
+		{`[1] == [1, 1]`, false},
+		{`[] != []`, false},
+		{`{"a": 1, "b": [2]} == {"b": [2], "a": 1}`, true},
+		{`{"a": 1} == {"a": 2}`, false},
+		{`{1: 1} == {true: 1}`, false},
+		{`let a = [1]; let b = push(a, 2); b == [1, 2]`, true},
+		{`let a = [1]; append(a, a); let b = [1]; append(b, b); a == b`, true},
+		{`let a = [1]; append(a, a); let b = [2]; append(b, b); a == b`, false},
+		{`fn(x) { x } == fn(x) { x }`, false},
+		{`let f = fn(x) { x }; f == f`, true},
+		{`len == len`, true},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		if _, ok := evaluated.(*object.Error); ok {
+			t.Errorf("%q: %s", tt.input, evaluated.Inspect())
+			continue
+		}
+		testBooleanObject(t, evaluated, tt.expected)
+	}
+}
+
 func TestBangOperaotr(t *testing.T) {
 	tests := []struct {
 		input    string
@@ -150,6 +274,9 @@ func TestConditionalExpressions(t *testing.T) {
 		{"if (1 > 2) { 10 }", nil},
 		{"if (1 > 2) { 10 } else { 20 }", 20},
 		{"if (1 < 2) { 10 } else { 20 }", 10},
+		{"if (true) { let a = 1; }", nil},
+		{"if (true) { }", nil},
+		{"if ((if (true) { let a = 1; })) { 10 } else { 20 }", 20},
 	}
 
 	for _, tt := range tests {
@@ -267,6 +394,46 @@ func TestErrorHandling(t *testing.T) {
 			`{"name": "Monkey"}[fn(x) {x}];`,
 			"unusable as hash key: FUNCTION",
 		},
+		{
+			"1 / 0",
+			"division by zero",
+		},
+		{
+			"18446744073709551616 / (1 - 1)",
+			"division by zero",
+		},
+		{
+			"let f = fn(a, b) { a }; f(1)",
+			"wrong number of arguments: want=2, got=1",
+		},
+		{
+			"fn(a) { a }(1, 2)",
+			"wrong number of arguments: want=1, got=2",
+		},
+		{
+			"let f = fn() { let a = 1; }; f() + 1",
+			"type mismatch: NULL + INTEGER",
+		},
+		{
+			"let a = [1]; a[1] = 2; a",
+			"index out of range: 1 with length 1",
+		},
+		{
+			"[1][18446744073709551616] = 2",
+			"index out of range: 18446744073709551616 with length 1",
+		},
+		{
+			"let h = {}; h[fn(x) { x }] = 1",
+			"unusable as hash key: FUNCTION",
+		},
+		{
+			`"abc"[0] = "x"`,
+			"index operator not supported: STRING",
+		},
+		{
+			"let a = [1]; a[0] = foobar; a",
+			"identifier not found: foobar",
+		},
 	}
 
 	for _, tt := range tests {
@@ -286,6 +453,49 @@ func TestErrorHandling(t *testing.T) {
 
 }
 
+func TestEvalRecursionDepth(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{"let f = fn(n) { if (n == 0) { 0 } else { 1 + f(n - 1) } }; f(1022)", 1022},
+		{"let f = fn(n) { if (n == 0) { 0 } else { 1 + f(n - 1) } }; f(1023)", "maximum recursion depth exceeded"},
+		{"let f = fn(x) { f(x) }; f(1);", "maximum recursion depth exceeded"},
+		// the depth is that of the call, not of where the function was made
+		{"let g = fn(n) { fn() { n } }; let f = fn(n) { if (n == 0) { 0 } else { g(n)() + f(n - 1) - n } }; f(1000)", 0},
+	}
+
+	for _, tt := range tests {
+		evaluated := Eval(parser.New(lexer.New(tt.input)).ParseProgram(), object.NewEnvironment())
+		switch expected := tt.expected.(type) {
+		case int:
+			testIntegerObject(t, evaluated, int64(expected))
+		case string:
+			errObj, ok := evaluated.(*object.Error)
+			if !ok || errObj.Message != expected {
+				t.Errorf("%q: wrong result. want error %q, got=%s", tt.input, expected, inspect(evaluated))
+			}
+		}
+	}
+}
+
+func TestEvalRecoversPanics(t *testing.T) {
+	// a prefix expression without operand, which the parser never produces
+	program := &ast.Program{Statements: []ast.Statement{
+		&ast.ExpressionStatement{Expression: &ast.PrefixExpression{Operator: "-"}},
+	}}
+
+	evaluated := Eval(program, object.NewEnvironment())
+
+	errObj, ok := evaluated.(*object.Error)
+	if !ok {
+		t.Fatalf("no error object returned. got=%T(%+v)", evaluated, evaluated)
+	}
+	if !strings.HasPrefix(errObj.Message, "internal error: ") {
+		t.Errorf("wrong error message. got=%q", errObj.Message)
+	}
+}
+
 func TestLetStatements(t *testing.T) {
 	tests := []struct {
 		input    string
@@ -432,6 +642,19 @@ func TestBuiltinfunctions(t *testing.T) {
 		{`rest([])`, nil},
 		{`push([], 1)`, []int{1}},
 		{`push(1, 1)`, "argument to `push` must be ARRAY, got INTEGER"},
+		{`let a = [1]; push(a, 2); a`, []int{1}},
+		{`let a = [1]; append(a, 2); a`, []int{1, 2}},
+		{`let a = []; let b = append(a, 1); b[0] = 2; a`, []int{2}},
+		{`append(1, 1)`, "argument to `append` must be ARRAY, got INTEGER"},
+		{`let h = {1: 1, 2: 2}; delete(h, 1); delete(h, 3); h[1]`, nil},
+		{`let h = {1: 1, 2: 2}; delete(h, 1); h[2]`, 2},
+		{`delete(1, 1)`, "argument to `delete` must be HASH, got INTEGER"},
+		{`delete({}, {})`, "unusable as hash key: HASH"},
+		// in insertion order, a duplicate key keeping its first place
+		{`keys({3: 0, 1: 0, 2: 0, 1: 0})`, []int{3, 1, 2}},
+		{`values({3: 1, 1: 2, 2: 3, 3: 4})`, []int{4, 2, 3}},
+		{`let h = {1: 1, 2: 2}; delete(h, 1); h[1] = 3; keys(h)`, []int{2, 1}},
+		{`keys(1)`, "argument to `keys` must be HASH, got INTEGER"},
 		{`puts("hello", "world!")`, nil},
 	}
 
@@ -550,12 +773,12 @@ func TestHashLiterals(t *testing.T) {
 		FALSE.HashKey():                            6,
 	}
 
-	if len(result.Pairs) != len(expected) {
-		t.Fatalf("Hash has wrong num of Pairs. got=%d", len(result.Pairs))
+	if result.Len() != len(expected) {
+		t.Fatalf("Hash has wrong num of Pairs. got=%d", result.Len())
 	}
 
 	for expectedKey, expectedValue := range expected {
-		pair, ok := result.Pairs[expectedKey]
+		pair, ok := result.Get(expectedKey)
 		if !ok {
 			t.Errorf("no pair for given key in Pairs")
 		}
@@ -589,3 +812,57 @@ func TestHashIndexExpressions(t *testing.T) {
 		}
 	}
 }
+
+// ----------------------------- ASSIGNMENT -----------------------------
+
+func TestIndexAssignments(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{"let a = [1, 2, 3]; a[1] = 5; a", []int{1, 5, 3}},
+		{"let a = [1, 2, 3]; a[0] = a[1] + a[2]; a[0]", 5},
+		{"let a = [[1]]; a[0][0] = 2; a[0]", []int{2}},
+		{`let h = {}; h["a"] = 1; h["a"] = h["a"] + 1; h["a"]`, 2},
+		{`let h = {true: 1}; h[false] = 2; h[true] + h[false]`, 3},
+		// arrays and hashes are shared, not copied
+		{"let a = [1]; let b = a; b[0] = 2; a[0]", 2},
+		{"let set = fn(a, v) { a[0] = v; }; let a = [1]; set(a, 3); a[0]", 3},
+		{"let a = [1]; let f = fn() { a[0] = 4 }; f(); a[0]", 4},
+		{`let h = {}; let f = fn(k) { h[k] = k * 2 }; f(1); f(2); h[1] + h[2]`, 6},
+		// a statement, whose value in a block is null
+		{"if (true) { let a = [1]; a[0] = 2 }", nil},
+		{"let f = fn(a) { a[0] = 2 }; f([1])", nil},
+		// the left side, the index and the value are evaluated in order
+		{"let log = []; let a = [0]; let f = fn(n, x) { append(log, n); x }; f(1, a)[f(2, 0)] = f(3, 7); push(log, a[0])",
+			[]int{1, 2, 3, 7}},
+		{"let a = [1]; append(a, a); len(a[1][1][1])", 2},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+
+		switch expected := tt.expected.(type) {
+		case int:
+			testIntegerObject(t, evaluated, int64(expected))
+
+		case nil:
+			testNullObject(t, evaluated)
+
+		case []int:
+			array, ok := evaluated.(*object.Array)
+			if !ok {
+				t.Errorf("%q: obj not Array. got=%T (%+v)", tt.input, evaluated, evaluated)
+				continue
+			}
+			if len(array.Elements) != len(expected) {
+				t.Errorf("%q: wrong num of elements. want=%d, got=%d",
+					tt.input, len(expected), len(array.Elements))
+				continue
+			}
+			for i, expectedElem := range expected {
+				testIntegerObject(t, array.Elements[i], int64(expectedElem))
+			}
+		}
+	}
+}
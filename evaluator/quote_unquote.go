@@ -41,6 +41,10 @@ func convertObjectToASTNode(obj object.Object) ast.Node {
 
 		return &ast.IntegerLiteral{Token: t, Value: obj.Value}
 
+	case *object.BigInt:
+		t := token.Token{Type: token.INT, Literal: obj.Value.String()}
+		return &ast.IntegerLiteral{Token: t, Big: obj.Value}
+
 	case *object.Boolean:
 		var t token.Token
 		if obj.Value {
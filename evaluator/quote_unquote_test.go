@@ -50,6 +50,10 @@ func TestQuoteUnquote(t *testing.T) {
 			`quote(8 + unquote(4 + 4))`,
 			`(8 + 8)`,
 		},
+		{
+			`quote(unquote(9223372036854775807 + 1))`,
+			`9223372036854775808`,
+		},
 		{
 			`quote(unquote(4 + 4) + 8)`,
 			`(8 + 8)`,
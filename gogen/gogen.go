@@ -0,0 +1,532 @@
+/*
+Package gogen translates Monkey programs to Go. The generated main package
+runs the program on top of package rt and computes what evaluator.Eval
+computes, so it has to be built inside the monc module:
+
+	let double = fn(x) { x * 2 };
+	puts(double(21));
+
+becomes
+
+	func program() object.Object {
+		var m_double object.Object
+		m_double = &rt.Function{Source: "fn(x) {\n(x * 2)\n}", Parameters: 1, Fn: func(args []object.Object) object.Object {
+			m1_x := args[0]
+			return rt.Mul(m1_x, rt.Int(2))
+		}}
+		return rt.Call(rt.Builtin("puts"), rt.Call(rt.Get("double", m_double), rt.Int(21)))
+	}
+
+Every function gets one Go variable per name it binds with `let` anywhere
+in its body. Like the evaluator, which looks names up when they are read,
+a name whose innermost variable is not set yet reads the variable of an
+enclosing function, or the builtin.
+*/
+package gogen
+
+import (
+	"bytes"
+	"fmt"
+	"go/format"
+	"monc/ast"
+	"monc/evaluator"
+	"monc/object"
+	"strconv"
+)
+
+const header = `// Code generated by monc gogen. DO NOT EDIT.
+
+package main
+
+import (
+	"monc/object"
+	"monc/rt"
+)
+
+func main() {
+	rt.Main(program)
+}
+
+`
+
+// Generate returns the formatted source of a Go main package running
+// program. Macros are expanded first, like for the evaluator.
+func Generate(program *ast.Program) ([]byte, error) {
+	macros := object.NewEnvironment()
+	evaluator.DefineMacros(program, macros)
+	expanded := evaluator.ExpandMacros(program, macros).(*ast.Program)
+
+	fn, err := function("program", expanded)
+	if err != nil {
+		return nil, err
+	}
+
+	return format.Source([]byte(header + fn))
+}
+
+// function returns the Go function name running program
+func function(name string, program *ast.Program) (string, error) {
+	g := &generator{}
+
+	body, err := g.body(nil, program.Statements)
+	if err != nil {
+		return "", err
+	}
+
+	return fmt.Sprintf("func %s() object.Object {\n%s}\n", name, body), nil
+}
+
+type generator struct {
+	scope *scope
+	out   *bytes.Buffer
+	temps int
+}
+
+// scope holds the variables of a function, or the main program
+type scope struct {
+	outer *scope
+	depth int // the number of functions the scope is nested in
+	vars  map[string]*variable
+	order []*variable
+}
+
+type variable struct {
+	name  string // the Go name
+	param int    // index of the parameter, -1 for `let` bindings
+	read  bool
+}
+
+// body returns the statements of a function with the given parameters,
+// declaring its variables first
+func (g *generator) body(params []*ast.Identifier, statements []ast.Statement) (string, error) {
+	s := &scope{outer: g.scope, vars: map[string]*variable{}}
+	if g.scope != nil {
+		s.depth = g.scope.depth + 1
+	}
+	for i, p := range params {
+		s.define(p.Value, i)
+	}
+	for _, name := range bindings(statements) {
+		s.define(name, -1)
+	}
+
+	outer, outerScope := g.out, g.scope
+	g.out, g.scope = &bytes.Buffer{}, s
+	defer func() { g.out, g.scope = outer, outerScope }()
+
+	if err := g.statements(statements, "return"); err != nil {
+		return "", err
+	}
+
+	var decls bytes.Buffer
+	unread := []string{}
+	for _, v := range s.order {
+		if v.param >= 0 {
+			fmt.Fprintf(&decls, "%s := args[%d]\n", v.name, v.param)
+		} else {
+			fmt.Fprintf(&decls, "var %s object.Object\n", v.name)
+		}
+		if !v.read {
+			unread = append(unread, v.name)
+		}
+	}
+	for _, name := range unread {
+		fmt.Fprintf(&decls, "_ = %s\n", name)
+	}
+
+	return decls.String() + g.out.String(), nil
+}
+
+func (s *scope) define(name string, param int) {
+	if v, ok := s.vars[name]; ok {
+		// a parameter bound again with `let` is the same variable
+		if param >= 0 {
+			v.param = param
+		}
+		return
+	}
+
+	// the depth keeps the variables of enclosing functions visible
+	prefix := "m_"
+	if s.depth > 0 {
+		prefix = fmt.Sprintf("m%d_", s.depth)
+	}
+
+	v := &variable{name: prefix + name, param: param}
+	s.vars[name] = v
+	s.order = append(s.order, v)
+}
+
+func (s *scope) resolve(name string) (*variable, bool) {
+	for ; s != nil; s = s.outer {
+		if v, ok := s.vars[name]; ok {
+			return v, true
+		}
+	}
+	return nil, false
+}
+
+// lookup returns the variables of name from the innermost scope out, up to
+// the first parameter, which is always set
+func (s *scope) lookup(name string) []*variable {
+	vars := []*variable{}
+	for ; s != nil; s = s.outer {
+		if v, ok := s.vars[name]; ok {
+			vars = append(vars, v)
+			if v.param >= 0 {
+				break
+			}
+		}
+	}
+	return vars
+}
+
+// bindings returns the names bound with `let` in statements, including
+// those in blocks but not in nested functions
+func bindings(statements []ast.Statement) []string {
+	names := []string{}
+
+	var walk func(node ast.Node)
+	walk = func(node ast.Node) {
+		switch node := node.(type) {
+		case *ast.LetStatement:
+			names = append(names, node.Name.Value)
+			walk(node.Value)
+		case *ast.ExpressionStatement:
+			walk(node.Expression)
+		case *ast.AssignStatement:
+			walk(node.Target)
+			walk(node.Value)
+		case *ast.ReturnStatement:
+			walk(node.ReturnValue)
+		case *ast.BlockStatement:
+			for _, s := range node.Statements {
+				walk(s)
+			}
+		case *ast.IfExpression:
+			walk(node.Condition)
+			walk(node.Consequence)
+			if node.Alternative != nil {
+				walk(node.Alternative)
+			}
+		case *ast.PrefixExpression:
+			walk(node.Right)
+		case *ast.InfixExpression:
+			walk(node.Left)
+			walk(node.Right)
+		case *ast.CallExpression:
+			walk(node.Function)
+			for _, a := range node.Arguments {
+				walk(a)
+			}
+		case *ast.ArrayLiteral:
+			for _, e := range node.Elements {
+				walk(e)
+			}
+		case *ast.IndexExpression:
+			walk(node.Left)
+			walk(node.Index)
+		case *ast.HashLiteral:
+			for _, pair := range node.Pairs {
+				walk(pair.Key)
+				walk(pair.Value)
+			}
+		}
+	}
+
+	for _, s := range statements {
+		walk(s)
+	}
+	return names
+}
+
+/*
+statements generates statements, storing the value of the last one in
+target: "return" returns it, "" discards it and any other target is the
+name of a variable. Like in the evaluator the value is null if the last
+statement is not an expression, or nil for the main program.
+*/
+func (g *generator) statements(statements []ast.Statement, target string) error {
+	for i, s := range statements {
+		last := i == len(statements)-1
+
+		switch s := s.(type) {
+		case *ast.ReturnStatement:
+			value, err := g.expr(s.ReturnValue)
+			if err != nil {
+				return err
+			}
+			g.emit("return %s", value)
+			// anything after it is unreachable
+			return nil
+
+		case *ast.LetStatement:
+			value, err := g.expr(s.Value)
+			if err != nil {
+				return err
+			}
+			v, _ := g.scope.resolve(s.Name.Value)
+			g.emit("%s = %s", v.name, value)
+
+		case *ast.AssignStatement:
+			call, err := g.call("rt.SetIndex", s.Target.Left, s.Target.Index, s.Value)
+			if err != nil {
+				return err
+			}
+			g.emit("%s", call)
+
+		case *ast.ExpressionStatement:
+			t := ""
+			if last {
+				t = target
+			}
+			if err := g.valueTo(s.Expression, t); err != nil {
+				return err
+			}
+			if last {
+				return nil
+			}
+
+		default:
+			return fmt.Errorf("cannot generate Go for %T", s)
+		}
+	}
+
+	switch {
+	case target == "return" && g.scope.outer == nil:
+		g.emit("return nil")
+	case target == "return":
+		g.emit("return rt.Null")
+	case target != "":
+		g.emit("%s = rt.Null", target)
+	}
+	return nil
+}
+
+// valueTo generates node and stores its value in target like statements
+func (g *generator) valueTo(node ast.Expression, target string) error {
+	if ie, ok := node.(*ast.IfExpression); ok && target != "return" {
+		return g.ifExpression(ie, target)
+	}
+
+	value, err := g.expr(node)
+	if err != nil {
+		return err
+	}
+
+	switch target {
+	case "":
+		g.emit("_ = %s", value)
+	case "return":
+		g.emit("return %s", value)
+	default:
+		g.emit("%s = %s", target, value)
+	}
+	return nil
+}
+
+func (g *generator) ifExpression(node *ast.IfExpression, target string) error {
+	cond, err := g.expr(node.Condition)
+	if err != nil {
+		return err
+	}
+
+	g.emit("if rt.Truthy(%s) {", cond)
+	if err := g.statements(node.Consequence.Statements, target); err != nil {
+		return err
+	}
+
+	if node.Alternative != nil {
+		g.emit("} else {")
+		if err := g.statements(node.Alternative.Statements, target); err != nil {
+			return err
+		}
+	} else if target != "" {
+		g.emit("} else {")
+		g.emit("%s = rt.Null", target)
+	}
+
+	g.emit("}")
+	return nil
+}
+
+// expr returns a Go expression for the value of node, emitting the
+// statements it needs first
+func (g *generator) expr(node ast.Expression) (string, error) {
+	switch node := node.(type) {
+	case *ast.IntegerLiteral:
+		if node.Big != nil {
+			return fmt.Sprintf("rt.BigInt(%q)", node.Big.String()), nil
+		}
+		return fmt.Sprintf("rt.Int(%d)", node.Value), nil
+
+	case *ast.StringLiteral:
+		return fmt.Sprintf("rt.Str(%s)", strconv.Quote(node.Value)), nil
+
+	case *ast.Boolean:
+		if node.Value {
+			return "rt.True", nil
+		}
+		return "rt.False", nil
+
+	case *ast.Identifier:
+		return g.identifier(node.Value), nil
+
+	case *ast.PrefixExpression:
+		right, err := g.expr(node.Right)
+		if err != nil {
+			return "", err
+		}
+
+		switch node.Operator {
+		case "!":
+			return fmt.Sprintf("rt.Bang(%s)", right), nil
+		case "-":
+			return fmt.Sprintf("rt.Minus(%s)", right), nil
+		}
+		return "", fmt.Errorf("unknown operator %s", node.Operator)
+
+	case *ast.InfixExpression:
+		fn, ok := infixFunctions[node.Operator]
+		if !ok {
+			return "", fmt.Errorf("unknown operator %s", node.Operator)
+		}
+		return g.call("rt."+fn, node.Left, node.Right)
+
+	case *ast.IfExpression:
+		t := g.temp()
+		g.emit("var %s object.Object", t)
+		return t, g.ifExpression(node, t)
+
+	case *ast.FunctionLiteral:
+		return g.functionLiteral(node)
+
+	case *ast.CallExpression:
+		if node.Function.TokenLiteral() == "quote" {
+			return "", fmt.Errorf("quote is only supported in macros")
+		}
+		return g.call("rt.Call", append([]ast.Expression{node.Function}, node.Arguments...)...)
+
+	case *ast.ArrayLiteral:
+		return g.call("rt.Array", node.Elements...)
+
+	case *ast.IndexExpression:
+		return g.call("rt.Index", node.Left, node.Index)
+
+	case *ast.HashLiteral:
+		operands := []ast.Expression{}
+		for _, pair := range node.Pairs {
+			operands = append(operands, pair.Key, pair.Value)
+		}
+		return g.call("rt.Hash", operands...)
+	}
+
+	return "", fmt.Errorf("cannot generate Go for %T", node)
+}
+
+var infixFunctions = map[string]string{
+	"+":  "Add",
+	"-":  "Sub",
+	"*":  "Mul",
+	"/":  "Div",
+	"<":  "Less",
+	">":  "Greater",
+	"==": "Equal",
+	"!=": "NotEqual",
+}
+
+// identifier reads name like the evaluator: the innermost variable that is
+// set, or else the builtin
+func (g *generator) identifier(name string) string {
+	vars := g.scope.lookup(name)
+	values := ""
+	for _, v := range vars {
+		v.read = true
+		values += ", " + v.name
+	}
+
+	switch {
+	case len(vars) > 0 && vars[len(vars)-1].param >= 0:
+		if len(vars) == 1 {
+			return vars[0].name
+		}
+	case object.GetBuiltinByName(name) != nil:
+		if len(vars) == 0 {
+			return fmt.Sprintf("rt.Builtin(%q)", name)
+		}
+		values += fmt.Sprintf(", rt.Builtin(%q)", name)
+	case len(vars) == 0:
+		return fmt.Sprintf("rt.Undefined(%q)", name)
+	}
+
+	return fmt.Sprintf("rt.Get(%q%s)", name, values)
+}
+
+/*
+call returns a call of fn with the values of operands. Statements emitted
+for an operand run before the call expression, so the operands before it
+are stored in temporaries first: the statements could bind a variable
+they read.
+*/
+func (g *generator) call(fn string, operands ...ast.Expression) (string, error) {
+	values := make([]string, len(operands))
+	code := make([]string, len(operands))
+
+	outer := g.out
+	for i, operand := range operands {
+		g.out = &bytes.Buffer{}
+		value, err := g.expr(operand)
+		if err != nil {
+			g.out = outer
+			return "", err
+		}
+		values[i], code[i] = value, g.out.String()
+	}
+	g.out = outer
+
+	for i := range operands {
+		g.out.WriteString(code[i])
+
+		for _, later := range code[i+1:] {
+			if later != "" {
+				t := g.temp()
+				g.emit("%s := %s", t, values[i])
+				values[i] = t
+				break
+			}
+		}
+	}
+
+	args := ""
+	for i, v := range values {
+		if i > 0 {
+			args += ", "
+		}
+		args += v
+	}
+	return fmt.Sprintf("%s(%s)", fn, args), nil
+}
+
+func (g *generator) functionLiteral(node *ast.FunctionLiteral) (string, error) {
+	body, err := g.body(node.Parameters, node.Body.Statements)
+	if err != nil {
+		return "", err
+	}
+
+	// printed like the evaluator's functions
+	source := (&object.Function{Parameters: node.Parameters, Body: node.Body}).Inspect()
+
+	return fmt.Sprintf(
+		"&rt.Function{Source: %s, Parameters: %d, Fn: func(args []object.Object) object.Object {\n%s}}",
+		strconv.Quote(source), len(node.Parameters), body,
+	), nil
+}
+
+func (g *generator) temp() string {
+	g.temps++
+	return fmt.Sprintf("t%d", g.temps)
+}
+
+func (g *generator) emit(format string, a ...interface{}) {
+	fmt.Fprintf(g.out, format+"\n", a...)
+}
@@ -0,0 +1,252 @@
+package gogen
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"monc/ast"
+	"monc/evaluator"
+	"monc/lexer"
+	"monc/object"
+	"monc/parser"
+	_ "monc/rt" // the generated programs use it, so changes rerun the tests
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// conformance are programs whose generated Go has to print what the
+// evaluator prints and compute what it computes
+var conformance = []string{
+	`5`,
+	`-10 + 2 * 3 - 8 / 2`,
+	`(1 < 2) == true`,
+	`1 > 2 != true`,
+	`!5`,
+	`!!true`,
+	`"Hello" + " " + "World!"`,
+	`true == true`,
+	`let a = 5; let b = a * 2; a + b`,
+	`let a = 1; let a = a + 1; a`,
+	`let x = 10;`,
+	`if (1 < 2) { 10 } else { 20 }`,
+	`if (false) { 10 }`,
+	`if (true) { }`,
+	`if (10 > 1) { if (10 > 1) { return 10; } return 1; }`,
+	`if (true) { let y = 2; }; y`,
+	`return 2 * 5; 9;`,
+	`let f = fn(x) { x; }; f(5)`,
+	`let f = fn(x) { return x; 1 }; f(5)`,
+	`let f = fn() { let a = 1; }; f()`,
+	`let f = fn() { let a = 1; }; [f(), if (true) { let b = 2; }, if (true) { }]`,
+	`let a = 1; let a = a + 1; a`,
+	`fn(x) { x * 2 }`,
+	`let add = fn(x) { fn(y) { x + y } }; add(2)(3)`,
+	`let counter = fn(x) { if (x > 100) { return true; } counter(x + 1) }; counter(0)`,
+	`let fib = fn(x) { if (x < 2) { return x; } fib(x - 1) + fib(x - 2) }; fib(15)`,
+	`let x = 1; let f = fn() { x }; let x = 2; f()`,
+	`let x = 1; let f = fn(x) { x }; f(2) + x`,
+	`let x = 1; let f = fn() { let y = x; let x = 2; y + x }; puts(f())`,
+	`let f = fn() { let a = len("ab"); let len = fn(s) { 10 }; a + len("abc") }; f()`,
+	`let f = fn(x) { fn() { if (false) { let x = 2; }; x } }; f(1)()`,
+	`[1, 2 * 2, 3 + 3]`,
+	`[1, 2, 3][1]`,
+	`[1, 2, 3][3]`,
+	`[1, 2, 3][-1]`,
+	`let a = [1, 2]; a[0] + a[1]`,
+	`{"one": 1}["one"]`,
+	`{1: 2, true: 3}[true]`,
+	`{"a": 1}["b"]`,
+	`len("four") + len([1, 2])`,
+	`first([1, 2]) + last([1, 2])`,
+	`rest([1, 2, 3])`,
+	`push([], 1)`,
+	`first([])`,
+	`let map = fn(arr, f) { if (len(arr) == 0) { return []; } push(map(rest(arr), f), f(first(arr))) }; map([1, 2, 3], fn(x) { x * 10 })`,
+	`puts("hello", 1); 2`,
+	`let f = fn(a, b) { a - b }; f(if (true) { let z = 1; z } else { 2 }, 3)`,
+	`let z = 1; [z, if (true) { let z = 2; z }, z]`,
+	`9223372036854775807 + 1`,
+	`-9223372036854775808 / -1 - 1`,
+	`let f = fn(n) { if (n == 0) { return 1; } n * f(n - 1) }; f(25)`,
+	`{18446744073709551616: 1}[4294967296 * 4294967296]`,
+	`let a = [1, 2]; let b = a; b[0] = 9; a`,
+	`let h = {"a": 1}; h["b"] = h["a"] + 1; h["a"] = 0; [h["a"], h["b"]]`,
+	`let f = fn(a) { a[0] = a[0] + 1; }; let a = [1]; f(a); f(a); a[0]`,
+	`let a = [1]; if (true) { a[0] = 2 }; a`,
+	`let a = [1]; let b = append(a, 2); let c = push(a, 3); [a, b, c]`,
+	`let h = {"a": 1, "b": 2}; delete(h, "a"); [h["a"], h["b"]]`,
+	`{"b": 1, "a": 2, 3: {true: [4]}}`,
+	`let h = {"b": 1, "a": 2, "b": 3}; h["c"] = 4; delete(h, "a"); [h, keys(h), values(h)]`,
+	`["a" + "b" == "ab", "ab" < "b", "b" > "ab", "a" < "a", [1, [2]] == [1, [2]], {1: 2, 3: 4} == {3: 4, 1: 2}, [1] != [2]]`,
+	`let a = [1]; append(a, a); let b = [1]; append(b, b); a == b`,
+	`let f = fn(x) { x }; [f == f, f == fn(x) { x }, len == len]`,
+	`let unless = macro(cond, a, b) { quote(if (!(unquote(cond))) { unquote(a) } else { unquote(b) }) }; unless(10 > 5, 1, 2)`,
+
+	// errors
+	`5 + true`,
+	`5 + true; 5`,
+	`-true`,
+	`true + false`,
+	`"a" - "b"`,
+	`if (10 > 1) { true + false; 1 }`,
+	`foobar`,
+	`{"name": "Monkey"}[fn(x) { x }]`,
+	`{[1]: 2}`,
+	`1[0]`,
+	`len(1)`,
+	`5()`,
+	`1 / 0`,
+	`let a = 0; 18446744073709551616 / a`,
+	`let f = fn(a, b) { a }; f(1)`,
+	`let f = fn(a, b) { a + b }; f(1, 2, 3)`,
+	`let f = fn() { let a = 1; }; f() + 1`,
+	`let f = fn() { g }; f()`,
+	`let a = [1]; a[1] = 2`,
+	`let a = [1]; a[-1] = 2; a`,
+	`let h = {}; h[[1]] = 2`,
+	`let n = 1; n[0] = 2`,
+	`append(1, 2)`,
+	`delete({}, [])`,
+}
+
+func TestConformance(t *testing.T) {
+	if _, err := exec.LookPath("go"); err != nil {
+		t.Skip("no go command")
+	}
+
+	var src bytes.Buffer
+	src.WriteString(`package main
+
+import (
+	"fmt"
+	"monc/object"
+	"monc/rt"
+)
+
+var programs = []func() object.Object{
+`)
+	for i := range conformance {
+		fmt.Fprintf(&src, "program%d,\n", i)
+	}
+	src.WriteString(`}
+
+func main() {
+	for i, p := range programs {
+		fmt.Printf("--- %d\n", i)
+		if result := rt.Run(p); result != nil {
+			fmt.Println(result.Inspect())
+		} else {
+			fmt.Println("nil")
+		}
+	}
+}
+
+`)
+
+	expected := ""
+	for i, input := range conformance {
+		fn, err := function(fmt.Sprintf("program%d", i), expand(parse(input)))
+		if err != nil {
+			t.Fatalf("%q: %s", input, err)
+		}
+		src.WriteString(fn)
+
+		expected += fmt.Sprintf("--- %d\n%s", i, evaluate(t, input))
+	}
+
+	output := runGo(t, src.Bytes())
+
+	want := strings.Split(expected, "--- ")
+	got := strings.Split(output, "--- ")
+	if len(got) != len(want) {
+		t.Fatalf("wrong output. want=\n%s\ngot=\n%s", expected, output)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("wrong output for %q. want=%q, got=%q", conformance[i-1], want[i], got[i])
+		}
+	}
+}
+
+func TestGenerate(t *testing.T) {
+	if _, err := exec.LookPath("go"); err != nil {
+		t.Skip("no go command")
+	}
+
+	src, err := Generate(parse(`let fib = fn(x) { if (x < 2) { return x; } fib(x - 1) + fib(x - 2) }; puts(fib(20));`))
+	if err != nil {
+		t.Fatalf("generate error: %s", err)
+	}
+
+	if output := runGo(t, src); output != "6765\n" {
+		t.Errorf("wrong output. want=%q, got=%q", "6765\n", output)
+	}
+}
+
+func TestGenerateErrors(t *testing.T) {
+	_, err := Generate(parse(`quote(1 + 2)`))
+	if err == nil || err.Error() != "quote is only supported in macros" {
+		t.Errorf("wrong error. got=%v", err)
+	}
+}
+
+// runGo runs the main package src, which has to be inside the module to
+// import monc/rt
+func runGo(t *testing.T, src []byte) string {
+	t.Helper()
+
+	if err := os.MkdirAll("testdata", 0o755); err != nil {
+		t.Fatal(err)
+	}
+	dir, err := os.MkdirTemp("testdata", "program")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	if err := os.WriteFile(filepath.Join(dir, "main.go"), src, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	output, err := exec.Command("go", "run", "./"+dir).CombinedOutput()
+	if err != nil {
+		t.Fatalf("go run: %s\n%s", err, output)
+	}
+	return string(output)
+}
+
+// evaluate returns the output and value of input in the evaluator
+func evaluate(t *testing.T, input string) string {
+	t.Helper()
+
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout := os.Stdout
+	os.Stdout = w
+
+	result := evaluator.Eval(expand(parse(input)), object.NewEnvironment())
+
+	os.Stdout = stdout
+	w.Close()
+	output, _ := io.ReadAll(r)
+
+	if result == nil {
+		return string(output) + "nil\n"
+	}
+	return string(output) + result.Inspect() + "\n"
+}
+
+func expand(program *ast.Program) *ast.Program {
+	env := object.NewEnvironment()
+	evaluator.DefineMacros(program, env)
+	return evaluator.ExpandMacros(program, env).(*ast.Program)
+}
+
+func parse(input string) *ast.Program {
+	return parser.New(lexer.New(input)).ParseProgram()
+}
@@ -7,15 +7,22 @@ type Lexer struct {
 	position     int  // current position in input (points to current char)
 	readPosition int  // current reading position (next char)
 	ch           byte // current char
+	line         int  // line of the current char
+	lineStart    int  // position of the first char of the current line
 }
 
 func New(input string) *Lexer {
-	l := &Lexer{input: input}
+	l := &Lexer{input: input, line: 1}
 	l.readChar()
 	return l
 }
 
 func (l *Lexer) readChar() {
+	if l.ch == '\n' {
+		l.line++
+		l.lineStart = l.readPosition
+	}
+
 	if l.readPosition >= len(l.input) {
 		l.ch = 0 // ASCII code for "NULL"
 	} else {
@@ -47,10 +54,20 @@ func (l *Lexer) readString() string {
 }
 
 func (l *Lexer) NextToken() token.Token {
-	var tok token.Token
-
 	l.skipWhitespace()
 
+	line, column := l.line, l.position-l.lineStart+1
+
+	tok := l.readToken()
+	tok.Line, tok.Column = line, column
+
+	return tok
+}
+
+// readToken reads the token starting at the current char
+func (l *Lexer) readToken() token.Token {
+	var tok token.Token
+
 	switch l.ch {
 	case '=':
 		if l.peekChar() == '=' {
@@ -95,7 +112,14 @@ func (l *Lexer) NextToken() token.Token {
 			tok = newToken(token.BANG, l.ch)
 		}
 	case '-':
-		tok = newToken(token.MINUS, l.ch)
+		if l.peekChar() == '>' {
+			ch := l.ch
+			l.readChar()
+			literal := string(ch) + string(l.ch)
+			tok = token.Token{Type: token.ARROW, Literal: literal}
+		} else {
+			tok = newToken(token.MINUS, l.ch)
+		}
 	case '/':
 		tok = newToken(token.SLASH, l.ch)
 	case '*':
@@ -1,18 +1,10 @@
 package main
 
 import (
-	"fmt"
-	"monc/repl"
+	"monc/cli"
 	"os"
-	"os/user"
 )
 
 func main() {
-	user, err := user.Current()
-	if err != nil {
-		panic(err)
-	}
-	fmt.Printf("Hello %s! This is the Monkey programming language!\n", user.Username)
-	fmt.Printf("Feel free to type in commands\n")
-	repl.Start(os.Stdin, os.Stdout)
+	os.Exit(cli.Main(os.Args[1:]))
 }
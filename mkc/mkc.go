@@ -0,0 +1,447 @@
+/*
+Package mkc reads and writes compiled Monkey programs (.mkc files), so a
+program can be run without lexing, parsing and compiling it again.
+
+All fixed-size integers are big endian, like the operands in package code.
+
+	magic     "MKC\x00"
+	version   uint16
+	flags     uint16   FlagDebug if the debug section is present
+	checksum  uint32   CRC-32 (IEEE) of everything after the header
+	constants uvarint count, then one tagged entry per constant
+	main      uvarint maximum stack depth, uvarint length, then the
+	          instructions of the main program
+	debug     only if FlagDebug is set, see below
+
+Constant entries start with a tag byte:
+
+	tagInteger     varint value
+	tagBigInt      string, the decimal digits of an integer outside int64
+	tagString      uvarint length, bytes
+	tagCompiledFn  uvarint locals, uvarint parameters, uvarint maximum stack
+	               depth, uvarint length, instructions
+
+Strings are a uvarint length followed by the bytes. The debug section holds
+
+	source    string
+	globals   uvarint count, then one string per global
+	lines     line table of the main program
+	functions for each tagCompiledFn constant in order: its name as a
+	          string, its line table, then its local and free variable
+	          names like the globals
+
+A line table is a uvarint count of entries, each the uvarint offset delta
+to the previous entry, the varint line delta and the uvarint column.
+*/
+package mkc
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"math"
+	"math/big"
+	"monc/code"
+	"monc/object"
+)
+
+const Version = 6
+
+const FlagDebug = 1 << 0
+
+var magic = []byte("MKC\x00")
+
+const headerSize = 12
+
+const (
+	tagInteger byte = iota + 1
+	tagString
+	tagCompiledFn
+	tagBigInt
+)
+
+var (
+	ErrBadMagic = errors.New("mkc: not a compiled monkey file")
+	ErrChecksum = errors.New("mkc: checksum mismatch")
+)
+
+// File is a compiled program as stored on disk
+type File struct {
+	Instructions code.Instructions
+	Constants    []object.Object
+	MaxStack     int // stack depth of Instructions, see [compiler.Bytecode]
+
+	// debug information, only written if any of it or of the functions'
+	// debug information is set
+	Source      string         // path of the program's source file
+	Lines       code.LineTable // source positions of Instructions
+	GlobalNames []string
+}
+
+func (f *File) hasDebugInfo() bool {
+	if f.Source != "" || len(f.Lines) > 0 || len(f.GlobalNames) > 0 {
+		return true
+	}
+	for _, fn := range f.functions() {
+		if fn.Name != "" || len(fn.Lines) > 0 || len(fn.LocalNames) > 0 || len(fn.FreeNames) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+func (f *File) functions() []*object.CompiledFn {
+	fns := []*object.CompiledFn{}
+	for _, c := range f.Constants {
+		if fn, ok := c.(*object.CompiledFn); ok {
+			fns = append(fns, fn)
+		}
+	}
+	return fns
+}
+
+// Encode writes f to w in the .mkc format
+func (f *File) Encode(w io.Writer) error {
+	var body bytes.Buffer
+	enc := &encoder{w: &body}
+
+	enc.uvarint(uint64(len(f.Constants)))
+	for i, c := range f.Constants {
+		if err := enc.constant(c); err != nil {
+			return fmt.Errorf("mkc: constant %d: %w", i, err)
+		}
+	}
+
+	enc.uvarint(uint64(f.MaxStack))
+	enc.bytes(f.Instructions)
+
+	var flags uint16
+	if f.hasDebugInfo() {
+		flags |= FlagDebug
+		enc.string(f.Source)
+		enc.strings(f.GlobalNames)
+		enc.lines(f.Lines)
+
+		for _, fn := range f.functions() {
+			enc.string(fn.Name)
+			enc.lines(fn.Lines)
+			enc.strings(fn.LocalNames)
+			enc.strings(fn.FreeNames)
+		}
+	}
+
+	header := make([]byte, headerSize)
+	copy(header, magic)
+	binary.BigEndian.PutUint16(header[4:], Version)
+	binary.BigEndian.PutUint16(header[6:], flags)
+	binary.BigEndian.PutUint32(header[8:], crc32.ChecksumIEEE(body.Bytes()))
+
+	if _, err := w.Write(header); err != nil {
+		return err
+	}
+	_, err := w.Write(body.Bytes())
+	return err
+}
+
+type encoder struct {
+	w *bytes.Buffer
+}
+
+func (e *encoder) uvarint(v uint64) {
+	buf := make([]byte, binary.MaxVarintLen64)
+	e.w.Write(buf[:binary.PutUvarint(buf, v)])
+}
+
+func (e *encoder) varint(v int64) {
+	buf := make([]byte, binary.MaxVarintLen64)
+	e.w.Write(buf[:binary.PutVarint(buf, v)])
+}
+
+func (e *encoder) bytes(b []byte) {
+	e.uvarint(uint64(len(b)))
+	e.w.Write(b)
+}
+
+func (e *encoder) string(s string) {
+	e.bytes([]byte(s))
+}
+
+func (e *encoder) strings(s []string) {
+	e.uvarint(uint64(len(s)))
+	for _, str := range s {
+		e.string(str)
+	}
+}
+
+func (e *encoder) lines(lt code.LineTable) {
+	e.uvarint(uint64(len(lt)))
+
+	var prev code.LineEntry
+	for _, entry := range lt {
+		e.uvarint(uint64(entry.Offset - prev.Offset))
+		e.varint(int64(entry.Line - prev.Line))
+		e.uvarint(uint64(entry.Column))
+		prev = entry
+	}
+}
+
+func (e *encoder) constant(obj object.Object) error {
+	switch obj := obj.(type) {
+	case *object.Integer:
+		e.w.WriteByte(tagInteger)
+		e.varint(obj.Value)
+
+	case *object.BigInt:
+		e.w.WriteByte(tagBigInt)
+		e.bytes([]byte(obj.Value.String()))
+
+	case *object.String:
+		e.w.WriteByte(tagString)
+		e.bytes([]byte(obj.Value))
+
+	case *object.CompiledFn:
+		e.w.WriteByte(tagCompiledFn)
+		e.uvarint(uint64(obj.NumLocals))
+		e.uvarint(uint64(obj.NumParameters))
+		e.uvarint(uint64(obj.MaxStack))
+		e.bytes(obj.Instructions)
+
+	default:
+		return fmt.Errorf("unsupported constant type %s", obj.Type())
+	}
+
+	return nil
+}
+
+// Decode reads a program written by [File.Encode]. It checks the header and
+// the checksum but not the instructions themselves.
+func Decode(r io.Reader) (*File, error) {
+	br := bufio.NewReader(r)
+
+	header := make([]byte, headerSize)
+	if _, err := io.ReadFull(br, header); err != nil {
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return nil, ErrBadMagic
+		}
+		return nil, err
+	}
+
+	if !bytes.Equal(header[:4], magic) {
+		return nil, ErrBadMagic
+	}
+
+	if version := binary.BigEndian.Uint16(header[4:]); version != Version {
+		return nil, fmt.Errorf("mkc: unsupported version %d, want %d", version, Version)
+	}
+
+	flags := binary.BigEndian.Uint16(header[6:])
+	checksum := binary.BigEndian.Uint32(header[8:])
+
+	body, err := io.ReadAll(br)
+	if err != nil {
+		return nil, err
+	}
+
+	if crc32.ChecksumIEEE(body) != checksum {
+		return nil, ErrChecksum
+	}
+
+	dec := &decoder{buf: body}
+	f := &File{}
+
+	count := dec.uvarint()
+	if count > uint64(len(body)) {
+		return nil, fmt.Errorf("mkc: constant count %d exceeds file size", count)
+	}
+
+	f.Constants = make([]object.Object, 0, count)
+	for i := uint64(0); i < count && dec.err == nil; i++ {
+		f.Constants = append(f.Constants, dec.constant())
+	}
+
+	f.MaxStack = dec.int()
+	f.Instructions = dec.bytes()
+
+	if flags&FlagDebug != 0 {
+		f.Source = dec.string()
+		f.GlobalNames = dec.strings()
+		f.Lines = dec.lines()
+
+		for _, fn := range f.functions() {
+			if dec.err != nil {
+				break
+			}
+			fn.Name = dec.string()
+			fn.Lines = dec.lines()
+			fn.LocalNames = dec.strings()
+			fn.FreeNames = dec.strings()
+		}
+	}
+
+	if dec.err != nil {
+		return nil, fmt.Errorf("mkc: %w", dec.err)
+	}
+
+	if dec.pos != len(body) {
+		return nil, fmt.Errorf("mkc: %d trailing bytes", len(body)-dec.pos)
+	}
+
+	return f, nil
+}
+
+// decoder reads from an in-memory body and remembers the first error, so
+// callers can check once after reading a whole entry
+type decoder struct {
+	buf []byte
+	pos int
+	err error
+}
+
+func (d *decoder) fail(format string, a ...interface{}) {
+	if d.err == nil {
+		d.err = fmt.Errorf(format, a...)
+	}
+}
+
+func (d *decoder) uvarint() uint64 {
+	if d.err != nil {
+		return 0
+	}
+	v, n := binary.Uvarint(d.buf[d.pos:])
+	if n <= 0 {
+		d.fail("malformed unsigned integer at offset %d", d.pos)
+		return 0
+	}
+	d.pos += n
+	return v
+}
+
+func (d *decoder) varint() int64 {
+	if d.err != nil {
+		return 0
+	}
+	v, n := binary.Varint(d.buf[d.pos:])
+	if n <= 0 {
+		d.fail("malformed integer at offset %d", d.pos)
+		return 0
+	}
+	d.pos += n
+	return v
+}
+
+// int reads an unsigned integer that is used as a count or index
+func (d *decoder) int() int {
+	v := d.uvarint()
+	if v > math.MaxInt32 {
+		d.fail("value %d out of range at offset %d", v, d.pos)
+		return 0
+	}
+	return int(v)
+}
+
+func (d *decoder) bytes() []byte {
+	n := d.int()
+	if d.err != nil {
+		return nil
+	}
+	if d.pos+n > len(d.buf) {
+		d.fail("length %d at offset %d exceeds file size", n, d.pos)
+		return nil
+	}
+	b := make([]byte, n)
+	copy(b, d.buf[d.pos:])
+	d.pos += n
+	return b
+}
+
+func (d *decoder) string() string {
+	return string(d.bytes())
+}
+
+func (d *decoder) strings() []string {
+	n := d.count()
+	if n == 0 {
+		return nil
+	}
+
+	s := make([]string, 0, n)
+	for i := 0; i < n && d.err == nil; i++ {
+		s = append(s, d.string())
+	}
+	return s
+}
+
+func (d *decoder) lines() code.LineTable {
+	n := d.count()
+	if n == 0 {
+		return nil
+	}
+
+	lt := make(code.LineTable, 0, n)
+	var prev code.LineEntry
+	for i := 0; i < n && d.err == nil; i++ {
+		entry := code.LineEntry{
+			Offset: prev.Offset + d.int(),
+			Line:   prev.Line + int(d.varint()),
+			Column: d.int(),
+		}
+		lt = append(lt, entry)
+		prev = entry
+	}
+	return lt
+}
+
+// count reads the number of entries that follow, each at least one byte
+func (d *decoder) count() int {
+	n := d.int()
+	if n > len(d.buf)-d.pos {
+		d.fail("count %d at offset %d exceeds file size", n, d.pos)
+		return 0
+	}
+	return n
+}
+
+func (d *decoder) constant() object.Object {
+	if d.err != nil {
+		return nil
+	}
+	if d.pos >= len(d.buf) {
+		d.fail("unexpected end of constants")
+		return nil
+	}
+
+	tag := d.buf[d.pos]
+	d.pos++
+
+	switch tag {
+	case tagInteger:
+		return &object.Integer{Value: d.varint()}
+
+	case tagBigInt:
+		digits := string(d.bytes())
+		n, ok := new(big.Int).SetString(digits, 10)
+		if !ok {
+			d.fail("invalid integer %q", digits)
+			return nil
+		}
+		return object.NewBigInt(n)
+
+	case tagString:
+		return &object.String{Value: string(d.bytes())}
+
+	case tagCompiledFn:
+		fn := &object.CompiledFn{}
+		fn.NumLocals = d.int()
+		fn.NumParameters = d.int()
+		fn.MaxStack = d.int()
+		fn.Instructions = d.bytes()
+		return fn
+	}
+
+	d.fail("unknown constant tag %d at offset %d", tag, d.pos-1)
+	return nil
+}
@@ -0,0 +1,193 @@
+package mkc
+
+import (
+	"bytes"
+	"errors"
+	"math/big"
+	"monc/code"
+	"monc/object"
+	"reflect"
+	"testing"
+)
+
+func testFile() *File {
+	fn := &object.CompiledFn{
+		Instructions: concatInstructions(
+			code.Make(code.OpGetLocal, 0),
+			code.Make(code.OpConstant, 0),
+			code.Make(code.OpAdd),
+			code.Make(code.OpReturnValue),
+		),
+		NumLocals:     1,
+		NumParameters: 1,
+		MaxStack:      2,
+		Name:          "add",
+		Lines:         code.LineTable{{Offset: 0, Line: 1, Column: 20}, {Offset: 5, Line: 1, Column: 18}},
+		LocalNames:    []string{"x"},
+	}
+
+	return &File{
+		Instructions: concatInstructions(
+			code.Make(code.OpClosure, 2, 0),
+			code.Make(code.OpConstant, 1),
+			code.Make(code.OpCall, 1),
+			code.Make(code.OpPop),
+		),
+		Constants: []object.Object{
+			&object.Integer{Value: -42},
+			&object.String{Value: "héllo"},
+			fn,
+			object.NewBigInt(new(big.Int).Lsh(big.NewInt(-1), 100)),
+		},
+		MaxStack:    2,
+		Source:      "add.mk",
+		Lines:       code.LineTable{{Offset: 0, Line: 2, Column: 1}, {Offset: 7, Line: 1, Column: 4}},
+		GlobalNames: []string{"add"},
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	original := testFile()
+
+	var buf bytes.Buffer
+	if err := original.Encode(&buf); err != nil {
+		t.Fatalf("encode failed: %s", err)
+	}
+
+	decoded, err := Decode(&buf)
+	if err != nil {
+		t.Fatalf("decode failed: %s", err)
+	}
+
+	if !bytes.Equal(decoded.Instructions, original.Instructions) {
+		t.Errorf("wrong instructions.\ngot=%q\nwant=%q", decoded.Instructions, original.Instructions)
+	}
+
+	if decoded.MaxStack != original.MaxStack {
+		t.Errorf("wrong max stack. got=%d, want=%d", decoded.MaxStack, original.MaxStack)
+	}
+
+	if decoded.Source != original.Source {
+		t.Errorf("wrong source. got=%q, want=%q", decoded.Source, original.Source)
+	}
+
+	if !reflect.DeepEqual(decoded.Lines, original.Lines) {
+		t.Errorf("wrong lines. got=%v, want=%v", decoded.Lines, original.Lines)
+	}
+
+	if !reflect.DeepEqual(decoded.GlobalNames, original.GlobalNames) {
+		t.Errorf("wrong global names. got=%v, want=%v", decoded.GlobalNames, original.GlobalNames)
+	}
+
+	if len(decoded.Constants) != len(original.Constants) {
+		t.Fatalf("wrong number of constants. got=%d, want=%d", len(decoded.Constants), len(original.Constants))
+	}
+
+	if c, ok := decoded.Constants[0].(*object.Integer); !ok || c.Value != -42 {
+		t.Errorf("wrong constant 0. got=%T (%+v)", decoded.Constants[0], decoded.Constants[0])
+	}
+
+	if c, ok := decoded.Constants[1].(*object.String); !ok || c.Value != "héllo" {
+		t.Errorf("wrong constant 1. got=%T (%+v)", decoded.Constants[1], decoded.Constants[1])
+	}
+
+	if c, ok := decoded.Constants[3].(*object.BigInt); !ok || c.Inspect() != "-1267650600228229401496703205376" {
+		t.Errorf("wrong constant 3. got=%T (%+v)", decoded.Constants[3], decoded.Constants[3])
+	}
+
+	fn, ok := decoded.Constants[2].(*object.CompiledFn)
+	if !ok {
+		t.Fatalf("constant 2 not CompiledFn. got=%T", decoded.Constants[2])
+	}
+	want := original.Constants[2].(*object.CompiledFn)
+	if !bytes.Equal(fn.Instructions, want.Instructions) ||
+		fn.NumLocals != want.NumLocals || fn.NumParameters != want.NumParameters ||
+		fn.MaxStack != want.MaxStack {
+		t.Errorf("wrong function. got=%+v, want=%+v", fn, want)
+	}
+	if fn.Name != want.Name || !reflect.DeepEqual(fn.Lines, want.Lines) ||
+		!reflect.DeepEqual(fn.LocalNames, want.LocalNames) || fn.FreeNames != nil {
+		t.Errorf("wrong function debug info. got=%+v, want=%+v", fn, want)
+	}
+}
+
+func TestDecodeWithoutDebugInfo(t *testing.T) {
+	f := testFile()
+	f.Source = ""
+	f.Lines = nil
+	f.GlobalNames = nil
+	fn := f.Constants[2].(*object.CompiledFn)
+	fn.Name, fn.Lines, fn.LocalNames = "", nil, nil
+
+	var buf bytes.Buffer
+	if err := f.Encode(&buf); err != nil {
+		t.Fatalf("encode failed: %s", err)
+	}
+
+	if flags := buf.Bytes()[7]; flags&FlagDebug != 0 {
+		t.Errorf("debug flag set without debug info")
+	}
+
+	decoded, err := Decode(&buf)
+	if err != nil {
+		t.Fatalf("decode failed: %s", err)
+	}
+	if decoded.Source != "" {
+		t.Errorf("unexpected source %q", decoded.Source)
+	}
+}
+
+func TestDecodeRejectsCorruptFiles(t *testing.T) {
+	var buf bytes.Buffer
+	if err := testFile().Encode(&buf); err != nil {
+		t.Fatalf("encode failed: %s", err)
+	}
+	valid := buf.Bytes()
+
+	corrupt := func(f func(b []byte) []byte) []byte {
+		b := make([]byte, len(valid))
+		copy(b, valid)
+		return f(b)
+	}
+
+	tests := []struct {
+		name     string
+		input    []byte
+		expected error
+	}{
+		{"empty", []byte{}, ErrBadMagic},
+		{"magic", corrupt(func(b []byte) []byte { b[0] = 'X'; return b }), ErrBadMagic},
+		{"version", corrupt(func(b []byte) []byte { b[5] = 99; return b }), nil},
+		{"checksum", corrupt(func(b []byte) []byte { b[len(b)-1] ^= 0xFF; return b }), ErrChecksum},
+		{"truncated", corrupt(func(b []byte) []byte { return b[:len(b)-3] }), ErrChecksum},
+	}
+
+	for _, tt := range tests {
+		_, err := Decode(bytes.NewReader(tt.input))
+		if err == nil {
+			t.Errorf("%s: expected error", tt.name)
+			continue
+		}
+		if tt.expected != nil && !errors.Is(err, tt.expected) {
+			t.Errorf("%s: wrong error. got=%q, want=%q", tt.name, err, tt.expected)
+		}
+	}
+}
+
+func TestEncodeRejectsUnsupportedConstants(t *testing.T) {
+	f := &File{Constants: []object.Object{&object.Boolean{Value: true}}}
+
+	if err := f.Encode(&bytes.Buffer{}); err == nil {
+		t.Fatalf("expected error for boolean constant")
+	}
+}
+
+func concatInstructions(s ...[]byte) code.Instructions {
+	out := code.Instructions{}
+
+	for _, ins := range s {
+		out = append(out, ins...)
+	}
+
+	return out
+}
@@ -110,8 +110,9 @@ var Builtins = []struct {
 		},
 	},
 	{ // `push` adds an element to an array.
-		// since arrays are immutable in monkey, it allocates a new array with
-		// the new element and returns that.
+		// it allocates a new array with the new element and returns that,
+		// leaving the array passed as argument and its aliases unchanged;
+		// `append` changes the array in place instead.
 		"push",
 		&Builtin{
 
@@ -135,6 +136,97 @@ var Builtins = []struct {
 			},
 		},
 	},
+	{ // `append` adds an element to the end of an array in place and returns
+		// the same array, so every variable referring to it sees the element.
+		// it is the mutating counterpart of `push`.
+		"append",
+		&Builtin{
+			Fn: func(args ...Object) Object {
+				if len(args) != 2 {
+					return newError("wrong number of arguments. got=%d, want=2",
+						len(args))
+				}
+				if args[0].Type() != ARRAY_OBJ {
+					return newError("argument to `append` must be ARRAY, got %s",
+						args[0].Type())
+				}
+				arr := args[0].(*Array)
+				arr.Elements = append(arr.Elements, args[1])
+
+				return arr
+			},
+		},
+	},
+	{ // `delete` removes a key from a hash in place and returns the same hash.
+		// deleting a missing key does nothing.
+		"delete",
+		&Builtin{
+			Fn: func(args ...Object) Object {
+				if len(args) != 2 {
+					return newError("wrong number of arguments. got=%d, want=2",
+						len(args))
+				}
+				if args[0].Type() != HASH_OBJ {
+					return newError("argument to `delete` must be HASH, got %s",
+						args[0].Type())
+				}
+				key, ok := args[1].(Hashable)
+				if !ok {
+					return newError("unusable as hash key: %s", args[1].Type())
+				}
+				hash := args[0].(*Hash)
+				hash.Delete(key.HashKey())
+
+				return hash
+			},
+		},
+	},
+	{ // `keys` returns a new array with the keys of a hash in the order
+		// they were inserted
+		"keys",
+		&Builtin{
+			Fn: func(args ...Object) Object {
+				if len(args) != 1 {
+					return newError("wrong number of arguments. got=%d, want=1",
+						len(args))
+				}
+				if args[0].Type() != HASH_OBJ {
+					return newError("argument to `keys` must be HASH, got %s",
+						args[0].Type())
+				}
+				pairs := args[0].(*Hash).Pairs()
+
+				keys := make([]Object, len(pairs))
+				for i, pair := range pairs {
+					keys[i] = pair.Key
+				}
+				return &Array{Elements: keys}
+			},
+		},
+	},
+	{ // `values` returns a new array with the values of a hash, in the
+		// order of `keys`
+		"values",
+		&Builtin{
+			Fn: func(args ...Object) Object {
+				if len(args) != 1 {
+					return newError("wrong number of arguments. got=%d, want=1",
+						len(args))
+				}
+				if args[0].Type() != HASH_OBJ {
+					return newError("argument to `values` must be HASH, got %s",
+						args[0].Type())
+				}
+				pairs := args[0].(*Hash).Pairs()
+
+				values := make([]Object, len(pairs))
+				for i, pair := range pairs {
+					values[i] = pair.Value
+				}
+				return &Array{Elements: values}
+			},
+		},
+	},
 }
 
 func newError(format string, a ...interface{}) *Error {
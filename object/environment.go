@@ -11,9 +11,24 @@ func NewEnclosedEnvironment(outer *Environment) *Environment {
 	return env
 }
 
+// NewCallEnvironment returns the environment of a call, depth calls deep,
+// of a function closed over outer
+func NewCallEnvironment(outer *Environment, depth int) *Environment {
+	env := NewEnclosedEnvironment(outer)
+	env.depth = depth
+	return env
+}
+
 type Environment struct {
 	store map[string]Object
 	outer *Environment
+	depth int
+}
+
+// Depth is the number of calls the environment is nested in, 0 for the
+// environment of a program
+func (e *Environment) Depth() int {
+	return e.depth
 }
 
 func (e *Environment) Get(name string) (Object, bool) {
@@ -0,0 +1,99 @@
+package object
+
+// Equal reports whether a and b have the same value. Integers, booleans,
+// null and strings are equal by value; arrays by their elements in order
+// and hashes by their pairs in any order, both recursively. Anything else,
+// like functions, is only equal to itself.
+func Equal(a, b Object) bool {
+	var c comparer
+	return c.equal(a, b)
+}
+
+// comparison is a pair of collections being compared
+type comparison struct{ a, b Object }
+
+/*
+comparer compares values and remembers the pairs of collections it has
+seen. A pair seen before is either still being compared, because arrays
+and hashes can contain themselves, or was found equal, since finding any
+pair unequal ends the comparison. Either way it is taken to be equal: the
+result then depends on the rest of the elements, which are compared
+anyway, and collections shared along many paths are only compared once.
+*/
+type comparer struct {
+	seen map[comparison]bool
+}
+
+func (c *comparer) equal(a, b Object) bool {
+	if a == b {
+		return true
+	}
+	if a.Type() != b.Type() {
+		return false
+	}
+
+	switch a := a.(type) {
+	case *Integer, *BigInt:
+		return CompareIntegers(a, b) == 0
+
+	case *Boolean:
+		return a.Value == b.(*Boolean).Value
+
+	case *Null:
+		return true
+
+	case *String:
+		return a.Value == b.(*String).Value
+
+	case *Array:
+		b := b.(*Array)
+		if len(a.Elements) != len(b.Elements) {
+			return false
+		}
+		if c.visit(a, b) {
+			return true
+		}
+
+		for i := range a.Elements {
+			if !c.equal(a.Elements[i], b.Elements[i]) {
+				return false
+			}
+		}
+		return true
+
+	case *Hash:
+		b := b.(*Hash)
+		if a.Len() != b.Len() {
+			return false
+		}
+		if c.visit(a, b) {
+			return true
+		}
+
+		for _, e := range a.entries {
+			if e.deleted {
+				continue
+			}
+			other, ok := b.Get(e.key)
+			if !ok || !c.equal(e.pair.Value, other.Value) {
+				return false
+			}
+		}
+		return true
+	}
+
+	return false
+}
+
+// visit reports whether the pair a, b was seen before and records it
+func (c *comparer) visit(a, b Object) bool {
+	if c.seen == nil {
+		c.seen = map[comparison]bool{}
+	}
+	pair := comparison{a, b}
+	if c.seen[pair] {
+		return true
+	}
+	c.seen[pair] = true
+	return false
+}
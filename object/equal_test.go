@@ -0,0 +1,116 @@
+package object
+
+import (
+	"math/big"
+	"testing"
+)
+
+func TestEqual(t *testing.T) {
+	str := func(s string) Object { return &String{Value: s} }
+	integer := func(n int64) Object { return &Integer{Value: n} }
+	array := func(elements ...Object) Object { return &Array{Elements: elements} }
+	hash := func(pairs ...Object) Object {
+		h := &Hash{}
+		for i := 0; i < len(pairs); i += 2 {
+			h.Set(pairs[i].(Hashable).HashKey(), HashPair{Key: pairs[i], Value: pairs[i+1]})
+		}
+		return h
+	}
+	large := NewBigInt(new(big.Int).Lsh(big.NewInt(1), 64))
+	fn := &Builtin{}
+
+	tests := []struct {
+		a, b     Object
+		expected bool
+	}{
+		{integer(1), integer(1), true},
+		{integer(1), integer(2), false},
+		{large, NewBigInt(new(big.Int).Lsh(big.NewInt(1), 64)), true},
+		{large, integer(0), false},
+		{&Boolean{Value: true}, &Boolean{Value: true}, true},
+		{&Boolean{Value: true}, &Boolean{Value: false}, false},
+		{&Null{}, &Null{}, true},
+		{str("a"), str("a"), true},
+		{str("a"), str("b"), false},
+		{str("1"), integer(1), false},
+		{array(), array(), true},
+		{array(integer(1), str("a")), array(integer(1), str("a")), true},
+		{array(integer(1), str("a")), array(str("a"), integer(1)), false},
+		{array(integer(1)), array(integer(1), integer(1)), false},
+		{array(array(integer(1))), array(array(integer(1))), true},
+		{hash(), hash(), true},
+		{hash(str("a"), integer(1), str("b"), integer(2)), hash(str("b"), integer(2), str("a"), integer(1)), true},
+		{hash(str("a"), integer(1)), hash(str("a"), integer(2)), false},
+		{hash(str("a"), integer(1)), hash(str("b"), integer(1)), false},
+		{hash(str("a"), array(integer(1))), hash(str("a"), array(integer(1))), true},
+		{array(hash()), array(array()), false},
+		{fn, fn, true},
+		{fn, &Builtin{}, false},
+	}
+
+	for i, tt := range tests {
+		if got := Equal(tt.a, tt.b); got != tt.expected {
+			t.Errorf("%d: Equal(%s, %s) wrong. want=%t, got=%t", i, tt.a.Inspect(), tt.b.Inspect(), tt.expected, got)
+		}
+	}
+}
+
+func TestEqualCycles(t *testing.T) {
+	// a = [1, a] and b = [1, b] unfold to the same infinite array
+	a := &Array{Elements: []Object{&Integer{Value: 1}}}
+	a.Elements = append(a.Elements, a)
+	b := &Array{Elements: []Object{&Integer{Value: 1}}}
+	b.Elements = append(b.Elements, b)
+	// c = [2, c] differs in the first element
+	c := &Array{Elements: []Object{&Integer{Value: 2}}}
+	c.Elements = append(c.Elements, c)
+
+	key := &String{Value: "self"}
+	h := &Hash{}
+	h.Set(key.HashKey(), HashPair{Key: key, Value: h})
+	g := &Hash{}
+	g.Set(key.HashKey(), HashPair{Key: key, Value: g})
+
+	tests := []struct {
+		a, b     Object
+		expected bool
+	}{
+		{a, a, true},
+		{a, b, true},
+		{a, c, false},
+		{&Array{Elements: []Object{a, c}}, &Array{Elements: []Object{b, c}}, true},
+		{h, g, true},
+		{h, a, false},
+	}
+
+	for i, tt := range tests {
+		if got := Equal(tt.a, tt.b); got != tt.expected {
+			t.Errorf("%d: Equal(%s, %s) wrong. want=%t, got=%t", i, tt.a.Inspect(), tt.b.Inspect(), tt.expected, got)
+		}
+	}
+}
+
+func TestEqualSharedStructure(t *testing.T) {
+	// like `let a = [x, x]; let b = [a, a]; ...`, which has 2^n paths to x
+	shared := func(leaf Object, depth int) Object {
+		node := leaf
+		for i := 0; i < depth; i++ {
+			key := &String{Value: "k"}
+			h := &Hash{}
+			h.Set(key.HashKey(), HashPair{Key: key, Value: node})
+			node = &Array{Elements: []Object{node, h, node}}
+		}
+		return node
+	}
+
+	a := shared(&Integer{Value: 1}, 100)
+	b := shared(&Integer{Value: 1}, 100)
+	c := shared(&Integer{Value: 2}, 100)
+
+	if !Equal(a, b) {
+		t.Errorf("equal shared structures compare unequal")
+	}
+	if Equal(a, c) {
+		t.Errorf("shared structures with different leaves compare equal")
+	}
+}
@@ -0,0 +1,104 @@
+package object
+
+/*
+Hash maps hash keys to pairs and lists them in the order their keys were
+first set, so Inspect and anything else iterating over a hash is
+deterministic. Setting an existing key keeps its place; deleting a key
+and setting it again moves it to the end. The zero Hash is empty and
+ready to use.
+
+Why pairs rather than values?
+When printing to REPL using the Inspect() method, we want to keep track of
+the objects that generated the respective HashKeys. Iterating over a hash
+needs them as well.
+*/
+type Hash struct {
+	entries []hashEntry     // in insertion order, with holes for deleted keys
+	index   map[HashKey]int // the position of each key in entries
+	deleted int
+}
+
+type hashEntry struct {
+	key     HashKey
+	pair    HashPair
+	deleted bool
+}
+
+// NewHash returns an empty hash with room for size pairs
+func NewHash(size int) *Hash {
+	return &Hash{
+		entries: make([]hashEntry, 0, size),
+		index:   make(map[HashKey]int, size),
+	}
+}
+
+// Len returns the number of pairs
+func (h *Hash) Len() int { return len(h.index) }
+
+func (h *Hash) Get(key HashKey) (HashPair, bool) {
+	i, ok := h.index[key]
+	if !ok {
+		return HashPair{}, false
+	}
+	return h.entries[i].pair, true
+}
+
+// Set adds pair under key, or replaces the pair already there in place
+func (h *Hash) Set(key HashKey, pair HashPair) {
+	if i, ok := h.index[key]; ok {
+		h.entries[i].pair = pair
+		return
+	}
+
+	if h.index == nil {
+		h.index = make(map[HashKey]int)
+	}
+	h.index[key] = len(h.entries)
+	h.entries = append(h.entries, hashEntry{key: key, pair: pair})
+}
+
+// Delete removes the pair under key, if any
+func (h *Hash) Delete(key HashKey) {
+	i, ok := h.index[key]
+	if !ok {
+		return
+	}
+
+	delete(h.index, key)
+	h.entries[i] = hashEntry{deleted: true}
+	h.deleted++
+
+	// compacting once half of the entries are holes keeps deletes O(1)
+	// amortized and the holes at most as many as the pairs
+	if h.deleted > len(h.entries)/2 {
+		h.compact()
+	}
+}
+
+func (h *Hash) compact() {
+	live := h.entries[:0]
+	for _, e := range h.entries {
+		if !e.deleted {
+			h.index[e.key] = len(live)
+			live = append(live, e)
+		}
+	}
+
+	// clear the tail so the removed pairs can be collected
+	for i := len(live); i < len(h.entries); i++ {
+		h.entries[i] = hashEntry{}
+	}
+	h.entries = live
+	h.deleted = 0
+}
+
+// Pairs returns the pairs in insertion order
+func (h *Hash) Pairs() []HashPair {
+	pairs := make([]HashPair, 0, h.Len())
+	for _, e := range h.entries {
+		if !e.deleted {
+			pairs = append(pairs, e.pair)
+		}
+	}
+	return pairs
+}
@@ -0,0 +1,71 @@
+package object
+
+import (
+	"fmt"
+	"testing"
+)
+
+func setInt(h *Hash, key, value int64) {
+	k := &Integer{Value: key}
+	h.Set(k.HashKey(), HashPair{Key: k, Value: &Integer{Value: value}})
+}
+
+func deleteInt(h *Hash, key int64) {
+	h.Delete((&Integer{Value: key}).HashKey())
+}
+
+func TestHashOrder(t *testing.T) {
+	tests := []struct {
+		build    func(h *Hash)
+		expected string
+	}{
+		{func(h *Hash) {}, "{}"},
+		{func(h *Hash) { setInt(h, 3, 0); setInt(h, 1, 0); setInt(h, 2, 0) }, "{3: 0, 1: 0, 2: 0}"},
+		// setting a key again keeps its place
+		{func(h *Hash) { setInt(h, 3, 0); setInt(h, 1, 0); setInt(h, 3, 9) }, "{3: 9, 1: 0}"},
+		// deleting it and setting it again moves it to the end
+		{func(h *Hash) { setInt(h, 3, 0); setInt(h, 1, 0); deleteInt(h, 3); setInt(h, 3, 9) }, "{1: 0, 3: 9}"},
+		{func(h *Hash) { setInt(h, 1, 0); deleteInt(h, 2); deleteInt(h, 1); deleteInt(h, 1) }, "{}"},
+	}
+
+	for i, tt := range tests {
+		h := &Hash{}
+		tt.build(h)
+		if got := h.Inspect(); got != tt.expected {
+			t.Errorf("%d: wrong hash. want=%q, got=%q", i, tt.expected, got)
+		}
+	}
+}
+
+func TestHashCompaction(t *testing.T) {
+	h := NewHash(0)
+	for i := int64(0); i < 100; i++ {
+		setInt(h, i, i*10)
+	}
+	// delete all but the multiples of 10, compacting on the way
+	for i := int64(0); i < 100; i++ {
+		if i%10 != 0 {
+			deleteInt(h, i)
+		}
+	}
+
+	if h.Len() != 10 {
+		t.Fatalf("wrong length. want=10, got=%d", h.Len())
+	}
+	if len(h.entries) > 2*h.Len() {
+		t.Errorf("holes not compacted: %d entries for %d pairs", len(h.entries), h.Len())
+	}
+	if got := h.Inspect(); got != "{0: 0, 10: 100, 20: 200, 30: 300, 40: 400, 50: 500, 60: 600, 70: 700, 80: 800, 90: 900}" {
+		t.Errorf("wrong hash after deletes. got=%q", got)
+	}
+
+	for i := int64(0); i < 100; i++ {
+		pair, ok := h.Get((&Integer{Value: i}).HashKey())
+		if ok != (i%10 == 0) {
+			t.Errorf("wrong lookup of %d. got ok=%t", i, ok)
+		}
+		if ok && pair.Value.Inspect() != fmt.Sprint(i*10) {
+			t.Errorf("wrong value of %d. got=%s", i, pair.Value.Inspect())
+		}
+	}
+}
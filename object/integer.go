@@ -0,0 +1,139 @@
+package object
+
+import (
+	"hash/fnv"
+	"math"
+	"math/big"
+)
+
+/*
+BigInt is an integer outside the range of Integer. It has the type INTEGER
+too: arithmetic promotes results that overflow int64 to a BigInt and
+demotes results that fit back to an Integer, so every integer has exactly
+one representation and the value of a BigInt never fits int64.
+*/
+type BigInt struct {
+	Value *big.Int
+}
+
+func (b *BigInt) Type() ObjectType { return INTEGER_OBJ }
+func (b *BigInt) Inspect() string  { return b.Value.String() }
+
+func (b *BigInt) HashKey() HashKey {
+	h := fnv.New64a()
+	if b.Value.Sign() < 0 {
+		h.Write([]byte{'-'})
+	}
+	h.Write(b.Value.Bytes())
+	return HashKey{Type: b.Type(), Value: h.Sum64()}
+}
+
+// NewBigInt returns x as an Integer if it fits, a BigInt otherwise
+func NewBigInt(x *big.Int) Object {
+	if x.IsInt64() {
+		return &Integer{Value: x.Int64()}
+	}
+	return &BigInt{Value: x}
+}
+
+// BigValue returns the value of an Integer or BigInt
+func BigValue(obj Object) *big.Int {
+	if b, ok := obj.(*BigInt); ok {
+		return b.Value
+	}
+	return big.NewInt(obj.(*Integer).Value)
+}
+
+// AddInt64 returns a + b and whether it did not overflow
+func AddInt64(a, b int64) (int64, bool) {
+	s := a + b
+	return s, (s > a) == (b > 0)
+}
+
+// SubInt64 returns a - b and whether it did not overflow
+func SubInt64(a, b int64) (int64, bool) {
+	d := a - b
+	return d, (d < a) == (b > 0)
+}
+
+// MulInt64 returns a * b and whether it did not overflow
+func MulInt64(a, b int64) (int64, bool) {
+	if a == 0 || b == 0 {
+		return 0, true
+	}
+	p := a * b
+	if (a == -1 && b == math.MinInt64) || (b == -1 && a == math.MinInt64) {
+		return p, false
+	}
+	return p, p/b == a
+}
+
+/*
+IntegerArithmetic computes left operator right for the operators + - * /
+on two integers, Integers or BigInts. Division truncates toward zero like
+it does for int64; dividing by zero panics, so callers check for it to
+report an error.
+*/
+func IntegerArithmetic(operator string, left, right Object) Object {
+	l, lok := left.(*Integer)
+	r, rok := right.(*Integer)
+	if lok && rok {
+		var result int64
+		ok := true
+		switch operator {
+		case "+":
+			result, ok = AddInt64(l.Value, r.Value)
+		case "-":
+			result, ok = SubInt64(l.Value, r.Value)
+		case "*":
+			result, ok = MulInt64(l.Value, r.Value)
+		case "/":
+			ok = !(l.Value == math.MinInt64 && r.Value == -1)
+			if ok {
+				result = l.Value / r.Value
+			}
+		}
+		if ok {
+			return &Integer{Value: result}
+		}
+	}
+
+	x, y := BigValue(left), BigValue(right)
+	result := new(big.Int)
+	switch operator {
+	case "+":
+		result.Add(x, y)
+	case "-":
+		result.Sub(x, y)
+	case "*":
+		result.Mul(x, y)
+	case "/":
+		result.Quo(x, y)
+	}
+	return NewBigInt(result)
+}
+
+// CompareIntegers returns -1, 0 or +1 as left is less than, equal to or
+// greater than right
+func CompareIntegers(left, right Object) int {
+	l, lok := left.(*Integer)
+	r, rok := right.(*Integer)
+	if lok && rok {
+		switch {
+		case l.Value < r.Value:
+			return -1
+		case l.Value > r.Value:
+			return 1
+		}
+		return 0
+	}
+	return BigValue(left).Cmp(BigValue(right))
+}
+
+// NegateInteger returns -obj for an Integer or BigInt
+func NegateInteger(obj Object) Object {
+	if i, ok := obj.(*Integer); ok && i.Value != math.MinInt64 {
+		return &Integer{Value: -i.Value}
+	}
+	return NewBigInt(new(big.Int).Neg(BigValue(obj)))
+}
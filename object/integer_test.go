@@ -0,0 +1,104 @@
+package object
+
+import (
+	"math"
+	"math/big"
+	"testing"
+)
+
+func TestIntegerArithmetic(t *testing.T) {
+	tests := []struct {
+		operator string
+		left     Object
+		right    Object
+		expected string
+		big      bool
+	}{
+		{"+", integer(1), integer(2), "3", false},
+		{"+", integer(math.MaxInt64), integer(1), "9223372036854775808", true},
+		{"-", integer(math.MinInt64), integer(1), "-9223372036854775809", true},
+		{"*", integer(1 << 32), integer(1 << 32), "18446744073709551616", true},
+		{"*", integer(-1), integer(math.MinInt64), "9223372036854775808", true},
+		{"/", integer(math.MinInt64), integer(-1), "9223372036854775808", true},
+		{"/", integer(-7), integer(2), "-3", false},
+		// results that fit are demoted
+		{"-", bigInt("9223372036854775808"), integer(1), "9223372036854775807", false},
+		{"/", bigInt("18446744073709551616"), integer(1 << 32), "4294967296", false},
+		{"/", bigInt("-100000000000000000000"), integer(3), "-33333333333333333333", true},
+	}
+
+	for _, tt := range tests {
+		result := IntegerArithmetic(tt.operator, tt.left, tt.right)
+		if result.Inspect() != tt.expected {
+			t.Errorf("%s %s %s: want=%s, got=%s", tt.left.Inspect(), tt.operator, tt.right.Inspect(), tt.expected, result.Inspect())
+		}
+		if _, isBig := result.(*BigInt); isBig != tt.big {
+			t.Errorf("%s %s %s: wrong representation %T", tt.left.Inspect(), tt.operator, tt.right.Inspect(), result)
+		}
+		if result.Type() != INTEGER_OBJ {
+			t.Errorf("wrong type %s", result.Type())
+		}
+	}
+}
+
+func TestCompareAndNegateIntegers(t *testing.T) {
+	if CompareIntegers(bigInt("9223372036854775808"), integer(math.MaxInt64)) != 1 ||
+		CompareIntegers(integer(math.MinInt64), bigInt("-9223372036854775809")) != 1 ||
+		CompareIntegers(integer(3), integer(3)) != 0 {
+		t.Errorf("wrong comparison")
+	}
+
+	if n, ok := NegateInteger(integer(math.MinInt64)).(*BigInt); !ok || n.Inspect() != "9223372036854775808" {
+		t.Errorf("-MinInt64 is not promoted")
+	}
+	if n, ok := NegateInteger(bigInt("9223372036854775808")).(*Integer); !ok || n.Value != math.MinInt64 {
+		t.Errorf("-(MaxInt64 + 1) is not demoted")
+	}
+}
+
+func TestOverflowChecks(t *testing.T) {
+	tests := []struct {
+		fn   func(a, b int64) (int64, bool)
+		a, b int64
+		ok   bool
+	}{
+		{AddInt64, math.MaxInt64, 0, true},
+		{AddInt64, math.MaxInt64, 1, false},
+		{AddInt64, math.MinInt64, -1, false},
+		{AddInt64, -5, 3, true},
+		{SubInt64, math.MinInt64, 1, false},
+		{SubInt64, 0, math.MinInt64, false},
+		{SubInt64, -1, math.MinInt64, true},
+		{MulInt64, math.MaxInt64, 2, false},
+		{MulInt64, math.MinInt64, 1, true},
+		{MulInt64, math.MinInt64, -1, false},
+		{MulInt64, 1 << 31, 1 << 31, true},
+		{MulInt64, 1 << 32, 1 << 31, false},
+	}
+
+	for i, tt := range tests {
+		if _, ok := tt.fn(tt.a, tt.b); ok != tt.ok {
+			t.Errorf("test %d: %d, %d: want ok=%t", i, tt.a, tt.b, tt.ok)
+		}
+	}
+}
+
+func TestBigIntHashKey(t *testing.T) {
+	a := bigInt("18446744073709551616")
+	b := IntegerArithmetic("*", integer(1<<32), integer(1<<32))
+	negative := bigInt("-18446744073709551616")
+
+	if a.(Hashable).HashKey() != b.(Hashable).HashKey() {
+		t.Errorf("equal big integers have different hash keys")
+	}
+	if a.(Hashable).HashKey() == negative.(Hashable).HashKey() {
+		t.Errorf("big integers of opposite signs have the same hash key")
+	}
+}
+
+func integer(n int64) Object { return &Integer{Value: n} }
+
+func bigInt(digits string) Object {
+	n, _ := new(big.Int).SetString(digits, 10)
+	return NewBigInt(n)
+}
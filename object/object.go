@@ -43,6 +43,17 @@ type CompiledFn struct {
 	Instructions  code.Instructions
 	NumLocals     int
 	NumParameters int
+	MaxStack      int // most values the function keeps on the stack above its locals
+
+	// debug information, empty if unknown
+	Name       string         // the name the function was bound to with `let`
+	Lines      code.LineTable // source positions of the instructions
+	LocalNames []string       // indexed by the operand of OpGetLocal
+	FreeNames  []string       // indexed by the operand of OpGetFree
+
+	// register of each local, indexed like LocalNames, for functions
+	// compiled with registers
+	LocalRegisters []int
 }
 
 func (cf *CompiledFn) Type() ObjectType { return COMPILED_FUNCTION_OBJ }
@@ -132,20 +143,7 @@ type Array struct {
 }
 
 func (ao *Array) Type() ObjectType { return ARRAY_OBJ }
-func (ao *Array) Inspect() string {
-	var out bytes.Buffer
-
-	elements := []string{}
-	for _, e := range ao.Elements {
-		elements = append(elements, e.Inspect())
-	}
-
-	out.WriteString("[")
-	out.WriteString(strings.Join(elements, ", "))
-	out.WriteString("]")
-
-	return out.String()
-}
+func (ao *Array) Inspect() string  { return inspect(ao, nil) }
 
 type HashKey struct {
 	Type  ObjectType
@@ -177,32 +175,55 @@ type HashPair struct {
 	Value Object
 }
 
-type Hash struct {
-	/*
-	 * Why not Pairs map[HashKey]Object?
-	 * When printing to REPL using the
-	 * Inspect() method, we want to keep track of the objects that generated the
-	 * respective HashKeys.
-	 * Also necessary for implementing some sort of range function for Hashes,
-	 * which iterates over keys and values in the hash.
-	 */
-	Pairs map[HashKey]HashPair
-}
-
 func (h *Hash) Type() ObjectType { return HASH_OBJ }
 
-func (h *Hash) Inspect() string {
+func (h *Hash) Inspect() string { return inspect(h, nil) }
+
+// inspect formats obj. Arrays and hashes can contain themselves since they
+// are mutable, so the ones in active, which are being formatted, show as
+// [...] and {...} instead.
+func inspect(obj Object, active []Object) string {
 	var out bytes.Buffer
 
-	pairs := []string{}
-	for _, pair := range h.Pairs {
-		pairs = append(pairs, fmt.Sprintf("%s: %s", pair.Key.Inspect(), pair.Value.Inspect()))
+	switch obj := obj.(type) {
+	case *Array:
+		for _, a := range active {
+			if a == obj {
+				return "[...]"
+			}
+		}
+		active = append(active, obj)
+
+		elements := []string{}
+		for _, e := range obj.Elements {
+			elements = append(elements, inspect(e, active))
+		}
+
+		out.WriteString("[")
+		out.WriteString(strings.Join(elements, ", "))
+		out.WriteString("]")
+
+	case *Hash:
+		for _, a := range active {
+			if a == obj {
+				return "{...}"
+			}
+		}
+		active = append(active, obj)
+
+		pairs := []string{}
+		for _, pair := range obj.Pairs() {
+			pairs = append(pairs, fmt.Sprintf("%s: %s", inspect(pair.Key, active), inspect(pair.Value, active)))
+		}
+
+		out.WriteString("{")
+		out.WriteString(strings.Join(pairs, ", "))
+		out.WriteString("}")
+
+	default:
+		return obj.Inspect()
 	}
 
-	out.WriteString("{")
-	out.WriteString(strings.Join(pairs, ", "))
-	out.WriteString("}")
-
 	return out.String()
 }
 
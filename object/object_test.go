@@ -2,6 +2,31 @@ package object
 
 import "testing"
 
+func TestInspectCycles(t *testing.T) {
+	array := &Array{Elements: []Object{&Integer{Value: 1}}}
+	array.Elements = append(array.Elements, array)
+
+	hash := &Hash{}
+	key := &String{Value: "self"}
+	hash.Set(key.HashKey(), HashPair{Key: key, Value: hash})
+
+	tests := []struct {
+		obj      Object
+		expected string
+	}{
+		{array, "[1, [...]]"},
+		{hash, "{self: {...}}"},
+		{&Array{Elements: []Object{array, array}}, "[[1, [...]], [1, [...]]]"},
+		{&Array{Elements: []Object{hash}}, "[{self: {...}}]"},
+	}
+
+	for _, tt := range tests {
+		if got := tt.obj.Inspect(); got != tt.expected {
+			t.Errorf("wrong Inspect. want=%q, got=%q", tt.expected, got)
+		}
+	}
+}
+
 func TestStringHashKey(t *testing.T) {
 	hello1 := &String{Value: "Hello World"}
 	hello2 := &String{Value: "Hello World"}
@@ -2,6 +2,7 @@ package parser
 
 import (
 	"fmt"
+	"math/big"
 	"monc/ast"
 	"monc/lexer"
 	"monc/token"
@@ -114,7 +115,7 @@ func (p *Parser) parseMacroLiteral() ast.Expression {
 	if !p.expectPeek(token.LPAREN) {
 		return nil
 	}
-	ml.Parameters = p.parseFunctionParameters()
+	ml.Parameters, _ = p.parseFunctionParameters()
 
 	if !p.expectPeek(token.LBRACE) {
 		return nil
@@ -127,7 +128,7 @@ func (p *Parser) parseMacroLiteral() ast.Expression {
 
 func (p *Parser) parseHashLiteral() ast.Expression {
 	hash := &ast.HashLiteral{Token: p.curToken}
-	hash.Pairs = make(map[ast.Expression]ast.Expression)
+	hash.Pairs = []ast.HashPair{}
 
 	for !p.peekTokenIs(token.RBRACE) {
 		p.nextToken()
@@ -140,7 +141,7 @@ func (p *Parser) parseHashLiteral() ast.Expression {
 		p.nextToken()
 		value := p.parseExpression(LOWEST)
 
-		hash.Pairs[key] = value
+		hash.Pairs = append(hash.Pairs, ast.HashPair{Key: key, Value: value})
 
 		if !p.peekTokenIs(token.RBRACE) && !p.expectPeek(token.COMMA) {
 			return nil
@@ -215,7 +216,13 @@ func (p *Parser) parseFunctionLiteral() ast.Expression {
 	if !p.expectPeek(token.LPAREN) {
 		return nil
 	}
-	lit.Parameters = p.parseFunctionParameters()
+	lit.Parameters, lit.ParameterTypes = p.parseFunctionParameters()
+
+	if p.peekTokenIs(token.ARROW) {
+		p.nextToken()
+		p.nextToken()
+		lit.ReturnType = p.parseType()
+	}
 
 	if !p.expectPeek(token.LBRACE) {
 		return nil
@@ -226,31 +233,119 @@ func (p *Parser) parseFunctionLiteral() ast.Expression {
 	return lit
 }
 
-func (p *Parser) parseFunctionParameters() []*ast.Identifier {
+// parseFunctionParameters returns the parameters and their optional type
+// annotations. The types slice is empty when no parameter is annotated.
+func (p *Parser) parseFunctionParameters() ([]*ast.Identifier, []ast.TypeExpression) {
 	idents := []*ast.Identifier{}
+	types := []ast.TypeExpression{}
+	annotated := false
 
 	if p.peekTokenIs(token.RPAREN) {
 		p.nextToken()
-		return idents
+		return idents, nil
 	}
 
-	p.nextToken()
+	parseParameter := func() {
+		p.nextToken()
+		ident := &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
+		idents = append(idents, ident)
 
-	ident := &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
-	idents = append(idents, ident)
+		var typ ast.TypeExpression
+		if p.peekTokenIs(token.COLON) {
+			p.nextToken()
+			p.nextToken()
+			typ = p.parseType()
+			annotated = true
+		}
+		types = append(types, typ)
+	}
+
+	parseParameter()
 
 	for p.peekTokenIs(token.COMMA) {
 		p.nextToken()
-		p.nextToken()
-		ident := &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
-		idents = append(idents, ident)
+		parseParameter()
 	}
 
 	if !p.expectPeek(token.RPAREN) {
-		return nil
+		return nil, nil
+	}
+
+	if !annotated {
+		return idents, nil
 	}
 
-	return idents
+	return idents, types
+}
+
+// parseType parses a type annotation starting at the current token
+func (p *Parser) parseType() ast.TypeExpression {
+	switch p.curToken.Type {
+	case token.IDENT:
+		return &ast.NamedType{Token: p.curToken, Name: p.curToken.Literal}
+
+	case token.LBRACKET:
+		array := &ast.ArrayType{Token: p.curToken}
+		p.nextToken()
+		if array.Element = p.parseType(); array.Element == nil {
+			return nil
+		}
+		if !p.expectPeek(token.RBRACKET) {
+			return nil
+		}
+		return array
+
+	case token.LBRACE:
+		hash := &ast.HashType{Token: p.curToken}
+		p.nextToken()
+		if hash.Key = p.parseType(); hash.Key == nil {
+			return nil
+		}
+		if !p.expectPeek(token.COLON) {
+			return nil
+		}
+		p.nextToken()
+		if hash.Value = p.parseType(); hash.Value == nil {
+			return nil
+		}
+		if !p.expectPeek(token.RBRACE) {
+			return nil
+		}
+		return hash
+
+	case token.FUNCTION:
+		fn := &ast.FunctionType{Token: p.curToken, Parameters: []ast.TypeExpression{}}
+		if !p.expectPeek(token.LPAREN) {
+			return nil
+		}
+
+		for !p.peekTokenIs(token.RPAREN) {
+			p.nextToken()
+			param := p.parseType()
+			if param == nil {
+				return nil
+			}
+			fn.Parameters = append(fn.Parameters, param)
+
+			if !p.peekTokenIs(token.RPAREN) && !p.expectPeek(token.COMMA) {
+				return nil
+			}
+		}
+		p.nextToken()
+
+		if p.peekTokenIs(token.ARROW) {
+			p.nextToken()
+			p.nextToken()
+			if fn.Return = p.parseType(); fn.Return == nil {
+				return nil
+			}
+		}
+		return fn
+	}
+
+	msg := fmt.Sprintf("expected a type, got '%s' instead.", p.curToken.Type)
+	p.errors = append(p.errors, msg)
+	return nil
 }
 
 func (p *Parser) parseIfExp() ast.Expression {
@@ -359,14 +454,20 @@ func (p *Parser) parseIntegerLiteral() ast.Expression {
 	lit := &ast.IntegerLiteral{Token: p.curToken}
 
 	value, err := strconv.ParseInt(p.curToken.Literal, 0, 64)
-	if err != nil {
-		msg := fmt.Sprintf("could not parse %q as integer", p.curToken.Literal)
-		p.errors = append(p.errors, msg)
-		return nil
+	if err == nil {
+		lit.Value = value
+		return lit
 	}
 
-	lit.Value = value
-	return lit
+	// literals too large for int64 are arbitrary-precision integers
+	if n, ok := new(big.Int).SetString(p.curToken.Literal, 0); ok {
+		lit.Big = n
+		return lit
+	}
+
+	msg := fmt.Sprintf("could not parse %q as integer", p.curToken.Literal)
+	p.errors = append(p.errors, msg)
+	return nil
 }
 
 func (p *Parser) Errors() []string {
@@ -445,6 +546,12 @@ func (p *Parser) parseLetStatement() *ast.LetStatement {
 
 	stmt.Name = &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
 
+	if p.peekTokenIs(token.COLON) {
+		p.nextToken()
+		p.nextToken()
+		stmt.Type = p.parseType()
+	}
+
 	if !p.expectPeek(token.ASSIGN) {
 		return nil
 	}
@@ -479,13 +586,20 @@ func (p *Parser) parseReturnStatement() *ast.ReturnStatement {
 	return stmt
 }
 
-func (p *Parser) parseExpressionStatement() *ast.ExpressionStatement {
+func (p *Parser) parseExpressionStatement() ast.Statement {
 	// defer untrace(trace("parseExpressionStatement"))
 
 	stmt := &ast.ExpressionStatement{Token: p.curToken}
 
 	stmt.Expression = p.parseExpression(LOWEST)
 
+	if p.peekTokenIs(token.ASSIGN) {
+		switch stmt.Expression.(type) {
+		case *ast.Identifier, *ast.IndexExpression:
+			return p.parseAssignStatement(stmt.Token, stmt.Expression)
+		}
+	}
+
 	if p.peekTokenIs(token.SEMICOLON) {
 		p.nextToken()
 	}
@@ -494,6 +608,31 @@ func (p *Parser) parseExpressionStatement() *ast.ExpressionStatement {
 
 }
 
+// parseAssignStatement parses the rest of `left[index] = value;` after its
+// target, which starts at start
+func (p *Parser) parseAssignStatement(start token.Token, target ast.Expression) ast.Statement {
+	p.nextToken()
+	stmt := &ast.AssignStatement{Token: p.curToken}
+
+	index, ok := target.(*ast.IndexExpression)
+	if !ok {
+		msg := fmt.Sprintf("%d:%d: cannot assign to %s, only to an element like a[i]",
+			start.Line, start.Column, target)
+		p.errors = append(p.errors, msg)
+		return nil
+	}
+	stmt.Target = index
+
+	p.nextToken()
+	stmt.Value = p.parseExpression(LOWEST)
+
+	if p.peekTokenIs(token.SEMICOLON) {
+		p.nextToken()
+	}
+
+	return stmt
+}
+
 func (p *Parser) expectPeek(t token.TokenType) bool {
 	if p.peekTokenIs(t) {
 		p.nextToken()
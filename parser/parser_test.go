@@ -173,6 +173,26 @@ func TestIntegerLiteralExpression(t *testing.T) {
 
 }
 
+func TestBigIntegerLiteralExpression(t *testing.T) {
+	input := "18446744073709551616;"
+
+	p := New(lexer.New(input))
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	stmt := program.Statements[0].(*ast.ExpressionStatement)
+	literal, ok := stmt.Expression.(*ast.IntegerLiteral)
+	if !ok {
+		t.Fatalf("exp not *ast.IntegerLiteral. got=%T", stmt.Expression)
+	}
+	if literal.Big == nil || literal.Big.String() != "18446744073709551616" {
+		t.Errorf("literal.Big not 18446744073709551616. got=%v", literal.Big)
+	}
+	if literal.String() != "18446744073709551616" {
+		t.Errorf("literal.String not 18446744073709551616. got=%s", literal.String())
+	}
+}
+
 func TestParsingPrefixExpressions(t *testing.T) {
 	prefixTests := []struct {
 		input    string
@@ -870,6 +890,68 @@ func TestParsingIndexExpressions(t *testing.T) {
 	}
 }
 
+func TestAssignStatements(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"a[0] = 1;", "a[0] = 1;"},
+		{`h["k"] = h["k"] + 1`, "h[k] = ((h[k]) + 1);"},
+		{"f()[i + 1] = x * 2;", "f()[(i + 1)] = (x * 2);"},
+		{"a[0][1] = a", "(a[0])[1] = a;"},
+	}
+
+	for _, tt := range tests {
+		p := New(lexer.New(tt.input))
+		prog := p.ParseProgram()
+		checkParserErrors(t, p)
+
+		if len(prog.Statements) != 1 {
+			t.Fatalf("%q: wrong number of statements. got=%d", tt.input, len(prog.Statements))
+		}
+		stmt, ok := prog.Statements[0].(*ast.AssignStatement)
+		if !ok {
+			t.Fatalf("%q: statement not *ast.AssignStatement. got=%T", tt.input, prog.Statements[0])
+		}
+		if stmt.String() != tt.expected {
+			t.Errorf("%q: wrong statement. want=%q, got=%q", tt.input, tt.expected, stmt.String())
+		}
+	}
+}
+
+func TestAssignStatementErrors(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected []string
+	}{
+		{"a = 1;", []string{"1:1: cannot assign to a, only to an element like a[i]"}},
+		{"let b = 2;\n  b = 1;", []string{"2:3: cannot assign to b, only to an element like a[i]"}},
+		// other expressions and malformed lets are not assignments
+		{"f() = 1;", []string{"no prefix parse function for = found"}},
+		{"let 5 = 1;", []string{
+			"expected next token to be 'IDENT' got 'INT' instead.",
+			"no prefix parse function for = found",
+		}},
+		{"a[0] = ;", []string{"no prefix parse function for ; found"}},
+	}
+
+	for _, tt := range tests {
+		p := New(lexer.New(tt.input))
+		p.ParseProgram()
+
+		errors := p.Errors()
+		if len(errors) != len(tt.expected) {
+			t.Errorf("%q: wrong errors. want=%q, got=%q", tt.input, tt.expected, errors)
+			continue
+		}
+		for i, msg := range errors {
+			if msg != tt.expected[i] {
+				t.Errorf("%q: wrong error. want=%q, got=%q", tt.input, tt.expected[i], msg)
+			}
+		}
+	}
+}
+
 func TestParsingHashLiteralStringKeys(t *testing.T) {
 	source := `{"one":1, "two":2, "three":3}`
 
@@ -895,7 +977,8 @@ func TestParsingHashLiteralStringKeys(t *testing.T) {
 		"three": 3,
 	}
 
-	for key, value := range hash.Pairs {
+	for _, pair := range hash.Pairs {
+		key, value := pair.Key, pair.Value
 		literal, ok := key.(*ast.StringLiteral)
 		if !ok {
 			t.Errorf("key is not ast.StringLiteral. got=%T", key)
@@ -903,6 +986,11 @@ func TestParsingHashLiteralStringKeys(t *testing.T) {
 		expectedValue := expected[literal.String()]
 		testIntegerLiteral(t, value, expectedValue)
 	}
+
+	// the pairs are in source order
+	if s := hash.String(); s != "{one:1, two:2, three:3}" {
+		t.Errorf("hash.String() wrong. got=%q", s)
+	}
 }
 
 func TestParsingEmptyHashLiteral(t *testing.T) {
@@ -947,7 +1035,8 @@ func TestParsingHashLiteralsBooleanKeys(t *testing.T) {
 		t.Errorf("hash.Pairs has wrong length. got=%d", len(hash.Pairs))
 	}
 
-	for key, value := range hash.Pairs {
+	for _, pair := range hash.Pairs {
+		key, value := pair.Key, pair.Value
 		boolean, ok := key.(*ast.Boolean)
 		if !ok {
 			t.Errorf("key is not ast.BooleanLiteral. got=%T", key)
@@ -983,7 +1072,8 @@ func TestParsingHashLiteralsIntegerKeys(t *testing.T) {
 		t.Errorf("hash.Pairs has wrong length. got=%d", len(hash.Pairs))
 	}
 
-	for key, value := range hash.Pairs {
+	for _, pair := range hash.Pairs {
+		key, value := pair.Key, pair.Value
 		integer, ok := key.(*ast.IntegerLiteral)
 		if !ok {
 			t.Errorf("key is not ast.IntegerLiteral. got=%T", key)
@@ -1026,7 +1116,8 @@ func TestParsingHashLiteralsWithExpressions(t *testing.T) {
 		},
 	}
 
-	for key, value := range hash.Pairs {
+	for _, pair := range hash.Pairs {
+		key, value := pair.Key, pair.Value
 		literal, ok := key.(*ast.StringLiteral)
 		if !ok {
 			t.Errorf("key is not ast.StringLiteral. got=%T", key)
@@ -1084,3 +1175,47 @@ func TestMacroLiteralParsing(t *testing.T) {
 
 	testInfixExpression(t, bodyStmt.Expression, "x", "+", "y")
 }
+
+func TestTypeAnnotationParsing(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"let x: int = 5;", "let x: int = 5;"},
+		{"let xs: [int] = [1];", "let xs: [int] = [1];"},
+		{`let h: {string: [bool]} = {};`, "let h: {string: [bool]} = {};"},
+		{"let f: fn(int, string) -> bool = g;", "let f: fn(int, string) -> bool = g;"},
+		{"let f: fn() = g;", "let f: fn() = g;"},
+		{"fn(a: string, b: [int]) -> bool { true }", "fn(a: string, b: [int]) -> bool true"},
+		{"fn(a, b: int) { a }", "fn(a, b: int) a"},
+		{"fn(a, b) -> int { a }", "fn(a, b) -> int a"},
+	}
+
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := New(l)
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+
+		if actual := program.String(); actual != tt.expected {
+			t.Errorf("expected=%q, got=%q", tt.expected, actual)
+		}
+	}
+}
+
+func TestTypeAnnotationErrors(t *testing.T) {
+	tests := []string{
+		"let x: = 5;",
+		"let xs: [int = [1];",
+		"fn(a: ) { a }",
+	}
+
+	for _, input := range tests {
+		p := New(lexer.New(input))
+		p.ParseProgram()
+
+		if len(p.Errors()) == 0 {
+			t.Errorf("expected parser errors for %q", input)
+		}
+	}
+}
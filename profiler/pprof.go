@@ -0,0 +1,189 @@
+package profiler
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"sort"
+)
+
+/*
+WritePprof writes the samples as a gzipped profile in the protocol buffer
+format of pprof, for `go tool pprof`. Each sample holds a count and the
+time since the previous one; its locations are the lines of the Monkey
+functions on the stack, with source the file name of the program. pprof
+takes names in angle brackets for C++ templates, so the main program is
+called main and anonymous functions by their line, like anonymous@3.
+
+Only the fields of profile.proto used here are encoded, by hand, so the
+module needs no dependencies.
+*/
+func (p *Profiler) WritePprof(w io.Writer, source string) error {
+	e := &encoder{strings: map[string]int64{"": 0}, table: []string{""}}
+
+	samples := make([]*sample, 0, len(p.samples))
+	for _, s := range p.samples {
+		samples = append(samples, s)
+	}
+	sort.Slice(samples, func(i, j int) bool { return samples[i].count > samples[j].count })
+
+	// ids of the functions and locations, from 1
+	functionIDs := map[*FunctionStats]uint64{}
+	var functions []*FunctionStats
+	locationIDs := map[location]uint64{}
+	var locations []location
+
+	var body message
+	for _, s := range samples {
+		ids := make([]uint64, len(s.stack))
+		for i, loc := range s.stack {
+			id, ok := locationIDs[loc]
+			if !ok {
+				id = uint64(len(locations) + 1)
+				locationIDs[loc] = id
+				locations = append(locations, loc)
+			}
+			ids[i] = id
+		}
+
+		var m message
+		m.packed(1, ids)
+		m.packed(2, []uint64{uint64(s.count), uint64(s.time)})
+		body.bytes(2, m) // sample
+	}
+
+	for i, loc := range locations {
+		stats := p.stats(loc.fn)
+		fid, ok := functionIDs[stats]
+		if !ok {
+			fid = uint64(len(functions) + 1)
+			functionIDs[stats] = fid
+			functions = append(functions, stats)
+		}
+
+		var line message
+		line.uint(1, fid)
+		line.uint(2, uint64(loc.line))
+
+		var m message
+		m.uint(1, uint64(i+1))
+		m.uint(2, 1) // the mapping
+		m.bytes(4, line)
+		body.bytes(4, m) // location
+	}
+
+	for i, f := range functions {
+		name := pprofName(f)
+
+		var m message
+		m.uint(1, uint64(i+1))
+		m.uint(2, uint64(e.string(name)))
+		m.uint(3, uint64(e.string(name)))
+		m.uint(4, uint64(e.string(source)))
+		m.uint(5, uint64(f.Line))
+		body.bytes(5, m) // function
+	}
+
+	// one mapping for the program, which pprof requires to find the
+	// functions
+	var mapping message
+	mapping.uint(1, 1)
+	mapping.uint(5, uint64(e.string(source)))
+	mapping.uint(7, 1) // has_functions
+
+	var profile message
+	profile.bytes(1, e.valueType("samples", "count"))
+	profile.bytes(1, e.valueType("time", "nanoseconds"))
+	profile = append(profile, body...)
+	profile.bytes(3, mapping)
+	profile.uint(9, uint64(p.start.UnixNano()))
+	profile.uint(10, uint64(p.duration))
+	profile.bytes(11, e.valueType("instructions", "count"))
+	profile.uint(12, uint64(p.Interval))
+	for _, s := range e.table {
+		profile.bytes(6, message(s))
+	}
+
+	gz := gzip.NewWriter(w)
+	if _, err := gz.Write(profile); err != nil {
+		return err
+	}
+	return gz.Close()
+}
+
+func pprofName(f *FunctionStats) string {
+	switch {
+	case f.fn == nil || f.Name == "<main>":
+		return "main"
+	case f.Name == "<anonymous>":
+		return fmt.Sprintf("anonymous@%d", f.Line)
+	}
+	return f.Name
+}
+
+// encoder holds the string table of a profile
+type encoder struct {
+	strings map[string]int64
+	table   []string
+}
+
+func (e *encoder) string(s string) int64 {
+	i, ok := e.strings[s]
+	if !ok {
+		i = int64(len(e.table))
+		e.strings[s] = i
+		e.table = append(e.table, s)
+	}
+	return i
+}
+
+func (e *encoder) valueType(typ, unit string) message {
+	var m message
+	m.uint(1, uint64(e.string(typ)))
+	m.uint(2, uint64(e.string(unit)))
+	return m
+}
+
+// message is an encoded protocol buffer message
+type message []byte
+
+// wire types
+const (
+	wireVarint = 0
+	wireBytes  = 2
+)
+
+func (m *message) varint(x uint64) {
+	for x >= 0x80 {
+		*m = append(*m, byte(x)|0x80)
+		x >>= 7
+	}
+	*m = append(*m, byte(x))
+}
+
+func (m *message) tag(field, wire int) {
+	m.varint(uint64(field)<<3 | uint64(wire))
+}
+
+// uint encodes an integer field, which is omitted if it is 0
+func (m *message) uint(field int, x uint64) {
+	if x == 0 {
+		return
+	}
+	m.tag(field, wireVarint)
+	m.varint(x)
+}
+
+func (m *message) bytes(field int, b []byte) {
+	m.tag(field, wireBytes)
+	m.varint(uint64(len(b)))
+	*m = append(*m, b...)
+}
+
+func (m *message) packed(field int, xs []uint64) {
+	var b message
+	for _, x := range xs {
+		b.varint(x)
+	}
+	m.bytes(field, b)
+}
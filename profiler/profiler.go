@@ -0,0 +1,256 @@
+// Package profiler profiles Monkey programs running on the VM, by the
+// functions and lines of the program rather than those of the VM
+package profiler
+
+import (
+	"fmt"
+	"io"
+	"monc/code"
+	"monc/object"
+	"monc/vm"
+	"sort"
+	"strings"
+	"time"
+)
+
+// DefaultInterval is the number of instructions between samples
+const DefaultInterval = 100
+
+/*
+Profiler is a vm.Hook that instruments calls and samples the stack. It
+counts the calls of each function and measures their time: self time is
+spent in the function itself, cumulative time includes its callees and
+counts recursive calls once. It also counts the instructions executed by
+opcode, and every Interval instructions records the Monkey stack for the
+pprof profile, with the time since the previous sample.
+
+Times include the overhead of the hook, which runs before every
+instruction, so they are best compared with each other.
+*/
+type Profiler struct {
+	Interval int
+
+	main      *object.CompiledFn
+	functions map[*object.CompiledFn]*FunctionStats
+	opcodes   map[code.Opcode]int64
+
+	stack  []activation
+	active map[*object.CompiledFn]int // activations of each function on the stack
+
+	instructions int64
+	samples      map[string]*sample // by the key of their stack
+	lastSample   time.Time
+
+	start    time.Time
+	duration time.Duration
+}
+
+// FunctionStats is the profile of one function
+type FunctionStats struct {
+	Name       string // "<main>" for the main program, "<anonymous>" if unnamed
+	Line       int    // the first line of its code, 0 if unknown
+	Calls      int64
+	Self       time.Duration
+	Cumulative time.Duration
+
+	fn *object.CompiledFn
+}
+
+// OpcodeCount is the number of instructions executed with one opcode
+type OpcodeCount struct {
+	Op    code.Opcode
+	Count int64
+}
+
+type activation struct {
+	fn       *object.CompiledFn
+	start    time.Time
+	children time.Duration // the time of the calls it made
+}
+
+// a location of the pprof profile: a line of a function
+type location struct {
+	fn   *object.CompiledFn
+	line int
+}
+
+// sample is the samples of one stack, innermost location first
+type sample struct {
+	stack []location
+	count int64
+	time  time.Duration
+}
+
+func New() *Profiler {
+	return &Profiler{
+		Interval:  DefaultInterval,
+		functions: map[*object.CompiledFn]*FunctionStats{},
+		opcodes:   map[code.Opcode]int64{},
+		active:    map[*object.CompiledFn]int{},
+		samples:   map[string]*sample{},
+	}
+}
+
+func (p *Profiler) Instruction(machine *vm.VM) error {
+	if p.start.IsZero() {
+		p.start = time.Now()
+		p.lastSample = p.start
+		p.main = machine.Function(0)
+		p.enter(p.main, p.start)
+	}
+
+	p.opcodes[machine.Op()]++
+	p.instructions++
+	if p.Interval > 0 && p.instructions%int64(p.Interval) == 0 {
+		p.sample(machine)
+	}
+	return nil
+}
+
+func (p *Profiler) Call(machine *vm.VM) error {
+	p.enter(machine.Function(0), time.Now())
+	return nil
+}
+
+func (p *Profiler) Return(machine *vm.VM) error {
+	p.leave(time.Now())
+	return nil
+}
+
+// Stop ends the profile after the program has run. Functions still active,
+// like the main program or those a runtime error occurred in, return now.
+func (p *Profiler) Stop() {
+	now := time.Now()
+	for len(p.stack) > 0 {
+		p.leave(now)
+	}
+	if !p.start.IsZero() {
+		p.duration = now.Sub(p.start)
+	}
+}
+
+func (p *Profiler) enter(fn *object.CompiledFn, now time.Time) {
+	stats := p.stats(fn)
+	stats.Calls++
+
+	p.active[fn]++
+	p.stack = append(p.stack, activation{fn: fn, start: now})
+}
+
+func (p *Profiler) leave(now time.Time) {
+	a := p.stack[len(p.stack)-1]
+	p.stack = p.stack[:len(p.stack)-1]
+
+	elapsed := now.Sub(a.start)
+	stats := p.functions[a.fn]
+	stats.Self += elapsed - a.children
+
+	p.active[a.fn]--
+	if p.active[a.fn] == 0 {
+		stats.Cumulative += elapsed
+	}
+
+	if len(p.stack) > 0 {
+		p.stack[len(p.stack)-1].children += elapsed
+	}
+}
+
+func (p *Profiler) stats(fn *object.CompiledFn) *FunctionStats {
+	stats, ok := p.functions[fn]
+	if !ok {
+		stats = &FunctionStats{Name: fn.Name, fn: fn}
+		switch {
+		case fn == p.main:
+			stats.Name = "<main>"
+		case stats.Name == "":
+			stats.Name = "<anonymous>"
+		}
+		if len(fn.Lines) > 0 {
+			stats.Line = fn.Lines[0].Line
+		}
+		p.functions[fn] = stats
+	}
+	return stats
+}
+
+// sample records the stack of machine
+func (p *Profiler) sample(machine *vm.VM) {
+	now := time.Now()
+
+	stack := make([]location, machine.Depth())
+	var key strings.Builder
+	for n := range stack {
+		stack[n] = location{fn: machine.Function(n), line: machine.StackFrame(n).Line}
+		fmt.Fprintf(&key, "%p:%d;", stack[n].fn, stack[n].line)
+	}
+
+	s, ok := p.samples[key.String()]
+	if !ok {
+		s = &sample{stack: stack}
+		p.samples[key.String()] = s
+	}
+	s.count++
+	s.time += now.Sub(p.lastSample)
+	p.lastSample = now
+}
+
+// Functions returns the profile of the functions that were called, by
+// decreasing self time
+func (p *Profiler) Functions() []FunctionStats {
+	functions := make([]FunctionStats, 0, len(p.functions))
+	for _, stats := range p.functions {
+		functions = append(functions, *stats)
+	}
+	sort.Slice(functions, func(i, j int) bool {
+		if functions[i].Self != functions[j].Self {
+			return functions[i].Self > functions[j].Self
+		}
+		return functions[i].Name < functions[j].Name
+	})
+	return functions
+}
+
+// Opcodes returns the number of instructions executed by opcode, most
+// frequent first
+func (p *Profiler) Opcodes() []OpcodeCount {
+	opcodes := make([]OpcodeCount, 0, len(p.opcodes))
+	for op, count := range p.opcodes {
+		opcodes = append(opcodes, OpcodeCount{op, count})
+	}
+	sort.Slice(opcodes, func(i, j int) bool {
+		if opcodes[i].Count != opcodes[j].Count {
+			return opcodes[i].Count > opcodes[j].Count
+		}
+		return opcodes[i].Op < opcodes[j].Op
+	})
+	return opcodes
+}
+
+// WriteReport writes the functions and opcodes as tables
+func (p *Profiler) WriteReport(w io.Writer) error {
+	fmt.Fprintf(w, "%8s %12s %12s  %s\n", "calls", "self", "cumulative", "function")
+	for _, f := range p.Functions() {
+		name := f.Name
+		if f.Line > 0 {
+			name = fmt.Sprintf("%s (line %d)", name, f.Line)
+		}
+		fmt.Fprintf(w, "%8d %12s %12s  %s\n", f.Calls,
+			f.Self.Round(time.Microsecond), f.Cumulative.Round(time.Microsecond), name)
+	}
+
+	fmt.Fprintf(w, "\n%12s  %s\n", "instructions", "opcode")
+	for _, o := range p.Opcodes() {
+		if _, err := fmt.Fprintf(w, "%12d  %s\n", o.Count, opName(o.Op)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func opName(op code.Opcode) string {
+	def, err := code.Lookup(byte(op))
+	if err != nil {
+		return fmt.Sprintf("Op(%d)", op)
+	}
+	return def.Name
+}
@@ -0,0 +1,157 @@
+package profiler
+
+import (
+	"bytes"
+	"monc/compiler"
+	"monc/lexer"
+	"monc/parser"
+	"monc/vm"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const program = `let fib = fn(n) {
+  if (n < 2) { return n; }
+  fib(n - 1) + fib(n - 2)
+};
+let twice = fn(f, x) { f(f(x)) };
+twice(fn(x) { x + 1 }, fib(10));`
+
+var backends = []struct {
+	name    string
+	options []compiler.Option
+}{
+	{"stack", nil},
+	{"registers", []compiler.Option{compiler.WithRegisters()}},
+}
+
+// profile runs source with a profiler sampling every interval instructions
+func profile(t *testing.T, source string, interval int, options []compiler.Option) *Profiler {
+	t.Helper()
+
+	p := parser.New(lexer.New(source))
+	comp := compiler.New(options...)
+	if err := comp.Compile(p.ParseProgram()); err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+
+	prof := New()
+	prof.Interval = interval
+	if err := vm.New(comp.Bytecode(), vm.WithHook(prof)).Run(); err != nil {
+		t.Fatalf("vm error: %s", err)
+	}
+	prof.Stop()
+	return prof
+}
+
+func TestFunctions(t *testing.T) {
+	for _, backend := range backends {
+		prof := profile(t, program, DefaultInterval, backend.options)
+
+		calls := map[string]int64{}
+		byName := map[string]FunctionStats{}
+		for _, f := range prof.Functions() {
+			calls[f.Name] = f.Calls
+			byName[f.Name] = f
+			if f.Self < 0 || f.Self > f.Cumulative {
+				t.Errorf("%s: %s: self time %s not within cumulative time %s",
+					backend.name, f.Name, f.Self, f.Cumulative)
+			}
+		}
+
+		want := map[string]int64{"<main>": 1, "fib": 177, "twice": 1, "<anonymous>": 2}
+		for name, n := range want {
+			if calls[name] != n {
+				t.Errorf("%s: wrong calls of %s. want=%d, got=%d", backend.name, name, n, calls[name])
+			}
+		}
+		if len(calls) != len(want) {
+			t.Errorf("%s: wrong functions. want=%v, got=%v", backend.name, want, calls)
+		}
+
+		if line := byName["fib"].Line; line != 2 {
+			t.Errorf("%s: wrong line of fib. want=2, got=%d", backend.name, line)
+		}
+		// recursive calls count once, so fib took no longer than the program
+		if main, fib := byName["<main>"], byName["fib"]; fib.Cumulative > main.Cumulative {
+			t.Errorf("%s: cumulative time of fib %s exceeds that of the program %s",
+				backend.name, fib.Cumulative, main.Cumulative)
+		}
+	}
+}
+
+func TestOpcodesAndSamples(t *testing.T) {
+	for _, backend := range backends {
+		prof := profile(t, program, 7, backend.options)
+
+		var instructions int64
+		opcodes := prof.Opcodes()
+		for i, o := range opcodes {
+			instructions += o.Count
+			if i > 0 && o.Count > opcodes[i-1].Count {
+				t.Errorf("%s: opcodes not sorted by count: %v", backend.name, opcodes)
+			}
+		}
+		if instructions != prof.instructions {
+			t.Errorf("%s: wrong instructions by opcode. want=%d, got=%d",
+				backend.name, prof.instructions, instructions)
+		}
+
+		var samples int64
+		for _, s := range prof.samples {
+			samples += s.count
+			if len(s.stack) == 0 || s.stack[len(s.stack)-1].fn != prof.main {
+				t.Errorf("%s: sample does not end in the main program", backend.name)
+			}
+		}
+		if samples != instructions/7 {
+			t.Errorf("%s: wrong samples. want=%d, got=%d", backend.name, instructions/7, samples)
+		}
+	}
+}
+
+func TestWriteReport(t *testing.T) {
+	prof := profile(t, program, DefaultInterval, nil)
+
+	var out bytes.Buffer
+	if err := prof.WriteReport(&out); err != nil {
+		t.Fatalf("report failed: %s", err)
+	}
+
+	for _, want := range []string{"calls", "cumulative", "fib (line 2)", "<main> (line 1)", "OpCall"} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("report does not contain %q. got=%q", want, out.String())
+		}
+	}
+}
+
+func TestWritePprof(t *testing.T) {
+	prof := profile(t, program, 1, nil)
+
+	path := filepath.Join(t.TempDir(), "prof.pb.gz")
+	var out bytes.Buffer
+	if err := prof.WritePprof(&out, "prog.mk"); err != nil {
+		t.Fatalf("writing profile failed: %s", err)
+	}
+	if err := os.WriteFile(path, out.Bytes(), 0o644); err != nil {
+		t.Fatalf("write failed: %s", err)
+	}
+
+	goTool, err := exec.LookPath("go")
+	if err != nil {
+		t.Skip("go not found to read the profile")
+	}
+	raw, err := exec.Command(goTool, "tool", "pprof", "-raw", path).CombinedOutput()
+	if err != nil {
+		t.Fatalf("pprof failed: %s\n%s", err, raw)
+	}
+
+	for _, want := range []string{"samples/count time/nanoseconds", "fib prog.mk:3", "main prog.mk:6", "anonymous@6"} {
+		if !strings.Contains(string(raw), want) {
+			t.Errorf("pprof output does not contain %q. got=%s", want, raw)
+		}
+	}
+}
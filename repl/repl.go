@@ -8,6 +8,7 @@ import (
 	"monc/lexer"
 	"monc/object"
 	"monc/parser"
+	"monc/types"
 	"monc/vm"
 )
 
@@ -17,11 +18,12 @@ func Start(in io.Reader, out io.Writer) {
 	scanner := bufio.NewScanner(in)
 
 	constants := []object.Object{}
-	globals := make([]object.Object, vm.GlobalSize)
+	globals := make([]vm.Value, vm.DefaultGlobalSize)
 	symbolTable := compiler.NewSymbolTable()
 	for i, v := range object.Builtins {
 		symbolTable.DefineBuiltin(i, v.Name)
 	}
+	checker := types.NewChecker()
 
 	for {
 		fmt.Fprintf(out, PROMPT)
@@ -40,12 +42,18 @@ func Start(in io.Reader, out io.Writer) {
 			continue
 		}
 
+		if errs := checker.Check(program); len(errs) != 0 {
+			printTypeErrors(out, errs)
+			continue
+		}
+
 		comp := compiler.NewWithState(symbolTable, constants)
 		err := comp.Compile(program)
 		if err != nil {
 			fmt.Fprintf(out, "Woops! Compilation failed:\n %s\n", err)
 			continue
 		}
+		printDiagnostics(out, comp.Diagnostics())
 
 		code := comp.Bytecode()
 		constants = code.Constants
@@ -54,6 +62,11 @@ func Start(in io.Reader, out io.Writer) {
 		err = machine.Run()
 		if err != nil {
 			fmt.Fprintf(out, "Woops! Execution failed:\n %s\n", err)
+			if runtimeErr, ok := err.(*vm.RuntimeError); ok {
+				for _, line := range runtimeErr.Trace.Lines() {
+					fmt.Fprintf(out, "\t%s\n", line)
+				}
+			}
 			continue
 		}
 
@@ -69,3 +82,19 @@ func printParserErrors(out io.Writer, errors []string) {
 	}
 
 }
+
+func printTypeErrors(out io.Writer, errors []*types.Error) {
+	for _, err := range errors {
+		io.WriteString(out, "\t\x1b[31m"+err.Error()+"\x1b[0m\n")
+	}
+}
+
+func printDiagnostics(out io.Writer, diagnostics []compiler.Diagnostic) {
+	for _, d := range diagnostics {
+		color := "\x1b[33m"
+		if d.Severity == compiler.SeverityError {
+			color = "\x1b[31m"
+		}
+		io.WriteString(out, "\t"+color+d.String()+"\x1b[0m\n")
+	}
+}
@@ -0,0 +1,272 @@
+/*
+Package rt is the runtime of the Go programs package gogen generates. It
+implements the operators, literals and calls of Monkey on object.Object the
+way package evaluator does, so a generated program computes what
+evaluator.Eval computes for its source.
+
+Errors abort the program like in the evaluator: operations panic with the
+error and Run recovers it.
+*/
+package rt
+
+import (
+	"fmt"
+	"math/big"
+	"monc/object"
+	"os"
+)
+
+var (
+	True  = &object.Boolean{Value: true}
+	False = &object.Boolean{Value: false}
+	Null  = &object.Null{}
+)
+
+// Function is a Monkey function literal compiled to Go
+type Function struct {
+	Source     string // what Inspect prints, the evaluator's format
+	Parameters int
+	Fn         func(args []object.Object) object.Object
+}
+
+func (f *Function) Type() object.ObjectType { return object.FUNCTION_OBJ }
+func (f *Function) Inspect() string         { return f.Source }
+
+// failure is the panic value of a runtime error
+type failure struct {
+	err *object.Error
+}
+
+func fail(format string, a ...interface{}) {
+	panic(failure{&object.Error{Message: fmt.Sprintf(format, a...)}})
+}
+
+// Run runs program and returns its value, or the error that ended it
+func Run(program func() object.Object) (result object.Object) {
+	defer func() {
+		if r := recover(); r != nil {
+			f, ok := r.(failure)
+			if !ok {
+				panic(r)
+			}
+			result = f.err
+		}
+	}()
+
+	return program()
+}
+
+// Main runs program as the main function of a generated program, exiting
+// with 1 on errors like `monc run`
+func Main(program func() object.Object) {
+	if err, ok := Run(program).(*object.Error); ok {
+		fmt.Fprintf(os.Stderr, "runtime error: %s\n", err.Message)
+		os.Exit(1)
+	}
+}
+
+func Int(value int64) object.Object  { return &object.Integer{Value: value} }
+func Str(value string) object.Object { return &object.String{Value: value} }
+
+// BigInt is an integer literal too large for Int, in decimal
+func BigInt(digits string) object.Object {
+	n, ok := new(big.Int).SetString(digits, 10)
+	if !ok {
+		panic("rt: invalid integer " + digits)
+	}
+	return object.NewBigInt(n)
+}
+
+func Bool(value bool) object.Object {
+	if value {
+		return True
+	}
+	return False
+}
+
+func Truthy(o object.Object) bool {
+	switch o {
+	case Null, False:
+		return false
+	}
+	return true
+}
+
+// Get returns the first of values that is set, the variables of name from
+// the innermost function out, possibly followed by a builtin. A variable is
+// nil until its `let` statement has run.
+func Get(name string, values ...object.Object) object.Object {
+	for _, value := range values {
+		if value != nil {
+			return value
+		}
+	}
+	return Undefined(name)
+}
+
+// Undefined fails for a name that is neither a variable nor a builtin
+func Undefined(name string) object.Object {
+	fail("identifier not found: %s", name)
+	return nil
+}
+
+func Builtin(name string) object.Object {
+	return object.GetBuiltinByName(name)
+}
+
+func Array(elements ...object.Object) object.Object {
+	return &object.Array{Elements: elements}
+}
+
+// Hash builds a hash from its keys and values in alternation
+func Hash(pairs ...object.Object) object.Object {
+	hash := object.NewHash(len(pairs) / 2)
+
+	for i := 0; i < len(pairs); i += 2 {
+		key, ok := pairs[i].(object.Hashable)
+		if !ok {
+			fail("unusable as hash key: %s", pairs[i].Type())
+		}
+		hash.Set(key.HashKey(), object.HashPair{Key: pairs[i], Value: pairs[i+1]})
+	}
+
+	return hash
+}
+
+func Index(left, index object.Object) object.Object {
+	switch {
+	case left.Type() == object.ARRAY_OBJ && index.Type() == object.INTEGER_OBJ:
+		elements := left.(*object.Array).Elements
+		integer, ok := index.(*object.Integer)
+		if !ok {
+			return Null // a BigInt
+		}
+		i := integer.Value
+		if i < 0 || i >= int64(len(elements)) {
+			return Null
+		}
+		return elements[i]
+
+	case left.Type() == object.HASH_OBJ:
+		key, ok := index.(object.Hashable)
+		if !ok {
+			fail("unusable as hash key: %s", index.Type())
+		}
+		pair, ok := left.(*object.Hash).Get(key.HashKey())
+		if !ok {
+			return Null
+		}
+		return pair.Value
+	}
+
+	fail("index operator not supported: %s", left.Type())
+	return nil
+}
+
+// SetIndex stores value in an element of an array, which must exist, or
+// in a hash
+func SetIndex(left, index, value object.Object) {
+	switch {
+	case left.Type() == object.ARRAY_OBJ && index.Type() == object.INTEGER_OBJ:
+		elements := left.(*object.Array).Elements
+		integer, ok := index.(*object.Integer)
+		if !ok || integer.Value < 0 || integer.Value >= int64(len(elements)) {
+			fail("index out of range: %s with length %d", index.Inspect(), len(elements))
+		}
+		elements[integer.Value] = value
+		return
+
+	case left.Type() == object.HASH_OBJ:
+		key, ok := index.(object.Hashable)
+		if !ok {
+			fail("unusable as hash key: %s", index.Type())
+		}
+		left.(*object.Hash).Set(key.HashKey(), object.HashPair{Key: index, Value: value})
+		return
+	}
+
+	fail("index operator not supported: %s", left.Type())
+}
+
+// Call calls fn, which like in the evaluator takes exactly its parameters
+func Call(fn object.Object, args ...object.Object) object.Object {
+	switch fn := fn.(type) {
+	case *Function:
+		if len(args) != fn.Parameters {
+			fail("wrong number of arguments: want=%d, got=%d", fn.Parameters, len(args))
+		}
+		return fn.Fn(args)
+
+	case *object.Builtin:
+		result := fn.Fn(args...)
+		if err, ok := result.(*object.Error); ok {
+			panic(failure{err})
+		}
+		if result == nil {
+			return Null
+		}
+		return result
+	}
+
+	fail("not a function: %s", fn.Type())
+	return nil
+}
+
+func Bang(right object.Object) object.Object {
+	return Bool(!Truthy(right))
+}
+
+func Minus(right object.Object) object.Object {
+	if right.Type() != object.INTEGER_OBJ {
+		fail("unknown operator: -%s", right.Type())
+	}
+	return object.NegateInteger(right)
+}
+
+func Add(left, right object.Object) object.Object      { return infix("+", left, right) }
+func Sub(left, right object.Object) object.Object      { return infix("-", left, right) }
+func Mul(left, right object.Object) object.Object      { return infix("*", left, right) }
+func Div(left, right object.Object) object.Object      { return infix("/", left, right) }
+func Less(left, right object.Object) object.Object     { return infix("<", left, right) }
+func Greater(left, right object.Object) object.Object  { return infix(">", left, right) }
+func Equal(left, right object.Object) object.Object    { return infix("==", left, right) }
+func NotEqual(left, right object.Object) object.Object { return infix("!=", left, right) }
+
+func infix(operator string, left, right object.Object) object.Object {
+	switch {
+	case left.Type() == object.INTEGER_OBJ && right.Type() == object.INTEGER_OBJ:
+		return integerInfix(operator, left, right)
+	case left.Type() != right.Type():
+		fail("type mismatch: %s %s %s", left.Type(), operator, right.Type())
+	case left.Type() == object.STRING_OBJ && operator == "+":
+		return Str(left.(*object.String).Value + right.(*object.String).Value)
+	case left.Type() == object.STRING_OBJ && operator == "<":
+		return Bool(left.(*object.String).Value < right.(*object.String).Value)
+	case left.Type() == object.STRING_OBJ && operator == ">":
+		return Bool(left.(*object.String).Value > right.(*object.String).Value)
+	case operator == "==":
+		return Bool(object.Equal(left, right))
+	case operator == "!=":
+		return Bool(!object.Equal(left, right))
+	}
+
+	fail("unknown operator: %s %s %s", left.Type(), operator, right.Type())
+	return nil
+}
+
+func integerInfix(operator string, left, right object.Object) object.Object {
+	switch operator {
+	case "+", "-", "*", "/":
+		if r, ok := right.(*object.Integer); ok && operator == "/" && r.Value == 0 {
+			fail("division by zero")
+		}
+		return object.IntegerArithmetic(operator, left, right)
+	case "<":
+		return Bool(object.CompareIntegers(left, right) < 0)
+	case ">":
+		return Bool(object.CompareIntegers(left, right) > 0)
+	case "==":
+		return Bool(object.CompareIntegers(left, right) == 0)
+	}
+	return Bool(object.CompareIntegers(left, right) != 0)
+}
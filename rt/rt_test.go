@@ -0,0 +1,37 @@
+package rt
+
+import (
+	"monc/object"
+	"testing"
+)
+
+func TestRun(t *testing.T) {
+	double := &Function{Source: "fn(x) { x * 2 }", Parameters: 1, Fn: func(args []object.Object) object.Object {
+		return Mul(args[0], Int(2))
+	}}
+
+	tests := []struct {
+		program  func() object.Object
+		expected string
+	}{
+		{func() object.Object { return Call(double, Int(21)) }, "42"},
+		{func() object.Object { return Call(double, Int(1), Int(2)) }, "ERROR: wrong number of arguments: want=1, got=2"},
+		{func() object.Object { return Call(double) }, "ERROR: wrong number of arguments: want=1, got=0"},
+		{func() object.Object { return Call(Builtin("len"), Int(1)) }, "ERROR: argument to `len` not supported, got INTEGER"},
+		{func() object.Object { return Call(Builtin("puts")) }, "null"},
+		{func() object.Object { return Get("x", nil) }, "ERROR: identifier not found: x"},
+		{func() object.Object { return Get("x", nil, Int(1), Int(2)) }, "1"},
+		{func() object.Object { return Add(Str("a"), Int(1)) }, "ERROR: type mismatch: STRING + INTEGER"},
+		{func() object.Object { return Equal(Str("a"), Str("a")) }, "true"},
+		{func() object.Object { return Equal(Array(Str("a")), Array(Str("a"))) }, "true"},
+		{func() object.Object { return Less(Str("ab"), Str("b")) }, "true"},
+		{func() object.Object { return Sub(Str("a"), Str("a")) }, "ERROR: unknown operator: STRING - STRING"},
+		{func() object.Object { return Hash(Array(), Int(1)) }, "ERROR: unusable as hash key: ARARY"},
+	}
+
+	for i, tt := range tests {
+		if got := Run(tt.program).Inspect(); got != tt.expected {
+			t.Errorf("test %d: wrong result. want=%q, got=%q", i, tt.expected, got)
+		}
+	}
+}
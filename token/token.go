@@ -5,6 +5,8 @@ type TokenType string
 type Token struct {
 	Type    TokenType
 	Literal string
+	Line    int // 1-based line of the first character, 0 if unknown
+	Column  int // 1-based column of the first character, 0 if unknown
 }
 
 const (
@@ -26,6 +28,7 @@ const (
 	GT       = ">"
 	EQ       = "=="
 	NOT_EQ   = "!="
+	ARROW    = "->" // separates a function's parameters from its return type
 
 	// Delimiters
 	COMMA     = ","
@@ -0,0 +1,598 @@
+package types
+
+import (
+	"fmt"
+	"monc/ast"
+	"monc/object"
+	"monc/token"
+	"sort"
+)
+
+// Error is a type mismatch found before the program runs
+type Error struct {
+	Line    int
+	Column  int
+	Message string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%d:%d: %s", e.Line, e.Column, e.Message)
+}
+
+type scope struct {
+	vars    map[string]Type
+	rebound map[string]bool // names the function, or program, binds more than once
+	outer   *scope
+}
+
+func (s *scope) lookup(name string) (Type, bool) {
+	t, ok := s.vars[name]
+	if !ok && s.outer != nil {
+		return s.outer.lookup(name)
+	}
+	return t, ok
+}
+
+// function is the context of the function literal being checked
+type function struct {
+	result  Type   // annotated return type, nil if unannotated
+	returns []Type // types of the return statements seen so far
+}
+
+/*
+Checker infers types locally, bottom-up from literals, and checks them
+against annotations on `let` statements, parameters and return types.
+Unannotated names and parameters have type Any, which never causes an
+error, so unannotated code keeps its dynamic semantics; a name bound once
+to a function literal has the signature the literal declares. Only
+operations that would certainly fail at runtime are reported.
+*/
+type Checker struct {
+	scope     *scope
+	functions []*function
+	errors    []*Error
+}
+
+// builtinTypes are the signatures of the builtins that have a useful one;
+// the others are Any
+var builtinTypes = map[string]Type{
+	"len": &Function{Parameters: []Type{Any}, Return: Int},
+}
+
+// NewChecker returns a checker whose global scope persists across calls to
+// Check, like the symbol table of a REPL session
+func NewChecker() *Checker {
+	global := &scope{vars: map[string]Type{}}
+	for _, b := range object.Builtins {
+		if t, ok := builtinTypes[b.Name]; ok {
+			global.vars[b.Name] = t
+		} else {
+			global.vars[b.Name] = Any
+		}
+	}
+
+	return &Checker{scope: global}
+}
+
+// Check type checks program and returns the errors found, ordered by
+// position
+func Check(program *ast.Program) []*Error {
+	return NewChecker().Check(program)
+}
+
+func (c *Checker) Check(program *ast.Program) []*Error {
+	c.errors = nil
+
+	counts := map[string]int{}
+	for _, s := range program.Statements {
+		countBindings(s, counts)
+	}
+	c.scope.rebound = rebound(counts)
+
+	for _, s := range program.Statements {
+		c.checkStatement(s)
+	}
+
+	sort.SliceStable(c.errors, func(i, j int) bool {
+		if c.errors[i].Line != c.errors[j].Line {
+			return c.errors[i].Line < c.errors[j].Line
+		}
+		return c.errors[i].Column < c.errors[j].Column
+	})
+
+	return c.errors
+}
+
+func (c *Checker) errorf(tok token.Token, format string, a ...interface{}) {
+	c.errors = append(c.errors, &Error{
+		Line:    tok.Line,
+		Column:  tok.Column,
+		Message: fmt.Sprintf(format, a...),
+	})
+}
+
+func (c *Checker) checkStatement(s ast.Statement) Type {
+	switch s := s.(type) {
+	case *ast.LetStatement:
+		c.checkLet(s)
+
+	case *ast.AssignStatement:
+		// the element type of the target, Any if it is not known or only
+		// inferred from a literal
+		element := c.checkIndex(s.Target)
+		if !declared(s.Target.Left) {
+			element = Any
+		}
+		if t := c.checkExpression(s.Value); !Assignable(t, element) {
+			c.errorf(s.Token, "cannot use %s as %s in assignment", t, element)
+		}
+
+	case *ast.ReturnStatement:
+		t := c.checkExpression(s.ReturnValue)
+		if len(c.functions) > 0 {
+			fn := c.functions[len(c.functions)-1]
+			if fn.result != nil && !Assignable(t, fn.result) {
+				c.errorf(s.Token, "cannot return %s from function returning %s", t, fn.result)
+			}
+			fn.returns = append(fn.returns, t)
+		}
+
+	case *ast.ExpressionStatement:
+		return c.checkExpression(s.Expression)
+
+	case *ast.BlockStatement:
+		return c.checkBlock(s)
+	}
+
+	return Any
+}
+
+// countBindings counts the names node binds with let, without descending
+// into function literals, which have scopes of their own
+func countBindings(node ast.Node, counts map[string]int) {
+	switch node := node.(type) {
+	case *ast.LetStatement:
+		counts[node.Name.Value]++
+		countBindings(node.Value, counts)
+	case *ast.ExpressionStatement:
+		countBindings(node.Expression, counts)
+	case *ast.AssignStatement:
+		countBindings(node.Target, counts)
+		countBindings(node.Value, counts)
+	case *ast.ReturnStatement:
+		countBindings(node.ReturnValue, counts)
+	case *ast.BlockStatement:
+		for _, s := range node.Statements {
+			countBindings(s, counts)
+		}
+	case *ast.PrefixExpression:
+		countBindings(node.Right, counts)
+	case *ast.InfixExpression:
+		countBindings(node.Left, counts)
+		countBindings(node.Right, counts)
+	case *ast.IfExpression:
+		countBindings(node.Condition, counts)
+		countBindings(node.Consequence, counts)
+		if node.Alternative != nil {
+			countBindings(node.Alternative, counts)
+		}
+	case *ast.CallExpression:
+		countBindings(node.Function, counts)
+		for _, a := range node.Arguments {
+			countBindings(a, counts)
+		}
+	case *ast.IndexExpression:
+		countBindings(node.Left, counts)
+		countBindings(node.Index, counts)
+	case *ast.ArrayLiteral:
+		for _, e := range node.Elements {
+			countBindings(e, counts)
+		}
+	case *ast.HashLiteral:
+		for _, pair := range node.Pairs {
+			countBindings(pair.Key, counts)
+			countBindings(pair.Value, counts)
+		}
+	}
+}
+
+// rebound returns the names counted more than once
+func rebound(counts map[string]int) map[string]bool {
+	names := map[string]bool{}
+	for name, n := range counts {
+		if n > 1 {
+			names[name] = true
+		}
+	}
+	return names
+}
+
+// declared reports whether the type of the collection e is declared, rather
+// than inferred from an array or hash literal. Assignments to elements are
+// only checked against declared element types.
+func declared(e ast.Expression) bool {
+	switch e := e.(type) {
+	case *ast.ArrayLiteral, *ast.HashLiteral:
+		return false
+	case *ast.IndexExpression:
+		return declared(e.Left)
+	}
+	return true
+}
+
+func (c *Checker) checkLet(s *ast.LetStatement) {
+	var declared Type
+	if s.Type != nil {
+		declared = c.resolve(s.Type)
+	}
+
+	// an unannotated name is Any, whatever its value, since the name can be
+	// bound again to another type before code that reads it runs. A function
+	// literal declares its signature, though, unless the name is bound more
+	// than once: a function called later may see either binding. Bind the
+	// name before checking the value so recursive functions can refer to
+	// themselves.
+	if declared == nil {
+		declared = Any
+		if fl, ok := s.Value.(*ast.FunctionLiteral); ok && !c.scope.rebound[s.Name.Value] {
+			declared = c.signature(fl)
+		}
+	}
+	c.scope.vars[s.Name.Value] = declared
+
+	t := c.checkExpression(s.Value)
+
+	if !Assignable(t, declared) {
+		c.errorf(s.Name.Token, "cannot use %s as %s in let statement", t, declared)
+	}
+}
+
+// checkBlock returns the type of the value the block evaluates to
+func (c *Checker) checkBlock(block *ast.BlockStatement) Type {
+	var t Type = Any
+
+	for i, s := range block.Statements {
+		t = c.checkStatement(s)
+		if _, ok := s.(*ast.ExpressionStatement); !ok && i == len(block.Statements)-1 {
+			t = Any
+		}
+	}
+
+	return t
+}
+
+func (c *Checker) checkExpression(e ast.Expression) Type {
+	switch e := e.(type) {
+	case *ast.IntegerLiteral:
+		return Int
+
+	case *ast.StringLiteral:
+		return String
+
+	case *ast.Boolean:
+		return Bool
+
+	case *ast.Identifier:
+		if t, ok := c.scope.lookup(e.Value); ok {
+			return t
+		}
+		// undefined names are reported by the compiler
+		return Any
+
+	case *ast.PrefixExpression:
+		return c.checkPrefix(e)
+
+	case *ast.InfixExpression:
+		return c.checkInfix(e)
+
+	case *ast.IfExpression:
+		c.checkExpression(e.Condition)
+		consequence := c.checkBlock(e.Consequence)
+		if e.Alternative == nil {
+			return Any
+		}
+		return join(consequence, c.checkBlock(e.Alternative))
+
+	case *ast.FunctionLiteral:
+		return c.checkFunction(e)
+
+	case *ast.CallExpression:
+		return c.checkCall(e)
+
+	case *ast.ArrayLiteral:
+		var element Type
+		for _, el := range e.Elements {
+			t := c.checkExpression(el)
+			if element == nil {
+				element = t
+			} else {
+				element = join(element, t)
+			}
+		}
+		if element == nil {
+			element = Any
+		}
+		return &Array{Element: element}
+
+	case *ast.HashLiteral:
+		return c.checkHash(e)
+
+	case *ast.IndexExpression:
+		return c.checkIndex(e)
+	}
+
+	return Any
+}
+
+func (c *Checker) checkPrefix(e *ast.PrefixExpression) Type {
+	right := c.checkExpression(e.Right)
+
+	switch e.Operator {
+	case "!":
+		return Bool
+	case "-":
+		if !Assignable(right, Int) {
+			c.errorf(e.Token, "invalid operation: -%s", right)
+		}
+		return Int
+	}
+
+	return Any
+}
+
+func (c *Checker) checkInfix(e *ast.InfixExpression) Type {
+	left := c.checkExpression(e.Left)
+	right := c.checkExpression(e.Right)
+
+	switch e.Operator {
+	case "+":
+		switch {
+		case left == Any && right == Any:
+			return Any
+		case left == Any && (right == Int || right == String):
+			return right
+		case right == Any && (left == Int || left == String):
+			return left
+		case (left == Int || left == String) && left == right:
+			return left
+		}
+		c.errorf(e.Token, "invalid operation: %s + %s", left, right)
+		return Any
+
+	case "<", ">":
+		// integers by value, strings lexicographically
+		switch {
+		case left == Any && (right == Any || right == Int || right == String):
+		case right == Any && (left == Int || left == String):
+		case (left == Int || left == String) && left == right:
+		default:
+			c.errorf(e.Token, "invalid operation: %s %s %s", left, e.Operator, right)
+		}
+		return Bool
+
+	case "-", "*", "/":
+		if !Assignable(left, Int) || !Assignable(right, Int) {
+			c.errorf(e.Token, "invalid operation: %s %s %s", left, e.Operator, right)
+		}
+		return Int
+
+	case "==", "!=":
+		return Bool
+	}
+
+	return Any
+}
+
+// signature returns the type of a function literal from its annotations
+func (c *Checker) signature(fl *ast.FunctionLiteral) *Function {
+	fn := &Function{Parameters: make([]Type, len(fl.Parameters)), Return: Any}
+
+	for i := range fl.Parameters {
+		fn.Parameters[i] = Any
+		if i < len(fl.ParameterTypes) && fl.ParameterTypes[i] != nil {
+			fn.Parameters[i] = c.resolve(fl.ParameterTypes[i])
+		}
+	}
+
+	if fl.ReturnType != nil {
+		fn.Return = c.resolve(fl.ReturnType)
+	}
+
+	return fn
+}
+
+func (c *Checker) checkFunction(fl *ast.FunctionLiteral) Type {
+	fn := c.signature(fl)
+
+	counts := map[string]int{}
+	for _, p := range fl.Parameters {
+		counts[p.Value]++
+	}
+	countBindings(fl.Body, counts)
+
+	c.scope = &scope{vars: map[string]Type{}, rebound: rebound(counts), outer: c.scope}
+	for i, p := range fl.Parameters {
+		c.scope.vars[p.Value] = fn.Parameters[i]
+	}
+
+	ctx := &function{}
+	if fl.ReturnType != nil {
+		ctx.result = fn.Return
+	}
+	c.functions = append(c.functions, ctx)
+
+	body := c.checkBlock(fl.Body)
+
+	c.functions = c.functions[:len(c.functions)-1]
+	c.scope = c.scope.outer
+
+	// the last expression statement is an implicit return
+	statements := fl.Body.Statements
+	var last *ast.ExpressionStatement
+	if len(statements) > 0 {
+		last, _ = statements[len(statements)-1].(*ast.ExpressionStatement)
+	}
+
+	if ctx.result != nil {
+		if last != nil && !Assignable(body, ctx.result) {
+			c.errorf(last.Token, "cannot return %s from function returning %s", body, ctx.result)
+		}
+		return fn
+	}
+
+	// infer the result of unannotated functions
+	var result Type
+	for _, t := range ctx.returns {
+		if result == nil {
+			result = t
+		} else {
+			result = join(result, t)
+		}
+	}
+	if last != nil {
+		if result == nil {
+			result = body
+		} else {
+			result = join(result, body)
+		}
+	}
+	if result == nil {
+		result = Any
+	}
+	fn.Return = result
+
+	return fn
+}
+
+func (c *Checker) checkCall(e *ast.CallExpression) Type {
+	callee := c.checkExpression(e.Function)
+
+	args := make([]Type, len(e.Arguments))
+	for i, a := range e.Arguments {
+		args[i] = c.checkExpression(a)
+	}
+
+	switch callee := callee.(type) {
+	case *Function:
+		if len(args) != len(callee.Parameters) {
+			c.errorf(e.Token, "wrong number of arguments: want=%d, got=%d", len(callee.Parameters), len(args))
+			return callee.Return
+		}
+		for i, a := range args {
+			if !Assignable(a, callee.Parameters[i]) {
+				c.errorf(e.Token, "cannot use %s as %s in argument %d", a, callee.Parameters[i], i+1)
+			}
+		}
+		return callee.Return
+
+	case *Basic:
+		if callee != Any {
+			c.errorf(e.Token, "calling non-function %s", callee)
+		}
+
+	default:
+		c.errorf(e.Token, "calling non-function %s", callee)
+	}
+
+	return Any
+}
+
+func (c *Checker) checkHash(e *ast.HashLiteral) Type {
+	var key, value Type
+
+	for _, pair := range e.Pairs {
+		kt := c.checkExpression(pair.Key)
+		vt := c.checkExpression(pair.Value)
+
+		if !hashable(kt) {
+			c.errorf(e.Token, "unusable as hash key: %s", kt)
+		}
+
+		if key == nil {
+			key, value = kt, vt
+		} else {
+			key, value = join(key, kt), join(value, vt)
+		}
+	}
+
+	if key == nil {
+		return &Hash{Key: Any, Value: Any}
+	}
+
+	return &Hash{Key: key, Value: value}
+}
+
+func (c *Checker) checkIndex(e *ast.IndexExpression) Type {
+	left := c.checkExpression(e.Left)
+	index := c.checkExpression(e.Index)
+
+	switch left := left.(type) {
+	case *Array:
+		if !Assignable(index, Int) {
+			c.errorf(e.Token, "cannot index %s with %s", left, index)
+		}
+		return left.Element
+
+	case *Hash:
+		if !hashable(index) {
+			c.errorf(e.Token, "unusable as hash key: %s", index)
+		}
+		return left.Value
+
+	case *Basic:
+		if left != Any {
+			c.errorf(e.Token, "index operator not supported: %s", left)
+		}
+
+	default:
+		c.errorf(e.Token, "index operator not supported: %s", left)
+	}
+
+	return Any
+}
+
+func hashable(t Type) bool {
+	return t == Any || t == Int || t == String || t == Bool
+}
+
+// resolve turns an annotation into a type
+func (c *Checker) resolve(te ast.TypeExpression) Type {
+	switch te := te.(type) {
+	case *ast.NamedType:
+		switch te.Name {
+		case "int":
+			return Int
+		case "bool":
+			return Bool
+		case "string":
+			return String
+		case "null":
+			return Null
+		case "any":
+			return Any
+		}
+		c.errorf(te.Token, "unknown type %s", te.Name)
+
+	case *ast.ArrayType:
+		return &Array{Element: c.resolve(te.Element)}
+
+	case *ast.HashType:
+		key := c.resolve(te.Key)
+		if !hashable(key) {
+			c.errorf(te.Token, "unusable as hash key: %s", key)
+		}
+		return &Hash{Key: key, Value: c.resolve(te.Value)}
+
+	case *ast.FunctionType:
+		fn := &Function{Parameters: []Type{}, Return: Any}
+		for _, p := range te.Parameters {
+			fn.Parameters = append(fn.Parameters, c.resolve(p))
+		}
+		if te.Return != nil {
+			fn.Return = c.resolve(te.Return)
+		}
+		return fn
+	}
+
+	return Any
+}
@@ -0,0 +1,196 @@
+package types
+
+import (
+	"monc/ast"
+	"monc/lexer"
+	"monc/parser"
+	"testing"
+)
+
+func TestCheckerAcceptsValidPrograms(t *testing.T) {
+	tests := []string{
+		`let x: int = 5; x + 1;`,
+		`let s: string = "a" + "b";`,
+		`let add = fn(a: int, b: int) -> int { a + b }; add(1, 2);`,
+		`let f = fn(a: string, b: [int]) -> bool { len(b) > len(a) }; f("ab", [1, 2, 3]);`,
+		`let xs: [int] = [1, 2, 3]; xs[0] * 2;`,
+		`let h: {string: int} = {"one": 1}; h["one"] + 1;`,
+		`let apply = fn(f: fn(int) -> int, x: int) -> int { f(x) }; apply(fn(y) { y * 2 }, 2);`,
+		`let fib = fn(x: int) -> int { if (x < 2) { return x; } fib(x - 1) + fib(x - 2) }; fib(10);`,
+		// unannotated code stays dynamically typed
+		`let id = fn(x) { x }; id(1) + id(2); id("a") + "b";`,
+		`let f = fn(x) { x + 1 }; f(1);`,
+		// an unannotated name can be bound again to another type
+		`let x = 1; let f = fn() { x + "a" }; let x = "b"; f();`,
+		`let x = 5; let y = x + 1; let x = "five"; x + "!";`,
+		`let f = fn() { 1 }; f() + 1; let g = fn() { f() + "a" }; let f = fn() { "b" }; g();`,
+		`let f = fn(a, b) { a }; let g = fn() { f(1) }; let f = fn(a) { a }; g();`,
+		`let h = fn() { let f = fn(a, b) { a }; let g = fn() { f(1) }; if (true) { let f = fn(a) { a }; }; g() };`,
+		`let empty: [string] = []; push(empty, "a");`,
+		`let any: any = 1; let s: string = any;`,
+		`let xs: [int] = [1, 2]; xs[0] = xs[1] * 2;`,
+		`let h: {string: int} = {}; h["a"] = 1;`,
+		`let xs: [any] = [1]; xs[0] = "a";`,
+		`let f = fn(a) { a[0] = 1; a["k"] = "v"; }; f([0]);`,
+		// only declared element types are checked in assignments
+		`let a = [1]; a[0] = "x";`,
+		`let h = {"a": 1}; h["b"] = "s";`,
+		`let a = [1]; append(a, "s");`,
+		`[1][0] = "x"; [[1]][0][0] = "x";`,
+		`let s: string = "a"; s < "b"; "c" > s;`,
+		`let f = fn(a) { a < "b" }; f("a");`,
+		`[1] == [1]; "a" != "b";`,
+	}
+
+	for _, input := range tests {
+		errs := Check(parse(input))
+		if len(errs) != 0 {
+			t.Errorf("unexpected type errors for %q: %v", input, errs)
+		}
+	}
+}
+
+func TestCheckerReportsMismatches(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected []string
+	}{
+		{
+			`let x: int = "five";`,
+			[]string{`1:5: cannot use string as int in let statement`},
+		},
+		{
+			`1 + "a"`,
+			[]string{`1:3: invalid operation: int + string`},
+		},
+		{
+			`let x: int = 5;
+let y: string = "a";
+x * y;`,
+			[]string{`3:3: invalid operation: int * string`},
+		},
+		{
+			`let f = fn(a: string) -> bool { true }; f(1);`,
+			[]string{`1:42: cannot use int as string in argument 1`},
+		},
+		{
+			`let f = fn(a: int) -> bool { a + 1 };`,
+			[]string{`1:30: cannot return int from function returning bool`},
+		},
+		{
+			`fn() -> string { return 1; }`,
+			[]string{`1:18: cannot return int from function returning string`},
+		},
+		{
+			`let xs: [int] = [1, 2]; xs["a"];`,
+			[]string{`1:27: cannot index [int] with string`},
+		},
+		{
+			`let xs: [int] = ["a"];`,
+			[]string{`1:5: cannot use [string] as [int] in let statement`},
+		},
+		{
+			`let n: number = 1;`,
+			[]string{`1:8: unknown type number`},
+		},
+		{
+			`let f = fn(a: int, b: int) { a }; f(1);`,
+			[]string{`1:36: wrong number of arguments: want=2, got=1`},
+		},
+		{
+			`let xs: [int] = [1]; xs[0] = "a";`,
+			[]string{`1:28: cannot use string as int in assignment`},
+		},
+		{
+			`let h: {string: int} = {}; h["a"] = "b"; h[[1]] = 2;`,
+			[]string{`1:35: cannot use string as int in assignment`, `1:43: unusable as hash key: [int]`},
+		},
+		{
+			`let f = fn(a: [int]) { a[0] = "x"; };`,
+			[]string{`1:29: cannot use string as int in assignment`},
+		},
+		{
+			`let m: [[int]] = [[1]]; m[0][0] = true;`,
+			[]string{`1:33: cannot use bool as int in assignment`},
+		},
+		{
+			`let s: string = "abc"; s[0] = "x";`,
+			[]string{`1:25: index operator not supported: string`},
+		},
+		{
+			`"a" < 1; true > false;`,
+			[]string{`1:5: invalid operation: string < int`, `1:15: invalid operation: bool > bool`},
+		},
+		{
+			`-"a"; 1(2);`,
+			[]string{`1:1: invalid operation: -string`, `1:8: calling non-function int`},
+		},
+	}
+
+	for _, tt := range tests {
+		errs := Check(parse(tt.input))
+
+		if len(errs) != len(tt.expected) {
+			t.Errorf("wrong number of errors for %q. want=%d, got=%d (%v)",
+				tt.input, len(tt.expected), len(errs), errs)
+			continue
+		}
+
+		for i, err := range errs {
+			if err.Error() != tt.expected[i] {
+				t.Errorf("wrong error. want=%q, got=%q", tt.expected[i], err.Error())
+			}
+		}
+	}
+}
+
+func TestCheckerKeepsGlobalsAcrossCalls(t *testing.T) {
+	checker := NewChecker()
+
+	if errs := checker.Check(parse(`let x: int = 1;`)); len(errs) != 0 {
+		t.Fatalf("unexpected type errors: %v", errs)
+	}
+
+	errs := checker.Check(parse(`x + "a"`))
+	if len(errs) != 1 {
+		t.Fatalf("expected 1 error, got=%v", errs)
+	}
+}
+
+func TestAssignable(t *testing.T) {
+	tests := []struct {
+		from     Type
+		to       Type
+		expected bool
+	}{
+		{Int, Int, true},
+		{Int, String, false},
+		{Any, String, true},
+		{Int, Any, true},
+		{&Array{Element: Int}, &Array{Element: Any}, true},
+		{&Array{Element: Int}, &Array{Element: Bool}, false},
+		{&Hash{Key: String, Value: Int}, &Hash{Key: String, Value: Int}, true},
+		{
+			&Function{Parameters: []Type{Any}, Return: Int},
+			&Function{Parameters: []Type{String}, Return: Int},
+			true,
+		},
+		{
+			&Function{Parameters: []Type{Int}, Return: Int},
+			&Function{Parameters: []Type{Int, Int}, Return: Int},
+			false,
+		},
+	}
+
+	for _, tt := range tests {
+		if actual := Assignable(tt.from, tt.to); actual != tt.expected {
+			t.Errorf("Assignable(%s, %s) wrong. want=%t, got=%t", tt.from, tt.to, tt.expected, actual)
+		}
+	}
+}
+
+func parse(input string) *ast.Program {
+	l := lexer.New(input)
+	p := parser.New(l)
+	return p.ParseProgram()
+}
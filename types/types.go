@@ -0,0 +1,136 @@
+package types
+
+import (
+	"bytes"
+	"strings"
+)
+
+// Type is the static type of a Monkey expression
+type Type interface {
+	String() string
+}
+
+// Basic is a type without components. Any is the type of unannotated
+// code: it is compatible with every other type, so dynamically typed
+// programs are never rejected.
+type Basic struct {
+	Name string
+}
+
+func (b *Basic) String() string { return b.Name }
+
+var (
+	Any    = &Basic{Name: "any"}
+	Int    = &Basic{Name: "int"}
+	Bool   = &Basic{Name: "bool"}
+	String = &Basic{Name: "string"}
+	Null   = &Basic{Name: "null"}
+)
+
+type Array struct {
+	Element Type
+}
+
+func (a *Array) String() string { return "[" + a.Element.String() + "]" }
+
+type Hash struct {
+	Key   Type
+	Value Type
+}
+
+func (h *Hash) String() string { return "{" + h.Key.String() + ": " + h.Value.String() + "}" }
+
+type Function struct {
+	Parameters []Type
+	Return     Type
+}
+
+func (f *Function) String() string {
+	var out bytes.Buffer
+
+	params := []string{}
+	for _, p := range f.Parameters {
+		params = append(params, p.String())
+	}
+
+	out.WriteString("fn(")
+	out.WriteString(strings.Join(params, ", "))
+	out.WriteString(") -> ")
+	out.WriteString(f.Return.String())
+
+	return out.String()
+}
+
+// Identical reports whether a and b are structurally the same type
+func Identical(a, b Type) bool {
+	switch a := a.(type) {
+	case *Basic:
+		return a == b
+
+	case *Array:
+		b, ok := b.(*Array)
+		return ok && Identical(a.Element, b.Element)
+
+	case *Hash:
+		b, ok := b.(*Hash)
+		return ok && Identical(a.Key, b.Key) && Identical(a.Value, b.Value)
+
+	case *Function:
+		b, ok := b.(*Function)
+		if !ok || len(a.Parameters) != len(b.Parameters) {
+			return false
+		}
+		for i := range a.Parameters {
+			if !Identical(a.Parameters[i], b.Parameters[i]) {
+				return false
+			}
+		}
+		return Identical(a.Return, b.Return)
+	}
+
+	return false
+}
+
+// Assignable reports whether a value of type from can be used where a value
+// of type to is expected. Any is assignable to and from every type.
+func Assignable(from, to Type) bool {
+	if from == Any || to == Any {
+		return true
+	}
+
+	switch to := to.(type) {
+	case *Basic:
+		return from == to
+
+	case *Array:
+		from, ok := from.(*Array)
+		return ok && Assignable(from.Element, to.Element)
+
+	case *Hash:
+		from, ok := from.(*Hash)
+		return ok && Assignable(from.Key, to.Key) && Assignable(from.Value, to.Value)
+
+	case *Function:
+		from, ok := from.(*Function)
+		if !ok || len(from.Parameters) != len(to.Parameters) {
+			return false
+		}
+		// parameters are contravariant
+		for i := range to.Parameters {
+			if !Assignable(to.Parameters[i], from.Parameters[i]) {
+				return false
+			}
+		}
+		return Assignable(from.Return, to.Return)
+	}
+
+	return false
+}
+
+// join returns the type of a value that is either a or b
+func join(a, b Type) Type {
+	if Identical(a, b) {
+		return a
+	}
+	return Any
+}
@@ -0,0 +1,74 @@
+package vm
+
+import (
+	"monc/compiler"
+	"testing"
+)
+
+var benchmarks = []struct {
+	name  string
+	input string
+}{
+	{
+		"fibonacci",
+		`let fibonacci = fn(x) {
+			if (x < 2) { return x; }
+			fibonacci(x - 1) + fibonacci(x - 2)
+		};
+		fibonacci(20);`,
+	},
+	{
+		"loop",
+		`let loop = fn(n, acc) { if (n == 0) { return acc; } loop(n - 1, acc + n * 2 - n) };
+		loop(500, 0);`,
+	},
+	{
+		"literals",
+		`let build = fn(n) {
+			if (n == 0) { return 0; }
+			let a = [n, n + 1, n + 2, n + 3, n + 4, n + 5, n + 6, n + 7];
+			let h = {1: a[0], 2: a[1], 3: a[2]};
+			h[1] + build(n - 1)
+		};
+		build(300);`,
+	},
+}
+
+func BenchmarkRun(b *testing.B) {
+	for _, backend := range backends {
+		for _, bm := range benchmarks {
+			b.Run(backend.name+"/"+bm.name, func(b *testing.B) {
+				comp := compiler.New(backend.options...)
+				if err := comp.Compile(parse(bm.input)); err != nil {
+					b.Fatalf("compiler error: %s", err)
+				}
+				bytecode := comp.Bytecode()
+
+				b.ResetTimer()
+				for i := 0; i < b.N; i++ {
+					machine := New(bytecode)
+					if err := machine.Run(); err != nil {
+						b.Fatalf("vm error: %s", err)
+					}
+				}
+			})
+		}
+	}
+}
+
+// BenchmarkTinyScript measures starting a VM for a program that needs
+// almost none of the stack
+func BenchmarkTinyScript(b *testing.B) {
+	comp := compiler.New()
+	if err := comp.Compile(parse(`1 + 2`)); err != nil {
+		b.Fatalf("compiler error: %s", err)
+	}
+	bytecode := comp.Bytecode()
+
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if err := New(bytecode).Run(); err != nil {
+			b.Fatalf("vm error: %s", err)
+		}
+	}
+}
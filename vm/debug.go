@@ -0,0 +1,139 @@
+package vm
+
+import (
+	"monc/code"
+	"monc/object"
+)
+
+/*
+Hook observes a VM created with WithHook, e.g. for a debugger. Instruction
+runs before every instruction, Call after the frame of a closure is pushed
+and Return before the frame of a closure is popped; builtins and a return
+from the main program do not count as calls. The methods run on the
+goroutine of Run and may inspect the VM with the methods below. An error
+they return stops the program with an error of KindCanceled.
+*/
+type Hook interface {
+	Instruction(vm *VM) error
+	Call(vm *VM) error
+	Return(vm *VM) error
+}
+
+// WithHook makes the VM report its progress to h. The checks of the
+// limits then run before every instruction, which makes the VM slower.
+func WithHook(h Hook) Option {
+	return func(vm *VM) { vm.hook = h }
+}
+
+// called runs the Call hook for the frame just pushed
+func (vm *VM) called() error {
+	return stopped(vm.hook.Call(vm))
+}
+
+// localSlot returns the slot of a local relative to the base pointer: its
+// index, or its register for functions compiled with registers
+func localSlot(fn *object.CompiledFn, index int) int {
+	if index < len(fn.LocalRegisters) {
+		return fn.LocalRegisters[index]
+	}
+	return index
+}
+
+// stopped completes an error of a hook
+func stopped(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &RuntimeError{Kind: KindCanceled, Err: err}
+}
+
+// Variable is a named value of a frame, as listed by Locals and Globals
+type Variable struct {
+	Name  string
+	Value object.Object // null for a local whose let has not run yet
+}
+
+// Depth returns the number of active frames, 1 in the main program
+func (vm *VM) Depth() int {
+	return vm.framesIndex
+}
+
+// StackFrame describes the active frame n calls out from the current one,
+// which is 0
+func (vm *VM) StackFrame(n int) StackFrame {
+	i := vm.framesIndex - 1 - n
+	frame := vm.frames[i]
+	fn := frame.cl.Fn
+
+	name := fn.Name
+	switch {
+	case i == 0:
+		name = "<main>"
+	case name == "":
+		name = "<anonymous>"
+	}
+
+	line, column := fn.Lines.Lookup(frame.ip)
+	return StackFrame{Function: name, Source: vm.source, Line: line, Column: column}
+}
+
+// Function returns the function of the active frame n calls out from the
+// current one; the main program is a function without name
+func (vm *VM) Function(n int) *object.CompiledFn {
+	return vm.frames[vm.framesIndex-1-n].cl.Fn
+}
+
+// Op returns the opcode of the instruction the current frame is at, the
+// widened one for an OpWide prefix
+func (vm *VM) Op() code.Opcode {
+	frame := vm.currentFrame()
+	ins := frame.Instructions()
+	op := code.Opcode(ins[frame.ip])
+	if op == code.OpWide && frame.ip+1 < len(ins) {
+		op = code.Opcode(ins[frame.ip+1])
+	}
+	return op
+}
+
+/*
+Locals returns the parameters, locals and free variables of the active
+frame n calls out from the current one, by the names the compiler
+recorded; nil for the main program, whose variables are globals.
+*/
+func (vm *VM) Locals(n int) []Variable {
+	i := vm.framesIndex - 1 - n
+	if i == 0 {
+		return nil
+	}
+
+	frame := vm.frames[i]
+	fn := frame.cl.Fn
+
+	var variables []Variable
+	for index, name := range fn.LocalNames {
+		if name == "" {
+			continue // shadowed by a later local of the same name
+		}
+		value := vm.stack[frame.bp+localSlot(fn, index)].Object()
+		variables = append(variables, Variable{Name: name, Value: value})
+	}
+	for index, name := range fn.FreeNames {
+		variables = append(variables, Variable{Name: name, Value: frame.cl.Free[index]})
+	}
+	return variables
+}
+
+// Globals returns the globals that were set, by the names the compiler
+// recorded
+func (vm *VM) Globals() []Variable {
+	var variables []Variable
+	for index, name := range vm.globalNames {
+		if name == "" || index >= len(vm.globals) {
+			continue
+		}
+		if value := vm.globals[index].Object(); value != nil {
+			variables = append(variables, Variable{Name: name, Value: value})
+		}
+	}
+	return variables
+}
@@ -0,0 +1,155 @@
+package vm
+
+import (
+	"errors"
+	"monc/object"
+	"reflect"
+	"testing"
+)
+
+// recorder is a Hook that records the events of a run
+type recorder struct {
+	events []string
+	locals [][]Variable // at each call
+
+	stopAfter int // stop the program at this instruction, if not 0
+	steps     int
+}
+
+var errStop = errors.New("stopped by the hook")
+
+func (r *recorder) Instruction(vm *VM) error {
+	r.steps++
+	if r.steps == r.stopAfter {
+		return errStop
+	}
+	return nil
+}
+
+func (r *recorder) Call(vm *VM) error {
+	r.events = append(r.events, "call "+vm.StackFrame(0).Function)
+	return nil
+}
+
+func (r *recorder) Return(vm *VM) error {
+	r.events = append(r.events, "return "+vm.StackFrame(0).Function)
+	r.locals = append(r.locals, vm.Locals(0))
+	return nil
+}
+
+func TestHook(t *testing.T) {
+	input := `
+let x = 10;
+let add = fn(a, b) { let sum = a + b; sum };
+let twice = fn(f) { fn(y) { f(y, y) } };
+twice(add)(x);
+`
+	expectedEvents := []string{
+		"call twice", "return twice",
+		"call <anonymous>", "call add", "return add", "return <anonymous>",
+	}
+	expectedLocals := [][]Variable{
+		{{"f", nil}},
+		{{"a", &object.Integer{Value: 10}}, {"b", &object.Integer{Value: 10}}, {"sum", &object.Integer{Value: 20}}},
+		{{"y", &object.Integer{Value: 10}}, {"f", nil}},
+	}
+
+	for _, backend := range backends {
+		r := &recorder{}
+		vm := New(compileWith(t, input, backend.options), WithHook(r))
+		if err := vm.Run(); err != nil {
+			t.Fatalf("%s: vm error: %s", backend.name, err)
+		}
+
+		if !reflect.DeepEqual(r.events, expectedEvents) {
+			t.Errorf("%s: wrong events. want=%v, got=%v", backend.name, expectedEvents, r.events)
+		}
+		if r.steps == 0 {
+			t.Errorf("%s: Instruction was not called", backend.name)
+		}
+
+		if len(r.locals) != len(expectedLocals) {
+			t.Fatalf("%s: wrong number of locals. got=%d", backend.name, len(r.locals))
+		}
+		for i, locals := range r.locals {
+			testVariables(t, backend.name, locals, expectedLocals[i])
+		}
+
+		globals := vm.Globals()
+		if len(globals) != 3 || globals[0].Name != "x" || globals[1].Name != "add" || globals[2].Name != "twice" {
+			t.Errorf("%s: wrong globals: %v", backend.name, globals)
+		}
+	}
+}
+
+// testVariables compares names, and integer values where expected is not
+// nil
+func testVariables(t *testing.T, backend string, actual, expected []Variable) {
+	t.Helper()
+
+	if len(actual) != len(expected) {
+		t.Errorf("%s: wrong variables. want=%v, got=%v", backend, expected, actual)
+		return
+	}
+	for i, v := range expected {
+		if actual[i].Name != v.Name {
+			t.Errorf("%s: wrong name of variable %d. want=%s, got=%s", backend, i, v.Name, actual[i].Name)
+		}
+		if v.Value != nil {
+			if err := testIntegerObject(v.Value.(*object.Integer).Value, actual[i].Value); err != nil {
+				t.Errorf("%s: variable %s: %s", backend, v.Name, err)
+			}
+		}
+	}
+}
+
+func TestHookLocalsNullUntilSet(t *testing.T) {
+	// g leaves its values in the slot of b
+	input := `let g = fn() { let a = 5; a }; let f = fn(n) { let b = n; b }; g(); f(1)`
+
+	for _, backend := range backends {
+		r := &callRecorder{}
+		if err := New(compileWith(t, input, backend.options), WithHook(r)).Run(); err != nil {
+			t.Fatalf("%s: vm error: %s", backend.name, err)
+		}
+
+		locals := r.locals["f"]
+		if len(locals) != 2 || locals[0].Value == nil || locals[1].Value != Null {
+			t.Errorf("%s: b is not null when f is called: %v", backend.name, locals)
+		}
+	}
+}
+
+// callRecorder records the locals of functions when they are called
+type callRecorder struct {
+	locals map[string][]Variable
+}
+
+func (r *callRecorder) Instruction(vm *VM) error { return nil }
+func (r *callRecorder) Return(vm *VM) error      { return nil }
+
+func (r *callRecorder) Call(vm *VM) error {
+	if r.locals == nil {
+		r.locals = map[string][]Variable{}
+	}
+	r.locals[vm.StackFrame(0).Function] = vm.Locals(0)
+	return nil
+}
+
+func TestHookStopsProgram(t *testing.T) {
+	for _, backend := range backends {
+		r := &recorder{stopAfter: 3}
+		err := New(compileWith(t, `let f = fn() { 1 }; f(); f()`, backend.options), WithHook(r)).Run()
+
+		var runtimeErr *RuntimeError
+		if !errors.As(err, &runtimeErr) {
+			t.Fatalf("%s: not a *RuntimeError: %T (%v)", backend.name, err, err)
+		}
+		if runtimeErr.Kind != KindCanceled || !errors.Is(err, errStop) {
+			t.Errorf("%s: wrong error: %s (%s)", backend.name, err, runtimeErr.Kind)
+		}
+		if r.steps != 3 {
+			t.Errorf("%s: the program continued for %d instructions", backend.name, r.steps)
+		}
+	}
+}
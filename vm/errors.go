@@ -0,0 +1,180 @@
+package vm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"monc/code"
+	"monc/object"
+)
+
+// ErrorKind classifies the errors Run returns
+type ErrorKind int
+
+const (
+	KindInternal        ErrorKind = iota // malformed bytecode, or a bug of the VM
+	KindTypeMismatch                     // operands of different types, e.g. 1 + true
+	KindUnknownOperator                  // operator undefined for its operands, e.g. -true
+	KindNotCallable                      // calling something other than a function
+	KindWrongArguments                   // calling a function with the wrong number of arguments
+	KindUnhashable                       // using a value as hash key that cannot be one
+	KindNotIndexable                     // indexing something other than an array or hash
+	KindDivisionByZero
+	KindIndexOutOfRange // assigning to an element past the end of an array
+	KindStackOverflow
+	KindRecursionDepth
+	KindBudgetExceeded
+	KindMemoryExceeded
+	KindGlobalsExhausted
+	KindCanceled // the context of RunContext is done, or a Hook stopped the program
+)
+
+var kindNames = map[ErrorKind]string{
+	KindInternal:         "internal error",
+	KindTypeMismatch:     "type mismatch",
+	KindUnknownOperator:  "unknown operator",
+	KindNotCallable:      "not callable",
+	KindWrongArguments:   "wrong arguments",
+	KindUnhashable:       "unhashable",
+	KindNotIndexable:     "not indexable",
+	KindDivisionByZero:   "division by zero",
+	KindIndexOutOfRange:  "index out of range",
+	KindStackOverflow:    "stack overflow",
+	KindRecursionDepth:   "recursion depth",
+	KindBudgetExceeded:   "budget exceeded",
+	KindMemoryExceeded:   "memory exceeded",
+	KindGlobalsExhausted: "globals exhausted",
+	KindCanceled:         "canceled",
+}
+
+func (k ErrorKind) String() string {
+	if name, ok := kindNames[k]; ok {
+		return name
+	}
+	return fmt.Sprintf("ErrorKind(%d)", int(k))
+}
+
+/*
+RuntimeError is returned by Run when the program fails. Error returns only
+the message, worded like the *object.Error the evaluator produces for the
+same program; the trace is printed separately, one frame per line.
+
+Op and IP are the failing instruction and its offset in the instructions of
+the innermost frame of Trace. Errors of the limits, like ErrStackOverflow,
+are Err, so errors.Is finds them through a *RuntimeError.
+*/
+type RuntimeError struct {
+	Kind  ErrorKind
+	Op    code.Opcode
+	IP    int
+	Types []object.ObjectType // of the offending values, in operand order
+	Err   error
+	Trace StackTrace
+}
+
+func (e *RuntimeError) Error() string { return e.Err.Error() }
+func (e *RuntimeError) Unwrap() error { return e.Err }
+
+// newError returns an error of the instructions, which Run completes with
+// the position and trace
+func newError(kind ErrorKind, types []object.ObjectType, format string, a ...interface{}) *RuntimeError {
+	return &RuntimeError{Kind: kind, Types: types, Err: fmt.Errorf(format, a...)}
+}
+
+func typeMismatch(op code.Opcode, left, right object.ObjectType) *RuntimeError {
+	return newError(KindTypeMismatch, []object.ObjectType{left, right},
+		"type mismatch: %s %s %s", left, operators[op], right)
+}
+
+func unknownOperator(op code.Opcode, left, right object.ObjectType) *RuntimeError {
+	return newError(KindUnknownOperator, []object.ObjectType{left, right},
+		"unknown operator: %s %s %s", left, operators[op], right)
+}
+
+func notCallable(callee object.ObjectType) *RuntimeError {
+	return newError(KindNotCallable, []object.ObjectType{callee}, "not a function: %s", callee)
+}
+
+func wrongArguments(callee *object.Closure, got int) *RuntimeError {
+	return newError(KindWrongArguments, []object.ObjectType{callee.Type()},
+		"wrong number of arguments: want=%d, got=%d", callee.Fn.NumParameters, got)
+}
+
+func unhashable(key object.ObjectType) *RuntimeError {
+	return newError(KindUnhashable, []object.ObjectType{key}, "unusable as hash key: %s", key)
+}
+
+func divisionByZero() *RuntimeError {
+	return newError(KindDivisionByZero, []object.ObjectType{object.INTEGER_OBJ, object.INTEGER_OBJ},
+		"division by zero")
+}
+
+func indexOutOfRange(index object.Object, length int) *RuntimeError {
+	return newError(KindIndexOutOfRange, []object.ObjectType{object.ARRAY_OBJ, index.Type()},
+		"index out of range: %s with length %d", index.Inspect(), length)
+}
+
+// operators are the source operators of the stack opcodes of binary
+// operations
+var operators = map[code.Opcode]string{
+	code.OpAdd:         "+",
+	code.OpSub:         "-",
+	code.OpMul:         "*",
+	code.OpDiv:         "/",
+	code.OpEqual:       "==",
+	code.OpNotEqual:    "!=",
+	code.OpGreaterThan: ">",
+	code.OpLessThan:    "<",
+}
+
+// runtimeError completes err, returned by the instruction the current frame
+// is at, into the *RuntimeError Run returns
+func (vm *VM) runtimeError(err error) *RuntimeError {
+	var e *RuntimeError
+	if !errors.As(err, &e) {
+		e = &RuntimeError{Kind: kindOf(err), Err: err}
+	}
+
+	frame := vm.currentFrame()
+	e.IP, e.Op = instructionAt(frame.Instructions(), frame.ip)
+	e.Trace = vm.StackTrace()
+	return e
+}
+
+// kindOf classifies the errors that are not created with a kind
+func kindOf(err error) ErrorKind {
+	switch {
+	case errors.Is(err, ErrStackOverflow):
+		return KindStackOverflow
+	case errors.Is(err, ErrRecursionDepth):
+		return KindRecursionDepth
+	case errors.Is(err, ErrBudgetExceeded):
+		return KindBudgetExceeded
+	case errors.Is(err, ErrMemoryExceeded):
+		return KindMemoryExceeded
+	case errors.Is(err, ErrGlobalsExhausted):
+		return KindGlobalsExhausted
+	case errors.Is(err, context.Canceled), errors.Is(err, context.DeadlineExceeded):
+		return KindCanceled
+	}
+	return KindInternal
+}
+
+// instructionAt finds the instruction ip is part of: the loops advance the
+// ip over the operands before executing an instruction, so an error can
+// leave it anywhere within. An OpWide prefix counts as part of its
+// instruction.
+func instructionAt(ins code.Instructions, ip int) (int, code.Opcode) {
+	start := 0
+	for start < len(ins) {
+		op, _, n, err := code.Decode(ins, start)
+		if err != nil {
+			return start, code.Opcode(ins[start])
+		}
+		if start+n > ip {
+			return start, op
+		}
+		start += n
+	}
+	return start, 0
+}
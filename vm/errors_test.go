@@ -0,0 +1,245 @@
+package vm
+
+import (
+	"errors"
+	"fmt"
+	"monc/code"
+	"monc/compiler"
+	"monc/evaluator"
+	"monc/object"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestRuntimeErrors(t *testing.T) {
+	tests := []struct {
+		input      string
+		kind       ErrorKind
+		message    string
+		types      []object.ObjectType
+		stackOp    code.Opcode
+		registerOp code.Opcode
+	}{
+		{`5 + true`, KindTypeMismatch, "type mismatch: INTEGER + BOOLEAN",
+			[]object.ObjectType{object.INTEGER_OBJ, object.BOOLEAN_OBJ}, code.OpAdd, code.OpRAdd},
+		{`"a" - "b"`, KindUnknownOperator, "unknown operator: STRING - STRING",
+			[]object.ObjectType{object.STRING_OBJ, object.STRING_OBJ}, code.OpSub, code.OpRSub},
+		{`true > false`, KindUnknownOperator, "unknown operator: BOOLEAN > BOOLEAN",
+			[]object.ObjectType{object.BOOLEAN_OBJ, object.BOOLEAN_OBJ}, code.OpGreaterThan, code.OpRGreaterThan},
+		{`1 < "a"`, KindTypeMismatch, "type mismatch: INTEGER < STRING",
+			[]object.ObjectType{object.INTEGER_OBJ, object.STRING_OBJ}, code.OpLessThan, code.OpRLessThan},
+		{`-true`, KindUnknownOperator, "unknown operator: -BOOLEAN",
+			[]object.ObjectType{object.BOOLEAN_OBJ}, code.OpMinus, code.OpRMinus},
+		{`5()`, KindNotCallable, "not a function: INTEGER",
+			[]object.ObjectType{object.INTEGER_OBJ}, code.OpCall, code.OpRCall},
+		{`fn(a) { a }()`, KindWrongArguments, "wrong number of arguments: want=1, got=0",
+			[]object.ObjectType{object.CLOSURE_OBJ}, code.OpCall, code.OpRCall},
+		{`{{}: 2}`, KindUnhashable, "unusable as hash key: HASH",
+			[]object.ObjectType{object.HASH_OBJ}, code.OpHash, code.OpRHash},
+		{`{1: 2}[{}]`, KindUnhashable, "unusable as hash key: HASH",
+			[]object.ObjectType{object.HASH_OBJ}, code.OpIndex, code.OpRIndex},
+		{`1[0]`, KindNotIndexable, "index operator not supported: INTEGER",
+			[]object.ObjectType{object.INTEGER_OBJ, object.INTEGER_OBJ}, code.OpIndex, code.OpRIndex},
+		{`[][0] = 1`, KindIndexOutOfRange, "index out of range: 0 with length 0",
+			[]object.ObjectType{object.ARRAY_OBJ, object.INTEGER_OBJ}, code.OpSetIndex, code.OpRSetIndex},
+		{`[1][18446744073709551616] = 1`, KindIndexOutOfRange, "index out of range: 18446744073709551616 with length 1",
+			[]object.ObjectType{object.ARRAY_OBJ, object.INTEGER_OBJ}, code.OpSetIndex, code.OpRSetIndex},
+		{`{}[{}] = 1`, KindUnhashable, "unusable as hash key: HASH",
+			[]object.ObjectType{object.HASH_OBJ}, code.OpSetIndex, code.OpRSetIndex},
+		{`"a"[0] = 1`, KindNotIndexable, "index operator not supported: STRING",
+			[]object.ObjectType{object.STRING_OBJ, object.INTEGER_OBJ}, code.OpSetIndex, code.OpRSetIndex},
+		{`let one = 1; let one = one + 1;`, KindTypeMismatch, "type mismatch: NULL + INTEGER",
+			[]object.ObjectType{object.NULL_OBJ, object.INTEGER_OBJ}, code.OpAdd, code.OpRAdd},
+		{`1 / 0`, KindDivisionByZero, "division by zero",
+			[]object.ObjectType{object.INTEGER_OBJ, object.INTEGER_OBJ}, code.OpDiv, code.OpRDiv},
+		{`18446744073709551616 / 0`, KindDivisionByZero, "division by zero",
+			[]object.ObjectType{object.INTEGER_OBJ, object.INTEGER_OBJ}, code.OpDiv, code.OpRDiv},
+	}
+
+	for _, backend := range backends {
+		for _, tt := range tests {
+			bytecode := compileWith(t, tt.input, backend.options)
+
+			err := New(bytecode).Run()
+
+			var runtimeErr *RuntimeError
+			if !errors.As(err, &runtimeErr) {
+				t.Errorf("%s/%q: not a *RuntimeError: %T (%v)", backend.name, tt.input, err, err)
+				continue
+			}
+
+			op := tt.stackOp
+			if bytecode.Registers {
+				op = tt.registerOp
+			}
+
+			if runtimeErr.Kind != tt.kind {
+				t.Errorf("%s/%q: wrong kind. want=%s, got=%s", backend.name, tt.input, tt.kind, runtimeErr.Kind)
+			}
+			if err.Error() != tt.message {
+				t.Errorf("%s/%q: wrong message. want=%q, got=%q", backend.name, tt.input, tt.message, err)
+			}
+			if !reflect.DeepEqual(runtimeErr.Types, tt.types) {
+				t.Errorf("%s/%q: wrong types. want=%v, got=%v", backend.name, tt.input, tt.types, runtimeErr.Types)
+			}
+			if runtimeErr.Op != op {
+				t.Errorf("%s/%q: wrong opcode. want=%d, got=%d", backend.name, tt.input, op, runtimeErr.Op)
+			}
+			if actual := code.Opcode(bytecode.Instructions[runtimeErr.IP]); actual != op {
+				t.Errorf("%s/%q: ip %d is at opcode %d", backend.name, tt.input, runtimeErr.IP, actual)
+			}
+		}
+	}
+}
+
+// TestRuntimeErrorsMatchEvaluator runs programs the VM and the evaluator
+// both reject and compares the messages
+func TestRuntimeErrorsMatchEvaluator(t *testing.T) {
+	inputs := []string{
+		`5 + true`,
+		`5 + true; 5`,
+		`"a" + 1`,
+		`true + false`,
+		`"a" - "b"`,
+		`true > false`,
+		`1 < "a"`,
+		`"a" > 1`,
+		`true < false`,
+		`-true`,
+		`-"a"`,
+		`5()`,
+		`{[1]: 2}`,
+		`1[0]`,
+		`"a"[0]`,
+		`let f = fn(x) { x * false }; f(2)`,
+		`let a = 0; 10 / a`,
+		`let f = fn() { let a = 1; }; f() + 1`,
+		`1 + if (true) { let a = 1; }`,
+		`fn(a) { a }(1, 2)`,
+		`let f = fn(a, b) { a }; f(1)`,
+	}
+
+	for _, input := range inputs {
+		evaluated := evaluator.Eval(parse(input), object.NewEnvironment())
+		expected, ok := evaluated.(*object.Error)
+		if !ok {
+			t.Fatalf("%q: the evaluator returned %T (%+v)", input, evaluated, evaluated)
+		}
+
+		for _, backend := range backends {
+			err := New(compileWith(t, input, backend.options)).Run()
+			if err == nil || err.Error() != expected.Message {
+				t.Errorf("%s/%q: want=%q, got=%v", backend.name, input, expected.Message, err)
+			}
+		}
+	}
+}
+
+func TestRuntimeErrorsOfLimits(t *testing.T) {
+	tests := []struct {
+		input   string
+		options []Option
+		kind    ErrorKind
+		target  error
+	}{
+		{`let f = fn() { f() }; f()`, nil, KindRecursionDepth, ErrRecursionDepth},
+		{`let f = fn(n) { f(n + 1) }; f(0)`, []Option{WithStackSize(100)}, KindStackOverflow, ErrStackOverflow},
+		{`let f = fn(x) { f(x + 1) }; f(0)`, []Option{WithInstructionBudget(100)}, KindBudgetExceeded, ErrBudgetExceeded},
+		{`[1, 2, 3]`, []Option{WithMemoryLimit(10)}, KindMemoryExceeded, ErrMemoryExceeded},
+		{`let a = 1; let b = 2`, []Option{WithGlobalSize(1)}, KindGlobalsExhausted, ErrGlobalsExhausted},
+	}
+
+	for _, backend := range backends {
+		for _, tt := range tests {
+			err := New(compileWith(t, tt.input, backend.options), tt.options...).Run()
+
+			var runtimeErr *RuntimeError
+			if !errors.As(err, &runtimeErr) {
+				t.Errorf("%s/%q: not a *RuntimeError: %T (%v)", backend.name, tt.input, err, err)
+				continue
+			}
+			if runtimeErr.Kind != tt.kind {
+				t.Errorf("%s/%q: wrong kind. want=%s, got=%s", backend.name, tt.input, tt.kind, runtimeErr.Kind)
+			}
+			if !errors.Is(err, tt.target) {
+				t.Errorf("%s/%q: error %q is not %q", backend.name, tt.input, err, tt.target)
+			}
+		}
+	}
+}
+
+// TestRecoverPanics runs malformed bytecode, which makes the VM panic
+func TestRecoverPanics(t *testing.T) {
+	for _, registers := range []bool{false, true} {
+		op := code.OpGetLocal
+		if registers {
+			op = code.OpRPop
+		}
+		bytecode := &compiler.Bytecode{
+			Instructions: code.Make(op, 65535),
+			Registers:    registers,
+		}
+
+		err := New(bytecode).Run()
+
+		var runtimeErr *RuntimeError
+		if !errors.As(err, &runtimeErr) {
+			t.Fatalf("registers=%t: not a *RuntimeError: %T (%v)", registers, err, err)
+		}
+		if runtimeErr.Kind != KindInternal || runtimeErr.Op != op {
+			t.Errorf("registers=%t: wrong error: %s at %d", registers, runtimeErr.Kind, runtimeErr.Op)
+		}
+		if !strings.HasPrefix(err.Error(), "internal error: ") {
+			t.Errorf("registers=%t: wrong message. got=%q", registers, err)
+		}
+	}
+}
+
+// TestWrongInstructionSet runs instructions of the other instruction set,
+// which the VM must not skip as if they had no operands
+func TestWrongInstructionSet(t *testing.T) {
+	tests := []struct {
+		registers    bool
+		instructions code.Instructions
+		expected     string
+	}{
+		{false, concat(code.Make(code.OpRMove, 4096, 4096), code.Make(code.OpNull), code.Make(code.OpPop)),
+			fmt.Sprintf("opcode %d is not a stack instruction", code.OpRMove)},
+		{true, concat(code.Make(code.OpNull), code.Make(code.OpRReturn)),
+			fmt.Sprintf("opcode %d is not a register instruction", code.OpNull)},
+	}
+
+	for _, tt := range tests {
+		bytecode := &compiler.Bytecode{Instructions: tt.instructions, MaxStack: 1, Registers: tt.registers}
+
+		err := New(bytecode).Run()
+
+		var runtimeErr *RuntimeError
+		if !errors.As(err, &runtimeErr) {
+			t.Fatalf("registers=%t: not a *RuntimeError: %T (%v)", tt.registers, err, err)
+		}
+		if runtimeErr.Kind != KindInternal || err.Error() != tt.expected {
+			t.Errorf("registers=%t: wrong error. want=%q, got=%s %q", tt.registers, tt.expected, runtimeErr.Kind, err)
+		}
+	}
+}
+
+func TestErrorKindString(t *testing.T) {
+	if s := KindTypeMismatch.String(); s != "type mismatch" {
+		t.Errorf("wrong string. got=%q", s)
+	}
+	if s := ErrorKind(100).String(); s != "ErrorKind(100)" {
+		t.Errorf("wrong string. got=%q", s)
+	}
+}
+
+func compileWith(t *testing.T, input string, options []compiler.Option) *compiler.Bytecode {
+	t.Helper()
+
+	comp := compiler.New(options...)
+	if err := comp.Compile(parse(input)); err != nil {
+		t.Fatalf("%q: compiler error: %s", input, err)
+	}
+	return comp.Bytecode()
+}
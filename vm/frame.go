@@ -9,6 +9,8 @@ type Frame struct {
 	cl *object.Closure
 	ip int
 	bp int //base pointer or frame pointer
+
+	ret int // register of the caller that receives the result (register VM)
 }
 
 func NewFrame(cl *object.Closure, bp int) *Frame {
@@ -0,0 +1,98 @@
+package vm
+
+import (
+	"errors"
+	"monc/compiler"
+	"monc/evaluator"
+	"monc/lexer"
+	"monc/object"
+	"monc/parser"
+	"strings"
+	"testing"
+)
+
+// FuzzRun runs arbitrary programs on both instruction sets. Programs that
+// do not parse or compile are skipped; every other program must run to a
+// result or a *RuntimeError that is not an internal error, i.e. a
+// recovered panic. Programs that stay within the VM's budgets also run in
+// both evaluators, which have no budgets, and must not give an internal
+// error there either.
+//
+//	go test ./vm -fuzz FuzzRun
+func FuzzRun(f *testing.F) {
+	seeds := []string{
+		`1 + 2 * 3 - 4 / 2`,
+		`1 / 0`,
+		`let a = 5; a / (a - 5)`,
+		`9223372036854775807 + 1`,
+		`-9223372036854775808 / -1`,
+		`return 5; 10`,
+		`if (true) { return 1; }`,
+		`let f = fn() { let a = 1; }; f() + 1`,
+		`1 + if (true) { let y = 2; }`,
+		`[1, 2, 3][true]`,
+		`[1, 2, 3][99999999999999999999]`,
+		`{"a": 1}[fn(x) { x }]`,
+		`first([]) + 1`,
+		`len(1)`,
+		`let f = fn(n) { if (n == 0) { 0 } else { f(n - 1) } }; f(100)`,
+		`let f = fn() { f() }; f()`,
+		`let a = a + 1;`,
+		`let f = fn() { let a = [a, fn() { a }]; a[1]() }; f()`,
+		`let f = fn(x) { f(x) }; f(1);`,
+		`if (false) { let x = 1; }; x + 1`,
+		`let g = fn(){ let y = 5; y }; let f = fn(){ if (false) { let x = 1; }; x }; g(); f()`,
+		`let m = fn(x) { fn(y) { x + y } }; m(1)(2)`,
+		`"a" + "b" == "ab"`,
+		`!-5`,
+	}
+	for _, seed := range seeds {
+		f.Add(seed)
+	}
+
+	f.Fuzz(func(t *testing.T, input string) {
+		p := parser.New(lexer.New(input))
+		program := p.ParseProgram()
+		if len(p.Errors()) != 0 {
+			return
+		}
+
+		bounded := true
+		for _, backend := range backends {
+			comp := compiler.New(backend.options...)
+			if err := comp.Compile(program); err != nil {
+				return
+			}
+
+			machine := New(comp.Bytecode(), WithInstructionBudget(100000), WithMemoryLimit(1<<20))
+			err := machine.Run()
+			if err == nil {
+				continue
+			}
+
+			var runtimeErr *RuntimeError
+			if !errors.As(err, &runtimeErr) {
+				t.Fatalf("%s/%q: not a *RuntimeError: %T (%v)", backend.name, input, err, err)
+			}
+			switch runtimeErr.Kind {
+			case KindInternal:
+				t.Fatalf("%s/%q: %s", backend.name, input, err)
+			case KindBudgetExceeded, KindMemoryExceeded:
+				bounded = false
+			}
+		}
+
+		if !bounded {
+			return
+		}
+		results := map[string]object.Object{
+			"eval":    evaluator.Eval(program, object.NewEnvironment()),
+			"closure": evaluator.Compile(program).Run(),
+		}
+		for engine, result := range results {
+			if errObj, ok := result.(*object.Error); ok && strings.HasPrefix(errObj.Message, "internal error: ") {
+				t.Fatalf("%s/%q: %s", engine, input, errObj.Message)
+			}
+		}
+	})
+}
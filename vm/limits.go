@@ -0,0 +1,214 @@
+package vm
+
+import (
+	"context"
+	"errors"
+	"monc/object"
+)
+
+// defaults of the options below
+const (
+	DefaultStackSize  = 2048
+	DefaultGlobalSize = 65536
+	DefaultMaxFrames  = 1024
+)
+
+// Errors for exceeding the limits of a VM; Run returns them wrapped in a
+// *RuntimeError, so test for them with errors.Is. Cancellation returns the
+// error of the context.
+var (
+	ErrStackOverflow    = errors.New("stack overflow")
+	ErrRecursionDepth   = errors.New("maximum recursion depth exceeded")
+	ErrBudgetExceeded   = errors.New("instruction budget exceeded")
+	ErrMemoryExceeded   = errors.New("memory limit exceeded")
+	ErrGlobalsExhausted = errors.New("too many globals")
+)
+
+// Option configures a VM
+type Option func(*VM)
+
+// WithStackSize limits the number of slots of the stack all frames share.
+// The stack starts small and grows up to it as calls need room.
+func WithStackSize(n int) Option {
+	return func(vm *VM) { vm.stackSize = n }
+}
+
+// WithMaxFrames limits the depth of calls, the main program included
+func WithMaxFrames(n int) Option {
+	return func(vm *VM) { vm.maxFrames = n }
+}
+
+// WithGlobalSize limits the number of globals. It has no effect with
+// NewWithGlobalStore, which is limited by the size of the store.
+func WithGlobalSize(n int) Option {
+	return func(vm *VM) { vm.globalSize = n }
+}
+
+// WithInstructionBudget makes Run fail with ErrBudgetExceeded instead of
+// executing more than n instructions
+func WithInstructionBudget(n int64) Option {
+	return func(vm *VM) { vm.budget = n }
+}
+
+/*
+WithMemoryLimit makes Run fail with ErrMemoryExceeded once the program has
+allocated about n bytes of strings, arrays, hashes and closures. Memory is
+counted when it is allocated, not when it is live, so this caps the work a
+program can do rather than its heap at any one time.
+*/
+func WithMemoryLimit(n int64) Option {
+	return func(vm *VM) { vm.memoryLimit = n }
+}
+
+// initial sizes of the stack and frames, which grow by doubling up to their
+// limits
+const (
+	initialStackSize = 64
+	initialFrames    = 16
+)
+
+// growStack makes the stack hold at least top slots
+func (vm *VM) growStack(top int) error {
+	if top > vm.stackSize {
+		return ErrStackOverflow
+	}
+
+	stack := make([]Value, grown(len(vm.stack), top, vm.stackSize))
+	copy(stack, vm.stack)
+	vm.stack = stack
+	return nil
+}
+
+// growFrames makes room for one more frame than are active
+func (vm *VM) growFrames() error {
+	if vm.framesIndex >= vm.maxFrames {
+		return ErrRecursionDepth
+	}
+
+	frames := make([]*Frame, grown(len(vm.frames), vm.framesIndex+1, vm.maxFrames))
+	copy(frames, vm.frames)
+	vm.frames = frames
+	return nil
+}
+
+// grown doubles size until it is at least need, but not past limit
+func grown(size, need, limit int) int {
+	if size == 0 {
+		size = 1
+	}
+	for size < need {
+		size *= 2
+	}
+	if size > limit {
+		size = limit
+	}
+	return size
+}
+
+// checkInterval is the number of instructions between checks of the
+// context and the budget
+const checkInterval = 1024
+
+// limits is the state of the checks above, embedded in VM
+type limits struct {
+	stackSize  int
+	maxFrames  int
+	globalSize int
+
+	ctx      context.Context
+	budget   int64 // 0 for no budget
+	executed int64 // instructions executed before the current slice
+	slice    int   // length of the current slice
+	steps    int   // instructions left in the current slice
+
+	memoryLimit int64 // 0 for no limit
+	memoryUsed  int64
+}
+
+// checkpoint runs before the first instruction and then every slice. It
+// stops the VM if its context is done or the budget is used up. With a
+// hook, slices are one instruction long and the hook runs last.
+func (vm *VM) checkpoint() error {
+	vm.executed += int64(vm.slice)
+
+	select {
+	case <-vm.ctx.Done():
+		return vm.ctx.Err()
+	default:
+	}
+
+	vm.slice = checkInterval
+	if vm.hook != nil {
+		vm.slice = 1
+	}
+	if vm.budget > 0 {
+		left := vm.budget - vm.executed
+		if left <= 0 {
+			return ErrBudgetExceeded
+		}
+		if left < checkInterval {
+			vm.slice = int(left)
+		}
+	}
+
+	vm.steps = vm.slice
+	if vm.hook != nil {
+		return stopped(vm.hook.Instruction(vm))
+	}
+	return nil
+}
+
+// charge counts the memory of obj, which was just allocated, against the
+// memory limit
+func (vm *VM) charge(obj object.Object) error {
+	if vm.memoryLimit == 0 {
+		return nil
+	}
+	return vm.chargeBytes(sizeOf(obj))
+}
+
+func (vm *VM) chargeBytes(n int64) error {
+	vm.memoryUsed += n
+	if vm.memoryUsed > vm.memoryLimit {
+		return ErrMemoryExceeded
+	}
+	return nil
+}
+
+// sizeOf estimates the memory of obj, 0 for values never charged
+func sizeOf(obj object.Object) int64 {
+	// rough sizes: a slot is an interface value, 16 bytes
+	switch obj := obj.(type) {
+	case *object.String:
+		return int64(16 + len(obj.Value))
+	case *object.Array:
+		return int64(24 + 16*len(obj.Elements))
+	case *object.Hash:
+		return int64(48 + 64*obj.Len())
+	case *object.Closure:
+		return int64(32 + 16*len(obj.Free))
+	}
+	return 0
+}
+
+// applyBuiltin calls fn and charges what it allocated. A builtin that
+// returns its first argument changed it in place, like append, so only
+// the growth of that argument counts.
+func (vm *VM) applyBuiltin(fn *object.Builtin, args []object.Object) (object.Object, error) {
+	var before int64
+	if vm.memoryLimit != 0 && len(args) > 0 {
+		before = sizeOf(args[0])
+	}
+
+	result := fn.Fn(args...)
+	if result == nil {
+		return Null, nil
+	}
+	if len(args) > 0 && result == args[0] {
+		if vm.memoryLimit == 0 {
+			return result, nil
+		}
+		return result, vm.chargeBytes(sizeOf(result) - before)
+	}
+	return result, vm.charge(result)
+}
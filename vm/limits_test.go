@@ -0,0 +1,191 @@
+package vm
+
+import (
+	"context"
+	"errors"
+	"monc/compiler"
+	"testing"
+	"time"
+)
+
+func TestLimits(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		options  []Option
+		expected error
+	}{
+		// 1; 2; 3 is 6 instructions in both instruction sets
+		{"budget used up", "1; 2; 3", []Option{WithInstructionBudget(6)}, nil},
+		{"budget exceeded", "1; 2; 3", []Option{WithInstructionBudget(5)}, ErrBudgetExceeded},
+		{
+			"budget exceeded in a long program",
+			`let f = fn(n) { if (n == 0) { return 0; } f(n - 1) }; f(500); f(500); f(500);`,
+			[]Option{WithInstructionBudget(5000)},
+			ErrBudgetExceeded,
+		},
+		{
+			"memory",
+			`let f = fn(n, s) { if (n == 0) { return s; } f(n - 1, s + s) }; f(60, "ab")`,
+			[]Option{WithMemoryLimit(1 << 20)},
+			ErrMemoryExceeded,
+		},
+		{
+			"memory of arrays",
+			`let f = fn(n, a) { if (n == 0) { return a; } f(n - 1, [a, a, a, a]) }; f(500, [])`,
+			[]Option{WithMemoryLimit(10000)},
+			ErrMemoryExceeded,
+		},
+		{
+			"memory of appends",
+			`let f = fn(n, a) { if (n == 0) { return a; } f(n - 1, append(a, n)) }; f(300, [])`,
+			[]Option{WithMemoryLimit(2000)},
+			ErrMemoryExceeded,
+		},
+		{
+			// only the growth of the array counts, not all of it each time
+			"appends below the limit",
+			`let f = fn(n, a) { if (n == 0) { return a; } f(n - 1, append(a, n)) }; f(300, [])`,
+			[]Option{WithMemoryLimit(1 << 16)},
+			nil,
+		},
+		{
+			"memory of hash assignments",
+			`let h = {}; let f = fn(n) { if (n == 0) { return h; } h[n] = n; f(n - 1) }; f(500)`,
+			[]Option{WithMemoryLimit(10000)},
+			ErrMemoryExceeded,
+		},
+		{
+			"memory below the limit",
+			`let f = fn(n, s) { if (n == 0) { return s; } f(n - 1, s + s) }; f(10, "ab")`,
+			[]Option{WithMemoryLimit(1 << 20)},
+			nil,
+		},
+		{
+			"stack size",
+			`let f = fn(n) { if (n == 0) { return 0; } f(n - 1) }; f(100)`,
+			[]Option{WithStackSize(64)},
+			ErrStackOverflow,
+		},
+		{
+			"frames",
+			`let f = fn(n) { if (n == 0) { return 0; } f(n - 1) }; f(10)`,
+			[]Option{WithMaxFrames(10)},
+			ErrRecursionDepth,
+		},
+		{
+			"frames within the maximum",
+			`let f = fn(n) { if (n == 0) { return 0; } f(n - 1) }; f(8)`,
+			[]Option{WithMaxFrames(10)},
+			nil,
+		},
+		{"globals", "let a = 1; let b = 2;", []Option{WithGlobalSize(1)}, ErrGlobalsExhausted},
+	}
+
+	for _, backend := range backends {
+		for _, tt := range tests {
+			comp := compiler.New(backend.options...)
+			if err := comp.Compile(parse(tt.input)); err != nil {
+				t.Fatalf("compiler error: %s", err)
+			}
+
+			err := New(comp.Bytecode(), tt.options...).Run()
+			if tt.expected == nil && err != nil {
+				t.Errorf("%s/%s: unexpected error: %s", backend.name, tt.name, err)
+			}
+			if tt.expected != nil && !errors.Is(err, tt.expected) {
+				t.Errorf("%s/%s: wrong error. want=%v, got=%v", backend.name, tt.name, tt.expected, err)
+			}
+
+			var runtimeErr *RuntimeError
+			if err != nil && !errors.As(err, &runtimeErr) {
+				t.Errorf("%s/%s: not a *RuntimeError: %T", backend.name, tt.name, err)
+			}
+		}
+	}
+}
+
+func TestGrowth(t *testing.T) {
+	input := `let f = fn(n) { if (n == 0) { return 0; } 1 + f(n - 1) }; f(300)`
+
+	for _, backend := range backends {
+		comp := compiler.New(backend.options...)
+		if err := comp.Compile(parse(input)); err != nil {
+			t.Fatalf("compiler error: %s", err)
+		}
+
+		vm := New(comp.Bytecode())
+		if len(vm.stack) != initialStackSize || len(vm.frames) != initialFrames {
+			t.Errorf("%s: started with %d slots and %d frames", backend.name, len(vm.stack), len(vm.frames))
+		}
+
+		if err := vm.Run(); err != nil {
+			t.Fatalf("%s: unexpected error: %s", backend.name, err)
+		}
+		if err := testIntegerObject(300, vm.LastPoppedStackElem()); err != nil {
+			t.Errorf("%s: %s", backend.name, err)
+		}
+
+		if len(vm.stack) > DefaultStackSize || len(vm.frames) != 512 {
+			t.Errorf("%s: grew to %d slots and %d frames", backend.name, len(vm.stack), len(vm.frames))
+		}
+	}
+
+	// the limits cap the growth, not just double it
+	vm := New(&compiler.Bytecode{}, WithStackSize(40), WithMaxFrames(3))
+	if len(vm.stack) != 40 || len(vm.frames) != 3 {
+		t.Errorf("started with %d slots and %d frames", len(vm.stack), len(vm.frames))
+	}
+	if grown(64, 65, 100) != 100 || grown(64, 65, 1000) != 128 || grown(0, 3, 10) != 4 {
+		t.Errorf("wrong growth")
+	}
+}
+
+func TestRunContext(t *testing.T) {
+	fib := `let fib = fn(x) { if (x < 2) { return x; } fib(x - 1) + fib(x - 2) }; fib(35);`
+
+	for _, backend := range backends {
+		comp := compiler.New(backend.options...)
+		if err := comp.Compile(parse(fib)); err != nil {
+			t.Fatalf("compiler error: %s", err)
+		}
+
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		err := New(comp.Bytecode()).RunContext(ctx)
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("%s: wrong error for a canceled context: %v", backend.name, err)
+		}
+		if runtimeErr, ok := err.(*RuntimeError); !ok || runtimeErr.Kind != KindCanceled || runtimeErr.IP != 0 {
+			t.Errorf("%s: wrong runtime error for a canceled context: %#v", backend.name, err)
+		}
+
+		ctx, cancel = context.WithTimeout(context.Background(), 10*time.Millisecond)
+		start := time.Now()
+		err = New(comp.Bytecode()).RunContext(ctx)
+		cancel()
+		if !errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("%s: wrong error for a deadline: %v", backend.name, err)
+		}
+		if elapsed := time.Since(start); elapsed > time.Second {
+			t.Errorf("%s: stopped %s after the deadline", backend.name, elapsed)
+		}
+	}
+}
+
+func TestNewVerifiedWithOptions(t *testing.T) {
+	comp := compiler.New()
+	if err := comp.Compile(parse("[1, 2, 3]")); err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+
+	if _, err := NewVerified(comp.Bytecode()); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if _, err := NewVerified(comp.Bytecode(), WithStackSize(2)); err == nil {
+		t.Errorf("expected the stack size to be verified")
+	}
+	if _, err := NewVerified(comp.Bytecode(), WithGlobalSize(0)); err != nil {
+		t.Errorf("unexpected error without globals: %s", err)
+	}
+}
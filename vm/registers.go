@@ -0,0 +1,381 @@
+package vm
+
+import (
+	"monc/code"
+	"monc/object"
+)
+
+/*
+runRegisters is run for bytecode compiled with compiler.WithRegisters. The
+registers of a frame are the stack slots from its base pointer on, so a
+call passes its arguments by placing the callee's frame right after the
+register holding the function. The frame, its instructions and its
+registers are kept in locals and only reloaded on calls and returns.
+*/
+func (vm *VM) runRegisters() error {
+	frame := vm.currentFrame()
+	ins := frame.Instructions()
+	regs := vm.stack[frame.bp:]
+
+	for frame.ip < len(ins)-1 {
+		frame.ip++
+		ip := frame.ip
+
+		if vm.steps == 0 {
+			if err := vm.checkpoint(); err != nil {
+				return err
+			}
+		}
+		vm.steps--
+
+		op := code.Opcode(ins[ip])
+
+		switch op {
+		case code.OpRMove:
+			regs[read16(ins, ip+1)] = regs[read16(ins, ip+3)]
+			frame.ip += 4
+
+		case code.OpRConstant:
+			regs[read16(ins, ip+1)] = vm.constants[read16(ins, ip+3)]
+			frame.ip += 4
+
+		case code.OpRTrue:
+			regs[read16(ins, ip+1)] = trueValue
+			frame.ip += 2
+
+		case code.OpRFalse:
+			regs[read16(ins, ip+1)] = falseValue
+			frame.ip += 2
+
+		case code.OpRNull:
+			regs[read16(ins, ip+1)] = nullValue
+			frame.ip += 2
+
+		case code.OpRGetGlobal:
+			regs[read16(ins, ip+1)] = vm.getGlobal(read16(ins, ip+3))
+			frame.ip += 4
+
+		case code.OpRSetGlobal:
+			frame.ip += 4
+			if err := vm.setGlobal(read16(ins, ip+1), regs[read16(ins, ip+3)]); err != nil {
+				return err
+			}
+
+		case code.OpRGetBuiltin:
+			regs[read16(ins, ip+1)] = Value{obj: object.Builtins[ins[ip+3]].Builtin}
+			frame.ip += 3
+
+		case code.OpRGetFree:
+			regs[read16(ins, ip+1)] = ValueOf(frame.cl.Free[ins[ip+3]])
+			frame.ip += 3
+
+		case code.OpRCurrentClosure:
+			regs[read16(ins, ip+1)] = Value{obj: frame.cl}
+			frame.ip += 2
+
+		case code.OpRAdd, code.OpRSub, code.OpRMul, code.OpRDiv,
+			code.OpREqual, code.OpRNotEqual, code.OpRGreaterThan, code.OpRLessThan:
+			frame.ip += 6
+			result, err := registerBinary(op, regs[read16(ins, ip+3)], regs[read16(ins, ip+5)])
+			if err != nil {
+				return err
+			}
+			regs[read16(ins, ip+1)] = result
+			if err := vm.charge(result.obj); err != nil {
+				return err
+			}
+
+		case code.OpRMinus:
+			frame.ip += 4
+			result, err := minusOperator(regs[read16(ins, ip+3)])
+			if err != nil {
+				return err
+			}
+			regs[read16(ins, ip+1)] = result
+
+		case code.OpRBang:
+			regs[read16(ins, ip+1)] = bangOperator(regs[read16(ins, ip+3)])
+			frame.ip += 4
+
+		case code.OpRJump:
+			frame.ip = read16(ins, ip+1) - 1
+
+		case code.OpRJumpIf:
+			frame.ip += 4
+			if !isTruthy(regs[read16(ins, ip+3)]) {
+				frame.ip = read16(ins, ip+1) - 1
+			}
+
+		case code.OpRArray:
+			frame.ip += 6
+			first, n := read16(ins, ip+3), read16(ins, ip+5)
+			array := buildArray(regs[first : first+n])
+			regs[read16(ins, ip+1)] = Value{obj: array}
+			if err := vm.charge(array); err != nil {
+				return err
+			}
+
+		case code.OpRHash:
+			frame.ip += 6
+			first, n := read16(ins, ip+3), read16(ins, ip+5)
+			hash, err := buildHash(regs[first : first+n])
+			if err != nil {
+				return err
+			}
+			regs[read16(ins, ip+1)] = Value{obj: hash}
+			if err := vm.charge(hash); err != nil {
+				return err
+			}
+
+		case code.OpRIndex:
+			frame.ip += 6
+			result, err := indexExpression(regs[read16(ins, ip+3)], regs[read16(ins, ip+5)])
+			if err != nil {
+				return err
+			}
+			regs[read16(ins, ip+1)] = result
+
+		case code.OpRSetIndex:
+			frame.ip += 6
+			if err := vm.setIndex(regs[read16(ins, ip+1)], regs[read16(ins, ip+3)], regs[read16(ins, ip+5)]); err != nil {
+				return err
+			}
+
+		case code.OpRCall:
+			frame.ip += 5
+			if err := vm.callRegisters(read16(ins, ip+1), read16(ins, ip+3), int(ins[ip+5])); err != nil {
+				return err
+			}
+			frame = vm.currentFrame()
+			ins = frame.Instructions()
+			regs = vm.stack[frame.bp:]
+
+		case code.OpRClosure:
+			frame.ip += 7
+			err := vm.registerClosure(read16(ins, ip+1), read16(ins, ip+3), read16(ins, ip+5), int(ins[ip+7]))
+			if err != nil {
+				return err
+			}
+
+		case code.OpRReturnValue, code.OpRReturn:
+			value := nullValue
+			if op == code.OpRReturnValue {
+				value = regs[read16(ins, ip+1)]
+			}
+
+			// a return in the main program ends it
+			if vm.framesIndex == 1 {
+				vm.lastPopped = value
+				return nil
+			}
+			if vm.hook != nil {
+				if err := stopped(vm.hook.Return(vm)); err != nil {
+					return err
+				}
+			}
+
+			returned := vm.popFrame()
+			frame = vm.currentFrame()
+			ins = frame.Instructions()
+			regs = vm.stack[frame.bp:]
+			regs[returned.ret] = value
+
+		case code.OpRPop:
+			vm.lastPopped = regs[read16(ins, ip+1)]
+			frame.ip += 2
+
+		case code.OpWide:
+			if err := vm.executeRegisterWide(ins, ip); err != nil {
+				return err
+			}
+			frame = vm.currentFrame()
+			ins = frame.Instructions()
+			regs = vm.stack[frame.bp:]
+
+		default:
+			return newError(KindInternal, nil, "opcode %d is not a register instruction", op)
+		}
+	}
+
+	return nil
+}
+
+// executeRegisterWide runs the register instruction following an OpWide
+// prefix, decoding it generically like executeWide
+func (vm *VM) executeRegisterWide(ins code.Instructions, ip int) error {
+	op, operands, n, err := code.Decode(ins, ip)
+	if err != nil {
+		return err
+	}
+
+	frame := vm.currentFrame()
+	frame.ip += n - 1
+	regs := vm.stack[frame.bp:]
+
+	switch op {
+	case code.OpRConstant:
+		regs[operands[0]] = vm.constants[operands[1]]
+
+	case code.OpRGetGlobal:
+		regs[operands[0]] = vm.getGlobal(operands[1])
+
+	case code.OpRSetGlobal:
+		return vm.setGlobal(operands[0], regs[operands[1]])
+
+	case code.OpRGetBuiltin:
+		regs[operands[0]] = Value{obj: object.Builtins[operands[1]].Builtin}
+
+	case code.OpRGetFree:
+		regs[operands[0]] = ValueOf(frame.cl.Free[operands[1]])
+
+	case code.OpRJump:
+		frame.ip = operands[0] - 1
+
+	case code.OpRJumpIf:
+		if !isTruthy(regs[operands[1]]) {
+			frame.ip = operands[0] - 1
+		}
+
+	case code.OpRArray:
+		array := buildArray(regs[operands[1] : operands[1]+operands[2]])
+		regs[operands[0]] = Value{obj: array}
+		return vm.charge(array)
+
+	case code.OpRHash:
+		hash, err := buildHash(regs[operands[1] : operands[1]+operands[2]])
+		if err != nil {
+			return err
+		}
+		regs[operands[0]] = Value{obj: hash}
+		return vm.charge(hash)
+
+	case code.OpRCall:
+		return vm.callRegisters(operands[0], operands[1], operands[2])
+
+	case code.OpRClosure:
+		return vm.registerClosure(operands[0], operands[1], operands[2], operands[3])
+
+	default:
+		return newError(KindInternal, nil, "opcode %d cannot be widened", op)
+	}
+
+	return nil
+}
+
+// callRegisters calls the function in register fn of the current frame
+// with the n arguments in the registers after it. The result of a builtin
+// is stored in dst right away, that of a closure when it returns.
+func (vm *VM) callRegisters(dst, fn, n int) error {
+	frame := vm.currentFrame()
+	base := frame.bp + fn
+
+	switch callee := vm.stack[base].obj.(type) {
+	case *object.Closure:
+		if n != callee.Fn.NumParameters {
+			return wrongArguments(callee, n)
+		}
+
+		if vm.framesIndex == len(vm.frames) {
+			if err := vm.growFrames(); err != nil {
+				return err
+			}
+		}
+
+		// registers are only ever accessed within the frame, so like in
+		// callClosure this is the only check. Growing moves the stack, which
+		// the caller reloads its registers from after the call.
+		bp := base + 1
+		if top := bp + callee.Fn.NumLocals + callee.Fn.MaxStack; top > len(vm.stack) {
+			if err := vm.growStack(top); err != nil {
+				return err
+			}
+		}
+
+		next := NewFrame(callee, bp)
+		next.ret = dst
+		vm.pushFrame(next)
+		vm.clearLocals(next)
+
+		if vm.hook != nil {
+			return vm.called()
+		}
+
+	case *object.Builtin:
+		result, err := vm.applyBuiltin(callee, objectsOf(vm.stack[base+1:base+1+n]))
+		vm.stack[frame.bp+dst] = ValueOf(result)
+		return err
+
+	default:
+		return notCallable(vm.stack[base].Type())
+	}
+
+	return nil
+}
+
+func (vm *VM) registerClosure(dst, constIndex, first, n int) error {
+	function, ok := vm.constants[constIndex].obj.(*object.CompiledFn)
+	if !ok {
+		return newError(KindInternal, nil, "constant %d is not a function", constIndex)
+	}
+
+	regs := vm.stack[vm.currentFrame().bp:]
+
+	closure := &object.Closure{Fn: function, Free: objectsOf(regs[first : first+n])}
+	regs[dst] = Value{obj: closure}
+	return vm.charge(closure)
+}
+
+// registerBinary evaluates the arithmetic and comparison instructions of
+// the register VM, going through the stack VM's helpers for everything but
+// integers so both report the same errors
+func registerBinary(op code.Opcode, left, right Value) (Value, error) {
+	if left.tag == tagInt && right.tag == tagInt {
+		// overflows fall through to binaryOperation, which promotes
+		switch op {
+		case code.OpRAdd:
+			if n, ok := object.AddInt64(left.n, right.n); ok {
+				return integerValue(n), nil
+			}
+		case code.OpRSub:
+			if n, ok := object.SubInt64(left.n, right.n); ok {
+				return integerValue(n), nil
+			}
+		case code.OpRMul:
+			if n, ok := object.MulInt64(left.n, right.n); ok {
+				return integerValue(n), nil
+			}
+		case code.OpREqual:
+			return booleanValue(left.n == right.n), nil
+		case code.OpRNotEqual:
+			return booleanValue(left.n != right.n), nil
+		case code.OpRGreaterThan:
+			return booleanValue(left.n > right.n), nil
+		case code.OpRLessThan:
+			return booleanValue(left.n < right.n), nil
+		}
+	}
+
+	switch op {
+	case code.OpRAdd:
+		return binaryOperation(code.OpAdd, left, right)
+	case code.OpRSub:
+		return binaryOperation(code.OpSub, left, right)
+	case code.OpRMul:
+		return binaryOperation(code.OpMul, left, right)
+	case code.OpRDiv:
+		return binaryOperation(code.OpDiv, left, right)
+	case code.OpREqual:
+		return comparison(code.OpEqual, left, right)
+	case code.OpRNotEqual:
+		return comparison(code.OpNotEqual, left, right)
+	case code.OpRLessThan:
+		return comparison(code.OpLessThan, left, right)
+	}
+	return comparison(code.OpGreaterThan, left, right)
+}
+
+// read16 reads the 2 byte operand at ins[pos]; it is inlined, unlike
+// code.ReadUint16 on a subslice
+func read16(ins code.Instructions, pos int) int {
+	return int(ins[pos])<<8 | int(ins[pos+1])
+}
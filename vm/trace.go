@@ -0,0 +1,68 @@
+package vm
+
+import (
+	"fmt"
+	"strings"
+)
+
+// StackFrame is one call in a Monkey stack trace
+type StackFrame struct {
+	Function string // "<main>" for the main program, "<anonymous>" if unnamed
+	Source   string // empty if unknown
+	Line     int    // 0 if unknown
+	Column   int
+}
+
+func (f StackFrame) String() string {
+	pos := f.Source
+	if f.Line > 0 {
+		if pos != "" {
+			pos += ":"
+		}
+		pos += fmt.Sprintf("%d:%d", f.Line, f.Column)
+	}
+	if pos == "" {
+		pos = "unknown"
+	}
+	return fmt.Sprintf("at %s (%s)", f.Function, pos)
+}
+
+// StackTrace lists the calls active when an error occurred, innermost
+// first
+type StackTrace []StackFrame
+
+func (st StackTrace) String() string {
+	return strings.Join(st.Lines(), "\n")
+}
+
+// Lines formats the trace one frame per line. A run of identical frames,
+// like those of a recursion that overflowed the stack, is listed once and
+// followed by a count of the others.
+func (st StackTrace) Lines() []string {
+	lines := []string{}
+	for i := 0; i < len(st); {
+		n := 1
+		for i+n < len(st) && st[i+n] == st[i] {
+			n++
+		}
+
+		lines = append(lines, st[i].String())
+		switch {
+		case n == 2:
+			lines = append(lines, "... repeated 1 more time")
+		case n > 2:
+			lines = append(lines, fmt.Sprintf("... repeated %d more times", n-1))
+		}
+		i += n
+	}
+	return lines
+}
+
+// StackTrace walks the active frames from the innermost one out
+func (vm *VM) StackTrace() StackTrace {
+	trace := make(StackTrace, vm.framesIndex)
+	for n := range trace {
+		trace[n] = vm.StackFrame(n)
+	}
+	return trace
+}
@@ -0,0 +1,94 @@
+package vm
+
+import (
+	"errors"
+	"monc/code"
+	"monc/compiler"
+	"testing"
+)
+
+func TestRuntimeErrorStackTrace(t *testing.T) {
+	input := `let inner = fn(x) {
+  x(1)
+};
+let outer = fn() {
+  let y = 2;
+  inner(y)
+};
+outer();`
+
+	comp := compiler.New()
+	if err := comp.Compile(parse(input)); err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+
+	bytecode := comp.Bytecode()
+	bytecode.Source = "trace.mk"
+
+	err := New(bytecode).Run()
+	if err == nil {
+		t.Fatalf("expected runtime error")
+	}
+
+	var runtimeErr *RuntimeError
+	if !errors.As(err, &runtimeErr) {
+		t.Fatalf("error is not a *RuntimeError. got=%T (%+v)", err, err)
+	}
+
+	if err.Error() != "not a function: INTEGER" {
+		t.Errorf("wrong message. got=%q", err.Error())
+	}
+	if runtimeErr.Kind != KindNotCallable || runtimeErr.Op != code.OpCall {
+		t.Errorf("wrong kind or opcode. got=%s, %d", runtimeErr.Kind, runtimeErr.Op)
+	}
+
+	expected := `at inner (trace.mk:2:4)
+at outer (trace.mk:6:8)
+at <main> (trace.mk:8:6)`
+
+	if trace := runtimeErr.Trace.String(); trace != expected {
+		t.Errorf("wrong stack trace.\nwant=\n%s\ngot=\n%s", expected, trace)
+	}
+}
+
+func TestStackTraceCollapsesRepeatedFrames(t *testing.T) {
+	f := StackFrame{Function: "f", Source: "a.mk", Line: 1, Column: 20}
+	g := StackFrame{Function: "g", Source: "a.mk", Line: 2, Column: 3}
+	main := StackFrame{Function: "<main>", Source: "a.mk", Line: 3, Column: 2}
+
+	tests := []struct {
+		trace    StackTrace
+		expected string
+	}{
+		{StackTrace{f, main}, "at f (a.mk:1:20)\nat <main> (a.mk:3:2)"},
+		{StackTrace{f, f, main}, "at f (a.mk:1:20)\n... repeated 1 more time\nat <main> (a.mk:3:2)"},
+		{StackTrace{g, f, f, f, f, main}, "at g (a.mk:2:3)\nat f (a.mk:1:20)\n... repeated 3 more times\nat <main> (a.mk:3:2)"},
+		// frames at other positions of the same function are not repeats
+		{StackTrace{f, {Function: "f", Source: "a.mk", Line: 1, Column: 9}}, "at f (a.mk:1:20)\nat f (a.mk:1:9)"},
+		{StackTrace{}, ""},
+	}
+
+	for _, tt := range tests {
+		if actual := tt.trace.String(); actual != tt.expected {
+			t.Errorf("wrong stack trace.\nwant=\n%s\ngot=\n%s", tt.expected, actual)
+		}
+	}
+}
+
+func TestStackFrameString(t *testing.T) {
+	tests := []struct {
+		frame    StackFrame
+		expected string
+	}{
+		{StackFrame{Function: "f", Source: "a.mk", Line: 1, Column: 2}, "at f (a.mk:1:2)"},
+		{StackFrame{Function: "<anonymous>", Line: 3, Column: 4}, "at <anonymous> (3:4)"},
+		{StackFrame{Function: "<main>", Source: "a.mk"}, "at <main> (a.mk)"},
+		{StackFrame{Function: "<main>"}, "at <main> (unknown)"},
+	}
+
+	for _, tt := range tests {
+		if actual := tt.frame.String(); actual != tt.expected {
+			t.Errorf("wrong string. want=%q, got=%q", tt.expected, actual)
+		}
+	}
+}
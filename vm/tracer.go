@@ -0,0 +1,104 @@
+package vm
+
+import (
+	"fmt"
+	"io"
+	"monc/code"
+	"strings"
+)
+
+// DefaultTraceValues is the number of stack values a Tracer shows
+const DefaultTraceValues = 3
+
+/*
+Tracer is a Hook that writes a line for every instruction the VM executes:
+the depth of its frame, the function, the offset of the instruction, its
+opcode and operands, and the values on top of the stack, topmost last. For
+bytecode compiled with registers, which has no operand stack, it shows the
+first registers of the frame instead. Unset slots show as _.
+
+Install it with WithHook; a VM without a hook does not pay for tracing.
+*/
+type Tracer struct {
+	Functions []string // trace only these, named like in stack traces; all if empty
+	Values    int      // the number of stack values shown
+
+	w io.Writer
+}
+
+func NewTracer(w io.Writer) *Tracer {
+	return &Tracer{Values: DefaultTraceValues, w: w}
+}
+
+func (t *Tracer) Instruction(vm *VM) error {
+	function := vm.StackFrame(0).Function
+	if len(t.Functions) > 0 && !contains(t.Functions, function) {
+		return nil
+	}
+
+	frame := vm.currentFrame()
+	_, err := fmt.Fprintf(t.w, "%3d %-12s %04d %-28s [%s]\n", vm.Depth(), function, frame.ip,
+		decodeAt(frame.Instructions(), frame.ip), strings.Join(t.values(vm, frame), " "))
+	return err
+}
+
+func (t *Tracer) Call(vm *VM) error { return nil }
+
+func (t *Tracer) Return(vm *VM) error { return nil }
+
+// decodeAt formats the instruction at ip, folding an OpWide prefix into it
+func decodeAt(ins code.Instructions, ip int) string {
+	def, err := code.Lookup(ins[ip])
+	if err != nil {
+		return err.Error()
+	}
+
+	var operands []int
+	if code.Opcode(ins[ip]) == code.OpWide && ip+1 < len(ins) {
+		if def, err = code.Lookup(ins[ip+1]); err != nil {
+			return err.Error()
+		}
+		operands, _ = code.ReadWideOperands(def, ins[ip+2:])
+	} else {
+		operands, _ = code.ReadOperands(def, ins[ip+1:])
+	}
+
+	out := def.Name
+	for _, o := range operands {
+		out += fmt.Sprintf(" %d", o)
+	}
+	return out
+}
+
+// values formats the top of the stack of frame, or its first registers
+func (t *Tracer) values(vm *VM, frame *Frame) []string {
+	fn := frame.cl.Fn
+	first, last := frame.bp+fn.NumLocals, vm.sp
+	if vm.registers {
+		first, last = frame.bp, frame.bp+fn.NumLocals+fn.MaxStack
+		if last > first+t.Values {
+			last = first + t.Values
+		}
+	} else if first < last-t.Values {
+		first = last - t.Values
+	}
+
+	values := make([]string, 0, last-first)
+	for _, v := range vm.stack[first:last] {
+		if obj := v.Object(); obj != nil {
+			values = append(values, obj.Inspect())
+		} else {
+			values = append(values, "_")
+		}
+	}
+	return values
+}
+
+func contains(names []string, name string) bool {
+	for _, n := range names {
+		if n == name {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,94 @@
+package vm
+
+import (
+	"bytes"
+	"errors"
+	"monc/code"
+	"strings"
+	"testing"
+)
+
+func TestTracer(t *testing.T) {
+	expected := `  1 <main>       0000 OpConstant 0                 []
+  1 <main>       0003 OpSetGlobal 0                [2]
+  1 <main>       0006 OpGetGlobal 0                []
+  1 <main>       0009 OpConstant 1                 [2]
+  1 <main>       0012 OpMul                        [2 3]
+  1 <main>       0013 OpPop                        [6]
+`
+
+	var out bytes.Buffer
+	if err := New(compileWith(t, `let x = 2; x * 3`, nil), WithHook(NewTracer(&out))).Run(); err != nil {
+		t.Fatalf("vm error: %s", err)
+	}
+	if out.String() != expected {
+		t.Errorf("wrong trace.\nwant:\n%s\ngot:\n%s", expected, out.String())
+	}
+}
+
+func TestTracerFunctions(t *testing.T) {
+	input := `let sq = fn(x) { x * x }; let f = fn() { sq(3) }; f() + sq(2)`
+
+	for _, backend := range backends {
+		var out bytes.Buffer
+		tracer := NewTracer(&out)
+		tracer.Functions = []string{"sq"}
+		tracer.Values = 2
+		if err := New(compileWith(t, input, backend.options), WithHook(tracer)).Run(); err != nil {
+			t.Fatalf("%s: vm error: %s", backend.name, err)
+		}
+
+		depths := map[string]int{}
+		for _, line := range strings.Split(strings.TrimSuffix(out.String(), "\n"), "\n") {
+			fields := strings.Fields(line)
+			if len(fields) < 3 || fields[1] != "sq" {
+				t.Fatalf("%s: traced another function: %q", backend.name, line)
+			}
+			if strings.Contains(line, "ReturnValue") {
+				depths[fields[0]]++
+			}
+			if values := line[strings.LastIndex(line, "[")+1 : len(line)-1]; len(strings.Fields(values)) > 2 {
+				t.Errorf("%s: more than 2 values: %q", backend.name, line)
+			}
+		}
+		// sq returns once from f, at depth 3, and once from main
+		if depths["2"] != 1 || depths["3"] != 1 {
+			t.Errorf("%s: wrong returns of sq by depth: %v", backend.name, depths)
+		}
+	}
+}
+
+func TestTracerRegisters(t *testing.T) {
+	var out bytes.Buffer
+	input := `let add = fn(a, b) { a + b }; add(1, 2)`
+	if err := New(compileWith(t, input, backends[1].options), WithHook(NewTracer(&out))).Run(); err != nil {
+		t.Fatalf("vm error: %s", err)
+	}
+
+	want := "  2 add          0000 OpRAdd 2 0 1                 [1 2 _]\n"
+	if !strings.Contains(out.String(), want) {
+		t.Errorf("trace does not contain %q. got=\n%s", want, out.String())
+	}
+}
+
+func TestDecodeWide(t *testing.T) {
+	ins := code.Instructions(code.Make(code.OpGetLocal, 300))
+	if got := decodeAt(ins, 0); got != "OpGetLocal 300" {
+		t.Errorf("wrong instruction. want=%q, got=%q", "OpGetLocal 300", got)
+	}
+}
+
+type failingWriter struct{}
+
+var errWrite = errors.New("disk full")
+
+func (failingWriter) Write(p []byte) (int, error) { return 0, errWrite }
+
+func TestTracerWriteError(t *testing.T) {
+	err := New(compileWith(t, `1`, nil), WithHook(NewTracer(failingWriter{}))).Run()
+
+	var runtimeErr *RuntimeError
+	if !errors.As(err, &runtimeErr) || runtimeErr.Kind != KindCanceled || !errors.Is(err, errWrite) {
+		t.Errorf("wrong error. want a canceled error wrapping %q, got=%v", errWrite, err)
+	}
+}
@@ -0,0 +1,119 @@
+package vm
+
+import "monc/object"
+
+/*
+Value is what the stack, registers, globals and constants of the VM hold.
+Integers and booleans are stored inline, so arithmetic and comparisons do
+not allocate; every other value is its object. Values are converted to
+objects where they leave the VM: as the elements of arrays and hashes, the
+free variables of closures, the arguments of builtins and the results of
+StackTop and LastPoppedStackElem.
+
+An integer that was unboxed from an object keeps it, so storing it in an
+array again does not allocate. The zero Value is the nil object, the value
+of globals never set.
+*/
+type Value struct {
+	tag valueTag
+	n   int64         // the integer, or 1 for true and 0 for false
+	obj object.Object // the object, for tagObject and unboxed integers
+}
+
+type valueTag uint8
+
+const (
+	tagObject valueTag = iota
+	tagInt
+	tagBool
+)
+
+var (
+	trueValue  = Value{tag: tagBool, n: 1}
+	falseValue = Value{tag: tagBool}
+	nullValue  = Value{obj: Null}
+)
+
+func integerValue(n int64) Value {
+	return Value{tag: tagInt, n: n}
+}
+
+func booleanValue(b bool) Value {
+	if b {
+		return trueValue
+	}
+	return falseValue
+}
+
+// ValueOf converts obj, unboxing integers and booleans
+func ValueOf(obj object.Object) Value {
+	switch obj := obj.(type) {
+	case *object.Integer:
+		return Value{tag: tagInt, n: obj.Value, obj: obj}
+	case *object.Boolean:
+		return booleanValue(obj.Value)
+	}
+	return Value{obj: obj}
+}
+
+// Object boxes v, allocating for integers computed by the VM
+func (v Value) Object() object.Object {
+	switch v.tag {
+	case tagInt:
+		if v.obj != nil {
+			return v.obj
+		}
+		return &object.Integer{Value: v.n}
+	case tagBool:
+		return nativeBoolToBooleanObject(v.n != 0)
+	}
+	return v.obj
+}
+
+func (v Value) Type() object.ObjectType {
+	switch v.tag {
+	case tagInt:
+		return object.INTEGER_OBJ
+	case tagBool:
+		return object.BOOLEAN_OBJ
+	}
+	return v.obj.Type()
+}
+
+// identical is the equality of == on anything but two integers: booleans
+// compare by value, objects by identity
+func (v Value) identical(w Value) bool {
+	if v.tag != w.tag {
+		return false
+	}
+	if v.tag == tagObject {
+		return v.obj == w.obj
+	}
+	return v.n == w.n
+}
+
+// equal compares v and w by value, see object.Equal
+func (v Value) equal(w Value) bool {
+	if v.identical(w) {
+		return true
+	}
+	return v.tag == tagObject && w.tag == tagObject && object.Equal(v.obj, w.obj)
+}
+
+// valuesOf converts objects, e.g. the constants of bytecode
+func valuesOf(objects []object.Object) []Value {
+	values := make([]Value, len(objects))
+	for i, obj := range objects {
+		values[i] = ValueOf(obj)
+	}
+	return values
+}
+
+// objectsOf boxes values, e.g. the elements of an array literal
+func objectsOf(values []Value) []object.Object {
+	objects := make([]object.Object, len(values))
+	for i, v := range values {
+		objects[i] = v.Object()
+	}
+	return objects
+}
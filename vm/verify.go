@@ -0,0 +1,270 @@
+package vm
+
+import (
+	"fmt"
+	"monc/code"
+	"monc/compiler"
+	"monc/object"
+)
+
+/*
+Verify checks that bytecode can be run without the VM indexing out of
+range, which Run assumes for speed:
+
+  - every instruction decodes: its opcode is defined, belongs to the
+    stack instruction set and it is not truncated
+  - constant, global, local, free and builtin operands are in range and
+    OpClosure refers to a function
+  - jumps land on instruction boundaries
+  - on every path the stack never underflows, has the same depth where
+    paths meet and stays within the declared MaxStack, which is all the VM
+    checks before running a function
+  - functions end every path with a return; only the main program may run
+    off its end
+
+Bytecode produced by the compiler always verifies; Verify is meant for
+bytecode from anywhere else, like a .mkc file. Sizes are checked against
+the defaults of a VM, use NewVerified for other ones.
+*/
+func Verify(bytecode *compiler.Bytecode) error {
+	return verify(bytecode, DefaultStackSize, DefaultGlobalSize)
+}
+
+func verify(bytecode *compiler.Bytecode, stackSize, globalSize int) error {
+	v := &verifier{
+		constants:  bytecode.Constants,
+		stackSize:  stackSize,
+		globalSize: globalSize,
+		free:       map[int]int{},
+		closures:   map[int]int{},
+	}
+
+	if err := v.function("main", bytecode.Instructions, bytecode.MaxStack, nil, -1); err != nil {
+		return err
+	}
+
+	for i, c := range bytecode.Constants {
+		switch c := c.(type) {
+		case *object.CompiledFn:
+			if err := v.function(fmt.Sprintf("fn#%d", i), c.Instructions, c.MaxStack, c, i); err != nil {
+				return err
+			}
+		case *object.Integer, *object.BigInt, *object.String:
+		default:
+			return fmt.Errorf("verify: constant %d has unsupported type %T", i, c)
+		}
+	}
+
+	// closures must capture at least as many free variables as their
+	// function reads
+	for i := range bytecode.Constants {
+		captured, ok := v.closures[i]
+		if ok && captured < v.free[i] {
+			return fmt.Errorf("verify: fn#%d reads %d free variables but a closure captures %d",
+				i, v.free[i], captured)
+		}
+	}
+
+	return nil
+}
+
+// NewVerified returns a VM for bytecode after checking it with [Verify]
+// against the sizes the options give the VM
+func NewVerified(bytecode *compiler.Bytecode, options ...Option) (*VM, error) {
+	vm := New(bytecode, options...)
+	if err := verify(bytecode, vm.stackSize, vm.globalSize); err != nil {
+		return nil, err
+	}
+	return vm, nil
+}
+
+type verifier struct {
+	constants  []object.Object
+	stackSize  int
+	globalSize int
+
+	free     map[int]int // number of free variables read, by constant index
+	closures map[int]int // smallest capture count of OpClosure, by constant index
+}
+
+type instruction struct {
+	pos      int
+	op       code.Opcode
+	operands []int
+	next     int
+}
+
+// function verifies one instruction sequence that declares maxStack. fn is
+// nil and constIndex -1 for the main program.
+func (v *verifier) function(name string, ins code.Instructions, maxStack int, fn *object.CompiledFn, constIndex int) error {
+	fail := func(pos int, format string, a ...interface{}) error {
+		return fmt.Errorf("verify: %s at %04d: %s", name, pos, fmt.Sprintf(format, a...))
+	}
+
+	numLocals := 0
+	if fn != nil {
+		numLocals = fn.NumLocals
+		if fn.NumParameters > fn.NumLocals {
+			return fmt.Errorf("verify: %s has %d parameters but only %d locals", name, fn.NumParameters, fn.NumLocals)
+		}
+	}
+
+	instructions := []instruction{}
+	index := map[int]int{} // position -> index into instructions
+
+	for pos := 0; pos < len(ins); {
+		op, operands, n, err := code.Decode(ins, pos)
+		if err != nil {
+			return fail(pos, "%s", err)
+		}
+
+		if op >= code.OpRMove {
+			def, _ := code.Lookup(byte(op))
+			return fail(pos, "%s is a register instruction", def.Name)
+		}
+
+		index[pos] = len(instructions)
+		instructions = append(instructions, instruction{pos: pos, op: op, operands: operands, next: pos + n})
+
+		if err := v.operands(op, operands, constIndex, numLocals); err != nil {
+			return fail(pos, "%s", err)
+		}
+
+		pos += n
+	}
+
+	// walk every path, recording the stack depth before each instruction
+	depths := make([]int, len(instructions))
+	for i := range depths {
+		depths[i] = -1
+	}
+
+	type state struct{ index, depth int }
+	work := []state{{0, 0}}
+
+	for len(work) > 0 {
+		s := work[len(work)-1]
+		work = work[:len(work)-1]
+
+		if s.index == len(instructions) {
+			if fn != nil {
+				return fail(len(ins), "function does not return")
+			}
+			continue
+		}
+
+		if depths[s.index] >= 0 {
+			if depths[s.index] != s.depth {
+				return fail(instructions[s.index].pos, "inconsistent stack depth: %d and %d", depths[s.index], s.depth)
+			}
+			continue
+		}
+		depths[s.index] = s.depth
+
+		in := instructions[s.index]
+		def, _ := code.Lookup(byte(in.op))
+		pop, push := def.StackEffect(in.operands)
+		if s.depth < pop {
+			return fail(in.pos, "%s pops %d values from a stack of %d", def.Name, pop, s.depth)
+		}
+
+		depth := s.depth - pop + push
+		if depth > maxStack {
+			return fail(in.pos, "stack depth %d exceeds the declared maximum %d", depth, maxStack)
+		}
+		if numLocals+depth > v.stackSize {
+			return fail(in.pos, "stack depth %d exceeds the stack size", numLocals+depth)
+		}
+
+		jump := func(target int) error {
+			i, ok := index[target]
+			if target == len(ins) {
+				i, ok = len(instructions), true
+			}
+			if !ok {
+				return fail(in.pos, "jump target %d is not an instruction boundary", target)
+			}
+			work = append(work, state{i, depth})
+			return nil
+		}
+
+		switch in.op {
+		case code.OpReturn, code.OpReturnValue:
+			// the path ends, in main by ending the program
+
+		case code.OpJump:
+			if err := jump(in.operands[0]); err != nil {
+				return err
+			}
+
+		case code.OpJumpIf:
+			if err := jump(in.operands[0]); err != nil {
+				return err
+			}
+			if err := jump(in.next); err != nil {
+				return err
+			}
+
+		default:
+			if err := jump(in.next); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// operands checks the operands of op against the sizes of what they index
+func (v *verifier) operands(op code.Opcode, operands []int, constIndex, numLocals int) error {
+	switch op {
+	case code.OpConstant:
+		if operands[0] >= len(v.constants) {
+			return fmt.Errorf("constant %d out of range", operands[0])
+		}
+		if _, ok := v.constants[operands[0]].(*object.CompiledFn); ok {
+			return fmt.Errorf("OpConstant loads function constant %d", operands[0])
+		}
+
+	case code.OpClosure:
+		if operands[0] >= len(v.constants) {
+			return fmt.Errorf("constant %d out of range", operands[0])
+		}
+		if _, ok := v.constants[operands[0]].(*object.CompiledFn); !ok {
+			return fmt.Errorf("OpClosure on constant %d which is not a function", operands[0])
+		}
+		if captured, ok := v.closures[operands[0]]; !ok || operands[1] < captured {
+			v.closures[operands[0]] = operands[1]
+		}
+
+	case code.OpGetGlobal, code.OpSetGlobal:
+		if operands[0] >= v.globalSize {
+			return fmt.Errorf("global %d out of range", operands[0])
+		}
+
+	case code.OpGetLocal, code.OpSetLocal:
+		if operands[0] >= numLocals {
+			return fmt.Errorf("local %d out of range, function has %d", operands[0], numLocals)
+		}
+
+	case code.OpGetFree:
+		if constIndex < 0 {
+			return fmt.Errorf("OpGetFree outside of a function")
+		}
+		if operands[0]+1 > v.free[constIndex] {
+			v.free[constIndex] = operands[0] + 1
+		}
+
+	case code.OpGetBuiltin:
+		if operands[0] >= len(object.Builtins) {
+			return fmt.Errorf("builtin %d out of range", operands[0])
+		}
+
+	case code.OpHash:
+		if operands[0]%2 != 0 {
+			return fmt.Errorf("OpHash with odd number of keys and values %d", operands[0])
+		}
+	}
+
+	return nil
+}
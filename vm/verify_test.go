@@ -0,0 +1,218 @@
+package vm
+
+import (
+	"monc/code"
+	"monc/compiler"
+	"monc/object"
+	"strings"
+	"testing"
+)
+
+func TestVerifyAcceptsValidBytecode(t *testing.T) {
+	fn := &object.CompiledFn{
+		Instructions: concat(
+			code.Make(code.OpGetLocal, 0),
+			code.Make(code.OpGetFree, 0),
+			code.Make(code.OpAdd),
+			code.Make(code.OpReturnValue),
+		),
+		NumLocals:     1,
+		NumParameters: 1,
+		MaxStack:      2,
+	}
+
+	bytecode := &compiler.Bytecode{
+		Instructions: concat(
+			code.Make(code.OpConstant, 0),
+			code.Make(code.OpClosure, 1, 1),
+			code.Make(code.OpConstant, 0),
+			code.Make(code.OpCall, 1),
+			code.Make(code.OpTrue),
+			code.Make(code.OpJumpIf, 21),
+			code.Make(code.OpNull),
+			code.Make(code.OpPop),
+			code.Make(code.OpJump, 21),
+			code.Make(code.OpPop),
+		),
+		Constants: []object.Object{&object.Integer{Value: 1}, fn},
+		MaxStack:  2,
+	}
+
+	if err := Verify(bytecode); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	machine, err := NewVerified(bytecode)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if err := machine.Run(); err != nil {
+		t.Fatalf("vm error: %s", err)
+	}
+}
+
+func TestVerifyRejectsMalformedBytecode(t *testing.T) {
+	function := func(numLocals int, ins ...[]byte) *object.CompiledFn {
+		return &object.CompiledFn{Instructions: concat(ins...), NumLocals: numLocals, MaxStack: 1}
+	}
+
+	tests := []struct {
+		name         string
+		instructions code.Instructions
+		constants    []object.Object
+		expected     string
+	}{
+		{
+			"undefined opcode",
+			code.Instructions{255},
+			nil,
+			"main at 0000: opcode 255 undefined",
+		},
+		{
+			"truncated",
+			code.Make(code.OpConstant, 0)[:2],
+			nil,
+			"OpConstant at 0 is truncated",
+		},
+		{
+			"constant out of range",
+			concat(code.Make(code.OpConstant, 3), code.Make(code.OpPop)),
+			[]object.Object{&object.Integer{Value: 1}},
+			"main at 0000: constant 3 out of range",
+		},
+		{
+			"closure over non-function",
+			concat(code.Make(code.OpClosure, 0, 0), code.Make(code.OpPop)),
+			[]object.Object{&object.Integer{Value: 1}},
+			"not a function",
+		},
+		{
+			"function as constant",
+			concat(code.Make(code.OpConstant, 0), code.Make(code.OpPop)),
+			[]object.Object{function(0, code.Make(code.OpReturn))},
+			"OpConstant loads function constant 0",
+		},
+		{
+			"builtin out of range",
+			concat(code.Make(code.OpGetBuiltin, 200), code.Make(code.OpPop)),
+			nil,
+			"builtin 200 out of range",
+		},
+		{
+			"local in main",
+			concat(code.Make(code.OpGetLocal, 0), code.Make(code.OpPop)),
+			nil,
+			"local 0 out of range, function has 0",
+		},
+		{
+			"local out of range",
+			code.Make(code.OpNull),
+			[]object.Object{function(1, code.Make(code.OpGetLocal, 1), code.Make(code.OpReturnValue))},
+			"fn#0 at 0000: local 1 out of range, function has 1",
+		},
+		{
+			"jump into an instruction",
+			concat(code.Make(code.OpJump, 1), code.Make(code.OpNull)),
+			nil,
+			"jump target 1 is not an instruction boundary",
+		},
+		{
+			"jump past the end",
+			code.Make(code.OpJump, 100),
+			nil,
+			"jump target 100 is not an instruction boundary",
+		},
+		{
+			"stack underflow",
+			code.Make(code.OpPop),
+			nil,
+			"OpPop pops 1 values from a stack of 0",
+		},
+		{
+			"call without callee",
+			concat(code.Make(code.OpNull), code.Make(code.OpCall, 1)),
+			nil,
+			"OpCall pops 2 values from a stack of 1",
+		},
+		{
+			"deeper than declared in main",
+			concat(code.Make(code.OpNull), code.Make(code.OpNull), code.Make(code.OpNull)),
+			nil,
+			"main at 0002: stack depth 3 exceeds the declared maximum 2",
+		},
+		{
+			"deeper than declared in a function",
+			code.Make(code.OpNull),
+			[]object.Object{function(0, code.Make(code.OpNull), code.Make(code.OpNull), code.Make(code.OpReturnValue))},
+			"fn#0 at 0001: stack depth 2 exceeds the declared maximum 1",
+		},
+		{
+			"inconsistent depth",
+			concat(
+				code.Make(code.OpTrue),
+				code.Make(code.OpJumpIf, 5),
+				code.Make(code.OpNull),
+				code.Make(code.OpPop),
+			),
+			nil,
+			"inconsistent stack depth",
+		},
+		{
+			"function falls off its end",
+			code.Make(code.OpNull),
+			[]object.Object{function(0, code.Make(code.OpNull), code.Make(code.OpPop))},
+			"fn#0 at 0002: function does not return",
+		},
+		{
+			"free variable not captured",
+			concat(code.Make(code.OpClosure, 0, 0), code.Make(code.OpPop)),
+			[]object.Object{function(0, code.Make(code.OpGetFree, 1), code.Make(code.OpReturnValue))},
+			"fn#0 reads 2 free variables but a closure captures 0",
+		},
+		{
+			"odd hash",
+			concat(code.Make(code.OpNull), code.Make(code.OpHash, 1), code.Make(code.OpPop)),
+			nil,
+			"odd number of keys and values",
+		},
+		{
+			"register instruction",
+			concat(code.Make(code.OpRMove, 4096, 4096), code.Make(code.OpNull), code.Make(code.OpPop)),
+			nil,
+			"main at 0000: OpRMove is a register instruction",
+		},
+		{
+			"unsupported constant",
+			code.Make(code.OpNull),
+			[]object.Object{True},
+			"constant 0 has unsupported type",
+		},
+	}
+
+	for _, tt := range tests {
+		bytecode := &compiler.Bytecode{Instructions: tt.instructions, Constants: tt.constants, MaxStack: 2}
+
+		err := Verify(bytecode)
+		if err == nil {
+			t.Errorf("%s: expected error", tt.name)
+			continue
+		}
+		if !strings.Contains(err.Error(), tt.expected) {
+			t.Errorf("%s: wrong error. want=%q, got=%q", tt.name, tt.expected, err)
+		}
+
+		if _, err := NewVerified(bytecode); err == nil {
+			t.Errorf("%s: NewVerified accepted malformed bytecode", tt.name)
+		}
+	}
+}
+
+func concat(s ...[]byte) code.Instructions {
+	out := code.Instructions{}
+
+	for _, ins := range s {
+		out = append(out, ins...)
+	}
+
+	return out
+}
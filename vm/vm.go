@@ -1,30 +1,40 @@
 package vm
 
 import (
+	"context"
 	"fmt"
+	"math"
 	"monc/code"
 	"monc/compiler"
 	"monc/object"
 )
 
-const StackSize = 2048
-const GlobalSize = 65536
-const MaxFrames = 1024
-
 var True = &object.Boolean{Value: true}
 var False = &object.Boolean{Value: false}
 var Null = &object.Null{}
 
 type VM struct {
-	constants []object.Object
+	constants []Value
 
-	stack []object.Object
+	stack []Value
 	sp    int // pointer to next value. stack top is stack[sp-1]
 
-	globals []object.Object
+	globals []Value
 
 	frames      []*Frame
 	framesIndex int
+
+	source      string   // the source file name shown in stack traces
+	globalNames []string // for Globals
+
+	hook Hook
+
+	// registers is set for bytecode compiled with compiler.WithRegisters,
+	// which runs with runRegisters and sets lastPopped with OpRPop
+	registers  bool
+	lastPopped Value
+
+	limits
 }
 
 func (vm *VM) currentFrame() *Frame {
@@ -41,34 +51,86 @@ func (vm *VM) popFrame() *Frame {
 	return vm.frames[vm.framesIndex]
 }
 
-func New(bytecode *compiler.Bytecode) *VM {
-	mainFn := &object.CompiledFn{Instructions: bytecode.Instructions}
+func New(bytecode *compiler.Bytecode, options ...Option) *VM {
+	mainFn := &object.CompiledFn{
+		Instructions: bytecode.Instructions,
+		MaxStack:     bytecode.MaxStack,
+		Lines:        bytecode.Lines,
+	}
 	mainClosure := &object.Closure{Fn: mainFn}
 	mainFrame := NewFrame(mainClosure, 0)
-	frames := make([]*Frame, MaxFrames)
-	frames[0] = mainFrame
 
-	return &VM{
-		constants: bytecode.Constants,
+	vm := &VM{
+		constants: valuesOf(bytecode.Constants),
 
-		stack: make([]object.Object, StackSize),
-		sp:    0,
+		source:      bytecode.Source,
+		globalNames: bytecode.GlobalNames,
 
-		globals: make([]object.Object, GlobalSize),
+		registers: bytecode.Registers,
 
-		frames:      frames,
-		framesIndex: 1,
+		limits: limits{
+			stackSize:  DefaultStackSize,
+			maxFrames:  DefaultMaxFrames,
+			globalSize: DefaultGlobalSize,
+		},
 	}
+
+	for _, option := range options {
+		option(vm)
+	}
+
+	// the stack, frames and globals grow up to their limits as they are
+	// used, so small programs start quickly
+	vm.stack = make([]Value, grown(initialStackSize, 0, vm.stackSize))
+	vm.frames = make([]*Frame, grown(initialFrames, 1, vm.maxFrames))
+	vm.frames[0] = mainFrame
+	vm.framesIndex = 1
+
+	return vm
 }
 
 func (vm *VM) StackTop() object.Object {
 	if vm.sp == 0 {
 		return nil
 	}
-	return vm.stack[vm.sp-1]
+	return vm.stack[vm.sp-1].Object()
 }
 
+// Run executes the program. Errors are returned as a *RuntimeError with
+// the stack trace at the failing instruction.
 func (vm *VM) Run() error {
+	return vm.RunContext(context.Background())
+}
+
+// RunContext is Run, stopping with the error of ctx once it is done. A
+// panic of the VM, which is a bug, is returned as an error of KindInternal.
+func (vm *VM) RunContext(ctx context.Context) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = vm.runtimeError(newError(KindInternal, nil, "internal error: %v", r))
+		}
+	}()
+
+	vm.ctx = ctx
+
+	if top := vm.currentFrame().cl.Fn.MaxStack; top > len(vm.stack) {
+		if err := vm.growStack(top); err != nil {
+			return vm.runtimeError(err)
+		}
+	}
+
+	run := vm.run
+	if vm.registers {
+		run = vm.runRegisters
+	}
+
+	if err := run(); err != nil {
+		return vm.runtimeError(err)
+	}
+	return nil
+}
+
+func (vm *VM) run() error {
 	var ip int
 	var ins code.Instructions
 	var op code.Opcode
@@ -76,6 +138,13 @@ func (vm *VM) Run() error {
 	for vm.currentFrame().ip < len(vm.currentFrame().Instructions())-1 {
 		vm.currentFrame().ip++
 
+		if vm.steps == 0 {
+			if err := vm.checkpoint(); err != nil {
+				return err
+			}
+		}
+		vm.steps--
+
 		ip = vm.currentFrame().ip
 		ins = vm.currentFrame().Instructions()
 		op = code.Opcode(ins[ip])
@@ -83,21 +152,14 @@ func (vm *VM) Run() error {
 		switch op {
 		case code.OpCurrentClosure:
 			currentClosure := vm.currentFrame().cl
-			err := vm.push(currentClosure)
-
-			if err != nil {
-				return err
-			}
+			vm.push(Value{obj: currentClosure})
 
 		case code.OpGetFree:
 			freeIndex := code.ReadUint8(ins[ip+1:])
 			vm.currentFrame().ip += 1
 
 			currentClosure := vm.currentFrame().cl
-			err := vm.push(currentClosure.Free[freeIndex])
-			if err != nil {
-				return err
-			}
+			vm.push(ValueOf(currentClosure.Free[freeIndex]))
 
 		case code.OpClosure:
 			constIndex := code.ReadUint16(ins[ip+1:])
@@ -113,9 +175,7 @@ func (vm *VM) Run() error {
 			builtinFnIndex := code.ReadUint8(ins[ip+1:])
 			vm.currentFrame().ip += 1
 			definition := object.Builtins[builtinFnIndex]
-			if err := vm.push(definition.Builtin); err != nil {
-				return err
-			}
+			vm.push(Value{obj: definition.Builtin})
 
 		case code.OpSetLocal:
 			localIndex := code.ReadUint8(ins[ip+1:])
@@ -125,26 +185,33 @@ func (vm *VM) Run() error {
 		case code.OpGetLocal:
 			localIndex := code.ReadUint8(ins[ip+1:])
 			vm.currentFrame().ip++
-			err := vm.push(vm.stack[vm.currentFrame().bp+int(localIndex)])
-			if err != nil {
-				return err
-			}
+			vm.push(vm.stack[vm.currentFrame().bp+int(localIndex)])
 
 		case code.OpReturn:
-			vm.sp = vm.popFrame().bp - 1
-			err := vm.push(Null)
-			if err != nil {
-				return err
+			if vm.framesIndex == 1 {
+				return vm.returnFromMain(nullValue)
+			}
+			if vm.hook != nil {
+				if err := stopped(vm.hook.Return(vm)); err != nil {
+					return err
+				}
 			}
+			vm.sp = vm.popFrame().bp - 1
+			vm.push(nullValue)
 
 		case code.OpReturnValue:
 			returnVal := vm.pop()
+			if vm.framesIndex == 1 {
+				return vm.returnFromMain(returnVal)
+			}
+			if vm.hook != nil {
+				if err := stopped(vm.hook.Return(vm)); err != nil {
+					return err
+				}
+			}
 			vm.sp = vm.popFrame().bp - 1
 
-			err := vm.push(returnVal)
-			if err != nil {
-				return err
-			}
+			vm.push(returnVal)
 
 		case code.OpCall:
 			numArgs := code.ReadUint8(ins[ip+1:])
@@ -164,15 +231,19 @@ func (vm *VM) Run() error {
 				return err
 			}
 
+		case code.OpSetIndex:
+			value := vm.pop()
+			index := vm.pop()
+			left := vm.pop()
+
+			if err := vm.setIndex(left, index, value); err != nil {
+				return err
+			}
+
 		case code.OpHash:
 			numElements := int(code.ReadUint16(ins[ip+1:]))
 			vm.currentFrame().ip += 2
-			hash, err := vm.buildHash(vm.sp-numElements, vm.sp)
-			if err != nil {
-				return err
-			}
-			vm.sp = vm.sp - numElements
-			err = vm.push(hash)
+			err := vm.executeHashLiteral(numElements)
 			if err != nil {
 				return err
 			}
@@ -180,9 +251,7 @@ func (vm *VM) Run() error {
 		case code.OpArray:
 			numElements := int(code.ReadUint16(ins[ip+1:]))
 			vm.currentFrame().ip += 2
-			array := vm.buildArray(vm.sp-numElements, vm.sp)
-			vm.sp = vm.sp - numElements
-			err := vm.push(array)
+			err := vm.executeArrayLiteral(numElements)
 			if err != nil {
 				return err
 			}
@@ -190,23 +259,19 @@ func (vm *VM) Run() error {
 		case code.OpSetGlobal:
 			globalIndex := code.ReadUint16(ins[ip+1:])
 			vm.currentFrame().ip += 2
-			vm.globals[globalIndex] = vm.pop()
+			if err := vm.setGlobal(int(globalIndex), vm.pop()); err != nil {
+				return err
+			}
 		case code.OpGetGlobal:
 			globalIndex := code.ReadUint16(ins[ip+1:])
 			vm.currentFrame().ip += 2
-			err := vm.push(vm.globals[globalIndex])
-			if err != nil {
-				return err
-			}
+			vm.push(vm.getGlobal(int(globalIndex)))
 
 		case code.OpConstant:
 			// ReadUint16 is faster than ReadOperands
 			constIndex := code.ReadUint16(ins[ip+1:])
 			vm.currentFrame().ip += 2
-			err := vm.push(vm.constants[constIndex])
-			if err != nil {
-				return err
-			}
+			vm.push(vm.constants[constIndex])
 
 		case code.OpAdd, code.OpMul, code.OpDiv, code.OpSub:
 			err := vm.executeBinaryOperation(op)
@@ -214,7 +279,7 @@ func (vm *VM) Run() error {
 				return err
 			}
 
-		case code.OpEqual, code.OpNotEqual, code.OpGreaterThan:
+		case code.OpEqual, code.OpNotEqual, code.OpGreaterThan, code.OpLessThan:
 			err := vm.executeComparison(op)
 			if err != nil {
 				return err
@@ -224,16 +289,10 @@ func (vm *VM) Run() error {
 			vm.pop()
 
 		case code.OpTrue:
-			err := vm.push(True)
-			if err != nil {
-				return err
-			}
+			vm.push(trueValue)
 
 		case code.OpFalse:
-			err := vm.push(False)
-			if err != nil {
-				return err
-			}
+			vm.push(falseValue)
 
 		case code.OpBang:
 			err := vm.executeBangOperator()
@@ -260,164 +319,339 @@ func (vm *VM) Run() error {
 			}
 
 		case code.OpNull:
-			if err := vm.push(Null); err != nil {
+			vm.push(nullValue)
+
+		case code.OpWide:
+			if err := vm.executeWide(ins, ip); err != nil {
 				return err
 			}
+
+		default:
+			return newError(KindInternal, nil, "opcode %d is not a stack instruction", op)
 		}
 	}
 
 	return nil
 }
 
+// executeWide runs the instruction following an OpWide prefix. It is the
+// slow path for operands that do not fit their narrow encoding, so it
+// decodes generically instead of reading fixed offsets.
+func (vm *VM) executeWide(ins code.Instructions, ip int) error {
+	op, operands, n, err := code.Decode(ins, ip)
+	if err != nil {
+		return err
+	}
+	vm.currentFrame().ip += n - 1
+
+	switch op {
+	case code.OpConstant:
+		vm.push(vm.constants[operands[0]])
+
+	case code.OpJump:
+		vm.currentFrame().ip = operands[0] - 1
+
+	case code.OpJumpIf:
+		condition := vm.pop()
+		if !isTruthy(condition) {
+			vm.currentFrame().ip = operands[0] - 1
+		}
+
+	case code.OpSetGlobal:
+		return vm.setGlobal(operands[0], vm.pop())
+
+	case code.OpGetGlobal:
+		vm.push(vm.getGlobal(operands[0]))
+
+	case code.OpArray:
+		return vm.executeArrayLiteral(operands[0])
+
+	case code.OpHash:
+		return vm.executeHashLiteral(operands[0])
+
+	case code.OpCall:
+		return vm.executeCall(operands[0])
+
+	case code.OpSetLocal:
+		vm.stack[vm.currentFrame().bp+operands[0]] = vm.pop()
+
+	case code.OpGetLocal:
+		vm.push(vm.stack[vm.currentFrame().bp+operands[0]])
+
+	case code.OpGetBuiltin:
+		vm.push(Value{obj: object.Builtins[operands[0]].Builtin})
+
+	case code.OpClosure:
+		return vm.pushClosure(operands[0], operands[1])
+
+	case code.OpGetFree:
+		vm.push(ValueOf(vm.currentFrame().cl.Free[operands[0]]))
+
+	default:
+		return newError(KindInternal, nil, "opcode %d cannot be widened", op)
+	}
+
+	return nil
+}
+
+// clearLocals sets the locals of the frame just pushed other than the
+// parameters to null: their slots hold values of earlier calls until their
+// let runs, which it may not, e.g. in a branch that is not taken
+func (vm *VM) clearLocals(frame *Frame) {
+	fn := frame.cl.Fn
+	for index := fn.NumParameters; index < fn.NumLocals; index++ {
+		vm.stack[frame.bp+localSlot(fn, index)] = nullValue
+	}
+}
+
 func (vm *VM) callClosure(cl *object.Closure, numArgs int) error {
 	if numArgs != cl.Fn.NumParameters {
-		return fmt.Errorf("wrong number of arguments: want=%d, got=%d", cl.Fn.NumParameters, numArgs)
+		return wrongArguments(cl, numArgs)
+	}
+
+	if vm.framesIndex == len(vm.frames) {
+		if err := vm.growFrames(); err != nil {
+			return err
+		}
+	}
+
+	// the only stack check for the frame: pushes within it never overflow
+	if top := vm.sp - numArgs + cl.Fn.NumLocals + cl.Fn.MaxStack; top > len(vm.stack) {
+		if err := vm.growStack(top); err != nil {
+			return err
+		}
 	}
 
 	frame := NewFrame(cl, vm.sp-numArgs)
 	vm.pushFrame(frame)
 	vm.sp = frame.bp + cl.Fn.NumLocals
+	vm.clearLocals(frame)
 
+	if vm.hook != nil {
+		return vm.called()
+	}
 	return nil
 }
 
-func (vm *VM) executeIndexExpression(left, index object.Object) error {
-	switch {
-	case left.Type() == object.ARRAY_OBJ && index.Type() == object.INTEGER_OBJ:
-		return vm.executeArrayIndex(left, index)
+func (vm *VM) executeIndexExpression(left, index Value) error {
+	result, err := indexExpression(left, index)
+	if err != nil {
+		return err
+	}
+	vm.push(result)
+	return nil
+}
 
-	case left.Type() == object.HASH_OBJ:
-		return vm.executeHashIndex(left, index)
-	default:
-		return fmt.Errorf("index operator not supported: %s", left.Type())
+func indexExpression(left, index Value) (Value, error) {
+	switch obj := left.obj.(type) {
+	case *object.Array:
+		if index.tag == tagInt {
+			return arrayIndex(obj, index.n), nil
+		}
+		if index.Type() == object.INTEGER_OBJ {
+			return nullValue, nil // a BigInt, out of any array's range
+		}
+
+	case *object.Hash:
+		return hashIndex(obj, index)
 	}
+
+	return Value{}, newError(KindNotIndexable, []object.ObjectType{left.Type(), index.Type()},
+		"index operator not supported: %s", left.Type())
 }
 
-func (vm *VM) executeArrayIndex(array, index object.Object) error {
-	arrayObj := array.(*object.Array)
-	i := index.(*object.Integer).Value
-	max := int64(len(arrayObj.Elements) - 1)
+func arrayIndex(array *object.Array, i int64) Value {
+	max := int64(len(array.Elements) - 1)
 
 	if i < 0 || i > max {
-		return vm.push(Null)
+		return nullValue
 	}
 
-	return vm.push(arrayObj.Elements[i])
+	return ValueOf(array.Elements[i])
 }
 
-func (vm *VM) executeHashIndex(hash, index object.Object) error {
-	hashObj := hash.(*object.Hash)
-	key, ok := index.(object.Hashable)
+func hashIndex(hash *object.Hash, index Value) (Value, error) {
+	key, ok := index.Object().(object.Hashable)
 	if !ok {
-		return fmt.Errorf("unuable as hash key: %s", index.Type())
+		return Value{}, unhashable(index.Type())
 	}
 
-	pair, ok := hashObj.Pairs[key.HashKey()]
+	pair, ok := hash.Get(key.HashKey())
 	if !ok {
-		return vm.push(Null)
+		return nullValue, nil
 	}
 
-	return vm.push(pair.Value)
+	return ValueOf(pair.Value), nil
 }
 
-func (vm *VM) buildHash(head, tail int) (object.Object, error) {
-	hashedPairs := make(map[object.HashKey]object.HashPair)
+// setIndex stores value in an element of an array, which must exist, or
+// in a hash
+func (vm *VM) setIndex(left, index, value Value) error {
+	switch obj := left.obj.(type) {
+	case *object.Array:
+		if index.Type() != object.INTEGER_OBJ {
+			break
+		}
+		if index.tag != tagInt || index.n < 0 || index.n >= int64(len(obj.Elements)) {
+			return indexOutOfRange(index.Object(), len(obj.Elements))
+		}
+		obj.Elements[index.n] = value.Object()
+		return nil
 
-	for i := head; i < tail; i += 2 {
-		key := vm.stack[i]
-		value := vm.stack[i+1]
-		pair := object.HashPair{Key: key, Value: value}
-		hashKey, ok := key.(object.Hashable)
+	case *object.Hash:
+		key, ok := index.Object().(object.Hashable)
 		if !ok {
-			return nil, fmt.Errorf("unusable as hash key: %s", key.Type())
+			return unhashable(index.Type())
+		}
+		before := obj.Len()
+		obj.Set(key.HashKey(), object.HashPair{Key: index.Object(), Value: value.Object()})
+		if obj.Len() == before || vm.memoryLimit == 0 {
+			return nil
 		}
-		hashedPairs[hashKey.HashKey()] = pair
+		return vm.chargeBytes(64)
+	}
+
+	return newError(KindNotIndexable, []object.ObjectType{left.Type(), index.Type()},
+		"index operator not supported: %s", left.Type())
+}
+
+func (vm *VM) executeHashLiteral(numElements int) error {
+	hash, err := buildHash(vm.stack[vm.sp-numElements : vm.sp])
+	if err != nil {
+		return err
 	}
+	vm.sp = vm.sp - numElements
+	vm.push(Value{obj: hash})
+	return vm.charge(hash)
+}
 
-	return &object.Hash{Pairs: hashedPairs}, nil
+func (vm *VM) executeArrayLiteral(numElements int) error {
+	array := buildArray(vm.stack[vm.sp-numElements : vm.sp])
+	vm.sp = vm.sp - numElements
+	vm.push(Value{obj: array})
+	return vm.charge(array)
 }
 
-func (vm *VM) buildArray(head, tail int) object.Object {
-	elements := make([]object.Object, tail-head)
+// buildHash pairs up keys and values, which alternate in elements
+func buildHash(elements []Value) (object.Object, error) {
+	hash := object.NewHash(len(elements) / 2)
 
-	for i := head; i < tail; i++ {
-		elements[i-head] = vm.stack[i]
+	for i := 0; i < len(elements); i += 2 {
+		key := elements[i].Object()
+		value := elements[i+1].Object()
+		pair := object.HashPair{Key: key, Value: value}
+		hashKey, ok := key.(object.Hashable)
+		if !ok {
+			return nil, unhashable(key.Type())
+		}
+		hash.Set(hashKey.HashKey(), pair)
 	}
 
-	return &object.Array{Elements: elements}
+	return hash, nil
 }
 
-func isTruthy(obj object.Object) bool {
-	switch obj := obj.(type) {
-	case *object.Boolean:
-		return obj.Value
-	case *object.Null:
-		return false
-	default:
+// buildArray boxes elements, which are usually part of the stack
+func buildArray(elements []Value) object.Object {
+	return &object.Array{Elements: objectsOf(elements)}
+}
+
+func isTruthy(v Value) bool {
+	switch v.tag {
+	case tagBool:
+		return v.n != 0
+	case tagInt:
 		return true
 	}
+
+	_, null := v.obj.(*object.Null)
+	return !null
 }
 
 func (vm *VM) executeMinusOperator() error {
-	oprnd := vm.pop()
+	result, err := minusOperator(vm.pop())
+	if err != nil {
+		return err
+	}
+	vm.push(result)
+	return nil
+}
 
-	if oprnd.Type() != object.INTEGER_OBJ {
-		return fmt.Errorf("unsupported type for negation: %s", oprnd.Type())
+func minusOperator(oprnd Value) (Value, error) {
+	if oprnd.tag == tagInt && oprnd.n != math.MinInt64 {
+		return integerValue(-oprnd.n), nil
 	}
 
-	value := oprnd.(*object.Integer).Value
+	if oprnd.Type() != object.INTEGER_OBJ {
+		return Value{}, newError(KindUnknownOperator, []object.ObjectType{oprnd.Type()},
+			"unknown operator: -%s", oprnd.Type())
+	}
 
-	return vm.push(&object.Integer{Value: -value})
+	return ValueOf(object.NegateInteger(oprnd.Object())), nil
 }
 
 func (vm *VM) executeBangOperator() error {
-	operand := vm.pop()
+	vm.push(bangOperator(vm.pop()))
+	return nil
+}
 
-	/* We consider every object other than False as a truthy value
-	 * and therefore push a False on the stack
+func bangOperator(operand Value) Value {
+	/* We consider every value other than false and null as a truthy
+	 * value and therefore push false on the stack
 	 */
 
-	switch operand {
-	case False:
-		return vm.push(True)
-	case Null:
-		return vm.push(True)
-	default:
-		return vm.push(False)
-	}
+	return booleanValue(!isTruthy(operand))
 }
 
 func (vm *VM) executeComparison(op code.Opcode) error {
 	right := vm.pop()
 	left := vm.pop()
 
-	if left.Type() == object.INTEGER_OBJ && right.Type() == object.INTEGER_OBJ {
-		return vm.executeIntegerComparison(op, left, right)
+	result, err := comparison(op, left, right)
+	if err != nil {
+		return err
+	}
+	vm.push(result)
+	return nil
+}
+
+// comparison takes the stack opcode op; the register VM maps its own
+// opcodes to those, so both report the same errors
+func comparison(op code.Opcode, left, right Value) (Value, error) {
+	if left.tag == tagInt && right.tag == tagInt {
+		return integerComparison(op, left.n, right.n)
 	}
 
-	switch op {
-	case code.OpEqual:
-		return vm.push(nativeBoolToBooleanObject(right == left))
-	case code.OpNotEqual:
-		return vm.push(nativeBoolToBooleanObject(right != left))
+	switch {
+	case left.Type() == object.INTEGER_OBJ && right.Type() == object.INTEGER_OBJ:
+		// at least one BigInt: compare the sign of their comparison to 0
+		return integerComparison(op, int64(object.CompareIntegers(left.Object(), right.Object())), 0)
+	case op == code.OpEqual:
+		return booleanValue(left.equal(right)), nil
+	case op == code.OpNotEqual:
+		return booleanValue(!left.equal(right)), nil
+	case left.Type() == object.STRING_OBJ && right.Type() == object.STRING_OBJ:
+		// > or <, lexicographically by bytes
+		l, r := left.obj.(*object.String).Value, right.obj.(*object.String).Value
+		return booleanValue(op == code.OpGreaterThan && l > r || op == code.OpLessThan && l < r), nil
+	case left.Type() != right.Type():
+		return Value{}, typeMismatch(op, left.Type(), right.Type())
 	default:
-		return fmt.Errorf("unknown operator: %d (%s %s)", op, left.Type(), right.Type())
+		return Value{}, unknownOperator(op, left.Type(), right.Type())
 	}
 }
 
-func (vm *VM) executeIntegerComparison(op code.Opcode, left, right object.Object) error {
-	leftVal := left.(*object.Integer).Value
-	rightVal := right.(*object.Integer).Value
-
+func integerComparison(op code.Opcode, leftVal, rightVal int64) (Value, error) {
 	switch op {
 	case code.OpEqual:
-		return vm.push(nativeBoolToBooleanObject(rightVal == leftVal))
+		return booleanValue(rightVal == leftVal), nil
 	case code.OpNotEqual:
-		return vm.push(nativeBoolToBooleanObject(rightVal != leftVal))
+		return booleanValue(rightVal != leftVal), nil
 	case code.OpGreaterThan:
-		return vm.push(nativeBoolToBooleanObject(leftVal > rightVal))
+		return booleanValue(leftVal > rightVal), nil
+	case code.OpLessThan:
+		return booleanValue(leftVal < rightVal), nil
 	default:
-		return fmt.Errorf("unknown operator: %d", op)
+		return Value{}, newError(KindInternal, nil, "unknown integer comparison: %d", op)
 	}
 }
 
@@ -433,68 +667,97 @@ func (vm *VM) executeBinaryOperation(op code.Opcode) error {
 	right := vm.pop()
 	left := vm.pop()
 
+	result, err := binaryOperation(op, left, right)
+	if err != nil {
+		return err
+	}
+	vm.push(result)
+	return vm.charge(result.obj)
+}
+
+// binaryOperation takes the stack opcode op, like comparison
+func binaryOperation(op code.Opcode, left, right Value) (Value, error) {
+	if left.tag == tagInt && right.tag == tagInt {
+		return execBiIntOp(op, left.n, right.n)
+	}
+
 	leftType := left.Type()
 	rightType := right.Type()
 
 	switch {
-
 	case leftType == object.INTEGER_OBJ && rightType == object.INTEGER_OBJ:
-		return vm.execBiIntOp(op, left, right)
+		// at least one BigInt, and zero never is one
+		if op == code.OpDiv && right.tag == tagInt && right.n == 0 {
+			return Value{}, divisionByZero()
+		}
+		return ValueOf(object.IntegerArithmetic(operators[op], left.Object(), right.Object())), nil
 
 	case leftType == object.STRING_OBJ && rightType == object.STRING_OBJ:
-		return vm.execBiStrOP(op, left, right)
+		return execBiStrOP(op, left.obj.(*object.String), right.obj.(*object.String))
+
+	case leftType != rightType:
+		return Value{}, typeMismatch(op, leftType, rightType)
 
 	default:
-		return fmt.Errorf("unsupported types for binary operation: %s %s", leftType, rightType)
+		return Value{}, unknownOperator(op, leftType, rightType)
 	}
 }
 
-func (vm *VM) execBiStrOP(op code.Opcode, lObj, rObj object.Object) error {
+func execBiStrOP(op code.Opcode, lObj, rObj *object.String) (Value, error) {
 	if op != code.OpAdd {
-		return fmt.Errorf("unknown string operator: %d", op)
+		return Value{}, unknownOperator(op, lObj.Type(), rObj.Type())
 	}
 
-	lVal := lObj.(*object.String).Value
-	rVal := rObj.(*object.String).Value
-
-	return vm.push(&object.String{Value: lVal + rVal})
+	return Value{obj: &object.String{Value: lObj.Value + rObj.Value}}, nil
 }
 
-func (vm *VM) execBiIntOp(op code.Opcode, left, right object.Object) error {
-	leftVal := left.(*object.Integer).Value
-	rightVal := right.(*object.Integer).Value
-
+// execBiIntOp promotes results that overflow int64 to object.BigInt
+func execBiIntOp(op code.Opcode, leftVal, rightVal int64) (Value, error) {
 	var result int64
+	ok := true
 
 	switch op {
 	case code.OpAdd:
-		result = leftVal + rightVal
+		result, ok = object.AddInt64(leftVal, rightVal)
 	case code.OpSub:
-		result = leftVal - rightVal
+		result, ok = object.SubInt64(leftVal, rightVal)
 	case code.OpMul:
-		result = leftVal * rightVal
+		result, ok = object.MulInt64(leftVal, rightVal)
 	case code.OpDiv:
-		result = leftVal / rightVal
+		if rightVal == 0 {
+			return Value{}, divisionByZero()
+		}
+		ok = !(leftVal == math.MinInt64 && rightVal == -1)
+		if ok {
+			result = leftVal / rightVal
+		}
 
 	default:
-		return fmt.Errorf("unknown integer operator: %d", op)
+		return Value{}, newError(KindInternal, nil, "unknown integer operator: %d", op)
 	}
 
-	return vm.push(&object.Integer{Value: result})
+	if !ok {
+		left, right := &object.Integer{Value: leftVal}, &object.Integer{Value: rightVal}
+		return ValueOf(object.IntegerArithmetic(operators[op], left, right)), nil
+	}
+	return integerValue(result), nil
 }
 
-func (vm *VM) push(o object.Object) error {
-	if vm.sp >= StackSize {
-		return fmt.Errorf("stack overflow")
-	}
+// returnFromMain ends the program with value, which LastPoppedStackElem
+// returns as if the last statement had been popped
+func (vm *VM) returnFromMain(value Value) error {
+	vm.stack[vm.sp] = value
+	return nil
+}
 
+// push does not check for overflow: callClosure makes sure the stack has
+// room for the function's MaxStack values when the frame is entered
+func (vm *VM) push(o Value) {
 	vm.stack[vm.sp] = o
 	vm.sp++
-
-	return nil
 }
 
-func (vm *VM) pop() object.Object {
+func (vm *VM) pop() Value {
 	o := vm.stack[vm.sp-1]
 	vm.sp--
 	return o
@@ -502,54 +765,80 @@ func (vm *VM) pop() object.Object {
 
 // for testing OpPop
 func (vm *VM) LastPoppedStackElem() object.Object {
-	return vm.stack[vm.sp]
+	if vm.registers {
+		return vm.lastPopped.Object()
+	}
+	return vm.stack[vm.sp].Object()
 }
 
-func NewWithGlobalStore(bytecode *compiler.Bytecode, s []object.Object) *VM {
-	vm := New(bytecode)
+// NewWithGlobalStore returns a VM that keeps its globals in s, so they can
+// be shared with VMs for later bytecode, as in the REPL
+func NewWithGlobalStore(bytecode *compiler.Bytecode, s []Value, options ...Option) *VM {
+	vm := New(bytecode, options...)
 	vm.globals = s
+	vm.globalSize = len(s)
 	return vm
 }
 
 func (vm *VM) executeCall(argCount int) error {
 	callee := vm.stack[vm.sp-1-argCount]
-	switch callee := callee.(type) {
+	switch fn := callee.obj.(type) {
 	case *object.Closure:
-		return vm.callClosure(callee, argCount)
+		return vm.callClosure(fn, argCount)
 	case *object.Builtin:
-		return vm.callBuiltin(callee, argCount)
+		return vm.callBuiltin(fn, argCount)
 	default:
-		return fmt.Errorf("calling non-closure and non-builtin")
+		return notCallable(callee.Type())
 	}
 }
 
 func (vm *VM) callBuiltin(builtinFn *object.Builtin, argCount int) error {
-	args := vm.stack[vm.sp-argCount : vm.sp]
-	result := builtinFn.Fn(args...)
+	args := objectsOf(vm.stack[vm.sp-argCount : vm.sp])
+	result, err := vm.applyBuiltin(builtinFn, args)
 	vm.sp = vm.sp - argCount - 1
+	vm.push(ValueOf(result))
 
-	if result != nil {
-		vm.push(result)
-	} else {
-		vm.push(Null)
-	}
-
-	return nil
+	return err
 }
 
 func (vm *VM) pushClosure(constIndex, freeVarCount int) error {
-	constant := vm.constants[constIndex]
-	function, ok := constant.(*object.CompiledFn)
+	function, ok := vm.constants[constIndex].obj.(*object.CompiledFn)
 	if !ok {
-		return fmt.Errorf("not a function: %+v", constant)
+		return newError(KindInternal, nil, "constant %d is not a function", constIndex)
 	}
 
 	free := make([]object.Object, freeVarCount)
 	for i := 0; i < freeVarCount; i++ {
-		free[i] = vm.stack[vm.sp-freeVarCount+i]
+		free[i] = vm.stack[vm.sp-freeVarCount+i].Object()
 	}
 	vm.sp = vm.sp - freeVarCount
 
 	closure := &object.Closure{Fn: function, Free: free}
-	return vm.push(closure)
+	vm.push(Value{obj: closure})
+	return vm.charge(closure)
+}
+
+// setGlobal checks the index, which the compiler does not know the VM's
+// number of globals for, and grows the globals to it
+func (vm *VM) setGlobal(index int, value Value) error {
+	if index >= len(vm.globals) {
+		if index >= vm.globalSize {
+			return fmt.Errorf("%w: the VM has %d", ErrGlobalsExhausted, vm.globalSize)
+		}
+		vm.globals = append(vm.globals, make([]Value, index+1-len(vm.globals))...)
+	}
+	vm.globals[index] = value
+	return nil
+}
+
+// getGlobal returns null for globals that were never set, like those of a
+// let in a branch that was not taken
+func (vm *VM) getGlobal(index int) Value {
+	if index >= len(vm.globals) {
+		return nullValue
+	}
+	if v := vm.globals[index]; v.tag != tagObject || v.obj != nil {
+		return v
+	}
+	return nullValue
 }
@@ -2,11 +2,14 @@ package vm
 
 import (
 	"fmt"
+	"math/big"
 	"monc/ast"
 	"monc/compiler"
+	"monc/evaluator"
 	"monc/lexer"
 	"monc/object"
 	"monc/parser"
+	"strings"
 	"testing"
 )
 
@@ -15,6 +18,47 @@ type vmTestCase struct {
 	expected interface{}
 }
 
+func TestBigIntegers(t *testing.T) {
+	tests := []vmTestCase{
+		{"9223372036854775807 + 1", bigInt("9223372036854775808")},
+		{"-9223372036854775807 - 2", bigInt("-9223372036854775809")},
+		{"4294967296 * 4294967296", bigInt("18446744073709551616")},
+		{"-9223372036854775808", -9223372036854775808},
+		{"-(-9223372036854775807 - 1)", bigInt("9223372036854775808")},
+		{"-9223372036854775808 / -1", bigInt("9223372036854775808")},
+		{"9223372036854775808 - 1", 9223372036854775807},
+		{"100000000000000000000 / 3", bigInt("33333333333333333333")},
+		{"99999999999999999999 > 9223372036854775807", true},
+		{"1 < 99999999999999999999", true},
+		{"18446744073709551616 == 4294967296 * 4294967296", true},
+		{"18446744073709551616 != 18446744073709551617", true},
+		{"{18446744073709551616: 1}[4294967296 * 4294967296]", 1},
+		{"[1, 2][18446744073709551616]", Null},
+		{"let f = fn(n) { if (n == 0) { return 1; } n * f(n - 1) }; f(25)", bigInt("15511210043330985984000000")},
+	}
+
+	runVmTests(t, tests)
+
+	for _, tt := range tests {
+		evaluated := evaluator.Eval(parse(tt.input), object.NewEnvironment())
+		if tt.expected == Null {
+			if evaluated.Type() != object.NULL_OBJ {
+				t.Errorf("evaluator/%q: object is not Null: %T (%+v)", tt.input, evaluated, evaluated)
+			}
+			continue
+		}
+		testExpectedObject(t, tt.expected, evaluated)
+	}
+}
+
+func bigInt(s string) *big.Int {
+	n, ok := new(big.Int).SetString(s, 10)
+	if !ok {
+		panic("bad big integer " + s)
+	}
+	return n
+}
+
 func TestBooleanExpressions(t *testing.T) {
 	tests := []vmTestCase{
 		{"true", true},
@@ -48,6 +92,36 @@ func TestBooleanExpressions(t *testing.T) {
 	runVmTests(t, tests)
 }
 
+func TestEquality(t *testing.T) {
+	tests := []vmTestCase{
+		{`"a" == "a"`, true},
+		{`"a" + "b" == "ab"`, true},
+		{`let s = "a"; s + s != "aa"`, false},
+		{`"a" == "b"`, false},
+		{`"a" < "b"`, true},
+		{`"ab" < "b"`, true},
+		{`"b" > "ab"`, true},
+		{`"a" < "a"`, false},
+		{`"" < "a"`, true},
+		{`"Z" < "a"`, true},
+		{`[1, "a", [true]] == [1, "a", [true]]`, true},
+		{`[1, 2] == [2, 1]`, false},
+		{`[1] == [1, 1]`, false},
+		{`[] != []`, false},
+		{`{"a": 1, "b": [2]} == {"b": [2], "a": 1}`, true},
+		{`{"a": 1} == {"a": 2}`, false},
+		{`{1: 1} == {true: 1}`, false},
+		{`let a = [1]; let b = push(a, 2); b == [1, 2]`, true},
+		{`let a = [1]; append(a, a); let b = [1]; append(b, b); a == b`, true},
+		{`let a = [1]; append(a, a); let b = [2]; append(b, b); a == b`, false},
+		{`fn(x) { x } == fn(x) { x }`, false},
+		{`let f = fn(x) { x }; f == f`, true},
+		{`len == len`, true},
+	}
+
+	runVmTests(t, tests)
+}
+
 func TestIntegerArithmetic(t *testing.T) {
 	tests := []vmTestCase{
 		{"1", 1},
@@ -83,6 +157,10 @@ func TestConditionals(t *testing.T) {
 		{"if (1 > 2) { 10 }", Null},
 		{"if (false) { 10 }", Null},
 		{"if ((if (false) {10})) { 10 } else {20}", 20},
+		{"if (true) { let a = 1; }", Null},
+		{"if (true) { }", Null},
+		{"if (false) { 10 } else { let a = 1; }", Null},
+		{"if ((if (true) { let a = 1; })) { 10 } else { 20 }", 20},
 	}
 
 	runVmTests(t, tests)
@@ -93,6 +171,13 @@ func TestGlobalLetStatements(t *testing.T) {
 		{"let one = 1; one;", 1},
 		{"let one = 1; let two = 2; one + two", 3},
 		{"let one = 1; let two = one + one; one + two", 3},
+		// a value that reads the variable being defined reads null
+		{"let b = if (b) { 1 } else { 2 }; b", 2},
+		{"let f = fn() { let a = if (a) { 1 } else { 2 }; a }; f()", 2},
+		{"let a = fn() { a }(); a", Null},
+		// as does one whose let has not run
+		{"if (false) { let x = 1; }; x", Null},
+		{"let g = fn() { let y = 5; y }; let f = fn() { if (false) { let x = 1; }; x }; g(); f()", Null},
 	}
 
 	runVmTests(t, tests)
@@ -212,6 +297,17 @@ func TestFunctionsWithReturnStatement(t *testing.T) {
 	runVmTests(t, tests)
 }
 
+func TestTopLevelReturn(t *testing.T) {
+	tests := []vmTestCase{
+		{"return 10; 9;", 10},
+		{"9; return 2 * 5; 9;", 10},
+		{"if (10 > 1) { return 10; } 1", 10},
+		{"let f = fn(x) { x }; return f(3);", 3},
+	}
+
+	runVmTests(t, tests)
+}
+
 func TestFunctionsWithoutReturnValue(t *testing.T) {
 	tests := []vmTestCase{
 		{
@@ -377,6 +473,15 @@ func TestCallingFunctionsWithArgumentsAndBindings(t *testing.T) {
          `,
 			expected: 50,
 		},
+		{
+			// a let in an argument is not overwritten by the callee's frame
+			input: `
+         let f = fn(x, y) { let z = 100; x + y + z };
+         let g = fn() { f(1, if (true) { let b = 5; b } else { 0 }); b };
+         g();
+         `,
+			expected: 5,
+		},
 	}
 
 	runVmTests(t, tests)
@@ -398,27 +503,55 @@ func TestCallingFunctionsWithWrongArguments(t *testing.T) {
 		},
 	}
 
-	for _, tt := range tests {
-		program := parse(tt.input)
+	for _, backend := range backends {
+		for _, tt := range tests {
+			program := parse(tt.input)
 
-		comp := compiler.New()
-		err := comp.Compile(program)
-		if err != nil {
-			t.Fatalf("compiler error: %s", err)
-		}
+			comp := compiler.New(backend.options...)
+			err := comp.Compile(program)
+			if err != nil {
+				t.Fatalf("compiler error: %s", err)
+			}
 
-		vm := New(comp.Bytecode())
-		err = vm.Run()
-		if err == nil {
-			t.Fatalf("expected VM error but resulted in none.")
-		}
+			vm := New(comp.Bytecode())
+			err = vm.Run()
+			if err == nil {
+				t.Fatalf("%s: expected VM error but resulted in none.", backend.name)
+			}
 
-		if err.Error() != tt.expected {
-			t.Fatalf("wrong VM error: want=%q, got=%q", tt.expected, err)
+			if err.Error() != tt.expected {
+				t.Fatalf("%s: wrong VM error: want=%q, got=%q", backend.name, tt.expected, err)
+			}
 		}
 	}
 }
 
+func TestIndexAssignments(t *testing.T) {
+	tests := []vmTestCase{
+		{"let a = [1, 2, 3]; a[1] = 5; a", []int{1, 5, 3}},
+		{"let a = [1, 2, 3]; a[0] = a[1] + a[2]; a[0]", 5},
+		{"let a = [[1]]; a[0][0] = 2; a[0]", []int{2}},
+		{`let h = {}; h["a"] = 1; h["a"] = h["a"] + 1; h["a"]`, 2},
+		{`let h = {1: 1}; h[2] = 4; h`, map[object.HashKey]int64{
+			(&object.Integer{Value: 1}).HashKey(): 1,
+			(&object.Integer{Value: 2}).HashKey(): 4,
+		}},
+		// arrays and hashes are shared, not copied
+		{"let a = [1]; let b = a; b[0] = 2; a[0]", 2},
+		{"let set = fn(a, v) { a[0] = v; }; let a = [1]; set(a, 3); a[0]", 3},
+		{"let a = [1]; let f = fn() { a[0] = 4 }; f(); a[0]", 4},
+		{"let f = fn() { let a = [0]; a[0] = 1; a[0] }; f()", 1},
+		// a statement, whose value in a block is null
+		{"if (true) { let a = [1]; a[0] = 2 }", Null},
+		// the left side, the index and the value are evaluated in order
+		{"let log = []; let a = [0]; let f = fn(n, x) { append(log, n); x }; f(1, a)[f(2, 0)] = f(3, 7); push(log, a[0])",
+			[]int{1, 2, 3, 7}},
+		{"let a = [1]; append(a, a); len(a[1][1][1])", 2},
+	}
+
+	runVmTests(t, tests)
+}
+
 func TestBuiltinFns(t *testing.T) {
 	ts := []vmTestCase{
 		{`len("")`, 0},
@@ -438,6 +571,22 @@ func TestBuiltinFns(t *testing.T) {
 		{`rest([])`, Null},
 		{`push([], 1)`, []int{1}},
 		{`push(1, 1)`, &object.Error{Message: "argument to `push` must be ARRAY, got INTEGER"}},
+		{`let a = [1]; push(a, 2); a`, []int{1}},
+		{`let a = [1]; append(a, 2); a`, []int{1, 2}},
+		{`let a = []; let b = append(a, 1); b[0] = 2; a`, []int{2}},
+		{`append(1, 1)`, &object.Error{Message: "argument to `append` must be ARRAY, got INTEGER"}},
+		{`let h = {1: 1, 2: 2}; delete(h, 1); delete(h, 3); h`, map[object.HashKey]int64{
+			(&object.Integer{Value: 2}).HashKey(): 2,
+		}},
+		{`delete(1, 1)`, &object.Error{Message: "argument to `delete` must be HASH, got INTEGER"}},
+		{`delete({}, {})`, &object.Error{Message: "unusable as hash key: HASH"}},
+		// in insertion order, a duplicate key keeping its first place
+		{`keys({3: 0, 1: 0, 2: 0, 1: 0})`, []int{3, 1, 2}},
+		{`values({3: 1, 1: 2, 2: 3, 3: 4})`, []int{4, 2, 3}},
+		{`let h = {1: 1, 2: 2}; delete(h, 1); h[1] = 3; keys(h)`, []int{2, 1}},
+		{`keys({})`, []int{}},
+		{`keys(1)`, &object.Error{Message: "argument to `keys` must be HASH, got INTEGER"}},
+		{`values({}, {})`, &object.Error{Message: "wrong number of arguments. got=2, want=1"}},
 		{`puts("hello", "world!")`, Null},
 	}
 
@@ -600,30 +749,127 @@ func TestRecursiveFibonacci(t *testing.T) {
 	runVmTests(t, tests)
 }
 
+func TestWideOperands(t *testing.T) {
+	names := []string{}
+	args := []string{}
+	lets := []string{}
+	for i := 0; i < 300; i++ {
+		names = append(names, letterName(i))
+		args = append(args, fmt.Sprintf("%d", i))
+		lets = append(lets, fmt.Sprintf("let %s = %d;", letterName(i), i))
+	}
+
+	tests := []vmTestCase{
+		{
+			input: fmt.Sprintf("fn(%s) { %s + %s }(%s)",
+				strings.Join(names, ", "), names[0], names[299], strings.Join(args, ", ")),
+			expected: 299,
+		},
+		{
+			input:    fmt.Sprintf("fn() { %s %s - %s }()", strings.Join(lets, " "), names[299], names[1]),
+			expected: 298,
+		},
+		{
+			input:    fmt.Sprintf("if (false) { %s 1 } else { 2 }", strings.Repeat("true; ", 40000)),
+			expected: 2,
+		},
+		{
+			input:    fmt.Sprintf("if (true) { %s 1 } else { 2 }", strings.Repeat("true; ", 40000)),
+			expected: 1,
+		},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestStackOverflow(t *testing.T) {
+	elements := []string{}
+	for i := 0; i < DefaultStackSize+1; i++ {
+		elements = append(elements, fmt.Sprintf("%d", i))
+	}
+	literal := "[" + strings.Join(elements, ", ") + "]"
+
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		// 1000 frames, below the maximum, but each keeps 3 values on the stack
+		{`let f = fn(n) { if (n == 0) { return 0; } 1 + f(n - 1) }; f(1000);`, "stack overflow"},
+		// more frames than the maximum, each keeping 1 value on the stack
+		{`let f = fn() { f() }; f();`, "maximum recursion depth exceeded"},
+		// checked when the function is called, not while it builds the array
+		{fmt.Sprintf("fn() { %s }();", literal), "stack overflow"},
+		// checked before the main program starts
+		{literal, "stack overflow"},
+	}
+
+	for _, backend := range backends {
+		for _, tt := range tests {
+			comp := compiler.New(backend.options...)
+			if err := comp.Compile(parse(tt.input)); err != nil {
+				t.Fatalf("compiler error: %s", err)
+			}
+
+			vm := New(comp.Bytecode())
+			err := vm.Run()
+			if err == nil {
+				t.Fatalf("%s: expected VM error but resulted in none.", backend.name)
+			}
+
+			if err.Error() != tt.expected {
+				t.Errorf("%s: wrong VM error: want=%q, got=%q", backend.name, tt.expected, err)
+			}
+
+			if vm.sp > len(vm.stack) {
+				t.Errorf("%s: stack pointer past the stack: %d", backend.name, vm.sp)
+			}
+		}
+	}
+}
+
 // ------------------------------ HELPERS -------------------------------
 
+// backends are the instruction sets every test runs with
+var backends = []struct {
+	name    string
+	options []compiler.Option
+}{
+	{"stack", nil},
+	{"register", []compiler.Option{compiler.WithRegisters()}},
+}
+
 func runVmTests(t *testing.T, tests []vmTestCase) {
 	t.Helper()
 
-	for _, tt := range tests {
-		prog := parse(tt.input)
-		comp := compiler.New()
-		err := comp.Compile(prog)
-		if err != nil {
-			t.Fatalf("compiler error: %s", err)
-		}
+	for _, backend := range backends {
+		for _, tt := range tests {
+			prog := parse(tt.input)
+			comp := compiler.New(backend.options...)
+			err := comp.Compile(prog)
+			if err != nil {
+				t.Fatalf("%s: compiler error: %s", backend.name, err)
+			}
 
-		// dumpBytecode(comp)
+			// dumpBytecode(comp)
 
-		vm := New(comp.Bytecode())
-		err = vm.Run()
-		if err != nil {
-			t.Fatalf("vm error: %s", err)
-		}
+			// everything the compiler emits has to pass the verifier,
+			// which only knows the stack instruction set
+			if !comp.Bytecode().Registers {
+				if err := Verify(comp.Bytecode()); err != nil {
+					t.Fatalf("verify error for %q: %s", tt.input, err)
+				}
+			}
+
+			vm := New(comp.Bytecode())
+			err = vm.Run()
+			if err != nil {
+				t.Fatalf("%s: vm error for %q: %s", backend.name, tt.input, err)
+			}
 
-		stackElem := vm.LastPoppedStackElem()
+			stackElem := vm.LastPoppedStackElem()
 
-		testExpectedObject(t, tt.expected, stackElem)
+			testExpectedObject(t, tt.expected, stackElem)
+		}
 	}
 }
 
@@ -650,6 +896,16 @@ func testExpectedObject(
 			t.Errorf("testIntegerObject failed: %s", err)
 		}
 
+	case *big.Int:
+		res, ok := actual.(*object.BigInt)
+		if !ok {
+			t.Errorf("object is not BigInt. got=%T (%+v)", actual, actual)
+			return
+		}
+		if res.Value.Cmp(expected) != 0 {
+			t.Errorf("object has wrong value. got=%s, expected=%s", res.Value, expected)
+		}
+
 	case bool:
 		err := testBooleanObject(bool(expected), actual)
 		if err != nil {
@@ -692,13 +948,13 @@ func testExpectedObject(
 			t.Errorf("object is not Hash. got=%T (%+v)", actual, actual)
 			return
 		}
-		if len(hash.Pairs) != len(expected) {
-			t.Errorf("hashs has wrong number of Pairs. want=%d, got=%d", len(expected), len(hash.Pairs))
+		if hash.Len() != len(expected) {
+			t.Errorf("hashs has wrong number of Pairs. want=%d, got=%d", len(expected), hash.Len())
 			return
 		}
 
 		for expectedKey, expectedValue := range expected {
-			pair, ok := hash.Pairs[expectedKey]
+			pair, ok := hash.Get(expectedKey)
 			if !ok {
 				t.Errorf("no pair for given key in Pairs")
 			}
@@ -755,6 +1011,19 @@ func parse(input string) *ast.Program {
 	return p.ParseProgram()
 }
 
+// letterName returns a distinct identifier for i, since identifiers
+// cannot contain digits
+func letterName(i int) string {
+	name := ""
+	for {
+		name = string(rune('a'+i%26)) + name
+		i = i/26 - 1
+		if i < 0 {
+			return "v" + name
+		}
+	}
+}
+
 // crude bytecode dumper
 func dumpBytecode(comp *compiler.Compiler) {
 	for i, constant := range comp.Bytecode().Constants {